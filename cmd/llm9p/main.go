@@ -13,9 +13,32 @@
 //	llm9p -addr :5640 -backend ollama
 //	llm9p -addr :5640 -backend ollama -ollama-url http://10.0.2.2:11434
 //
+// Or with an OpenAI-compatible server (OpenAI, vLLM, llama.cpp, LM Studio):
+//
+//	OPENAI_API_KEY=sk-... llm9p -addr :5640 -backend openai
+//	llm9p -addr :5640 -backend openai -openai-url http://localhost:8000/v1
+//
+// Give every open fid on ask its own conversation:
+//
+//	llm9p -addr :5640 -isolate
+//
+// Keep conversations across restarts:
+//
+//	llm9p -addr :5640 -store /var/lib/llm9p
+//
+// Price tokens in the cost file from your own table:
+//
+//	llm9p -addr :5640 -prices /etc/llm9p/prices
+//
+// Keep vector indexes for semantic search on disk (Ollama and
+// OpenAI-compatible backends):
+//
+//	llm9p -addr :5640 -backend ollama -index /var/lib/llm9p/index
+//
 // Mount with:
 //
 //	9pfuse localhost:5640 /mnt/llm
+//	9pfuse -a isolate localhost:5640 /mnt/llm   # per-fid conversations for this mount
 //
 // Interact:
 //
@@ -42,8 +65,15 @@ import (
 func main() {
 	addr := flag.String("addr", ":5640", "Address to listen on")
 	debug := flag.Bool("debug", false, "Enable debug logging")
-	backend := flag.String("backend", "api", "Backend to use: 'api' (Anthropic API), 'cli' (Claude Code CLI), or 'ollama' (local Ollama)")
+	backend := flag.String("backend", "api", "Backend to use: 'api' (Anthropic API), 'cli' (Claude Code CLI), 'ollama' (local Ollama), or 'openai' (OpenAI-compatible server)")
 	ollamaURL := flag.String("ollama-url", "http://localhost:11434", "Ollama API URL (for -backend ollama)")
+	openaiURL := flag.String("openai-url", "https://api.openai.com/v1", "OpenAI-compatible API base URL (for -backend openai)")
+	openaiKeyEnv := flag.String("openai-key-env", "OPENAI_API_KEY", "Environment variable holding the API key (for -backend openai; may be unset for local servers)")
+	isolate := flag.Bool("isolate", false, "Give each open fid on ask its own conversation (per mount: aname 'isolate' or 'shared')")
+	storeDir := flag.String("store", "", "Directory in which to keep conversations across restarts (default: kept in memory only)")
+	pricesFile := flag.String("prices", "", "File of per-model token prices for the cost file, over the built-in Claude prices")
+	indexDir := flag.String("index", "", "Directory in which to keep vector indexes (default: kept in memory only)")
+	toolDir := flag.String("tool-dir", "", "Directory of the executables tools may run (default: none, tool calls are answered by clients)")
 	flag.Parse()
 
 	var client llm.Backend
@@ -74,13 +104,42 @@ func main() {
 		client = llm.NewOllamaClient(*ollamaURL)
 		log.Printf("Using Ollama backend at %s", *ollamaURL)
 
+	case "openai":
+		client = llm.NewOpenAIClient(*openaiURL, os.Getenv(*openaiKeyEnv))
+		log.Printf("Using OpenAI-compatible backend at %s", *openaiURL)
+
 	default:
-		fmt.Fprintf(os.Stderr, "Error: unknown backend '%s' (use 'api', 'cli', or 'ollama')\n", *backend)
+		fmt.Fprintf(os.Stderr, "Error: unknown backend '%s' (use 'api', 'cli', 'ollama', or 'openai')\n", *backend)
 		os.Exit(1)
 	}
 
 	// Create filesystem
-	root := llmfs.NewRoot(client)
+	opts := llmfs.Options{IsolateAsk: *isolate, IndexDir: *indexDir, ToolDir: *toolDir}
+	if *storeDir != "" {
+		store, err := llm.NewFileStore(*storeDir)
+		if err != nil {
+			log.Fatalf("Failed to open conversation store: %v", err)
+		}
+		opts.Store = store
+		log.Printf("Keeping conversations in %s", *storeDir)
+	}
+	if *pricesFile != "" {
+		prices, err := llm.LoadPrices(*pricesFile)
+		if err != nil {
+			log.Fatalf("Failed to load prices: %v", err)
+		}
+		opts.Prices = prices
+	}
+	if *indexDir != "" {
+		log.Printf("Keeping indexes in %s", *indexDir)
+	}
+	if *toolDir != "" {
+		if info, err := os.Stat(*toolDir); err != nil || !info.IsDir() {
+			log.Fatalf("Tool directory %s is not a directory", *toolDir)
+		}
+		log.Printf("Tools may run the executables in %s", *toolDir)
+	}
+	root := llmfs.NewRootWithOptions(client, opts)
 
 	// Create 9P server
 	server := protocol.NewServer(root)
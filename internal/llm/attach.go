@@ -0,0 +1,200 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif" // image.DecodeConfig for the attachment types
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Attachment is a file sent with a prompt: an image, a PDF or plain
+// text. Data is held in memory only, so a message restored from a saved
+// or imported history knows its attachments' names, types and sizes but
+// not their data, and sends a placeholder in their place.
+type Attachment struct {
+	Name      string `json:"name"`
+	MediaType string `json:"media_type"`
+	Size      int    `json:"size"`
+	Data      []byte `json:"-"`
+}
+
+// Attachment media types
+const (
+	MediaPNG  = "image/png"
+	MediaJPEG = "image/jpeg"
+	MediaGIF  = "image/gif"
+	MediaWebP = "image/webp"
+	MediaPDF  = "application/pdf"
+	MediaText = "text/plain"
+)
+
+// Attachment limits
+const (
+	// MaxImageSize is the API's limit for an image
+	MaxImageSize = 5 << 20
+	// MaxDocumentSize is the API's limit for a request, and so for a PDF
+	MaxDocumentSize = 32 << 20
+	// MaxTextSize caps a text attachment, which is sent as prompt text
+	MaxTextSize = 1 << 20
+	// MaxAttachments is how many files a prompt may carry
+	MaxAttachments = 20
+)
+
+// NewAttachment makes an attachment of data, its media type sniffed from
+// the content: text in any text/* type that is valid UTF-8 is plain text
+func NewAttachment(name string, data []byte) (Attachment, error) {
+	a := Attachment{Name: name, MediaType: sniffMediaType(data), Size: len(data), Data: data}
+	if err := a.validate(); err != nil {
+		return Attachment{}, err
+	}
+	return a, nil
+}
+
+// sniffMediaType returns the media type of data, without parameters.
+// DetectContentType takes any data without control bytes for text, so
+// text that is not valid UTF-8 (Latin-1, say) is reported as binary.
+func sniffMediaType(data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		if !utf8.Valid(data) {
+			return "application/octet-stream"
+		}
+		return MediaText
+	}
+	return mediaType
+}
+
+// validate checks the attachment's type and size
+func (a Attachment) validate() error {
+	var limit int
+	switch {
+	case a.IsImage():
+		limit = MaxImageSize
+	case a.MediaType == MediaPDF:
+		limit = MaxDocumentSize
+	case a.MediaType == MediaText:
+		limit = MaxTextSize
+	default:
+		return fmt.Errorf("attachment %s: unsupported type %s (PNG, JPEG, GIF, WebP, PDF or UTF-8 text)", a.Name, a.MediaType)
+	}
+	if len(a.Data) == 0 {
+		return fmt.Errorf("attachment %s is empty", a.Name)
+	}
+	if len(a.Data) > limit {
+		return fmt.Errorf("attachment %s: %d bytes is over the %s limit of %d", a.Name, len(a.Data), a.MediaType, limit)
+	}
+	return nil
+}
+
+// IsImage reports whether the attachment is an image
+func (a Attachment) IsImage() bool {
+	switch a.MediaType {
+	case MediaPNG, MediaJPEG, MediaGIF, MediaWebP:
+		return true
+	}
+	return false
+}
+
+// placeholder stands for the attachment in plain text
+func (a Attachment) placeholder() string {
+	return fmt.Sprintf("[attachment %s: %s, %d bytes]", a.Name, a.MediaType, a.Size)
+}
+
+// text is the attachment as prompt text: the placeholder, followed by
+// the content of a text attachment
+func (a Attachment) text() string {
+	if a.MediaType == MediaText && len(a.Data) > 0 {
+		return a.placeholder() + "\n" + string(a.Data)
+	}
+	return a.placeholder()
+}
+
+// base64 is the data in standard base64
+func (a Attachment) base64() string {
+	return base64.StdEncoding.EncodeToString(a.Data)
+}
+
+// dataURL is the data as a data: URL
+func (a Attachment) dataURL() string {
+	return "data:" + a.MediaType + ";base64," + a.base64()
+}
+
+// Token estimates for attachments sent as blocks. An image costs about
+// its area over 750 pixels, and is scaled down to about 1600 tokens at
+// most; a PDF page is sent as its text and an image of the page.
+const (
+	imageTokens   = 1600
+	pdfPageTokens = 2000
+)
+
+// pdfPage matches a page object of a PDF
+var pdfPage = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// tokens estimates the tokens the attachment's data adds to a prompt,
+// besides those of its text
+func (a Attachment) tokens() int {
+	switch {
+	case len(a.Data) == 0:
+		return 0
+	case a.IsImage():
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(a.Data))
+		if err != nil {
+			return imageTokens // WebP, which the standard library cannot read
+		}
+		return min(cfg.Width*cfg.Height/750+1, imageTokens)
+	case a.MediaType == MediaPDF:
+		return max(len(pdfPage.FindAllIndex(a.Data, -1)), 1) * pdfPageTokens
+	}
+	return 0
+}
+
+// checkAttachments checks that a backend can send attachments, besides
+// text: images if images is set and PDFs if pdfs is
+func checkAttachments(backend string, attachments []Attachment, images, pdfs bool) error {
+	if len(attachments) > MaxAttachments {
+		return fmt.Errorf("%d attachments is over the limit of %d", len(attachments), MaxAttachments)
+	}
+	for _, a := range attachments {
+		if len(a.Data) == 0 {
+			continue // sent as its placeholder
+		}
+		if err := a.validate(); err != nil {
+			return err
+		}
+		if a.IsImage() && !images || a.MediaType == MediaPDF && !pdfs {
+			return fmt.Errorf("attachment %s: the %s backend cannot send %s", a.Name, backend, a.MediaType)
+		}
+	}
+	return nil
+}
+
+// newPrompt returns a prompt added now, with its attachments
+func newPrompt(prompt string, attachments []Attachment) Message {
+	m := newMessage("user", prompt)
+	m.Attachments = attachments
+	return m
+}
+
+// withoutData returns copies of attachments without their data
+func withoutData(attachments []Attachment) []Attachment {
+	if attachments == nil {
+		return nil
+	}
+	stripped := make([]Attachment, len(attachments))
+	for i, a := range attachments {
+		a.Data = nil
+		stripped[i] = a
+	}
+	return stripped
+}
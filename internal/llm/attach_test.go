@@ -0,0 +1,257 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// testPNG is a 30x25 PNG image
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 25))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const testPDF = "%PDF-1.4\n1 0 obj << /Type /Pages /Kids [2 0 R] >> endobj\n2 0 obj << /Type /Page >> endobj\n%%EOF\n"
+
+func TestNewAttachment(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string // media type, or "" if refused
+	}{
+		{"shot.png", testPNG(t), MediaPNG},
+		{"paper.pdf", []byte(testPDF), MediaPDF},
+		{"notes.txt", []byte("héllo\n"), MediaText},
+		{"latin1.txt", []byte("h\xe9llo\n"), ""},
+		{"page.html", []byte("<html><body>hi</body></html>"), MediaText},
+		{"blob", []byte{0, 1, 2, 3, 0xff}, ""},
+		{"empty", nil, ""},
+		{"big.txt", bytes.Repeat([]byte("a"), MaxTextSize+1), ""},
+	}
+	for _, tt := range tests {
+		a, err := NewAttachment(tt.name, tt.data)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("NewAttachment(%s) = %s, want an error", tt.name, a.MediaType)
+			}
+			continue
+		}
+		if err != nil || a.MediaType != tt.want || a.Size != len(tt.data) || a.Name != tt.name {
+			t.Errorf("NewAttachment(%s) = %+v, %v; want type %s", tt.name, a, err, tt.want)
+		}
+	}
+}
+
+func TestAttachment_Tokens(t *testing.T) {
+	img, _ := NewAttachment("shot.png", testPNG(t))
+	pdf, _ := NewAttachment("paper.pdf", []byte(testPDF))
+	if got := img.tokens(); got != 30*25/750+1 {
+		t.Errorf("image tokens = %d", got)
+	}
+	if got := pdf.tokens(); got != pdfPageTokens {
+		t.Errorf("one-page PDF tokens = %d", got)
+	}
+	img.Data = nil
+	if got := img.tokens(); got != 0 {
+		t.Errorf("tokens without data = %d", got)
+	}
+}
+
+func TestMessage_TextAttachments(t *testing.T) {
+	img, _ := NewAttachment("shot.png", testPNG(t))
+	notes, _ := NewAttachment("notes.txt", []byte("buy milk"))
+	msg := newPrompt("What is this?", []Attachment{img, notes})
+	want := fmt.Sprintf("[attachment shot.png: image/png, %d bytes]\n[attachment notes.txt: text/plain, 8 bytes]\nbuy milk\nWhat is this?", img.Size)
+	if got := msg.Text(); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+
+	// The data is not saved, only what it was
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Message
+	json.Unmarshal(data, &restored)
+	if len(restored.Attachments) != 2 || restored.Attachments[0].Data != nil || restored.Attachments[1].Size != 8 {
+		t.Errorf("restored attachments = %+v", restored.Attachments)
+	}
+	if !strings.Contains(restored.Text(), "[attachment notes.txt: text/plain, 8 bytes]\nWhat is this?") {
+		t.Errorf("restored Text() = %q", restored.Text())
+	}
+}
+
+func TestClient_Attachments(t *testing.T) {
+	var req struct {
+		Messages []struct {
+			Content []struct {
+				Type   string `json:"type"`
+				Text   string `json:"text"`
+				Title  string `json:"title"`
+				Source struct {
+					Type      string `json:"type"`
+					MediaType string `json:"media_type"`
+					Data      string `json:"data"`
+				} `json:"source"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[{"type":"text","text":"a cat"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+
+	data := testPNG(t)
+	img, _ := NewAttachment("shot.png", data)
+	pdf, _ := NewAttachment("paper.pdf", []byte(testPDF))
+	notes, _ := NewAttachment("notes.txt", []byte("buy milk"))
+	if _, err := client.Ask(context.Background(), "What is this?", img, pdf, notes); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+
+	blocks := req.Messages[0].Content
+	if len(blocks) != 4 {
+		t.Fatalf("blocks = %+v", blocks)
+	}
+	if b := blocks[0]; b.Type != "image" || b.Source.Type != "base64" || b.Source.MediaType != MediaPNG || b.Source.Data != base64.StdEncoding.EncodeToString(data) {
+		t.Errorf("image block = %+v", b)
+	}
+	if b := blocks[1]; b.Type != "document" || b.Title != "paper.pdf" || b.Source.MediaType != MediaPDF {
+		t.Errorf("PDF block = %+v", b)
+	}
+	if b := blocks[2]; b.Type != "document" || b.Source.Type != "text" || b.Source.Data != "buy milk" {
+		t.Errorf("text block = %+v", b)
+	}
+	if b := blocks[3]; b.Type != "text" || b.Text != "What is this?" {
+		t.Errorf("prompt block = %+v", b)
+	}
+
+	// A restored history sends placeholders
+	msgs := client.Messages()
+	msgs[0].Attachments = withoutData(msgs[0].Attachments)
+	client.SetMessages(msgs)
+	if _, err := client.Ask(context.Background(), "And now?"); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	if b := req.Messages[0].Content[0]; b.Type != "text" || !strings.HasPrefix(b.Text, "[attachment shot.png: image/png") {
+		t.Errorf("restored image block = %+v", b)
+	}
+}
+
+func TestOllamaClient_Attachments(t *testing.T) {
+	var req ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"model":"llava","message":{"role":"assistant","content":"a cat"},"done":true,"done_reason":"stop"}`)
+	}))
+	defer server.Close()
+	client := NewOllamaClient(server.URL)
+
+	data := testPNG(t)
+	img, _ := NewAttachment("shot.png", data)
+	notes, _ := NewAttachment("notes.txt", []byte("buy milk"))
+	if _, err := client.Ask(context.Background(), "What is this?", img, notes); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	prompt := req.Messages[len(req.Messages)-1]
+	if len(prompt.Images) != 1 || prompt.Images[0] != base64.StdEncoding.EncodeToString(data) {
+		t.Errorf("images = %v", prompt.Images)
+	}
+	if prompt.Content != "[attachment notes.txt: text/plain, 8 bytes]\nbuy milk\nWhat is this?" {
+		t.Errorf("content = %q", prompt.Content)
+	}
+
+	pdf, _ := NewAttachment("paper.pdf", []byte(testPDF))
+	if _, err := client.Ask(context.Background(), "Summarize", pdf); err == nil {
+		t.Error("Ollama should refuse a PDF")
+	}
+}
+
+func TestOpenAIClient_Attachments(t *testing.T) {
+	var req struct {
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"id":"c1","model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"a cat"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+	client := NewOpenAIClient(server.URL, "")
+	client.SetModel("gpt-4o")
+
+	data := testPNG(t)
+	img, _ := NewAttachment("shot.png", data)
+	notes, _ := NewAttachment("notes.txt", []byte("buy milk"))
+	if _, err := client.Ask(context.Background(), "What is this?", notes, img); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	var parts []openAIPart
+	if err := json.Unmarshal(req.Messages[0].Content, &parts); err != nil || len(parts) != 3 {
+		t.Fatalf("content = %s", req.Messages[0].Content)
+	}
+	if parts[0].Type != "text" || parts[0].Text != "[attachment notes.txt: text/plain, 8 bytes]\nbuy milk" {
+		t.Errorf("text part = %+v", parts[0])
+	}
+	if parts[1].Type != "image_url" || parts[1].ImageURL.URL != "data:image/png;base64,"+base64.StdEncoding.EncodeToString(data) {
+		t.Errorf("image part = %+v", parts[1])
+	}
+	if parts[2].Text != "What is this?" {
+		t.Errorf("prompt part = %+v", parts[2])
+	}
+
+	// Without attachments the content stays a string
+	if _, err := client.Ask(context.Background(), "Thanks"); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	if got := string(req.Messages[len(req.Messages)-1].Content); got != `"Thanks"` {
+		t.Errorf("content = %s", got)
+	}
+}
+
+func TestCLIClient_Attachments(t *testing.T) {
+	img, _ := NewAttachment("shot.png", testPNG(t))
+	if _, err := NewCLIClient().Ask(context.Background(), "What is this?", img); err == nil || !strings.Contains(err.Error(), "cannot send image/png") {
+		t.Errorf("Ask() error = %v, want the image refused", err)
+	}
+}
+
+func TestExport_Attachments(t *testing.T) {
+	client := NewOllamaClient("http://localhost:0")
+	img, _ := NewAttachment("shot.png", testPNG(t))
+	client.SetMessages([]Message{newPrompt("What is this?", []Attachment{img}), {Role: "assistant", Content: "a cat"}})
+	for _, format := range ExportFormats {
+		data, err := Export(client, format)
+		if err != nil {
+			t.Fatalf("Export(%s) error: %v", format, err)
+		}
+		if !strings.Contains(string(data), "shot.png") || strings.Contains(string(data), img.base64()[:20]) {
+			t.Errorf("Export(%s) = %s, want the attachment without its data", format, data)
+		}
+	}
+	if client.Messages()[0].Attachments[0].Data == nil {
+		t.Error("Export() dropped the data from the history")
+	}
+}
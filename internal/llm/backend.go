@@ -22,6 +22,18 @@ type Backend interface {
 	ThinkingTokens() int
 	// SetThinkingTokens sets the thinking token budget
 	SetThinkingTokens(tokens int)
+	// Params returns the generation parameters (max_tokens, top_p, ...)
+	Params() Params
+	// SetParams sets the generation parameters for subsequent requests;
+	// invalid values and parameters the backend does not apply are rejected
+	SetParams(p Params) error
+	// SupportedParams lists the generation parameters the backend applies
+	SupportedParams() []string
+	// Cache returns the prompt caching mode: CacheOn, CacheSystem or CacheOff
+	Cache() string
+	// SetCache sets the prompt caching mode; backends that cache by
+	// themselves or not at all accept only CacheOff
+	SetCache(mode string) error
 	// Prefill returns the assistant response prefill string
 	Prefill() string
 	// SetPrefill sets a string to prefill the assistant response
@@ -29,37 +41,114 @@ type Backend interface {
 	SetPrefill(prefill string)
 	// LastTokens returns token count from last response
 	LastTokens() int
-	// TotalTokens returns cumulative token count for this conversation
+	// TotalTokens returns the size of the conversation's context: as
+	// reported for the last exchange, or estimated once the history has
+	// changed since
 	TotalTokens() int
+	// CountTokens counts the tokens text takes for the current model,
+	// with the model's tokenizer where the backend offers one
+	CountTokens(ctx context.Context, text string) (TokenCount, error)
 	// ContextLimit returns the model's context window limit
 	ContextLimit() int
-	// Compact summarizes the conversation to reduce token usage
-	// The conversation history is replaced with a summary
+	// Compact shrinks the conversation to reduce token usage, as the
+	// compaction policy says: by default older turns are replaced with
+	// a summary
 	Compact(ctx context.Context) error
+	// CompactPolicy returns how Compact shrinks the history and when it
+	// runs by itself
+	CompactPolicy() CompactPolicy
+	// SetCompactPolicy sets the compaction policy
+	SetCompactPolicy(p CompactPolicy) error
+	// Summarize asks model (the conversation's model if empty) for a
+	// summary outside the conversation, returning it and the tokens used
+	Summarize(ctx context.Context, model, prompt string) (string, int, error)
 	// Messages returns conversation history
 	Messages() []Message
 	// MessagesJSON returns conversation history as JSON
 	MessagesJSON() ([]byte, error)
 	// AddSystemMessage adds a system message to conversation history
 	AddSystemMessage(content string)
+	// SetMessages replaces the conversation history, e.g. when restoring it
+	SetMessages(msgs []Message)
+	// ValidateMessages checks that msgs is a history the backend can send
+	ValidateMessages(msgs []Message) error
 	// Reset clears conversation history (but preserves system prompt)
 	Reset()
-	// Ask sends a prompt and returns the response (blocking)
-	Ask(ctx context.Context, prompt string) (string, error)
+	// Ask sends a prompt, with any attachments, and returns the response
+	// (blocking). Backends that cannot send an attachment's type fail.
+	Ask(ctx context.Context, prompt string, attachments ...Attachment) (string, error)
 	// AskWithHistory sends a prompt with explicit message history (for per-fid isolation)
 	// Returns response text and token count
 	AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error)
-	// StartStream begins streaming a response
-	StartStream(ctx context.Context, prompt string) error
+	// Complete is AskWithHistory returning the whole reply, with any
+	// attachments sent as Ask sends them
+	Complete(ctx context.Context, history []Message, prompt string, attachments ...Attachment) (*Reply, error)
+	// LastThinking returns the model's thinking for the last response, if any
+	LastThinking() string
+	// LastMeta describes the last exchange: model, stop reason, usage, latency
+	LastMeta() Meta
+	// Usage returns the tokens the conversation has used, by model, since
+	// it began or was last reset
+	Usage() Usage
+	// MaxContinuations returns how many times a response cut off at the
+	// token limit is automatically continued (0 = never)
+	MaxContinuations() int
+	// SetMaxContinuations sets the automatic continuation limit
+	SetMaxContinuations(n int) error
+	// StartStream begins streaming a response to a prompt with any attachments
+	StartStream(ctx context.Context, prompt string, attachments ...Attachment) error
 	// ReadStreamChunk reads the next streaming chunk
 	ReadStreamChunk() (string, bool)
 	// IsStreaming returns whether a stream is in progress
 	IsStreaming() bool
 	// WaitStream waits for stream to complete
 	WaitStream()
+	// ReadThinking returns the current stream's thinking text from offset,
+	// blocking until there is some; false when the stream has no more, or
+	// ctx is cancelled
+	ReadThinking(ctx context.Context, offset int64) (string, bool)
+	// Retrier returns the retry policy and status shared by this backend's requests
+	Retrier() *Retrier
+	// Toolbox returns the tools offered to this backend's conversations,
+	// shared by all of them; nil if the backend cannot call tools
+	Toolbox() *Toolbox
+	// Schema returns the JSON Schema answers must match, shared by all
+	// of this backend's conversations
+	Schema() *Schema
+}
+
+// Embedder is implemented by backends that can embed text, with a model
+// of their own, set apart from the chat model. Not every backend can:
+// check with a type assertion on the backend before it is wrapped (in a
+// StoredBackend, say).
+type Embedder interface {
+	// Embed returns a vector for each input, in order
+	Embed(ctx context.Context, inputs []string) (*Embeddings, error)
+	// EmbedModel returns the embedding model
+	EmbedModel() string
+	// SetEmbedModel sets the embedding model
+	SetEmbedModel(model string)
+}
+
+// Reply is a complete response to a prompt
+type Reply struct {
+	Text     string // response text
+	Thinking string // the model's thinking, if it returned any
+	Tokens   int    // input plus output tokens
+	// Calls are the tool calls the response made, while an exchange
+	// runs; Steps are the tool call and result messages that came
+	// before the response, to be added to the history after the prompt
+	Calls []ToolCall
+	Steps []Message
+	Meta
 }
 
 // Verify that all clients implement Backend
 var _ Backend = (*Client)(nil)
 var _ Backend = (*CLIClient)(nil)
 var _ Backend = (*OllamaClient)(nil)
+var _ Backend = (*OpenAIClient)(nil)
+
+// and that those that can embed implement Embedder
+var _ Embedder = (*OllamaClient)(nil)
+var _ Embedder = (*OpenAIClient)(nil)
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Prompt caching modes. With caching, the API stores the prompt up to
+// each cache_control breakpoint, and a later request starting with the
+// same prefix reads it back at a fraction of the input price.
+const (
+	// CacheOn marks the system blocks and the conversation up to the
+	// new prompt, so the next turn reads the whole history from the cache
+	CacheOn = "on"
+	// CacheSystem marks only the system blocks
+	CacheSystem = "system"
+	// CacheOff sends no breakpoints
+	CacheOff = "off"
+)
+
+// CacheModes lists the prompt caching modes
+var CacheModes = []string{CacheOn, CacheSystem, CacheOff}
+
+// validateCache checks a SetCache mode
+func validateCache(mode string) error {
+	for _, m := range CacheModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cache mode %q (use %s)", mode, strings.Join(CacheModes, ", "))
+}
+
+// noCacheControl is SetCache for backends without cache breakpoints,
+// which accept only CacheOff
+func noCacheControl(backend, mode string) error {
+	if err := validateCache(mode); err != nil {
+		return err
+	}
+	if mode != CacheOff {
+		return fmt.Errorf("the %s backend has no prompt caching control", backend)
+	}
+	return nil
+}
+
+// cacheControl is a cache_control breakpoint
+var cacheControl = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+
+// applyCache sets the cache_control breakpoints of mode in params: on the
+// last system block, which caches the system prompt, and unless mode is
+// CacheSystem on the last user message, which caches the conversation
+// up to the new prompt. A prefix shorter than the model's minimum is
+// not cached, and costs nothing extra.
+func applyCache(params *anthropic.MessageNewParams, mode string) {
+	if mode == CacheOff {
+		return
+	}
+	if n := len(params.System); n > 0 {
+		params.System[n-1].CacheControl = cacheControl
+	}
+	if mode == CacheSystem {
+		return
+	}
+	for i := len(params.Messages) - 1; i >= 0; i-- {
+		msg := params.Messages[i]
+		if msg.Role != anthropic.MessageParamRoleUser || len(msg.Content) == 0 {
+			continue
+		}
+		if cc := msg.Content[len(msg.Content)-1].GetCacheControl(); cc != nil {
+			*cc = cacheControl
+		}
+		return
+	}
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// cachedRequest is the part of a Messages API request that shows the
+// cache breakpoints
+type cachedRequest struct {
+	System []struct {
+		Text         string          `json:"text"`
+		CacheControl json.RawMessage `json:"cache_control"`
+	} `json:"system"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content []struct {
+			Text         string          `json:"text"`
+			CacheControl json.RawMessage `json:"cache_control"`
+		} `json:"content"`
+	} `json:"messages"`
+}
+
+// breakpoints lists the texts marked with cache_control
+func (r cachedRequest) breakpoints() []string {
+	var marked []string
+	for _, b := range r.System {
+		if b.CacheControl != nil {
+			marked = append(marked, b.Text)
+		}
+	}
+	for _, m := range r.Messages {
+		for _, b := range m.Content {
+			if b.CacheControl != nil {
+				marked = append(marked, b.Text)
+			}
+		}
+	}
+	return marked
+}
+
+func TestClient_Cache(t *testing.T) {
+	var req cachedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = cachedRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":1,"cache_read_input_tokens":2000,"cache_creation_input_tokens":40}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	client.SetSystemPrompt("You are terse.")
+	client.AddSystemMessage("Facts: ...")
+
+	tests := []struct {
+		mode string
+		want string // texts marked, in order
+	}{
+		{CacheOn, "[Facts: ... second]"},
+		{CacheSystem, "[Facts: ...]"},
+		{CacheOff, "[]"},
+	}
+	if _, err := client.Ask(context.Background(), "first"); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	for _, tt := range tests {
+		if err := client.SetCache(tt.mode); err != nil {
+			t.Fatalf("SetCache(%q) error: %v", tt.mode, err)
+		}
+		if _, err := client.Ask(context.Background(), "second"); err != nil {
+			t.Fatalf("Ask() error: %v", err)
+		}
+		if got := fmt.Sprint(req.breakpoints()); got != tt.want {
+			t.Errorf("cache %s: breakpoints %s, want %s", tt.mode, got, tt.want)
+		}
+		Pop(client)
+	}
+
+	meta := client.LastMeta()
+	if meta.CacheReadTokens != 2000 || meta.CacheWriteTokens != 40 || meta.ContextTokens != 2046 {
+		t.Errorf("LastMeta() = %+v", meta)
+	}
+	if client.SetCache("always") == nil {
+		t.Error("SetCache() should reject an unknown mode")
+	}
+}
+
+func TestNoCacheControl(t *testing.T) {
+	client := NewOllamaClient("http://localhost:11434")
+	if client.Cache() != CacheOff || client.SetCache(CacheOff) != nil {
+		t.Error("Ollama should report and accept cache off")
+	}
+	if client.SetCache(CacheOn) == nil {
+		t.Error("SetCache(on) should fail without cache control")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 )
 
 // CLIClient uses the Claude Code CLI for LLM requests.
@@ -22,11 +23,17 @@ type CLIClient struct {
 	prefill        string // assistant response prefill for keeping model in character
 	messages       []Message
 	lastTokens     int
-	totalTokens    int // cumulative estimated token count
+	totalTokens    int // context size, see TotalTokens
 	thinkingTokens int // 0 = disabled, >0 = budget, -1 = max (default)
+	lastMeta       Meta
+	usage          Usage
+	compact        CompactPolicy // how Compact shrinks the history
+	turn           exchangeLock  // held by an exchange: Ask, stream or Compact
 	streaming      bool
 	streamChan     chan string
 	streamDone     chan struct{}
+	retry          *Retrier
+	schema         *Schema
 }
 
 // cliResponse represents the JSON response from claude CLI
@@ -42,9 +49,29 @@ func NewCLIClient() *CLIClient {
 		temperature:    0.7,
 		messages:       make([]Message, 0),
 		thinkingTokens: -1, // -1 = max thinking (31999 tokens) enabled by default
+		retry:          NewRetrier(DefaultRetryPolicy),
+		schema:         NewSchema(),
+		compact:        DefaultCompactPolicy,
+		usage:          Usage{},
 	}
 }
 
+// Retrier returns the retry policy and status for this client's requests
+func (c *CLIClient) Retrier() *Retrier {
+	return c.retry
+}
+
+// Toolbox returns nil: the CLI runs its own tools, and llm9p's cannot be
+// offered through it
+func (c *CLIClient) Toolbox() *Toolbox {
+	return nil
+}
+
+// Schema returns the JSON Schema this client's answers must match
+func (c *CLIClient) Schema() *Schema {
+	return c.schema
+}
+
 // normalizeModel converts full model names to CLI aliases
 func normalizeModel(model string) string {
 	model = strings.ToLower(model)
@@ -106,6 +133,79 @@ func (c *CLIClient) SetThinkingTokens(tokens int) {
 	c.thinkingTokens = tokens
 }
 
+// LastMeta describes the last exchange. The CLI reports no stop reason,
+// and its token counts are estimates.
+func (c *CLIClient) LastMeta() Meta {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastMeta
+}
+
+// Usage returns the tokens the conversation has used, by model, since it
+// began or was last reset
+func (c *CLIClient) Usage() Usage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.usage.clone()
+}
+
+// MaxContinuations returns 0 - the CLI cannot continue a response
+func (c *CLIClient) MaxContinuations() int {
+	return 0
+}
+
+// SetMaxContinuations accepts only 0, since the CLI cannot continue a response
+func (c *CLIClient) SetMaxContinuations(n int) error {
+	if n != 0 {
+		return fmt.Errorf("continuation is not supported by this backend")
+	}
+	return nil
+}
+
+// CompactPolicy returns how Compact shrinks the history
+func (c *CLIClient) CompactPolicy() CompactPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.compact
+}
+
+// SetCompactPolicy sets how Compact shrinks the history
+func (c *CLIClient) SetCompactPolicy(p CompactPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compact = p
+	return nil
+}
+
+// Params returns no parameters - the claude CLI has no flags for them
+func (c *CLIClient) Params() Params {
+	return Params{}
+}
+
+// SetParams rejects any parameter, since the CLI cannot apply them
+func (c *CLIClient) SetParams(p Params) error {
+	return checkParams(p, nil)
+}
+
+// Cache returns CacheOff: the CLI manages its own requests, without
+// cache breakpoints
+func (c *CLIClient) Cache() string {
+	return CacheOff
+}
+
+// SetCache accepts only CacheOff
+func (c *CLIClient) SetCache(mode string) error {
+	return noCacheControl(BackendCLI, mode)
+}
+
+// SupportedParams returns nil - the CLI applies no generation parameters
+func (c *CLIClient) SupportedParams() []string {
+	return nil
+}
+
 // Prefill returns the assistant response prefill string
 func (c *CLIClient) Prefill() string {
 	c.mu.RLock()
@@ -162,7 +262,23 @@ func (c *CLIClient) MessagesJSON() ([]byte, error) {
 func (c *CLIClient) AddSystemMessage(content string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.messages = append([]Message{{Role: "system", Content: content}}, c.messages...)
+	msg := newMessage("system", content)
+	c.messages = append([]Message{msg}, c.messages...)
+	c.totalTokens += estimateMessage(msg)
+}
+
+// SetMessages replaces the conversation history
+func (c *CLIClient) SetMessages(msgs []Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(make([]Message, 0, len(msgs)), msgs...)
+	c.totalTokens = estimateContext(c.systemPrompt, c.messages)
+}
+
+// ValidateMessages checks that msgs has only roles the backend knows;
+// consecutive turns of the same role are accepted
+func (c *CLIClient) ValidateMessages(msgs []Message) error {
+	return CheckMessages(msgs, false)
 }
 
 // Reset clears the conversation history
@@ -172,9 +288,11 @@ func (c *CLIClient) Reset() {
 	c.messages = make([]Message, 0)
 	c.lastTokens = 0
 	c.totalTokens = 0
+	c.lastMeta = Meta{}
+	c.usage = Usage{}
 }
 
-// TotalTokens returns cumulative estimated token count for this conversation
+// TotalTokens returns the estimated context size of the conversation
 func (c *CLIClient) TotalTokens() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -189,31 +307,40 @@ func (c *CLIClient) ContextLimit() int {
 	return contextLimitForModel(model)
 }
 
-// Compact summarizes the conversation to reduce token usage
+// Compact shrinks the conversation according to the compaction policy
 func (c *CLIClient) Compact(ctx context.Context) error {
-	c.mu.Lock()
-	if len(c.messages) < 4 {
-		c.mu.Unlock()
-		return nil // Not enough to compact
+	if err := c.turn.lock(ctx); err != nil {
+		return err
 	}
+	defer c.turn.unlock()
+	c.mu.Lock()
+	msgs := append([]Message(nil), c.messages...)
+	policy := c.compact
+	c.mu.Unlock()
 
-	// Build conversation text for summarization
-	var conversationText string
-	for _, msg := range c.messages {
-		if msg.Role == "system" {
-			continue // Don't include system messages in summary
-		}
-		conversationText += fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content)
+	compacted, err := compactMessages(ctx, msgs, policy, c.Summarize)
+	if err != nil || compacted == nil {
+		return err
 	}
 
-	model := c.model
-	thinkingTokens := c.thinkingTokens
+	c.mu.Lock()
+	c.messages = compacted
+	c.totalTokens = estimateContext(c.systemPrompt, compacted)
 	c.mu.Unlock()
+	return nil
+}
 
-	// Use a compact summarization prompt
-	summaryPrompt := "Summarize this conversation concisely, preserving key facts, decisions, and context needed to continue:\n\n" + conversationText
+// Summarize asks model (the conversation's model if empty) for a summary
+// outside the conversation. The CLI reports no usage, so the tokens are
+// estimated from the summary.
+func (c *CLIClient) Summarize(ctx context.Context, model, prompt string) (string, int, error) {
+	c.mu.RLock()
+	if model == "" {
+		model = c.model
+	}
+	thinkingTokens := c.thinkingTokens
+	c.mu.RUnlock()
 
-	// Build CLI command for summarization
 	args := []string{
 		"--print",
 		"--output-format", "json",
@@ -223,44 +350,31 @@ func (c *CLIClient) Compact(ctx context.Context) error {
 		"-",
 	}
 
-	cmd := exec.CommandContext(ctx, "claude", args...)
-	cmd.Stdin = bytes.NewBufferString(summaryPrompt)
-
-	// Set thinking token budget
-	cmd.Env = append(cmd.Environ(), func() string {
-		if thinkingTokens < 0 {
-			return "MAX_THINKING_TOKENS=31999"
-		}
-		return fmt.Sprintf("MAX_THINKING_TOKENS=%d", thinkingTokens)
-	}())
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("compaction failed: %w (stderr: %s)", err, stderr.String())
+	stdout, err := c.run(ctx, args, prompt, thinkingTokens)
+	if err != nil {
+		return "", 0, err
 	}
 
-	summary, err := parseJSONResponse(stdout.String())
+	summary, err := parseJSONResponse(stdout)
 	if err != nil {
-		return fmt.Errorf("compaction parse failed: %w", err)
+		return "", 0, fmt.Errorf("parse failed: %w", err)
 	}
-
-	// Replace conversation with summary
-	c.mu.Lock()
-	c.messages = []Message{{Role: "system", Content: "Previous conversation summary: " + summary}}
-	// Estimate tokens for the new conversation state (chars * 0.25)
-	c.totalTokens = len(summary) / 4
-	c.mu.Unlock()
-
-	return nil
-}
-
-// estimateTokens estimates token count from character count
-// Uses rough approximation of 4 chars per token
-func estimateTokens(s string) int {
-	return (len(s) + 3) / 4 // Round up
+	meta := estimatedMeta(model, prompt, summary)
+	meta.Backend = BackendCLI
+	recordLifetime(meta)
+	return summary, EstimateTokens(summary), nil
+}
+
+// estimatedMeta is the Meta of an exchange with estimated token counts
+func estimatedMeta(model, prompt, response string) Meta {
+	meta := Meta{
+		Model:        model,
+		InputTokens:  EstimateTokens(prompt),
+		OutputTokens: EstimateTokens(response),
+		Estimated:    true,
+	}
+	meta.ContextTokens = meta.InputTokens + meta.OutputTokens
+	return meta
 }
 
 // buildPrompt builds a full prompt string from conversation history
@@ -269,9 +383,9 @@ func (c *CLIClient) buildPrompt() string {
 	for _, msg := range c.messages {
 		switch msg.Role {
 		case "user":
-			parts = append(parts, fmt.Sprintf("Human: %s", msg.Content))
+			parts = append(parts, fmt.Sprintf("Human: %s", msg.Text()))
 		case "assistant":
-			parts = append(parts, fmt.Sprintf("Assistant: %s", msg.Content))
+			parts = append(parts, fmt.Sprintf("Assistant: %s", msg.Text()))
 		}
 	}
 	return strings.Join(parts, "\n\n")
@@ -293,10 +407,23 @@ func (c *CLIClient) getSystemPrompt() string {
 	return strings.Join(systems, "\n\n")
 }
 
-// Ask sends a prompt to the LLM via CLI and returns the response
-func (c *CLIClient) Ask(ctx context.Context, prompt string) (string, error) {
+// CountTokens estimates the tokens of text: the CLI has no tokenizer
+func (c *CLIClient) CountTokens(ctx context.Context, text string) (TokenCount, error) {
+	return estimateCount(text), nil
+}
+
+// Ask sends a prompt to the LLM via CLI and returns the response. Only
+// text attachments can be sent, as part of the prompt.
+func (c *CLIClient) Ask(ctx context.Context, prompt string, attachments ...Attachment) (string, error) {
+	if err := checkAttachments(BackendCLI, attachments, false, false); err != nil {
+		return "", err
+	}
+	if err := c.turn.lock(ctx); err != nil {
+		return "", err
+	}
+	defer c.turn.unlock()
 	c.mu.Lock()
-	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+	c.messages = append(c.messages, newPrompt(prompt, attachments))
 	fullPrompt := c.buildPrompt()
 	systemPrompt := c.getSystemPrompt()
 	model := c.model
@@ -317,40 +444,18 @@ func (c *CLIClient) Ask(ctx context.Context, prompt string) (string, error) {
 		"--dangerously-skip-permissions",
 	}
 
+	format := c.schema.format()
+	if format != nil {
+		systemPrompt = strings.TrimSpace(systemPrompt + "\n\n" + format.instruction())
+	}
 	if systemPrompt != "" {
 		args = append(args, "--system-prompt", systemPrompt)
 	}
 
 	args = append(args, "-") // Read from stdin
 
-	cmd := exec.CommandContext(ctx, "claude", args...)
-	cmd.Stdin = bytes.NewBufferString(fullPrompt)
-
-	// Set thinking token budget via environment variable
-	// -1 = max (31999), 0 = disabled, >0 = specific budget
-	cmd.Env = append(cmd.Environ(), func() string {
-		if thinkingTokens < 0 {
-			return "MAX_THINKING_TOKENS=31999"
-		}
-		return fmt.Sprintf("MAX_THINKING_TOKENS=%d", thinkingTokens)
-	}())
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		// Remove user message on error
-		c.mu.Lock()
-		if len(c.messages) > 0 {
-			c.messages = c.messages[:len(c.messages)-1]
-		}
-		c.mu.Unlock()
-		return "", fmt.Errorf("claude CLI error: %w (stderr: %s)", err, stderr.String())
-	}
-
-	// Parse JSON response
-	responseText, err := parseJSONResponse(stdout.String())
+	startTime := time.Now()
+	responseText, meta, err := c.answer(ctx, args, fullPrompt, model, thinkingTokens, format)
 	if err != nil {
 		// Remove user message on error
 		c.mu.Lock()
@@ -358,20 +463,87 @@ func (c *CLIClient) Ask(ctx context.Context, prompt string) (string, error) {
 			c.messages = c.messages[:len(c.messages)-1]
 		}
 		c.mu.Unlock()
-		return "", fmt.Errorf("failed to parse CLI response: %w", err)
+		return "", err
 	}
+	meta.done(BackendCLI, startTime)
 
 	// Update state
 	c.mu.Lock()
-	c.messages = append(c.messages, Message{Role: "assistant", Content: responseText})
-	// Estimate tokens: prompt + response (chars / 4)
-	c.lastTokens = estimateTokens(fullPrompt) + estimateTokens(responseText)
-	c.totalTokens += c.lastTokens
+	c.messages = append(c.messages, newMessage("assistant", responseText))
+	c.lastMeta = meta
+	c.usage.add(meta)
+	c.lastTokens = meta.InputTokens + meta.OutputTokens
+	c.totalTokens = contextTokens(meta, c.systemPrompt, c.messages)
 	c.mu.Unlock()
 
 	return responseText, nil
 }
 
+// answer runs the CLI on prompt and returns the result, with usage
+// estimated over every run. With a format, a result that does not match
+// its schema is sent back with the reason, as the next turns of the
+// prompt, up to the format's retries.
+func (c *CLIClient) answer(ctx context.Context, args []string, prompt, model string, thinkingTokens int, format *answerFormat) (string, Meta, error) {
+	var meta Meta
+	for {
+		stdout, err := c.run(ctx, args, prompt, thinkingTokens)
+		if err != nil {
+			return "", Meta{}, err
+		}
+		text, err := parseJSONResponse(stdout)
+		if err != nil {
+			return "", Meta{}, fmt.Errorf("failed to parse CLI response: %w", err)
+		}
+		run := estimatedMeta(model, prompt, text)
+		run.InputTokens += meta.InputTokens
+		run.OutputTokens += meta.OutputTokens
+		run.SchemaRetries = meta.SchemaRetries
+		meta = run
+
+		answer, err := format.check(text)
+		if err == nil {
+			return answer, meta, nil
+		}
+		if meta.SchemaRetries >= format.retries {
+			return "", Meta{}, &SchemaError{Reason: err.Error(), Attempts: meta.SchemaRetries + 1, Response: text}
+		}
+		meta.SchemaRetries++
+		round := format.repair(text, err)
+		prompt += fmt.Sprintf("\n\nAssistant: %s\n\nHuman: %s", round.call.Content, round.result.Content)
+	}
+}
+
+// run executes the claude CLI with input on stdin and returns its stdout.
+// Runs that fail on a rate limit or overload are retried.
+func (c *CLIClient) run(ctx context.Context, args []string, input string, thinkingTokens int) (string, error) {
+	var output string
+	err := c.retry.Do(ctx, func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, "claude", args...)
+		cmd.Stdin = bytes.NewBufferString(input)
+		cmd.Env = append(cmd.Environ(), thinkingEnv(thinkingTokens))
+
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			return &CLIError{Err: err, Stderr: stderr.String()}
+		}
+		output = stdout.String()
+		return nil
+	})
+	return output, err
+}
+
+// thinkingEnv sets the thinking token budget via environment variable
+// -1 = max (31999), 0 = disabled, >0 = specific budget
+func thinkingEnv(thinkingTokens int) string {
+	if thinkingTokens < 0 {
+		return "MAX_THINKING_TOKENS=31999"
+	}
+	return fmt.Sprintf("MAX_THINKING_TOKENS=%d", thinkingTokens)
+}
+
 // parseJSONResponse extracts the result from claude CLI JSON output
 func parseJSONResponse(output string) (string, error) {
 	// Try parsing each line as JSON (CLI may output multiple JSON objects)
@@ -403,14 +575,20 @@ func parseJSONResponse(output string) (string, error) {
 
 // StartStream begins streaming a response for the given prompt
 // Uses text output mode and reads stdout progressively for real streaming
-func (c *CLIClient) StartStream(ctx context.Context, prompt string) error {
-	c.mu.Lock()
-	if c.streaming {
-		c.mu.Unlock()
+func (c *CLIClient) StartStream(ctx context.Context, prompt string, attachments ...Attachment) error {
+	if err := checkAttachments(BackendCLI, attachments, false, false); err != nil {
+		return err
+	}
+	if c.IsStreaming() {
 		return fmt.Errorf("stream already in progress")
 	}
+	// Held until the stream ends
+	if err := c.turn.lock(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
 
-	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+	c.messages = append(c.messages, newPrompt(prompt, attachments))
 	fullPrompt := c.buildPrompt()
 	systemPrompt := c.getSystemPrompt()
 	model := c.model
@@ -423,19 +601,28 @@ func (c *CLIClient) StartStream(ctx context.Context, prompt string) error {
 
 	go func() {
 		var fullResponse string
+		startTime := time.Now()
 
 		defer func() {
 			// Update conversation history with full response
+			var meta Meta
+			if fullResponse != "" {
+				meta = estimatedMeta(model, fullPrompt, fullResponse)
+				meta.done(BackendCLI, startTime)
+			}
 			c.mu.Lock()
 			if fullResponse != "" {
-				c.messages = append(c.messages, Message{Role: "assistant", Content: fullResponse})
-				c.lastTokens = estimateTokens(fullPrompt) + estimateTokens(fullResponse)
-				c.totalTokens += c.lastTokens
+				c.messages = append(c.messages, newMessage("assistant", fullResponse))
+				c.lastMeta = meta
+				c.usage.add(meta)
+				c.lastTokens = meta.InputTokens + meta.OutputTokens
+				c.totalTokens = contextTokens(meta, c.systemPrompt, c.messages)
 			}
 			c.streaming = false
 			close(c.streamChan)
 			close(c.streamDone)
 			c.mu.Unlock()
+			c.turn.unlock()
 		}()
 
 		// Build command for streaming - use text output, not JSON
@@ -454,34 +641,52 @@ func (c *CLIClient) StartStream(ctx context.Context, prompt string) error {
 
 		args = append(args, "-")
 
-		cmd := exec.CommandContext(ctx, "claude", args...)
-		cmd.Stdin = bytes.NewBufferString(fullPrompt)
+		// A run that fails before printing anything is retried
+		err := c.retry.Do(ctx, func(ctx context.Context) error {
+			cmd := exec.CommandContext(ctx, "claude", args...)
+			cmd.Stdin = bytes.NewBufferString(fullPrompt)
+			cmd.Env = append(cmd.Environ(), thinkingEnv(thinkingTokens))
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
 
-		// Set thinking token budget via environment variable
-		cmd.Env = append(cmd.Environ(), func() string {
-			if thinkingTokens < 0 {
-				return "MAX_THINKING_TOKENS=31999"
+			// Get stdout pipe for streaming reads
+			stdout, err := cmd.StdoutPipe()
+			if err != nil {
+				return err
 			}
-			return fmt.Sprintf("MAX_THINKING_TOKENS=%d", thinkingTokens)
-		}())
 
-		// Get stdout pipe for streaming reads
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			select {
-			case c.streamChan <- fmt.Sprintf("[Error: %v]", err):
-			case <-ctx.Done():
+			// Start the command
+			if err := cmd.Start(); err != nil {
+				return err
 			}
-			c.mu.Lock()
-			if len(c.messages) > 0 {
-				c.messages = c.messages[:len(c.messages)-1]
+
+			// Read stdout in chunks and send to channel
+			buf := make([]byte, 256) // Small buffer for responsive streaming
+			for {
+				n, err := stdout.Read(buf)
+				if n > 0 {
+					chunk := string(buf[:n])
+					fullResponse += chunk
+					select {
+					case c.streamChan <- chunk:
+					case <-ctx.Done():
+						cmd.Process.Kill()
+						cmd.Wait()
+						return ctx.Err()
+					}
+				}
+				if err != nil {
+					break // EOF or error
+				}
 			}
-			c.mu.Unlock()
-			return
-		}
 
-		// Start the command
-		if err := cmd.Start(); err != nil {
+			// Wait for command to finish; only a run with no output has failed
+			if err := cmd.Wait(); err != nil && fullResponse == "" {
+				return &CLIError{Err: err, Stderr: stderr.String()}
+			}
+			return nil
+		})
+		if err != nil && fullResponse == "" {
 			select {
 			case c.streamChan <- fmt.Sprintf("[Error: %v]", err):
 			case <-ctx.Done():
@@ -491,42 +696,6 @@ func (c *CLIClient) StartStream(ctx context.Context, prompt string) error {
 				c.messages = c.messages[:len(c.messages)-1]
 			}
 			c.mu.Unlock()
-			return
-		}
-
-		// Read stdout in chunks and send to channel
-		buf := make([]byte, 256) // Small buffer for responsive streaming
-		for {
-			n, err := stdout.Read(buf)
-			if n > 0 {
-				chunk := string(buf[:n])
-				fullResponse += chunk
-				select {
-				case c.streamChan <- chunk:
-				case <-ctx.Done():
-					cmd.Process.Kill()
-					return
-				}
-			}
-			if err != nil {
-				break // EOF or error
-			}
-		}
-
-		// Wait for command to finish
-		if err := cmd.Wait(); err != nil {
-			// Only report error if we got no response
-			if fullResponse == "" {
-				select {
-				case c.streamChan <- fmt.Sprintf("[Error: %v]", err):
-				case <-ctx.Done():
-				}
-				c.mu.Lock()
-				if len(c.messages) > 0 {
-					c.messages = c.messages[:len(c.messages)-1]
-				}
-				c.mu.Unlock()
-			}
 		}
 	}()
 
@@ -565,10 +734,32 @@ func (c *CLIClient) WaitStream() {
 	}
 }
 
+// LastThinking returns "" - the CLI's printed output does not include thinking
+func (c *CLIClient) LastThinking() string {
+	return ""
+}
+
+// ReadThinking returns no text - the CLI does not stream thinking
+func (c *CLIClient) ReadThinking(ctx context.Context, offset int64) (string, bool) {
+	return "", false
+}
+
 // AskWithHistory sends a prompt with explicit message history for per-fid isolation.
 // Unlike Ask(), this does not modify the client's internal messages state.
 // Returns response text and estimated token count.
 func (c *CLIClient) AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error) {
+	reply, err := c.Complete(ctx, history, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.Text, reply.Tokens, nil
+}
+
+// Complete sends a prompt with explicit message history and returns the whole reply.
+func (c *CLIClient) Complete(ctx context.Context, history []Message, prompt string, attachments ...Attachment) (*Reply, error) {
+	if err := checkAttachments(BackendCLI, attachments, false, false); err != nil {
+		return nil, err
+	}
 	// Get settings with lock
 	c.mu.RLock()
 	model := c.model
@@ -591,14 +782,14 @@ func (c *CLIClient) AskWithHistory(ctx context.Context, history []Message, promp
 		case "system":
 			systemParts = append(systemParts, msg.Content)
 		case "user":
-			parts = append(parts, fmt.Sprintf("Human: %s", msg.Content))
+			parts = append(parts, fmt.Sprintf("Human: %s", msg.Text()))
 		case "assistant":
-			parts = append(parts, fmt.Sprintf("Assistant: %s", msg.Content))
+			parts = append(parts, fmt.Sprintf("Assistant: %s", msg.Text()))
 		}
 	}
 
 	// Add the new user prompt
-	parts = append(parts, fmt.Sprintf("Human: %s", prompt))
+	parts = append(parts, fmt.Sprintf("Human: %s", newPrompt(prompt, attachments).Text()))
 
 	fullPrompt := strings.Join(parts, "\n\n")
 	systemPrompt := strings.Join(systemParts, "\n\n")
@@ -612,46 +803,30 @@ func (c *CLIClient) AskWithHistory(ctx context.Context, history []Message, promp
 		"--dangerously-skip-permissions",
 	}
 
+	format := c.schema.format()
+	if format != nil {
+		systemPrompt = strings.TrimSpace(systemPrompt + "\n\n" + format.instruction())
+	}
 	if systemPrompt != "" {
 		args = append(args, "--system-prompt", systemPrompt)
 	}
 
 	args = append(args, "-") // Read from stdin
 
-	cmd := exec.CommandContext(ctx, "claude", args...)
-	cmd.Stdin = bytes.NewBufferString(fullPrompt)
-
-	// Set thinking token budget via environment variable
-	cmd.Env = append(cmd.Environ(), func() string {
-		if thinkingTokens < 0 {
-			return "MAX_THINKING_TOKENS=31999"
-		}
-		return fmt.Sprintf("MAX_THINKING_TOKENS=%d", thinkingTokens)
-	}())
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return "", 0, fmt.Errorf("claude CLI error: %w (stderr: %s)", err, stderr.String())
-	}
-
-	// Parse JSON response
-	responseText, err := parseJSONResponse(stdout.String())
+	startTime := time.Now()
+	responseText, meta, err := c.answer(ctx, args, fullPrompt, model, thinkingTokens, format)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to parse CLI response: %w", err)
+		return nil, err
 	}
 
 	// Prepend prefill to response to keep model in character
 	// Note: CLI doesn't support true prefill (partial assistant message),
-	// so we prepend it to the response for consistent behavior with API client
-	if prefill != "" {
+	// so we prepend it to the response for consistent behavior with API client.
+	// An answer in a schema's JSON has none.
+	if prefill != "" && format == nil {
 		responseText = prefill + responseText
 	}
+	meta.done(BackendCLI, startTime)
 
-	// Estimate tokens: prompt + response (chars / 4)
-	tokens := estimateTokens(fullPrompt) + estimateTokens(responseText)
-
-	return responseText, tokens, nil
+	return &Reply{Text: responseText, Tokens: meta.InputTokens + meta.OutputTokens, Meta: meta}, nil
 }
@@ -5,18 +5,78 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/anthropics/anthropic-sdk-go/packages/param"
 )
 
 // Message represents a single message in a conversation
 type Message struct {
-	Role    string `json:"role"`    // "user" or "assistant"
-	Content string `json:"content"` // message content
+	Role        string       `json:"role"`                   // "user" or "assistant"
+	Content     string       `json:"content"`                // message content
+	ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`   // assistant: tools the model called
+	ToolResults []ToolResult `json:"tool_results,omitempty"` // user: results of the calls before
+	Attachments []Attachment `json:"attachments,omitempty"`  // user: files sent with the prompt
+	Time        time.Time    `json:"time"`                   // when it was added (zero if unknown)
+	Pinned      bool         `json:"pinned,omitempty"`       // kept verbatim by compaction
+}
+
+// newMessage returns a message added now
+func newMessage(role, content string) Message {
+	return Message{Role: role, Content: content, Time: time.Now().UTC()}
+}
+
+// MarshalJSON leaves out the time of a message that has none, such as
+// one from a history saved before times were recorded
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	if !m.Time.IsZero() {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		Role        string       `json:"role"`
+		Content     string       `json:"content"`
+		ToolCalls   []ToolCall   `json:"tool_calls,omitempty"`
+		ToolResults []ToolResult `json:"tool_results,omitempty"`
+		Attachments []Attachment `json:"attachments,omitempty"`
+		Pinned      bool         `json:"pinned,omitempty"`
+	}{m.Role, m.Content, m.ToolCalls, m.ToolResults, m.Attachments, m.Pinned})
+}
+
+// isPrompt reports whether m is a prompt, a user message that starts a
+// turn, rather than one carrying tool results back to the model
+func (m Message) isPrompt() bool {
+	return m.Role == "user" && len(m.ToolResults) == 0
+}
+
+// Text renders the message as plain text, for backends and formats that
+// have no tool or attachment blocks: a placeholder line for each
+// attachment, followed by the content of a text attachment, then the
+// content, then a line for each tool call or result
+func (m Message) Text() string {
+	var lines []string
+	for _, a := range m.Attachments {
+		lines = append(lines, a.text())
+	}
+	if m.Content != "" {
+		lines = append(lines, m.Content)
+	}
+	for _, call := range m.ToolCalls {
+		lines = append(lines, fmt.Sprintf("[tool call %s %s: %s]", call.ID, call.Name, call.Input))
+	}
+	for _, result := range m.ToolResults {
+		status := "result"
+		if result.IsError {
+			status = "error"
+		}
+		lines = append(lines, fmt.Sprintf("[tool %s %s: %s]", status, result.CallID, result.Content))
+	}
+	return strings.Join(lines, "\n")
 }
 
 // MetricsCallback is called after each LLM request with performance data
@@ -47,24 +107,58 @@ type Client struct {
 	prefill        string // assistant response prefill for keeping model in character
 	messages       []Message
 	lastTokens     int
-	totalTokens    int // cumulative token count for context tracking
-	thinkingTokens int // 0 = disabled, >0 = budget, -1 = max (default for CLI, not used for API yet)
+	totalTokens    int // context size, see TotalTokens
+	thinkingTokens int // 0 = disabled, >0 = budget, -1 = max
+	params         Params
+	continuations  int    // max automatic continuations at max_tokens
+	lastThinking   string // thinking text of the last response
+	lastMeta       Meta
+	usage          Usage
+	compact        CompactPolicy // how Compact shrinks the history
+	cache          string        // prompt caching mode, CacheOn, ...
+	turn           exchangeLock  // held by an exchange: Ask, stream or Compact
 	streaming      bool
 	streamChan     chan string
 	streamDone     chan struct{}
+	thinking       *thinkingStream // thinking text of the current/last stream
+	retry          *Retrier
+	tools          *Toolbox
+	schema         *Schema
 }
 
 // NewClient creates a new LLM client
 func NewClient(apiKey string) *Client {
-	client := anthropic.NewClient(option.WithAPIKey(apiKey))
+	// Retries are handled by our own Retrier so they are visible and configurable
+	client := anthropic.NewClient(option.WithAPIKey(apiKey), option.WithMaxRetries(0))
 	return &Client{
 		client:      client,
 		model:       "claude-sonnet-4-20250514",
 		temperature: 0.7,
 		messages:    make([]Message, 0),
+		retry:       NewRetrier(DefaultRetryPolicy),
+		tools:       NewToolbox(),
+		schema:      NewSchema(),
+		compact:     DefaultCompactPolicy,
+		cache:       CacheOn,
+		usage:       Usage{},
 	}
 }
 
+// Retrier returns the retry policy and status for this client's requests
+func (c *Client) Retrier() *Retrier {
+	return c.retry
+}
+
+// Toolbox returns the tools offered to this client's conversations
+func (c *Client) Toolbox() *Toolbox {
+	return c.tools
+}
+
+// Schema returns the JSON Schema this client's answers must match
+func (c *Client) Schema() *Schema {
+	return c.schema
+}
+
 // Model returns the current model name
 func (c *Client) Model() string {
 	c.mu.RLock()
@@ -98,21 +192,124 @@ func (c *Client) SetTemperature(temp float64) error {
 }
 
 // ThinkingTokens returns the current thinking token budget
-// Note: API backend does not currently use extended thinking
 func (c *Client) ThinkingTokens() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.thinkingTokens
 }
 
-// SetThinkingTokens sets the thinking token budget
-// Note: API backend does not currently use extended thinking
+// SetThinkingTokens sets the thinking token budget. The setting is kept
+// as given; requests raise a budget below the API minimum of 1024 to it
+// (see thinkingBudget).
 func (c *Client) SetThinkingTokens(tokens int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.thinkingTokens = tokens
 }
 
+// LastThinking returns the model's thinking for the last response
+func (c *Client) LastThinking() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastThinking
+}
+
+// LastMeta returns how the last response ended
+func (c *Client) LastMeta() Meta {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastMeta
+}
+
+// Usage returns the tokens the conversation has used, by model, since it
+// began or was last reset
+func (c *Client) Usage() Usage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.usage.clone()
+}
+
+// MaxContinuations returns the automatic continuation limit
+func (c *Client) MaxContinuations() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.continuations
+}
+
+// SetMaxContinuations sets how many times a response that stops at
+// max_tokens is continued
+func (c *Client) SetMaxContinuations(n int) error {
+	if err := validateContinuations(n); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.continuations = n
+	return nil
+}
+
+// CompactPolicy returns how Compact shrinks the history
+func (c *Client) CompactPolicy() CompactPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.compact
+}
+
+// SetCompactPolicy sets how Compact shrinks the history
+func (c *Client) SetCompactPolicy(p CompactPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compact = p
+	return nil
+}
+
+// anthropicParams are the generation parameters the Messages API accepts
+var anthropicParams = []string{ParamMaxTokens, ParamTopP, ParamTopK, ParamStop}
+
+// Cache returns the prompt caching mode
+func (c *Client) Cache() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cache
+}
+
+// SetCache sets the prompt caching mode for subsequent requests
+func (c *Client) SetCache(mode string) error {
+	if err := validateCache(mode); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = mode
+	return nil
+}
+
+// Params returns the generation parameters
+func (c *Client) Params() Params {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.params.clone()
+}
+
+// SetParams sets the generation parameters for subsequent requests
+func (c *Client) SetParams(p Params) error {
+	if err := checkParams(p, anthropicParams); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.params = p.clone()
+	return nil
+}
+
+// SupportedParams lists the generation parameters the API backend applies
+func (c *Client) SupportedParams() []string {
+	return anthropicParams
+}
+
 // Prefill returns the assistant response prefill string
 func (c *Client) Prefill() string {
 	c.mu.RLock()
@@ -169,7 +366,23 @@ func (c *Client) AddSystemMessage(content string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	// System messages are prepended to conversations
-	c.messages = append([]Message{{Role: "system", Content: content}}, c.messages...)
+	msg := newMessage("system", content)
+	c.messages = append([]Message{msg}, c.messages...)
+	c.totalTokens += estimateMessage(msg)
+}
+
+// SetMessages replaces the conversation history
+func (c *Client) SetMessages(msgs []Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(make([]Message, 0, len(msgs)), msgs...)
+	c.totalTokens = estimateContext(c.systemPrompt, c.messages)
+}
+
+// ValidateMessages checks that msgs can be sent to the Messages API,
+// which requires user and assistant turns to alternate from user
+func (c *Client) ValidateMessages(msgs []Message) error {
+	return CheckMessages(msgs, true)
 }
 
 // Reset clears the conversation history
@@ -179,9 +392,12 @@ func (c *Client) Reset() {
 	c.messages = make([]Message, 0)
 	c.lastTokens = 0
 	c.totalTokens = 0
+	c.lastThinking = ""
+	c.lastMeta = Meta{}
+	c.usage = Usage{}
 }
 
-// TotalTokens returns cumulative token count for this conversation
+// TotalTokens returns the context size of the conversation
 func (c *Client) TotalTokens() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -196,60 +412,86 @@ func (c *Client) ContextLimit() int {
 	return contextLimitForModel(model)
 }
 
-// Compact summarizes the conversation to reduce token usage
+// Compact shrinks the conversation according to the compaction policy
 func (c *Client) Compact(ctx context.Context) error {
-	c.mu.Lock()
-	if len(c.messages) < 4 {
-		c.mu.Unlock()
-		return nil // Not enough to compact
+	if err := c.turn.lock(ctx); err != nil {
+		return err
 	}
+	defer c.turn.unlock()
+	c.mu.Lock()
+	msgs := append([]Message(nil), c.messages...)
+	policy := c.compact
+	c.mu.Unlock()
 
-	// Build conversation text for summarization
-	var conversationText string
-	for _, msg := range c.messages {
-		if msg.Role == "system" {
-			continue // Don't include system messages in summary
-		}
-		conversationText += fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content)
+	compacted, err := compactMessages(ctx, msgs, policy, c.Summarize)
+	if err != nil || compacted == nil {
+		return err
 	}
 
-	model := c.model
+	c.mu.Lock()
+	c.messages = compacted
+	c.totalTokens = estimateContext(c.systemPrompt, compacted)
 	c.mu.Unlock()
+	return nil
+}
 
-	// Use a compact summarization prompt
-	summaryPrompt := "Summarize this conversation concisely, preserving key facts, decisions, and context needed to continue:\n\n" + conversationText
-
-	// Build API request for summarization
-	apiMessages := []anthropic.MessageParam{
-		anthropic.NewUserMessage(anthropic.NewTextBlock(summaryPrompt)),
+// Summarize asks model (the conversation's model if empty) for a summary
+// outside the conversation
+func (c *Client) Summarize(ctx context.Context, model, prompt string) (string, int, error) {
+	if model == "" {
+		model = c.Model()
 	}
-
 	params := anthropic.MessageNewParams{
 		Model:     anthropic.Model(model),
 		MaxTokens: 2048,
-		Messages:  apiMessages,
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
+		},
 	}
 
-	response, err := c.client.Messages.New(ctx, params)
+	var response *anthropic.Message
+	err := c.retry.Do(ctx, func(ctx context.Context) (err error) {
+		response, err = c.client.Messages.New(ctx, params)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("compaction failed: %w", err)
+		return "", 0, err
 	}
 
-	// Extract summary
 	var summary string
 	for _, block := range response.Content {
 		if block.Type == "text" {
 			summary += block.Text
 		}
 	}
+	meta := Meta{Model: string(response.Model), Backend: BackendAPI}
+	addUsage(&meta, response.Usage)
+	recordLifetime(meta)
+	return summary, int(response.Usage.InputTokens + response.Usage.OutputTokens), nil
+}
 
-	// Replace conversation with summary
-	c.mu.Lock()
-	c.messages = []Message{{Role: "system", Content: "Previous conversation summary: " + summary}}
-	c.totalTokens = int(response.Usage.InputTokens + response.Usage.OutputTokens)
-	c.mu.Unlock()
+// CountTokens counts text as a user message with the count_tokens
+// endpoint, falling back to an estimate if the request fails
+func (c *Client) CountTokens(ctx context.Context, text string) (TokenCount, error) {
+	if text == "" {
+		return estimateCount(text), nil // the API rejects empty messages
+	}
+	params := anthropic.MessageCountTokensParams{
+		Model: anthropic.Model(c.Model()),
+		Messages: []anthropic.MessageParam{
+			anthropic.NewUserMessage(anthropic.NewTextBlock(text)),
+		},
+	}
 
-	return nil
+	var count *anthropic.MessageTokensCount
+	err := c.retry.Do(ctx, func(ctx context.Context) (err error) {
+		count, err = c.client.Messages.CountTokens(ctx, params)
+		return err
+	})
+	if err != nil {
+		return countFallback(ctx, CountAPI, text, err)
+	}
+	return TokenCount{Tokens: int(count.InputTokens), Method: CountAPI}, nil
 }
 
 // contextLimitForModel returns the context window size for a model
@@ -268,62 +510,50 @@ func contextLimitForModel(model string) int {
 	}
 }
 
-// Ask sends a prompt to the LLM and returns the response
-func (c *Client) Ask(ctx context.Context, prompt string) (string, error) {
+// Ask sends a prompt, with any attachments, to the LLM and returns the
+// response
+func (c *Client) Ask(ctx context.Context, prompt string, attachments ...Attachment) (string, error) {
+	if err := checkAttachments(BackendAPI, attachments, true, true); err != nil {
+		return "", err
+	}
+	if err := c.turn.lock(ctx); err != nil {
+		return "", err
+	}
+	defer c.turn.unlock()
 	c.mu.Lock()
 	// Add user message to history
-	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+	c.messages = append(c.messages, newPrompt(prompt, attachments))
 
 	// Build the API messages from conversation history
-	apiMessages := make([]anthropic.MessageParam, 0, len(c.messages))
-	var systemBlocks []anthropic.TextBlockParam
-
-	// Add dedicated system prompt first
-	if c.systemPrompt != "" {
-		systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
-			Text: c.systemPrompt,
-		})
-	}
-
-	for _, msg := range c.messages {
-		switch msg.Role {
-		case "system":
-			// Also include system messages from conversation history
-			systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
-				Text: msg.Content,
-			})
-		case "user":
-			apiMessages = append(apiMessages, anthropic.NewUserMessage(
-				anthropic.NewTextBlock(msg.Content),
-			))
-		case "assistant":
-			apiMessages = append(apiMessages, anthropic.NewAssistantMessage(
-				anthropic.NewTextBlock(msg.Content),
-			))
-		}
-	}
+	systemBlocks, apiMessages := anthropicMessages(c.systemPrompt, c.messages)
 
 	model := c.model
 	temp := c.temperature
+	thinkingTokens := c.thinkingTokens
+	genParams := c.params.clone()
+	continuations := c.continuations
+	cache := c.cache
 	c.mu.Unlock()
 
 	// Build request params
 	params := anthropic.MessageNewParams{
 		Model:       anthropic.Model(model),
-		MaxTokens:   4096,
+		MaxTokens:   maxResponseTokens,
 		Messages:    apiMessages,
 		Temperature: anthropic.Float(temp),
 	}
+	applyParams(&params, genParams)
+	applyThinking(&params, thinkingTokens)
 
 	// Add system prompt if present
 	if len(systemBlocks) > 0 {
 		params.System = systemBlocks
 	}
+	applyCache(&params, cache)
 
 	// Make the API call with timing
 	startTime := time.Now()
-	response, err := c.client.Messages.New(ctx, params)
-	latencyMs := time.Since(startTime).Milliseconds()
+	reply, err := c.exchange(ctx, params, "", continuations)
 
 	if err != nil {
 		// Remove the user message on error
@@ -335,75 +565,163 @@ func (c *Client) Ask(ctx context.Context, prompt string) (string, error) {
 		return "", fmt.Errorf("API error: %w", err)
 	}
 
-	// Extract response text
-	var responseText string
-	for _, block := range response.Content {
-		if block.Type == "text" {
-			responseText += block.Text
-		}
-	}
+	// Record timing and metrics (input and output tokens separately for analysis)
+	reply.done(BackendAPI, startTime)
 
 	// Update state
 	c.mu.Lock()
-	c.messages = append(c.messages, Message{Role: "assistant", Content: responseText})
-	c.lastTokens = int(response.Usage.InputTokens + response.Usage.OutputTokens)
-	c.totalTokens += c.lastTokens
+	c.messages = append(c.messages, reply.Steps...)
+	c.messages = append(c.messages, newMessage("assistant", reply.Text))
+	c.lastThinking = reply.Thinking
+	c.lastMeta = reply.Meta
+	c.usage.add(reply.Meta)
+	c.lastTokens = reply.Tokens
+	c.totalTokens = contextTokens(reply.Meta, c.systemPrompt, c.messages)
 	c.mu.Unlock()
 
-	// Record metrics (input and output tokens separately for analysis)
-	inputToks := int(response.Usage.InputTokens)
-	outputToks := int(response.Usage.OutputTokens)
-	RecordMetrics(inputToks, outputToks, latencyMs)
+	return reply.Text, nil
+}
+
+// send makes a request and, while the response stops at max_tokens, up to
+// maxContinuations follow-up requests that send the text so far back as the
+// start of the assistant turn. The pieces, after any prefill, are joined
+// into one reply, whose usage covers all the requests.
+func (c *Client) send(ctx context.Context, params anthropic.MessageNewParams, prefill string, maxContinuations int) (*Reply, *anthropic.Message, error) {
+	messages := params.Messages
+	reply := &Reply{Text: prefill}
+	var response *anthropic.Message
+	for {
+		if prefix := continuationPrefix(reply.Text); prefix != "" {
+			params.Messages = append(messages[:len(messages):len(messages)],
+				anthropic.NewAssistantMessage(anthropic.NewTextBlock(prefix)))
+		}
 
-	return responseText, nil
+		var httpResp *http.Response
+		err := c.retry.Do(ctx, func(ctx context.Context) (err error) {
+			response, err = c.client.Messages.New(ctx, params, option.WithResponseInto(&httpResp))
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		text, thinking := responseContent(response)
+		reply.Text = joinContinuation(reply.Text, text)
+		reply.Thinking += thinking
+		reply.Model = string(response.Model)
+		reply.RequestID = requestID(httpResp, "request-id", response.ID)
+		reply.StopReason = string(response.StopReason)
+		addUsage(&reply.Meta, response.Usage)
+
+		if reply.StopReason != StopMaxTokens || reply.Continuations >= maxContinuations {
+			break
+		}
+		reply.Continuations++
+		params = continuationParams(params)
+	}
+	reply.Calls = responseCalls(response)
+	reply.Tokens = reply.InputTokens + reply.OutputTokens
+	return reply, response, nil
+}
+
+// exchange sends a prompt: the requests of send, within the agent loop
+// when there are tools or a schema. The prefill starts only the first
+// response. With a schema there is no prefill, and the model is made to
+// answer by calling a tool whose input is the schema; thinking cannot be
+// combined with a forced tool, so with thinking the schema is only asked
+// for in the system prompt.
+func (c *Client) exchange(ctx context.Context, params anthropic.MessageNewParams, prefill string, maxContinuations int) (*Reply, error) {
+	format := c.schema.format()
+	answerTool := format != nil && params.Thinking.GetBudgetTokens() == nil
+	if format != nil {
+		prefill = ""
+		if !answerTool {
+			params.System = append(params.System, anthropic.TextBlockParam{Text: format.instruction()})
+		}
+	}
+	var last *anthropic.Message
+	return c.tools.runTools(ctx, format, func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error) {
+		if len(tools) > 0 {
+			params.Tools = anthropicTools(tools)
+		}
+		if round != nil {
+			params.Messages = append(params.Messages, anthropicAssistant(round.call, last), anthropicUser(round.result))
+			prefill = ""
+			if round.last {
+				params.ToolChoice = anthropic.ToolChoiceUnionParam{OfToolChoiceNone: &anthropic.ToolChoiceNoneParam{}}
+			}
+		}
+		if answerTool {
+			// Any tool while the model may still call its own, else the answer
+			params.Tools = anthropicTools(append(tools[:len(tools):len(tools)], format.tool()))
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfToolChoiceTool: &anthropic.ToolChoiceToolParam{Name: answerToolName}}
+			if len(tools) > 0 && (round == nil || !round.last) {
+				params.ToolChoice = anthropic.ToolChoiceUnionParam{OfToolChoiceAny: &anthropic.ToolChoiceAnyParam{}}
+			}
+		}
+		reply, response, err := c.send(ctx, params, prefill, maxContinuations)
+		last = response
+		if err == nil && answerTool {
+			takeAnswer(reply, format)
+		}
+		return reply, err
+	})
+}
+
+// addUsage adds a response's token usage to meta. The context is the
+// response's whole prompt, cached or not, and the response.
+func addUsage(meta *Meta, usage anthropic.Usage) {
+	meta.InputTokens += int(usage.InputTokens)
+	meta.OutputTokens += int(usage.OutputTokens)
+	meta.CacheReadTokens += int(usage.CacheReadInputTokens)
+	meta.CacheWriteTokens += int(usage.CacheCreationInputTokens)
+	meta.ContextTokens = int(usage.InputTokens + usage.CacheReadInputTokens +
+		usage.CacheCreationInputTokens + usage.OutputTokens)
+}
+
+// continuationParams adapts a request for continuing its response. The API
+// does not accept thinking with a prefilled assistant turn, so it is
+// dropped, and with it the budget's share of max_tokens.
+func continuationParams(params anthropic.MessageNewParams) anthropic.MessageNewParams {
+	if budget := params.Thinking.GetBudgetTokens(); budget != nil {
+		params.MaxTokens -= *budget
+		params.Thinking = anthropic.ThinkingConfigParamUnion{}
+	}
+	return params
 }
 
 // StartStream begins streaming a response for the given prompt
-func (c *Client) StartStream(ctx context.Context, prompt string) error {
-	c.mu.Lock()
-	if c.streaming {
-		c.mu.Unlock()
+func (c *Client) StartStream(ctx context.Context, prompt string, attachments ...Attachment) error {
+	if err := checkAttachments(BackendAPI, attachments, true, true); err != nil {
+		return err
+	}
+	if c.IsStreaming() {
 		return fmt.Errorf("stream already in progress")
 	}
+	// Held until the stream ends
+	if err := c.turn.lock(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
 
 	// Add user message to history
-	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
+	c.messages = append(c.messages, newPrompt(prompt, attachments))
 
 	// Build the API messages from conversation history
-	apiMessages := make([]anthropic.MessageParam, 0, len(c.messages))
-	var systemBlocks []anthropic.TextBlockParam
-
-	// Add dedicated system prompt first
-	if c.systemPrompt != "" {
-		systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
-			Text: c.systemPrompt,
-		})
-	}
-
-	for _, msg := range c.messages {
-		switch msg.Role {
-		case "system":
-			// Also include system messages from conversation history
-			systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
-				Text: msg.Content,
-			})
-		case "user":
-			apiMessages = append(apiMessages, anthropic.NewUserMessage(
-				anthropic.NewTextBlock(msg.Content),
-			))
-		case "assistant":
-			apiMessages = append(apiMessages, anthropic.NewAssistantMessage(
-				anthropic.NewTextBlock(msg.Content),
-			))
-		}
-	}
+	systemBlocks, apiMessages := anthropicMessages(c.systemPrompt, c.messages)
 
 	model := c.model
 	temp := c.temperature
+	thinkingTokens := c.thinkingTokens
+	genParams := c.params.clone()
+	continuations := c.continuations
+	cache := c.cache
 
 	c.streaming = true
 	c.streamChan = make(chan string, 100)
 	c.streamDone = make(chan struct{})
+	c.thinking = newThinkingStream()
+	thinking := c.thinking
 	c.mu.Unlock()
 
 	// Start streaming in a goroutine
@@ -414,49 +732,91 @@ func (c *Client) StartStream(ctx context.Context, prompt string) error {
 			close(c.streamChan)
 			close(c.streamDone)
 			c.mu.Unlock()
+			thinking.close()
+			c.turn.unlock()
 		}()
 
 		// Build request params
 		params := anthropic.MessageNewParams{
 			Model:       anthropic.Model(model),
-			MaxTokens:   4096,
+			MaxTokens:   maxResponseTokens,
 			Messages:    apiMessages,
 			Temperature: anthropic.Float(temp),
 		}
+		applyParams(&params, genParams)
+		applyThinking(&params, thinkingTokens)
 
 		if len(systemBlocks) > 0 {
 			params.System = systemBlocks
 		}
+		applyCache(&params, cache)
+
+		var fullResponse, fullThinking string
+		var meta Meta
+		messages := params.Messages
+		startTime := time.Now()
+
+		// Use streaming; a stream that fails before producing any text is
+		// retried, and one that stops at max_tokens may be continued
+		var err error
+		for {
+			if prefix := continuationPrefix(fullResponse); prefix != "" {
+				params.Messages = append(messages[:len(messages):len(messages)],
+					anthropic.NewAssistantMessage(anthropic.NewTextBlock(prefix)))
+			}
 
-		// Use streaming
-		stream := c.client.Messages.NewStreaming(ctx, params)
-
-		var fullResponse string
-		var inputTokens, outputTokens int64
-
-		for stream.Next() {
-			event := stream.Current()
-
-			switch event.Type {
-			case "content_block_delta":
-				delta := event.Delta
-				if delta.Type == "text_delta" {
-					chunk := delta.Text
-					fullResponse += chunk
-					select {
-					case c.streamChan <- chunk:
-					case <-ctx.Done():
-						return
+			var streamed bool
+			var usage anthropic.Usage
+			var httpResp *http.Response
+			err = c.retry.Do(ctx, func(ctx context.Context) error {
+				overlap := continuationOverlap(fullResponse)
+				stream := c.client.Messages.NewStreaming(ctx, params, option.WithResponseInto(&httpResp))
+				for stream.Next() {
+					event := stream.Current()
+
+					switch event.Type {
+					case "content_block_delta":
+						delta := event.Delta
+						switch delta.Type {
+						case "text_delta":
+							chunk := delta.Text
+							if chunk, overlap = dropOverlap(chunk, overlap); chunk == "" {
+								continue
+							}
+							streamed = true
+							fullResponse += chunk
+							select {
+							case c.streamChan <- chunk:
+							case <-ctx.Done():
+								return ctx.Err()
+							}
+						case "thinking_delta":
+							streamed = true
+							fullThinking += delta.Thinking
+							thinking.append(delta.Thinking)
+						}
+					case "message_delta":
+						usage.OutputTokens = event.Usage.OutputTokens
+						meta.StopReason = event.Delta.StopReason
+					case "message_start":
+						usage = event.Message.Usage
+						meta.Model = string(event.Message.Model)
+						meta.RequestID = requestID(httpResp, "request-id", event.Message.ID)
 					}
 				}
-			case "message_delta":
-				outputTokens = event.Usage.OutputTokens
-			case "message_start":
-				inputTokens = event.Message.Usage.InputTokens
+				if err := stream.Err(); err != nil && streamed {
+					return fatal(err)
+				}
+				return stream.Err()
+			})
+			addUsage(&meta, usage)
+			if err != nil || meta.StopReason != StopMaxTokens || meta.Continuations >= continuations {
+				break
 			}
+			meta.Continuations++
+			params = continuationParams(params)
 		}
-
-		if err := stream.Err(); err != nil {
+		if err != nil {
 			// Send error as chunk
 			select {
 			case c.streamChan <- fmt.Sprintf("\n[Error: %v]", err):
@@ -471,11 +831,16 @@ func (c *Client) StartStream(ctx context.Context, prompt string) error {
 			return
 		}
 
+		meta.done(BackendAPI, startTime)
+
 		// Update state with complete response
 		c.mu.Lock()
-		c.messages = append(c.messages, Message{Role: "assistant", Content: fullResponse})
-		c.lastTokens = int(inputTokens + outputTokens)
-		c.totalTokens += c.lastTokens
+		c.messages = append(c.messages, newMessage("assistant", fullResponse))
+		c.lastThinking = fullThinking
+		c.lastMeta = meta
+		c.usage.add(meta)
+		c.lastTokens = meta.InputTokens + meta.OutputTokens
+		c.totalTokens = contextTokens(meta, c.systemPrompt, c.messages)
 		c.mu.Unlock()
 	}()
 
@@ -497,6 +862,15 @@ func (c *Client) ReadStreamChunk() (string, bool) {
 	return chunk, ok
 }
 
+// ReadThinking returns the current stream's thinking text from offset,
+// blocking until there is some. Returns false when there is no more.
+func (c *Client) ReadThinking(ctx context.Context, offset int64) (string, bool) {
+	c.mu.RLock()
+	thinking := c.thinking
+	c.mu.RUnlock()
+	return thinking.read(ctx, offset)
+}
+
 // IsStreaming returns whether a stream is currently in progress
 func (c *Client) IsStreaming() bool {
 	c.mu.RLock()
@@ -519,96 +893,237 @@ func (c *Client) WaitStream() {
 // Unlike Ask(), this does not modify the client's internal messages state.
 // Returns response text and token count.
 func (c *Client) AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error) {
+	reply, err := c.Complete(ctx, history, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.Text, reply.Tokens, nil
+}
+
+// Complete sends a prompt with explicit message history and returns the
+// whole reply. Like AskWithHistory, it leaves the client's state alone.
+func (c *Client) Complete(ctx context.Context, history []Message, prompt string, attachments ...Attachment) (*Reply, error) {
+	if err := checkAttachments(BackendAPI, attachments, true, true); err != nil {
+		return nil, err
+	}
 	// Get settings with lock
 	c.mu.RLock()
 	model := c.model
 	temp := c.temperature
 	systemPrompt := c.systemPrompt
 	prefill := c.prefill
+	thinkingTokens := c.thinkingTokens
+	genParams := c.params.clone()
+	continuations := c.continuations
+	cache := c.cache
 	c.mu.RUnlock()
 
-	// Build API messages from provided history plus the new prompt
-	apiMessages := make([]anthropic.MessageParam, 0, len(history)+2)
-	var systemBlocks []anthropic.TextBlockParam
-
-	// Add dedicated system prompt first
-	if systemPrompt != "" {
-		systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
-			Text: systemPrompt,
-		})
+	// The API does not accept a prefilled assistant turn with thinking enabled
+	if thinkingTokens != 0 {
+		prefill = ""
 	}
 
-	for _, msg := range history {
-		switch msg.Role {
-		case "system":
-			systemBlocks = append(systemBlocks, anthropic.TextBlockParam{
-				Text: msg.Content,
-			})
-		case "user":
-			apiMessages = append(apiMessages, anthropic.NewUserMessage(
-				anthropic.NewTextBlock(msg.Content),
-			))
-		case "assistant":
-			apiMessages = append(apiMessages, anthropic.NewAssistantMessage(
-				anthropic.NewTextBlock(msg.Content),
-			))
-		}
-	}
+	// Build the API messages from provided history plus the new prompt
+	systemBlocks, apiMessages := anthropicMessages(systemPrompt, history)
 
 	// Add the new user prompt
-	apiMessages = append(apiMessages, anthropic.NewUserMessage(
-		anthropic.NewTextBlock(prompt),
-	))
-
-	// Add prefill as partial assistant message to keep model in character
-	// The model will continue from this point
-	if prefill != "" {
-		apiMessages = append(apiMessages, anthropic.NewAssistantMessage(
-			anthropic.NewTextBlock(prefill),
-		))
-	}
+	apiMessages = append(apiMessages, anthropicUser(newPrompt(prompt, attachments)))
 
 	// Build request params
 	params := anthropic.MessageNewParams{
 		Model:       anthropic.Model(model),
-		MaxTokens:   4096,
+		MaxTokens:   maxResponseTokens,
 		Messages:    apiMessages,
 		Temperature: anthropic.Float(temp),
 	}
+	applyParams(&params, genParams)
+	applyThinking(&params, thinkingTokens)
 
 	// Add system prompt if present
 	if len(systemBlocks) > 0 {
 		params.System = systemBlocks
 	}
+	applyCache(&params, cache)
 
-	// Make the API call with timing
+	// Make the API call with timing. The prefill is sent as a partial
+	// assistant message to keep the model in character; the model continues
+	// from it and the reply includes it.
 	startTime := time.Now()
-	response, err := c.client.Messages.New(ctx, params)
-	latencyMs := time.Since(startTime).Milliseconds()
-
+	reply, err := c.exchange(ctx, params, prefill, continuations)
 	if err != nil {
-		return "", 0, fmt.Errorf("API error: %w", err)
+		return nil, fmt.Errorf("API error: %w", err)
 	}
 
-	// Extract response text
-	var responseText string
-	for _, block := range response.Content {
-		if block.Type == "text" {
-			responseText += block.Text
+	// Record timing and metrics
+	reply.done(BackendAPI, startTime)
+
+	return reply, nil
+}
+
+// applyParams sets the generation parameters that are not left to default
+func applyParams(params *anthropic.MessageNewParams, p Params) {
+	if p.MaxTokens > 0 {
+		params.MaxTokens = int64(p.MaxTokens)
+	}
+	if p.TopP > 0 {
+		params.TopP = anthropic.Float(p.TopP)
+	}
+	if p.TopK > 0 {
+		params.TopK = anthropic.Int(int64(p.TopK))
+	}
+	params.StopSequences = p.Stop
+}
+
+// applyThinking enables extended thinking when tokens is not 0. The budget
+// comes on top of max_tokens, and the API does not allow sampling settings.
+func applyThinking(params *anthropic.MessageNewParams, tokens int) {
+	budget := thinkingBudget(tokens)
+	if budget == 0 {
+		return
+	}
+	params.Thinking = anthropic.ThinkingConfigParamOfThinkingConfigEnabled(int64(budget))
+	params.MaxTokens += int64(budget)
+	params.Temperature = param.Opt[float64]{}
+	params.TopP = param.Opt[float64]{}
+	params.TopK = param.Opt[int64]{}
+}
+
+// anthropicMessages converts a history to the system blocks, for the
+// system prompt and the system messages, and the messages of a request
+func anthropicMessages(systemPrompt string, history []Message) ([]anthropic.TextBlockParam, []anthropic.MessageParam) {
+	var system []anthropic.TextBlockParam
+	if systemPrompt != "" {
+		system = append(system, anthropic.TextBlockParam{Text: systemPrompt})
+	}
+	msgs := make([]anthropic.MessageParam, 0, len(history)+1)
+	for _, msg := range history {
+		switch msg.Role {
+		case "system":
+			system = append(system, anthropic.TextBlockParam{Text: msg.Content})
+		case "user":
+			msgs = append(msgs, anthropicUser(msg))
+		case "assistant":
+			msgs = append(msgs, anthropicAssistant(msg, nil))
 		}
 	}
+	return system, msgs
+}
 
-	// Prepend prefill to response (it was used as partial assistant message)
-	if prefill != "" {
-		responseText = prefill + responseText
+// anthropicUser converts a user message, which may carry tool results or
+// attachments
+func anthropicUser(msg Message) anthropic.MessageParam {
+	var blocks []anthropic.ContentBlockParamUnion
+	for _, result := range msg.ToolResults {
+		blocks = append(blocks, anthropic.NewToolResultBlock(result.CallID, result.Content, result.IsError))
 	}
+	for _, a := range msg.Attachments {
+		blocks = append(blocks, anthropicAttachment(a))
+	}
+	if msg.Content != "" || len(blocks) == 0 {
+		blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
+	}
+	return anthropic.NewUserMessage(blocks...)
+}
 
-	tokens := int(response.Usage.InputTokens + response.Usage.OutputTokens)
+// anthropicAttachment converts an attachment to a base64 image block or
+// a document block, titled with its name; one without data is sent as
+// its placeholder
+func anthropicAttachment(a Attachment) anthropic.ContentBlockParamUnion {
+	var source anthropic.DocumentBlockParamSourceUnion
+	switch {
+	case len(a.Data) == 0:
+		return anthropic.NewTextBlock(a.placeholder())
+	case a.IsImage():
+		return anthropic.NewImageBlockBase64(a.MediaType, a.base64())
+	case a.MediaType == MediaPDF:
+		source.OfBase64PDFSource = &anthropic.Base64PDFSourceParam{Data: a.base64()}
+	default:
+		source.OfPlainTextSource = &anthropic.PlainTextSourceParam{Data: string(a.Data)}
+	}
+	return anthropic.ContentBlockParamUnion{OfRequestDocumentBlock: &anthropic.DocumentBlockParam{
+		Source: source,
+		Title:  anthropic.String(a.Name),
+	}}
+}
 
-	// Record metrics
-	inputToks := int(response.Usage.InputTokens)
-	outputToks := int(response.Usage.OutputTokens)
-	RecordMetrics(inputToks, outputToks, latencyMs)
+// anthropicAssistant converts an assistant message, which may call tools,
+// starting it with the thinking blocks of the response it came from, if
+// any: the API needs them back while the exchange goes on
+func anthropicAssistant(msg Message, response *anthropic.Message) anthropic.MessageParam {
+	var blocks []anthropic.ContentBlockParamUnion
+	if response != nil {
+		for _, block := range response.ToParam().Content {
+			if block.OfRequestThinkingBlock != nil || block.OfRequestRedactedThinkingBlock != nil {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	if msg.Content != "" || len(msg.ToolCalls) == 0 {
+		blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
+	}
+	for _, call := range msg.ToolCalls {
+		blocks = append(blocks, anthropic.ContentBlockParamUnion{OfRequestToolUseBlock: &anthropic.ToolUseBlockParam{
+			ID:    call.ID,
+			Name:  call.Name,
+			Input: call.Input,
+		}})
+	}
+	return anthropic.NewAssistantMessage(blocks...)
+}
 
-	return responseText, tokens, nil
+// anthropicTools converts tools to their API form
+func anthropicTools(tools []Tool) []anthropic.ToolUnionParam {
+	params := make([]anthropic.ToolUnionParam, 0, len(tools))
+	for _, tool := range tools {
+		schema := tool.schema()
+		input := anthropic.ToolInputSchemaParam{Properties: schema["properties"], ExtraFields: map[string]any{}}
+		for k, v := range schema {
+			if k != "type" && k != "properties" {
+				input.ExtraFields[k] = v
+			}
+		}
+		t := anthropic.ToolParam{Name: tool.Name, InputSchema: input}
+		if tool.Description != "" {
+			t.Description = anthropic.String(tool.Description)
+		}
+		params = append(params, anthropic.ToolUnionParam{OfTool: &t})
+	}
+	return params
+}
+
+// takeAnswer makes a call of the answer tool the reply's text, dropping
+// any other calls made with it
+func takeAnswer(reply *Reply, format *answerFormat) {
+	for _, call := range reply.Calls {
+		if call.Name == answerToolName {
+			reply.Text = format.fromTool(call.Input)
+			reply.Calls = nil
+			reply.StopReason = StopEndTurn
+			return
+		}
+	}
+}
+
+// responseCalls returns the tool calls of a response
+func responseCalls(response *anthropic.Message) []ToolCall {
+	var calls []ToolCall
+	for _, block := range response.Content {
+		if block.Type == "tool_use" {
+			calls = append(calls, ToolCall{ID: block.ID, Name: block.Name, Input: block.Input})
+		}
+	}
+	return calls
+}
+
+// responseContent returns the text and thinking blocks of a response
+func responseContent(response *anthropic.Message) (text, thinking string) {
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			text += block.Text
+		case "thinking":
+			thinking += block.Thinking
+		}
+	}
+	return text, thinking
 }
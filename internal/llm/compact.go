@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Compaction strategies
+const (
+	// CompactSummary replaces older turns with a summary written by the
+	// model, keeping the last Keep turns verbatim
+	CompactSummary = "summary"
+	// CompactWindow drops all but the last Keep turns
+	CompactWindow = "window"
+	// CompactTruncate drops the oldest turns until the history fits in
+	// Budget tokens, never dropping the last Keep turns
+	CompactTruncate = "truncate"
+)
+
+// CompactStrategies lists the compaction strategies
+var CompactStrategies = []string{CompactSummary, CompactWindow, CompactTruncate}
+
+// CompactPolicy configures how a conversation is compacted, and when
+// that happens by itself. A turn is a user message and the messages
+// after it up to the next one. System messages and pinned turns, those
+// with a pinned message, are always kept as they are.
+type CompactPolicy struct {
+	Strategy string
+	Keep     int // turns kept verbatim
+	Budget   int // token budget of CompactTruncate
+	// Threshold is the fraction of the context window at which the
+	// conversation is compacted before the next prompt (0 = never)
+	Threshold float64
+	// Model writes the summaries ("" = the conversation's model), so a
+	// cheaper model can be used for them
+	Model string
+}
+
+// DefaultCompactPolicy summarizes the whole conversation once it fills
+// 80% of the context window
+var DefaultCompactPolicy = CompactPolicy{Strategy: CompactSummary, Threshold: 0.80}
+
+// Validate checks that the policy can be applied
+func (p CompactPolicy) Validate() error {
+	switch p.Strategy {
+	case CompactSummary, CompactWindow:
+	case CompactTruncate:
+		if p.Budget <= 0 {
+			return fmt.Errorf("truncate needs a token budget")
+		}
+	default:
+		return fmt.Errorf("unknown compaction strategy %q (use %s)", p.Strategy, strings.Join(CompactStrategies, ", "))
+	}
+	if p.Keep < 0 || p.Budget < 0 {
+		return fmt.Errorf("keep and budget must not be negative")
+	}
+	if p.Threshold < 0 || p.Threshold > 1 {
+		return fmt.Errorf("threshold %g out of range (0-1)", p.Threshold)
+	}
+	return nil
+}
+
+// summaryPrefix starts the system message holding a summary
+const summaryPrefix = "Previous conversation summary: "
+
+// summarizer asks model (or the conversation's model if empty) for a
+// summary outside the conversation, returning it and the tokens used
+type summarizer func(ctx context.Context, model, prompt string) (string, int, error)
+
+// compactMessages applies policy to msgs. It returns the compacted
+// history, or nil if there is nothing to compact.
+func compactMessages(ctx context.Context, msgs []Message, policy CompactPolicy, summarize summarizer) ([]Message, error) {
+	// turns[i] is the index of the prompt starting turn i; tool rounds
+	// stay in the turn of their prompt
+	var turns []int
+	for i, msg := range msgs {
+		if msg.isPrompt() {
+			turns = append(turns, i)
+		}
+	}
+	turnEnd := func(t int) int {
+		if t+1 < len(turns) {
+			return turns[t+1]
+		}
+		return len(msgs)
+	}
+	pinned := func(t int) bool {
+		for _, msg := range msgs[turns[t]:turnEnd(t)] {
+			if msg.Pinned {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The turns that may go, oldest first
+	var candidates []int
+	for t := 0; t < len(turns)-policy.Keep; t++ {
+		if !pinned(t) {
+			candidates = append(candidates, t)
+		}
+	}
+
+	drop := candidates
+	if policy.Strategy == CompactTruncate {
+		total := estimateContext("", msgs)
+		drop = nil
+		for _, t := range candidates {
+			if total <= policy.Budget {
+				break
+			}
+			for _, msg := range msgs[turns[t]:turnEnd(t)] {
+				if msg.Role != "system" {
+					total -= estimateMessage(msg)
+				}
+			}
+			drop = append(drop, t)
+		}
+	}
+	if len(drop) == 0 || policy.Strategy == CompactSummary && len(drop) < 2 {
+		return nil, nil // not enough to compact
+	}
+
+	dropped := make(map[int]bool) // by message index
+	var conversationText strings.Builder
+	for _, t := range drop {
+		for i := turns[t]; i < turnEnd(t); i++ {
+			if msgs[i].Role == "system" {
+				continue // stays where it is
+			}
+			dropped[i] = true
+			fmt.Fprintf(&conversationText, "%s: %s\n\n", msgs[i].Role, msgs[i].Text())
+		}
+	}
+
+	var summary string
+	if policy.Strategy == CompactSummary {
+		prompt := "Summarize this conversation concisely, preserving key facts, decisions, and context needed to continue:\n\n" + conversationText.String()
+		var err error
+		summary, _, err = summarize(ctx, policy.Model, prompt)
+		if err != nil {
+			return nil, fmt.Errorf("compaction failed: %w", err)
+		}
+	}
+
+	compacted := make([]Message, 0, len(msgs)-len(dropped)+1)
+	for i, msg := range msgs {
+		if !dropped[i] {
+			compacted = append(compacted, msg)
+			continue
+		}
+		if summary != "" {
+			// The summary takes the place of the first dropped message
+			compacted = append(compacted, newMessage("system", summaryPrefix+summary))
+			summary = ""
+		}
+	}
+	return compacted, nil
+}
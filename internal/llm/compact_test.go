@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// turnsHistory returns a system message and n turns of a user question
+// and an assistant answer
+func turnsHistory(n int) []Message {
+	msgs := []Message{{Role: "system", Content: "Be brief."}}
+	for i := 1; i <= n; i++ {
+		msgs = append(msgs,
+			Message{Role: "user", Content: fmt.Sprintf("q%d", i)},
+			Message{Role: "assistant", Content: fmt.Sprintf("a%d", i)})
+	}
+	return msgs
+}
+
+func contents(msgs []Message) string {
+	var s []string
+	for _, m := range msgs {
+		s = append(s, m.Content)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestCompactMessages(t *testing.T) {
+	var summarizedWith, summarized string
+	summarize := func(ctx context.Context, model, prompt string) (string, int, error) {
+		summarizedWith, summarized = model, prompt
+		return "S", 7, nil
+	}
+
+	pinned := turnsHistory(4)
+	pinned[4].Pinned = true // the answer of turn 2
+
+	tests := []struct {
+		name   string
+		msgs   []Message
+		policy CompactPolicy
+		want   string // contents after compaction, "" if unchanged
+	}{
+		{"summary of everything", turnsHistory(3), CompactPolicy{Strategy: CompactSummary}, "Be brief. " + summaryPrefix + "S"},
+		{"summary keeps last turns", turnsHistory(4), CompactPolicy{Strategy: CompactSummary, Keep: 2}, "Be brief. " + summaryPrefix + "S q3 a3 q4 a4"},
+		{"summary needs two turns", turnsHistory(3), CompactPolicy{Strategy: CompactSummary, Keep: 2}, ""},
+		{"summary skips pinned turns", pinned, CompactPolicy{Strategy: CompactSummary, Keep: 1}, "Be brief. " + summaryPrefix + "S q2 a2 q4 a4"},
+		{"window", turnsHistory(4), CompactPolicy{Strategy: CompactWindow, Keep: 1}, "Be brief. q4 a4"},
+		{"window keeps pinned turns", pinned, CompactPolicy{Strategy: CompactWindow, Keep: 1}, "Be brief. q2 a2 q4 a4"},
+		{"window within limit", turnsHistory(2), CompactPolicy{Strategy: CompactWindow, Keep: 2}, ""},
+		// Each message is six tokens with its framing, "Be brief." seven
+		{"truncate to budget", turnsHistory(4), CompactPolicy{Strategy: CompactTruncate, Budget: 35}, "Be brief. q3 a3 q4 a4"},
+		{"truncate within budget", turnsHistory(2), CompactPolicy{Strategy: CompactTruncate, Budget: 40}, ""},
+		{"truncate honours keep", turnsHistory(3), CompactPolicy{Strategy: CompactTruncate, Budget: 1, Keep: 1}, "Be brief. q3 a3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compactMessages(context.Background(), tt.msgs, tt.policy, summarize)
+			if err != nil {
+				t.Fatalf("compactMessages() error: %v", err)
+			}
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("compactMessages() = %q, want no change", contents(got))
+				}
+				return
+			}
+			if contents(got) != tt.want {
+				t.Errorf("compactMessages() = %q, want %q", contents(got), tt.want)
+			}
+		})
+	}
+
+	// The summary covers only what is dropped, using the policy's model
+	compactMessages(context.Background(), turnsHistory(3),
+		CompactPolicy{Strategy: CompactSummary, Keep: 1, Model: "small"}, summarize)
+	if summarizedWith != "small" || !strings.Contains(summarized, "user: q2") || strings.Contains(summarized, "q3") {
+		t.Errorf("summarized with %q: %q", summarizedWith, summarized)
+	}
+
+	failing := func(ctx context.Context, model, prompt string) (string, int, error) {
+		return "", 0, fmt.Errorf("overloaded")
+	}
+	if _, err := compactMessages(context.Background(), turnsHistory(3), DefaultCompactPolicy, failing); err == nil {
+		t.Error("compactMessages() should fail when the summary does")
+	}
+}
+
+func TestCompactPolicy_Validate(t *testing.T) {
+	for _, p := range []CompactPolicy{
+		{Strategy: "rolling"},
+		{Strategy: CompactTruncate},
+		{Strategy: CompactWindow, Keep: -1},
+		{Strategy: CompactSummary, Threshold: 1.5},
+	} {
+		if p.Validate() == nil {
+			t.Errorf("Validate(%+v) should fail", p)
+		}
+	}
+	if err := DefaultCompactPolicy.Validate(); err != nil {
+		t.Errorf("default policy: %v", err)
+	}
+}
+
+func TestOllamaClient_CompactModel(t *testing.T) {
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"summary"},"done":true,"prompt_eval_count":20,"eval_count":5}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	client.SetModel("big")
+	client.SetMessages(turnsHistory(3))
+	if err := client.SetCompactPolicy(CompactPolicy{Strategy: CompactSummary, Keep: 1, Model: "tiny"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Compact(context.Background()); err != nil {
+		t.Fatalf("Compact() error: %v", err)
+	}
+	if len(models) != 1 || models[0] != "tiny" {
+		t.Errorf("summarized with %v, want tiny", models)
+	}
+	if got := contents(client.Messages()); got != "Be brief. "+summaryPrefix+"summary q3 a3" {
+		t.Errorf("Messages() after Compact = %q", got)
+	}
+	if client.SetCompactPolicy(CompactPolicy{Strategy: "nope"}) == nil {
+		t.Error("SetCompactPolicy() should reject an unknown strategy")
+	}
+}
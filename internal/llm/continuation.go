@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Stop reasons, as reported in Meta. Other backends' values are mapped
+// onto the Anthropic names so that scripts check a single vocabulary.
+const (
+	StopEndTurn   = "end_turn"      // the model finished
+	StopMaxTokens = "max_tokens"    // the response hit the token limit
+	StopSequence  = "stop_sequence" // a stop sequence matched (Anthropic only)
+	StopToolUse   = "tool_use"      // the model called tools
+)
+
+// MaxContinuationsLimit caps SetMaxContinuations
+const MaxContinuationsLimit = 32
+
+// normalizeStopReason maps Ollama's done_reason and OpenAI's
+// finish_reason onto the Anthropic stop reasons
+func normalizeStopReason(reason string) string {
+	switch reason {
+	case "length":
+		return StopMaxTokens
+	case "stop":
+		return StopEndTurn
+	case "tool_calls":
+		return StopToolUse
+	}
+	return reason
+}
+
+// validateContinuations checks a SetMaxContinuations value
+func validateContinuations(n int) error {
+	if n < 0 || n > MaxContinuationsLimit {
+		return fmt.Errorf("continuations must be between 0 and %d", MaxContinuationsLimit)
+	}
+	return nil
+}
+
+// continuationPrefix is the text so far as sent back for a continuation.
+// The Anthropic API rejects an assistant turn ending in whitespace.
+func continuationPrefix(text string) string {
+	return strings.TrimRightFunc(text, unicode.IsSpace)
+}
+
+// continuationOverlap is the whitespace trimmed from the prefix, which the
+// model cannot see and often repeats at the start of its continuation
+func continuationOverlap(text string) string {
+	return text[len(continuationPrefix(text)):]
+}
+
+// dropOverlap removes from the start of chunk what it repeats of overlap,
+// returning the rest of the chunk and of the overlap still to be matched.
+// Once the continuation departs from the overlap, nothing more is dropped.
+func dropOverlap(chunk, overlap string) (string, string) {
+	switch {
+	case overlap == "":
+		return chunk, ""
+	case strings.HasPrefix(chunk, overlap):
+		return chunk[len(overlap):], ""
+	case strings.HasPrefix(overlap, chunk):
+		return "", overlap[len(chunk):]
+	}
+	return chunk, ""
+}
+
+// joinContinuation appends the next piece of a continued response
+func joinContinuation(text, next string) string {
+	next, _ = dropOverlap(next, continuationOverlap(text))
+	return text + next
+}
@@ -0,0 +1,231 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+func TestJoinContinuation(t *testing.T) {
+	tests := []struct {
+		text, next, want string
+	}{
+		{"", "Hello", "Hello"},
+		{"Hello", " world", "Hello world"},
+		{"Hello ", " world", "Hello world"},
+		{"line\n\n", "\n\nnext", "line\n\nnext"},
+		{"func(", "x)", "func(x)"},
+	}
+	for _, tt := range tests {
+		if got := joinContinuation(tt.text, tt.next); got != tt.want {
+			t.Errorf("joinContinuation(%q, %q) = %q, want %q", tt.text, tt.next, got, tt.want)
+		}
+	}
+	if got := normalizeStopReason("length"); got != StopMaxTokens {
+		t.Errorf("normalizeStopReason(length) = %q", got)
+	}
+	if got := normalizeStopReason("stop"); got != StopEndTurn {
+		t.Errorf("normalizeStopReason(stop) = %q", got)
+	}
+}
+
+// newTruncatingAnthropicServer answers with pieces in turn, each but the
+// last stopping at max_tokens, and records the final message of each request
+func newTruncatingAnthropicServer(t *testing.T, pieces []string, last *[]map[string]any) *Client {
+	t.Helper()
+	n := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []map[string]any `json:"messages"`
+			Stream   bool             `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		*last = append(*last, req.Messages[len(req.Messages)-1])
+
+		piece, stop := pieces[n], "max_tokens"
+		if n++; n == len(pieces) {
+			stop = "end_turn"
+		}
+		if !req.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":"msg","type":"message","role":"assistant","model":"m","content":[{"type":"text","text":%q}],"stop_reason":%q,"usage":{"input_tokens":10,"output_tokens":5}}`, piece, stop)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, ev := range []string{
+			`{"type":"message_start","message":{"id":"msg","type":"message","role":"assistant","model":"m","content":[],"usage":{"input_tokens":10,"output_tokens":0}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":%q}}`, piece),
+			`{"type":"content_block_stop","index":0}`,
+			fmt.Sprintf(`{"type":"message_delta","delta":{"stop_reason":%q},"usage":{"output_tokens":5}}`, stop),
+			`{"type":"message_stop"}`,
+		} {
+			var typ struct{ Type string }
+			json.Unmarshal([]byte(ev), &typ)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ.Type, ev)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	return client
+}
+
+func TestClient_Continuation(t *testing.T) {
+	var last []map[string]any
+	client := newTruncatingAnthropicServer(t, []string{"func main() {\n", "\tfmt.Println()", "\n}"}, &last)
+
+	// Without continuation the truncation is only recorded
+	if err := client.SetMaxContinuations(-1); err == nil {
+		t.Error("negative continuations should be rejected")
+	}
+	response, err := client.Ask(context.Background(), "write code")
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if meta := client.LastMeta(); response != "func main() {\n" || meta.StopReason != StopMaxTokens || meta.Continuations != 0 {
+		t.Errorf("Ask() = %q, meta %+v", response, client.LastMeta())
+	}
+
+	// With continuation the pieces are joined
+	last = nil
+	client = newTruncatingAnthropicServer(t, []string{"func main() {\n", "\tfmt.Println()", "\n}"}, &last)
+	client.SetMaxContinuations(5)
+	response, err = client.Ask(context.Background(), "write code")
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if want := "func main() {\n\tfmt.Println()\n}"; response != want {
+		t.Errorf("Ask() = %q, want %q", response, want)
+	}
+	if meta := client.LastMeta(); meta.StopReason != StopEndTurn || meta.Continuations != 2 {
+		t.Errorf("LastMeta() = %+v, want end_turn after 2 continuations", meta)
+	}
+	if client.LastTokens() != 45 {
+		t.Errorf("LastTokens() = %d, want all three requests (45)", client.LastTokens())
+	}
+
+	// The continuation sends the text so far, without trailing whitespace
+	if len(last) != 3 || last[1]["role"] != "assistant" {
+		t.Fatalf("continuation requests ended with %v", last)
+	}
+	content, _ := last[1]["content"].([]any)
+	block, _ := content[0].(map[string]any)
+	if block["text"] != "func main() {" {
+		t.Errorf("continuation prefix = %q, want trailing whitespace trimmed", block["text"])
+	}
+	if msgs := client.Messages(); len(msgs) != 2 {
+		t.Errorf("continuations should add one assistant message, history has %d", len(msgs))
+	}
+}
+
+func TestClient_ContinuationStream(t *testing.T) {
+	var last []map[string]any
+	client := newTruncatingAnthropicServer(t, []string{"one two ", " three"}, &last)
+	client.SetMaxContinuations(1)
+
+	if err := client.StartStream(context.Background(), "count"); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	var chunks []string
+	for {
+		chunk, ok := client.ReadStreamChunk()
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	client.WaitStream()
+
+	if got := strings.Join(chunks, ""); got != "one two three" {
+		t.Errorf("streamed %q, want %q", got, "one two three")
+	}
+	if meta := client.LastMeta(); meta.StopReason != StopEndTurn || meta.Continuations != 1 {
+		t.Errorf("LastMeta() = %+v", meta)
+	}
+}
+
+func TestOllamaClient_Continuation(t *testing.T) {
+	var requests []ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		piece, reason := "Hello, ", "length"
+		if len(requests)%2 == 0 {
+			piece, reason = "world", "stop"
+		}
+		if !req.Stream {
+			fmt.Fprintf(w, `{"message":{"role":"assistant","content":%q},"done":true,"done_reason":%q,"prompt_eval_count":4,"eval_count":2}`, piece, reason)
+			return
+		}
+		fmt.Fprintf(w, "{\"message\":{\"role\":\"assistant\",\"content\":%q},\"done\":false}\n", piece)
+		fmt.Fprintf(w, "{\"message\":{\"role\":\"assistant\",\"content\":\"\"},\"done\":true,\"done_reason\":%q,\"prompt_eval_count\":4,\"eval_count\":2}\n", reason)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	client.SetThinkingTokens(-1)
+	client.SetMaxContinuations(1)
+
+	response, err := client.Ask(context.Background(), "greet")
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if response != "Hello, world" {
+		t.Errorf("Ask() = %q, want %q", response, "Hello, world")
+	}
+	if meta := client.LastMeta(); meta.StopReason != StopEndTurn || meta.Continuations != 1 {
+		t.Errorf("LastMeta() = %+v", meta)
+	}
+	cont := requests[1]
+	if m := cont.Messages[len(cont.Messages)-1]; m.Role != "assistant" || m.Content != "Hello," {
+		t.Errorf("continuation ends with %+v", m)
+	}
+	if cont.Think {
+		t.Error("continuation should not ask the model to think again")
+	}
+
+	if err := client.StartStream(context.Background(), "greet"); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	var streamed string
+	for {
+		chunk, ok := client.ReadStreamChunk()
+		if !ok {
+			break
+		}
+		streamed += chunk
+	}
+	client.WaitStream()
+	if streamed != "Hello, world" || client.LastMeta().Continuations != 1 {
+		t.Errorf("streamed %q, meta %+v", streamed, client.LastMeta())
+	}
+}
+
+func TestOpenAIClient_StopReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"trunc"},"finish_reason":"length"}]}`)
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient(server.URL, "")
+	client.SetModel("m")
+	if err := client.SetMaxContinuations(1); err == nil {
+		t.Error("chat completions should not accept continuations")
+	}
+	if _, err := client.Ask(context.Background(), "hi"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if got := client.LastMeta().StopReason; got != StopMaxTokens {
+		t.Errorf("StopReason = %q, want %q", got, StopMaxTokens)
+	}
+}
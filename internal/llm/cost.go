@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TokenUsage counts tokens by kind. Input excludes the cache reads and
+// writes, which are priced differently.
+type TokenUsage struct {
+	Input      int
+	Output     int
+	CacheRead  int
+	CacheWrite int
+}
+
+// add counts the tokens of an exchange. OpenAI reports cache reads as
+// part of the prompt tokens; they are moved out of Input.
+func (u *TokenUsage) add(meta Meta) {
+	input := meta.InputTokens
+	if meta.Backend == BackendOpenAI {
+		input -= meta.CacheReadTokens
+	}
+	u.Input += input
+	u.Output += meta.OutputTokens
+	u.CacheRead += meta.CacheReadTokens
+	u.CacheWrite += meta.CacheWriteTokens
+}
+
+// Usage counts the tokens used by model
+type Usage map[string]TokenUsage
+
+// add counts the tokens of an exchange under the model that answered
+func (u Usage) add(meta Meta) {
+	t := u[meta.Model]
+	t.add(meta)
+	u[meta.Model] = t
+}
+
+// clone returns a copy of u
+func (u Usage) clone() Usage {
+	c := make(Usage, len(u))
+	for model, t := range u {
+		c[model] = t
+	}
+	return c
+}
+
+// Models returns the models of u in order
+func (u Usage) Models() []string {
+	models := make([]string, 0, len(u))
+	for model := range u {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// UsageOf is the Usage of a single exchange
+func UsageOf(meta Meta) Usage {
+	u := Usage{}
+	u.add(meta)
+	return u
+}
+
+// lifetime counts the tokens of every exchange since the server started
+var lifetime = struct {
+	sync.Mutex
+	usage Usage
+}{usage: Usage{}}
+
+// recordLifetime adds an exchange to the lifetime usage
+func recordLifetime(meta Meta) {
+	lifetime.Lock()
+	defer lifetime.Unlock()
+	lifetime.usage.add(meta)
+}
+
+// LifetimeUsage returns the tokens used by all conversations, and by
+// compaction summaries, since the server started
+func LifetimeUsage() Usage {
+	lifetime.Lock()
+	defer lifetime.Unlock()
+	return lifetime.usage.clone()
+}
+
+// Price is what a model charges, in US dollars per million tokens
+type Price struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64
+	CacheWrite float64
+}
+
+// Cost is what tokens cost, in US dollars
+type Cost struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64
+	CacheWrite float64
+}
+
+// Total is the sum of the cost's parts
+func (c Cost) Total() float64 {
+	return c.Input + c.Output + c.CacheRead + c.CacheWrite
+}
+
+// Add returns the sum of c and d
+func (c Cost) Add(d Cost) Cost {
+	return Cost{c.Input + d.Input, c.Output + d.Output, c.CacheRead + d.CacheRead, c.CacheWrite + d.CacheWrite}
+}
+
+// PriceTable maps model names to prices. A model takes the price of the
+// longest entry its name starts with, so "claude-sonnet-4" covers every
+// release of Sonnet 4 and 4.5 whatever its date.
+type PriceTable map[string]Price
+
+// DefaultPrices are Anthropic's list prices for the Claude models. Cache
+// writes (five-minute caching) cost 1.25 times the input price and cache
+// reads a tenth of it.
+var DefaultPrices = PriceTable{
+	"claude-opus-4-5":   {5, 25, 0.50, 6.25},
+	"claude-opus-4":     {15, 75, 1.50, 18.75},
+	"claude-sonnet-4":   {3, 15, 0.30, 3.75},
+	"claude-haiku-4-5":  {1, 5, 0.10, 1.25},
+	"claude-3-opus":     {15, 75, 1.50, 18.75},
+	"claude-3-7-sonnet": {3, 15, 0.30, 3.75},
+	"claude-3-5-sonnet": {3, 15, 0.30, 3.75},
+	"claude-3-5-haiku":  {0.80, 4, 0.08, 1},
+	"claude-3-haiku":    {0.25, 1.25, 0.03, 0.30},
+}
+
+// Lookup returns the price of model
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	best := ""
+	for prefix := range t {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return t[best], true
+}
+
+// Cost prices u at model's price; false if the model has none
+func (t PriceTable) Cost(model string, u TokenUsage) (Cost, bool) {
+	p, ok := t.Lookup(model)
+	if !ok {
+		return Cost{}, false
+	}
+	const perToken = 1e-6 // prices are per million tokens
+	return Cost{
+		Input:      float64(u.Input) * p.Input * perToken,
+		Output:     float64(u.Output) * p.Output * perToken,
+		CacheRead:  float64(u.CacheRead) * p.CacheRead * perToken,
+		CacheWrite: float64(u.CacheWrite) * p.CacheWrite * perToken,
+	}, true
+}
+
+// ParsePrices reads a price file over the prices in base, returning the
+// combined table. Each line gives a model name, or the start of one, and
+// its prices in US dollars per million tokens:
+//
+//	# model            input output [cache_read [cache_write]]
+//	claude-sonnet-4    3     15     0.30        3.75
+//	gpt-4o             2.50  10     1.25
+//
+// Cache prices left out are a tenth and 1.25 times the input price.
+func ParsePrices(data []byte, base PriceTable) (PriceTable, error) {
+	table := make(PriceTable, len(base))
+	for model, p := range base {
+		table[model] = p
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || len(fields) > 5 {
+			return nil, fmt.Errorf("prices line %d: want model, input, output and optional cache_read and cache_write prices", n)
+		}
+		var values []float64
+		for _, f := range fields[1:] {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("prices line %d: invalid price %q", n, f)
+			}
+			values = append(values, v)
+		}
+		p := Price{Input: values[0], Output: values[1], CacheRead: values[0] / 10, CacheWrite: values[0] * 1.25}
+		if len(values) > 2 {
+			p.CacheRead = values[2]
+		}
+		if len(values) > 3 {
+			p.CacheWrite = values[3]
+		}
+		table[fields[0]] = p
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// LoadPrices reads the price file at path over DefaultPrices
+func LoadPrices(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrices(data, DefaultPrices)
+}
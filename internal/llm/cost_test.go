@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPriceTable_Lookup(t *testing.T) {
+	tests := []struct {
+		model string
+		want  float64 // input price
+		ok    bool
+	}{
+		{"claude-sonnet-4-20250514", 3, true},
+		{"claude-sonnet-4-5-20250929", 3, true},
+		{"claude-opus-4-1-20250805", 15, true},
+		{"claude-opus-4-5-20251101", 5, true}, // longer prefix wins
+		{"claude-3-5-haiku-20241022", 0.80, true},
+		{"llama3.2", 0, false},
+	}
+	for _, tt := range tests {
+		p, ok := DefaultPrices.Lookup(tt.model)
+		if ok != tt.ok || p.Input != tt.want {
+			t.Errorf("Lookup(%q) = %v, %v, want input %v, %v", tt.model, p, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPriceTable_Cost(t *testing.T) {
+	u := TokenUsage{Input: 1000, Output: 500, CacheRead: 20000, CacheWrite: 2000}
+	cost, ok := DefaultPrices.Cost("claude-sonnet-4-20250514", u)
+	if !ok {
+		t.Fatal("Cost() found no price")
+	}
+	want := Cost{Input: 0.003, Output: 0.0075, CacheRead: 0.006, CacheWrite: 0.0075}
+	for _, c := range [][2]float64{
+		{cost.Input, want.Input}, {cost.Output, want.Output},
+		{cost.CacheRead, want.CacheRead}, {cost.CacheWrite, want.CacheWrite},
+		{cost.Total(), 0.024},
+	} {
+		if math.Abs(c[0]-c[1]) > 1e-9 {
+			t.Errorf("Cost() = %+v, want %+v", cost, want)
+			break
+		}
+	}
+	if _, ok := DefaultPrices.Cost("llama3.2", u); ok {
+		t.Error("Cost() priced a model without a price")
+	}
+}
+
+func TestParsePrices(t *testing.T) {
+	data := []byte(`# local prices
+gpt-4o          2.50  10   1.25
+claude-sonnet-4 2     10   0.20  2.50
+
+llama           0     0
+`)
+	table, err := ParsePrices(data, DefaultPrices)
+	if err != nil {
+		t.Fatalf("ParsePrices() error: %v", err)
+	}
+	tests := []struct {
+		model string
+		want  Price
+	}{
+		{"gpt-4o-2024-08-06", Price{2.50, 10, 1.25, 3.125}},
+		{"claude-sonnet-4-20250514", Price{2, 10, 0.20, 2.50}},
+		{"claude-3-haiku-20240307", DefaultPrices["claude-3-haiku"]},
+		{"llama3.2", Price{}},
+	}
+	for _, tt := range tests {
+		if p, ok := table.Lookup(tt.model); !ok || p != tt.want {
+			t.Errorf("Lookup(%q) = %+v, want %+v", tt.model, p, tt.want)
+		}
+	}
+	if DefaultPrices["claude-sonnet-4"].Input != 3 {
+		t.Error("ParsePrices() changed the base table")
+	}
+
+	for _, bad := range []string{"gpt-4o 2.50", "gpt-4o 2.50 ten", "gpt-4o -1 10", "gpt-4o 1 2 3 4 5"} {
+		if _, err := ParsePrices([]byte(bad), nil); err == nil {
+			t.Errorf("ParsePrices(%q) should fail", bad)
+		}
+	}
+}
+
+func TestUsage_OpenAICacheReads(t *testing.T) {
+	u := UsageOf(Meta{Model: "gpt-4o", Backend: BackendOpenAI, InputTokens: 1200, OutputTokens: 50, CacheReadTokens: 1024})
+	if got := u["gpt-4o"]; got != (TokenUsage{Input: 176, Output: 50, CacheRead: 1024}) {
+		t.Errorf("UsageOf() = %+v", got)
+	}
+	u = UsageOf(Meta{Model: "claude", Backend: BackendAPI, InputTokens: 10, OutputTokens: 5, CacheReadTokens: 1024, CacheWriteTokens: 30})
+	if got := u["claude"]; got != (TokenUsage{Input: 10, Output: 5, CacheRead: 1024, CacheWrite: 30}) {
+		t.Errorf("UsageOf() = %+v", got)
+	}
+}
+
+func TestOllamaClient_Usage(t *testing.T) {
+	const model = "usage-test-model"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"model":%q,"message":{"role":"assistant","content":"ok"},"done":true,"prompt_eval_count":100,"eval_count":20}`, model)
+	}))
+	defer server.Close()
+
+	before := LifetimeUsage()[model]
+	client := NewOllamaClient(server.URL)
+	for i := 0; i < 2; i++ {
+		if _, err := client.Ask(context.Background(), "hello"); err != nil {
+			t.Fatalf("Ask() error: %v", err)
+		}
+	}
+	if got := client.Usage()[model]; got != (TokenUsage{Input: 200, Output: 40}) {
+		t.Errorf("Usage() = %+v, want 200 input and 40 output tokens", got)
+	}
+	if got := LifetimeUsage()[model]; got.Input-before.Input != 200 || got.Output-before.Output != 40 {
+		t.Errorf("LifetimeUsage() grew by %d/%d, want 200/40", got.Input-before.Input, got.Output-before.Output)
+	}
+
+	client.Reset()
+	if len(client.Usage()) != 0 {
+		t.Errorf("Usage() after Reset = %v", client.Usage())
+	}
+	if got := LifetimeUsage()[model]; got.Input-before.Input != 200 {
+		t.Error("Reset() changed the lifetime usage")
+	}
+}
@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Default embedding models
+const (
+	DefaultOllamaEmbedModel = "nomic-embed-text"
+	DefaultOpenAIEmbedModel = "text-embedding-3-small"
+)
+
+// MaxEmbedInputs is how many inputs one Embed may take
+const MaxEmbedInputs = 2048
+
+// Embeddings are the vectors for a list of inputs, all of one length
+type Embeddings struct {
+	Model      string      `json:"model"`
+	Dimensions int         `json:"dimensions"`
+	Tokens     int         `json:"tokens"` // input tokens, if reported
+	Vectors    [][]float32 `json:"embeddings"`
+}
+
+// checkEmbedInputs checks the inputs of an Embed
+func checkEmbedInputs(inputs []string) error {
+	if len(inputs) == 0 {
+		return errors.New("nothing to embed")
+	}
+	if len(inputs) > MaxEmbedInputs {
+		return fmt.Errorf("%d inputs is over the limit of %d", len(inputs), MaxEmbedInputs)
+	}
+	for i, input := range inputs {
+		if input == "" {
+			return fmt.Errorf("input %d is empty", i)
+		}
+	}
+	return nil
+}
+
+// newEmbeddings makes the embeddings of n inputs from the vectors a
+// server returned, checking there is one of each input and all are the
+// same length
+func newEmbeddings(model string, vectors [][]float64, tokens, n int) (*Embeddings, error) {
+	if len(vectors) != n {
+		return nil, fmt.Errorf("%d embeddings returned for %d inputs", len(vectors), n)
+	}
+	e := &Embeddings{Model: model, Tokens: tokens, Vectors: make([][]float32, n)}
+	for i, v := range vectors {
+		if i == 0 {
+			e.Dimensions = len(v)
+		}
+		if len(v) == 0 || len(v) != e.Dimensions {
+			return nil, fmt.Errorf("embedding %d has %d dimensions, not %d", i, len(v), e.Dimensions)
+		}
+		e.Vectors[i] = make([]float32, len(v))
+		for j, x := range v {
+			e.Vectors[i][j] = float32(x)
+		}
+	}
+	return e, nil
+}
+
+// MarshalBinary encodes the vectors compactly: the number of vectors and
+// their dimensions, as little-endian uint32s, then each vector's values
+// as little-endian float32s
+func (e *Embeddings) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 8, 8+4*len(e.Vectors)*e.Dimensions)
+	binary.LittleEndian.PutUint32(data[0:], uint32(len(e.Vectors)))
+	binary.LittleEndian.PutUint32(data[4:], uint32(e.Dimensions))
+	for _, v := range e.Vectors {
+		if len(v) != e.Dimensions {
+			return nil, fmt.Errorf("a vector has %d dimensions, not %d", len(v), e.Dimensions)
+		}
+		for _, x := range v {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(x))
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes the vectors of MarshalBinary. The model and
+// tokens are not part of the encoding.
+func (e *Embeddings) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("embeddings: short header")
+	}
+	n := int(binary.LittleEndian.Uint32(data[0:]))
+	dims := int(binary.LittleEndian.Uint32(data[4:]))
+	data = data[8:]
+	if uint64(len(data)) != 4*uint64(n)*uint64(dims) {
+		return fmt.Errorf("embeddings: %d bytes of data for %d vectors of %d dimensions", len(data), n, dims)
+	}
+	e.Dimensions = dims
+	e.Vectors = make([][]float32, n)
+	for i := range e.Vectors {
+		v := make([]float32, dims)
+		for j := range v {
+			v[j] = math.Float32frombits(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		}
+		e.Vectors[i] = v
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestOllamaClient_Embed(t *testing.T) {
+	var req ollamaEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"model":"nomic-embed-text","embeddings":[[0.5,-1],[0.25,2]],"prompt_eval_count":7}`)
+	}))
+	defer server.Close()
+	client := NewOllamaClient(server.URL)
+
+	e, err := client.Embed(context.Background(), []string{"cat", "dog"})
+	if err != nil {
+		t.Fatalf("Embed() error: %v", err)
+	}
+	if req.Model != DefaultOllamaEmbedModel || fmt.Sprint(req.Input) != "[cat dog]" {
+		t.Errorf("request = %+v", req)
+	}
+	want := &Embeddings{Model: "nomic-embed-text", Dimensions: 2, Tokens: 7, Vectors: [][]float32{{0.5, -1}, {0.25, 2}}}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("Embed() = %+v, want %+v", e, want)
+	}
+
+	// The embedding model is not the chat model
+	client.SetEmbedModel("mxbai-embed-large")
+	client.Embed(context.Background(), []string{"cat", "dog"})
+	if req.Model != "mxbai-embed-large" || client.Model() == "mxbai-embed-large" {
+		t.Errorf("model sent = %s, chat model %s", req.Model, client.Model())
+	}
+
+	if _, err := client.Embed(context.Background(), []string{"cat"}); err == nil {
+		t.Error("Embed() should fail when the count of vectors is wrong")
+	}
+	if _, err := client.Embed(context.Background(), []string{"cat", ""}); err == nil {
+		t.Error("Embed() should refuse an empty input")
+	}
+}
+
+func TestOpenAIClient_Embed(t *testing.T) {
+	var req openAIEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"model":"text-embedding-3-small","data":[{"index":1,"embedding":[3,4]},{"index":0,"embedding":[1,2]}],"usage":{"prompt_tokens":4}}`)
+	}))
+	defer server.Close()
+	client := NewOpenAIClient(server.URL, "")
+
+	e, err := client.Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed() error: %v", err)
+	}
+	if req.Model != DefaultOpenAIEmbedModel || req.EncodingFormat != "float" || len(req.Input) != 2 {
+		t.Errorf("request = %+v", req)
+	}
+	if fmt.Sprint(e.Vectors) != "[[1 2] [3 4]]" || e.Dimensions != 2 || e.Tokens != 4 {
+		t.Errorf("Embed() = %+v", e)
+	}
+}
+
+func TestEmbeddings_Binary(t *testing.T) {
+	e := &Embeddings{Dimensions: 3, Vectors: [][]float32{{1, -2.5, 0}, {0.125, 3, -1}}}
+	data, err := e.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error: %v", err)
+	}
+	if len(data) != 8+2*3*4 || data[0] != 2 || data[4] != 3 {
+		t.Errorf("MarshalBinary() = % x", data)
+	}
+	var back Embeddings
+	if err := back.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() error: %v", err)
+	}
+	if !reflect.DeepEqual(back.Vectors, e.Vectors) || back.Dimensions != 3 {
+		t.Errorf("round trip = %+v", back)
+	}
+	if back.UnmarshalBinary(data[:len(data)-1]) == nil {
+		t.Error("UnmarshalBinary() should fail on truncated data")
+	}
+}
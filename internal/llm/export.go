@@ -0,0 +1,372 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExportFormats are the transcript formats Export writes and
+// ParseExport reads:
+//
+//	anthropic  Messages API request body: model, system, messages
+//	openai     Chat Completions request body: model, messages
+//	ollama     /api/chat request body: model, messages
+//	markdown   "## User", "## Assistant" and "## System" sections
+//	jsonl      one {"role":..,"content":..} message per line
+//
+// Tool calls and results take each API's form: tool_use and tool_result
+// blocks, or tool_calls and "tool" role messages. Markdown shows them as
+// the lines of Message.Text. Attachments are exported without their
+// data, as the placeholder lines of Message.Text before the content.
+var ExportFormats = []string{"anthropic", "openai", "ollama", "markdown", "jsonl"}
+
+// exportMessage is a message of the Anthropic request body. Content is
+// text, or content blocks when the message calls tools or answers them.
+type exportMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// exportBlock is a content block of an exported message
+type exportBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+// anthropicExport renders a message for the Anthropic request body
+func anthropicExport(msg Message) exportMessage {
+	if len(msg.ToolCalls) == 0 && len(msg.ToolResults) == 0 {
+		return exportMessage{msg.Role, msg.Text()}
+	}
+	var blocks []exportBlock
+	for _, result := range msg.ToolResults {
+		blocks = append(blocks, exportBlock{Type: "tool_result", ToolUseID: result.CallID, Content: result.Content, IsError: result.IsError})
+	}
+	if msg.Content != "" {
+		blocks = append(blocks, exportBlock{Type: "text", Text: msg.Content})
+	}
+	for _, call := range msg.ToolCalls {
+		blocks = append(blocks, exportBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: call.Input})
+	}
+	return exportMessage{msg.Role, blocks}
+}
+
+// Export renders b's conversation history in one of ExportFormats.
+// The system prompt setting is not part of the history and is left out;
+// system messages in the history go in the Anthropic "system" field or
+// stay in place in the other formats.
+func Export(b Backend, format string) ([]byte, error) {
+	msgs := b.Messages()
+	for i := range msgs {
+		msgs[i].Attachments = withoutData(msgs[i].Attachments)
+	}
+	switch format {
+	case "anthropic":
+		body := struct {
+			Model    string          `json:"model"`
+			System   string          `json:"system,omitempty"`
+			Messages []exportMessage `json:"messages"`
+		}{Model: b.Model(), Messages: []exportMessage{}}
+		var system []string
+		for _, msg := range msgs {
+			if msg.Role == "system" {
+				system = append(system, msg.Content)
+				continue
+			}
+			body.Messages = append(body.Messages, anthropicExport(msg))
+		}
+		body.System = strings.Join(system, "\n\n")
+		return marshalExport(body)
+	case "openai":
+		body := struct {
+			Model    string          `json:"model"`
+			Messages []openAIMessage `json:"messages"`
+		}{Model: b.Model(), Messages: []openAIMessage{}}
+		body.Messages = append(body.Messages, openAIHistory(msgs)...)
+		return marshalExport(body)
+	case "ollama":
+		body := struct {
+			Model    string          `json:"model"`
+			Messages []ollamaMessage `json:"messages"`
+		}{Model: b.Model(), Messages: []ollamaMessage{}}
+		body.Messages = append(body.Messages, ollamaHistory(msgs)...)
+		return marshalExport(body)
+	case "markdown":
+		var buf bytes.Buffer
+		for i, msg := range msgs {
+			if i > 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(&buf, "## %s\n\n%s\n", markdownRole(msg.Role), msg.Text())
+		}
+		return buf.Bytes(), nil
+	case "jsonl":
+		return encodeMessages(msgs)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+func marshalExport(v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// markdownRole is the section heading of a role
+func markdownRole(role string) string {
+	if role == "" {
+		return role
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// ParseExport reads a conversation in any of ExportFormats, in
+// llm9p's own JSON array, or as a transcript (see ParseTranscript). The
+// format is recognised from the data. Message content given as an array
+// of blocks, as the APIs also allow, is joined from its text blocks, and
+// tool calls and results in any of the API forms are kept.
+func ParseExport(data []byte) ([]Message, error) {
+	text := strings.TrimSpace(string(data))
+	switch {
+	case text == "":
+		return nil, fmt.Errorf("import: empty")
+	case strings.HasPrefix(text, "["):
+		var msgs []Message
+		if err := json.Unmarshal([]byte(text), &msgs); err != nil {
+			return nil, fmt.Errorf("import: %w", err)
+		}
+		return msgs, nil
+	case strings.HasPrefix(text, "{"):
+		var body struct {
+			System   json.RawMessage `json:"system"`
+			Messages []struct {
+				Role       string          `json:"role"`
+				Content    json.RawMessage `json:"content"`
+				ToolCalls  []importCall    `json:"tool_calls"`
+				ToolCallID string          `json:"tool_call_id"`
+				ToolName   string          `json:"tool_name"`
+			} `json:"messages"`
+		}
+		if json.Unmarshal([]byte(text), &body) != nil || body.Messages == nil {
+			msgs, err := parseJSONLines(text) // a message per line
+			if err != nil {
+				return nil, fmt.Errorf("import: %w", err)
+			}
+			return msgs, nil
+		}
+		var msgs []Message
+		if len(body.System) > 0 {
+			system, err := blockText(body.System)
+			if err != nil {
+				return nil, fmt.Errorf("import: system: %w", err)
+			}
+			if system != "" {
+				msgs = append(msgs, Message{Role: "system", Content: system})
+			}
+		}
+		for i, m := range body.Messages {
+			msg, err := parseBlocks(m.Content)
+			if err != nil {
+				return nil, fmt.Errorf("import: message %d: %w", i, err)
+			}
+			msg.Role = m.Role
+			for _, call := range m.ToolCalls {
+				msg.ToolCalls = append(msg.ToolCalls, call.toolCall())
+			}
+			if m.Role == "tool" {
+				// An OpenAI or Ollama result joins the results in the
+				// user message after the calls
+				result := ToolResult{CallID: m.ToolCallID, Content: msg.Content}
+				if result.CallID == "" {
+					result.CallID = callByName(msgs, m.ToolName)
+				}
+				if n := len(msgs); n > 0 && msgs[n-1].Role == "user" && len(msgs[n-1].ToolResults) > 0 {
+					msgs[n-1].ToolResults = append(msgs[n-1].ToolResults, result)
+				} else {
+					msgs = append(msgs, Message{Role: "user", ToolResults: []ToolResult{result}})
+				}
+				continue
+			}
+			if n := len(msgs); m.Role == "user" && n > 0 && msgs[n-1].Role == "user" && len(msgs[n-1].ToolResults) > 0 && msgs[n-1].Content == "" {
+				msgs[n-1].Content = msg.Content // the text after tool messages
+				continue
+			}
+			msgs = append(msgs, msg)
+		}
+		return msgs, nil
+	case strings.HasPrefix(text, "## "):
+		return parseMarkdown(text)
+	case IsTranscript(text):
+		msgs, err := ParseTranscript(text)
+		if err != nil {
+			return nil, fmt.Errorf("import: %w", err)
+		}
+		return msgs, nil
+	}
+	return nil, fmt.Errorf("import: unrecognised format (use %s, or a JSON message array)", strings.Join(ExportFormats, ", "))
+}
+
+// blockText returns content given either as a string or as an array of
+// content blocks, keeping the text of the text blocks
+func blockText(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("content is neither text nor blocks")
+	}
+	var parts []string
+	for _, b := range blocks {
+		if b.Type == "text" {
+			parts = append(parts, b.Text)
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// importCall is an OpenAI or Ollama tool call. OpenAI encodes the
+// arguments as a JSON string, Ollama as an object.
+type importCall struct {
+	ID       string `json:"id"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+func (c importCall) toolCall() ToolCall {
+	input := c.Function.Arguments
+	var s string
+	if json.Unmarshal(input, &s) == nil {
+		input = json.RawMessage(s)
+	}
+	return ToolCall{ID: c.ID, Name: c.Function.Name, Input: callInput(input)}
+}
+
+// callInput is a call's input on one line, as the APIs return it; the
+// request body formats indent it
+func callInput(raw json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) != nil {
+		return json.RawMessage("{}")
+	}
+	return buf.Bytes()
+}
+
+// callByName finds the ID of the first call to tool in the last assistant
+// message of msgs that is not yet answered, for Ollama results, which
+// name the tool rather than the call
+func callByName(msgs []Message, tool string) string {
+	answered := make(map[string]bool)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		switch msgs[i].Role {
+		case "user":
+			for _, result := range msgs[i].ToolResults {
+				answered[result.CallID] = true
+			}
+		case "assistant":
+			for j, call := range msgs[i].ToolCalls {
+				if call.ID == "" {
+					call.ID = newToolCallID()
+					msgs[i].ToolCalls[j].ID = call.ID
+				}
+				if call.Name == tool && !answered[call.ID] {
+					return call.ID
+				}
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// parseBlocks reads message content given as text or as content blocks:
+// the text blocks make the content, and tool_use and tool_result blocks
+// the tool calls and results
+func parseBlocks(raw json.RawMessage) (Message, error) {
+	var msg Message
+	if len(raw) == 0 || string(raw) == "null" {
+		return msg, nil // an OpenAI assistant message with only calls
+	}
+	if err := json.Unmarshal(raw, &msg.Content); err == nil {
+		return msg, nil
+	}
+	var blocks []struct {
+		Type      string          `json:"type"`
+		Text      string          `json:"text"`
+		ID        string          `json:"id"`
+		Name      string          `json:"name"`
+		Input     json.RawMessage `json:"input"`
+		ToolUseID string          `json:"tool_use_id"`
+		Content   json.RawMessage `json:"content"`
+		IsError   bool            `json:"is_error"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return msg, fmt.Errorf("content is neither text nor blocks")
+	}
+	var parts []string
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			parts = append(parts, b.Text)
+		case "tool_use":
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: b.ID, Name: b.Name, Input: callInput(b.Input)})
+		case "tool_result":
+			result := ToolResult{CallID: b.ToolUseID, IsError: b.IsError}
+			if len(b.Content) > 0 {
+				content, err := blockText(b.Content)
+				if err != nil {
+					return msg, fmt.Errorf("tool result: %w", err)
+				}
+				result.Content = content
+			}
+			msg.ToolResults = append(msg.ToolResults, result)
+		}
+	}
+	msg.Content = strings.Join(parts, "\n")
+	return msg, nil
+}
+
+// parseMarkdown reads the markdown export: a "## Role" heading line
+// starts each message
+func parseMarkdown(text string) ([]Message, error) {
+	var msgs []Message
+	var content []string
+	flush := func() {
+		if len(msgs) > 0 {
+			msgs[len(msgs)-1].Content = strings.TrimSpace(strings.Join(content, "\n"))
+		}
+		content = content[:0]
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if heading, ok := strings.CutPrefix(line, "## "); ok {
+			role := strings.ToLower(strings.TrimSpace(heading))
+			if role == "system" || role == "user" || role == "assistant" {
+				flush()
+				msgs = append(msgs, Message{Role: role})
+				continue
+			}
+		}
+		if len(msgs) == 0 {
+			return nil, fmt.Errorf("import: markdown must start with a ## User, ## Assistant or ## System heading")
+		}
+		content = append(content, line)
+	}
+	flush()
+	return msgs, nil
+}
@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExport_RoundTrip(t *testing.T) {
+	history := []Message{
+		{Role: "system", Content: "Answer briefly."},
+		{Role: "user", Content: "Name a colour."},
+		{Role: "assistant", Content: "Blue.\n\nOr green."},
+	}
+	client := NewOllamaClient("http://localhost:11434")
+	client.SetModel("llama3")
+	client.SetMessages(history)
+
+	for _, format := range ExportFormats {
+		t.Run(format, func(t *testing.T) {
+			data, err := Export(client, format)
+			if err != nil {
+				t.Fatalf("Export() error: %v", err)
+			}
+			got, err := ParseExport(data)
+			if err != nil {
+				t.Fatalf("ParseExport() error: %v\n%s", err, data)
+			}
+			if len(got) != len(history) {
+				t.Fatalf("ParseExport() = %v, want %v", got, history)
+			}
+			for i := range history {
+				if got[i].Role != history[i].Role || got[i].Content != history[i].Content {
+					t.Errorf("message %d = %v, want %v", i, got[i], history[i])
+				}
+			}
+		})
+	}
+
+	data, _ := Export(client, "anthropic")
+	if !strings.Contains(string(data), `"system": "Answer briefly."`) || !strings.Contains(string(data), `"model": "llama3"`) {
+		t.Errorf("anthropic export:\n%s", data)
+	}
+	if _, err := Export(client, "yaml"); err == nil {
+		t.Error("Export() should reject an unknown format")
+	}
+}
+
+func TestExport_Tools(t *testing.T) {
+	history := []Message{
+		{Role: "user", Content: "2+3?"},
+		{Role: "assistant", Content: "Adding.", ToolCalls: []ToolCall{{ID: "call_1", Name: "add", Input: json.RawMessage(`{"a":2,"b":3}`)}}},
+		{Role: "user", ToolResults: []ToolResult{{CallID: "call_1", Content: "5"}}},
+		{Role: "assistant", Content: "5"},
+	}
+	client := NewOllamaClient("http://localhost:11434")
+	client.SetMessages(history)
+
+	for _, format := range []string{"anthropic", "openai", "ollama", "jsonl"} {
+		data, err := Export(client, format)
+		if err != nil {
+			t.Fatalf("Export(%s) error: %v", format, err)
+		}
+		got, err := ParseExport(data)
+		if err != nil {
+			t.Fatalf("ParseExport(%s) error: %v\n%s", format, err, data)
+		}
+		if !reflect.DeepEqual(got, history) {
+			t.Errorf("%s round trip = %+v, want %+v\n%s", format, got, history, data)
+		}
+	}
+
+	data, _ := Export(client, "markdown")
+	if !strings.Contains(string(data), `[tool call call_1 add: {"a":2,"b":3}]`) || !strings.Contains(string(data), "[tool result call_1: 5]") {
+		t.Errorf("markdown export:\n%s", data)
+	}
+}
+
+func TestParseExport_Blocks(t *testing.T) {
+	body := `{
+	  "system": [{"type": "text", "text": "Be kind."}],
+	  "messages": [
+	    {"role": "user", "content": [{"type": "text", "text": "Look:"}, {"type": "image", "source": {}}, {"type": "text", "text": "what is it?"}]},
+	    {"role": "assistant", "content": "A cat."}
+	  ]
+	}`
+	msgs, err := ParseExport([]byte(body))
+	if err != nil {
+		t.Fatalf("ParseExport() error: %v", err)
+	}
+	want := []Message{
+		{Role: "system", Content: "Be kind."},
+		{Role: "user", Content: "Look:\nwhat is it?"},
+		{Role: "assistant", Content: "A cat."},
+	}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("ParseExport() = %v, want %v", msgs, want)
+	}
+
+	for _, bad := range []string{"", "hello", "## Heading\ntext", `{"messages": [{"role": "user", "content": 3}]}`} {
+		if _, err := ParseExport([]byte(bad)); err == nil {
+			t.Errorf("ParseExport(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Operations on a conversation's history, for any Backend. They go
+// through Messages and SetMessages, so a StoredBackend or a session with
+// a store records the result.
+
+// Rewind truncates b's history to its first n messages
+func Rewind(b Backend, n int) error {
+	msgs := b.Messages()
+	if n < 0 || n > len(msgs) {
+		return fmt.Errorf("rewind: history has %d messages", len(msgs))
+	}
+	b.SetMessages(msgs[:n])
+	return nil
+}
+
+// ErrNoExchange is returned by Pop and Retry when the history has no
+// prompt in it
+var ErrNoExchange = errors.New("no exchange in history")
+
+// lastExchange returns the index of the last prompt in msgs, the user
+// message starting the last exchange
+func lastExchange(msgs []Message) (int, error) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].isPrompt() {
+			return i, nil
+		}
+	}
+	return 0, ErrNoExchange
+}
+
+// Pop drops the last exchange from b's history: the last user message
+// and everything after it
+func Pop(b Backend) error {
+	msgs := b.Messages()
+	i, err := lastExchange(msgs)
+	if err != nil {
+		return fmt.Errorf("pop: %w", err)
+	}
+	b.SetMessages(msgs[:i])
+	return nil
+}
+
+// Retry drops the last exchange from b's history and asks its prompt
+// again, returning the new response. If the request fails the history
+// is left as it was.
+func Retry(ctx context.Context, b Backend) (string, error) {
+	msgs := b.Messages()
+	i, err := lastExchange(msgs)
+	if err != nil {
+		return "", fmt.Errorf("retry: %w", err)
+	}
+	b.SetMessages(msgs[:i])
+	response, err := b.Ask(ctx, msgs[i].Content, msgs[i].Attachments...)
+	if err != nil {
+		b.SetMessages(msgs)
+		return "", err
+	}
+	return response, nil
+}
+
+// Fork replaces dst's history with a copy of src's
+func Fork(dst, src Backend) {
+	dst.SetMessages(src.Messages())
+}
+
+// SetMessage replaces message i of b's history
+func SetMessage(b Backend, i int, msg Message) error {
+	msgs := b.Messages()
+	if i < 0 || i >= len(msgs) {
+		return fmt.Errorf("no message %d in history", i)
+	}
+	msgs[i] = msg
+	b.SetMessages(msgs)
+	return nil
+}
+
+// RemoveMessage deletes message i from b's history
+func RemoveMessage(b Backend, i int) error {
+	msgs := b.Messages()
+	if i < 0 || i >= len(msgs) {
+		return fmt.Errorf("no message %d in history", i)
+	}
+	b.SetMessages(append(msgs[:i], msgs[i+1:]...))
+	return nil
+}
+
+// exchangeLock serializes the exchanges of one conversation, so each
+// prompt is answered with the history before it and a failed prompt is
+// the last message when it is removed. Unlike a sync.Mutex, waiting for
+// it can be cancelled. The zero value is unlocked.
+type exchangeLock struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func (l *exchangeLock) sem() chan struct{} {
+	l.once.Do(func() { l.ch = make(chan struct{}, 1) })
+	return l.ch
+}
+
+// lock waits until no other exchange holds l, or ctx is done
+func (l *exchangeLock) lock(ctx context.Context) error {
+	select {
+	case l.sem() <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *exchangeLock) unlock() {
+	<-l.sem()
+}
@@ -0,0 +1,66 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryOperations(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"again"},"done":true}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	history := []Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "q1"}, {Role: "assistant", Content: "a1"},
+		{Role: "user", Content: "q2"}, {Role: "assistant", Content: "a2"},
+	}
+	client.SetMessages(history)
+
+	// A failed retry leaves the history alone
+	fail = true
+	if _, err := Retry(context.Background(), client); err == nil {
+		t.Fatal("Retry() should fail")
+	}
+	if !reflect.DeepEqual(client.Messages(), history) {
+		t.Errorf("history after failed retry = %v", client.Messages())
+	}
+
+	fail = false
+	if response, err := Retry(context.Background(), client); err != nil || response != "again" {
+		t.Fatalf("Retry() = %q, %v", response, err)
+	}
+	if msgs := client.Messages(); len(msgs) != 5 || msgs[3].Content != "q2" || msgs[4].Content != "again" {
+		t.Errorf("history after retry = %v", msgs)
+	}
+
+	if err := Pop(client); err != nil || len(client.Messages()) != 3 {
+		t.Errorf("Pop() = %v, %d messages left", err, len(client.Messages()))
+	}
+	if err := Rewind(client, 4); err == nil {
+		t.Error("Rewind() past the end should fail")
+	}
+	if err := Rewind(client, 1); err != nil || len(client.Messages()) != 1 {
+		t.Errorf("Rewind(1) = %v, %v", err, client.Messages())
+	}
+	if err := Pop(client); err == nil {
+		t.Error("Pop() with no exchange should fail")
+	}
+
+	other := NewOllamaClient(server.URL)
+	Fork(other, client)
+	if !reflect.DeepEqual(other.Messages(), client.Messages()) {
+		t.Errorf("Fork() copied %v", other.Messages())
+	}
+}
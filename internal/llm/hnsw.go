@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// HNSW graph parameters
+const (
+	hnswM        = 16  // neighbours per node above level 0, twice that at 0
+	hnswEfBuild  = 200 // candidates considered when linking a new node
+	hnswEfSearch = 64  // candidates considered by a search, at least
+	hnswMaxLevel = 16
+)
+
+// hnsw is a hierarchical navigable small world graph (Malkov and
+// Yashunin) over the vectors of an index, for approximate nearest
+// neighbour search. Its nodes are numbered as the index's chunks. The
+// vectors are normalized, so the distance between two is one minus their
+// dot product. Levels are drawn from a generator with a fixed seed, so
+// inserting the same vectors in the same order builds the same graph.
+type hnsw struct {
+	rng   *rand.Rand
+	links [][][]int32 // by node, then level
+	entry int         // -1 while empty
+	top   int         // level of the entry point
+}
+
+// candidate is a node and its distance from the vector searched for
+type candidate struct {
+	node int
+	dist float32
+}
+
+func newHNSW() *hnsw {
+	return &hnsw{rng: rand.New(rand.NewSource(1)), entry: -1}
+}
+
+// dot returns the dot product of two vectors of the same length
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func distance(a, b []float32) float32 {
+	return 1 - dot(a, b)
+}
+
+// insert links the next node, whose vector is vectors[len(g.links)]
+func (g *hnsw) insert(vectors [][]float32) {
+	node := len(g.links)
+	level := min(int(-math.Log(1-g.rng.Float64())/math.Log(hnswM)), hnswMaxLevel)
+	g.links = append(g.links, make([][]int32, level+1))
+	if g.entry < 0 {
+		g.entry, g.top = node, level
+		return
+	}
+
+	q := vectors[node]
+	ep := candidate{g.entry, distance(q, vectors[g.entry])}
+	for l := g.top; l > level; l-- {
+		ep = g.greedy(vectors, q, ep, l)
+	}
+	for l := min(level, g.top); l >= 0; l-- {
+		found := g.searchLevel(vectors, q, ep, hnswEfBuild, l)
+		for _, c := range found[:min(len(found), hnswM)] {
+			g.links[node][l] = append(g.links[node][l], int32(c.node))
+			g.link(vectors, c.node, node, l)
+		}
+		ep = found[0]
+	}
+	if level > g.top {
+		g.entry, g.top = node, level
+	}
+}
+
+// link adds to to the neighbours of from at level l, dropping the
+// farthest of them if it then has too many
+func (g *hnsw) link(vectors [][]float32, from, to, l int) {
+	links := append(g.links[from][l], int32(to))
+	limit := hnswM
+	if l == 0 {
+		limit = 2 * hnswM
+	}
+	if len(links) > limit {
+		v := vectors[from]
+		sort.Slice(links, func(i, j int) bool {
+			return distance(v, vectors[links[i]]) < distance(v, vectors[links[j]])
+		})
+		links = links[:limit]
+	}
+	g.links[from][l] = links
+}
+
+// greedy moves from ep to the nearest node to q at level l
+func (g *hnsw) greedy(vectors [][]float32, q []float32, ep candidate, l int) candidate {
+	for moved := true; moved; {
+		moved = false
+		for _, n := range g.links[ep.node][l] {
+			if d := distance(q, vectors[n]); d < ep.dist {
+				ep, moved = candidate{int(n), d}, true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLevel returns up to ef of the nodes nearest to q at level l,
+// nearest first, searching outwards from ep
+func (g *hnsw) searchLevel(vectors [][]float32, q []float32, ep candidate, ef, l int) []candidate {
+	visited := map[int]bool{ep.node: true}
+	candidates := &candidateHeap{items: []candidate{ep}}
+	results := &candidateHeap{items: []candidate{ep}, farthest: true}
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(candidate)
+		if c.dist > results.items[0].dist {
+			break // nothing left is nearer than the results
+		}
+		for _, n := range g.links[c.node][l] {
+			if visited[int(n)] {
+				continue
+			}
+			visited[int(n)] = true
+			d := distance(q, vectors[n])
+			if results.Len() < ef || d < results.items[0].dist {
+				heap.Push(candidates, candidate{int(n), d})
+				heap.Push(results, candidate{int(n), d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	found := results.items
+	sort.Slice(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+	return found
+}
+
+// search returns up to ef of the nodes nearest to q, nearest first
+func (g *hnsw) search(vectors [][]float32, q []float32, ef int) []candidate {
+	if g.entry < 0 {
+		return nil
+	}
+	ep := candidate{g.entry, distance(q, vectors[g.entry])}
+	for l := g.top; l > 0; l-- {
+		ep = g.greedy(vectors, q, ep, l)
+	}
+	return g.searchLevel(vectors, q, ep, ef, 0)
+}
+
+// candidateHeap is a heap of candidates, nearest or farthest on top
+type candidateHeap struct {
+	items    []candidate
+	farthest bool
+}
+
+func (h *candidateHeap) Len() int { return len(h.items) }
+
+func (h *candidateHeap) Less(i, j int) bool {
+	if h.farthest {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
+}
+
+func (h *candidateHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *candidateHeap) Push(x any) { h.items = append(h.items, x.(candidate)) }
+
+func (h *candidateHeap) Pop() any {
+	n := len(h.items) - 1
+	x := h.items[n]
+	h.items = h.items[:n]
+	return x
+}
@@ -0,0 +1,870 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrNoEmbedder is returned for embeddings asked of a backend that cannot
+// embed text
+var ErrNoEmbedder = errors.New("this backend cannot embed text (the ollama and openai backends can)")
+
+// Index kinds
+const (
+	IndexFlat = "flat" // exact: a query is compared with every chunk
+	IndexHNSW = "hnsw" // approximate: a query walks a graph of the chunks
+)
+
+// Index settings
+const (
+	DefaultChunkSize    = 1000 // characters
+	DefaultChunkOverlap = 200
+	MaxChunkSize        = 100000
+	DefaultIndexK       = 5
+	MaxIndexK           = 100
+)
+
+// Files of an index kept on disk, in a directory of its own. The chunks
+// and vectors files are numbered by the generation in the settings file.
+const (
+	indexSettingsFile = "index.json"
+	indexChunksFile   = "chunks.%d.jsonl"
+	indexVectorsFile  = "vectors.%d.f32"
+)
+
+// indexName is what an index may be called
+var indexName = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]{0,63}$`)
+
+// Document is text to index, under an ID
+type Document struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// Chunk is a piece of an indexed document
+type Chunk struct {
+	ID    string `json:"id"`    // of the document
+	Chunk int    `json:"chunk"` // its place in the document, from 0
+	Text  string `json:"text"`
+}
+
+// Match is a chunk found by a query, scored by its cosine similarity
+// to the query
+type Match struct {
+	Chunk
+	Score float64 `json:"score"`
+}
+
+// IndexSettings describe an index. The model and dimensions are those of
+// the first document added; queries and later documents must be embedded
+// with the same model.
+type IndexSettings struct {
+	Kind       string `json:"kind"`
+	Model      string `json:"model,omitempty"`
+	Dimensions int    `json:"dimensions,omitempty"`
+	ChunkSize  int    `json:"chunk_size"`
+	Overlap    int    `json:"chunk_overlap"`
+	K          int    `json:"k"`
+}
+
+// indexMeta is the settings file of an index on disk
+type indexMeta struct {
+	IndexSettings
+	Generation int `json:"generation"`
+}
+
+// indexRecord is a line of an index's chunks file: a chunk, whose vector
+// is the next in the vectors file, or the removal of a document
+type indexRecord struct {
+	ID      string `json:"id"`
+	Chunk   int    `json:"chunk,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// Index is a searchable collection of embedded document chunks, held in
+// memory and, if it has a directory, on disk. There the settings are in
+// index.json, the chunks in chunks.G.jsonl and their vectors in
+// vectors.G.f32, as a run of Embeddings in their binary encoding; both
+// are only appended to, and synced, so a crash loses at most the last
+// documents added. A document added again replaces the old one, whose
+// chunks stay in the files, unused, until the index is next loaded: it
+// is then compacted into files of the next generation G, which
+// index.json is replaced to name. An HNSW graph is rebuilt from the
+// vectors when the index is loaded.
+type Index struct {
+	name       string
+	dir        string // "" keeps the index in memory only
+	embedder   Embedder
+	generation int // of the files on disk
+
+	mu       sync.RWMutex
+	settings IndexSettings
+	chunks   []Chunk
+	vectors  [][]float32      // normalized, one per chunk
+	removed  []bool           // chunks of removed or replaced documents
+	docs     map[string][]int // chunks by document ID
+	graph    *hnsw            // for IndexHNSW
+}
+
+func newIndex(name, dir, kind string, embedder Embedder) *Index {
+	x := &Index{
+		name:     name,
+		dir:      dir,
+		embedder: embedder,
+		settings: IndexSettings{Kind: kind, ChunkSize: DefaultChunkSize, Overlap: DefaultChunkOverlap, K: DefaultIndexK},
+		docs:     make(map[string][]int),
+	}
+	if kind == IndexHNSW {
+		x.graph = newHNSW()
+	}
+	return x
+}
+
+// Name returns the index's name
+func (x *Index) Name() string {
+	return x.name
+}
+
+// Settings returns the index's settings
+func (x *Index) Settings() IndexSettings {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.settings
+}
+
+// Size returns how many documents and chunks the index holds
+func (x *Index) Size() (docs, chunks int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for _, c := range x.docs {
+		chunks += len(c)
+	}
+	return len(x.docs), chunks
+}
+
+// Documents returns the IDs of the indexed documents, sorted
+func (x *Index) Documents() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	ids := make([]string, 0, len(x.docs))
+	for id := range x.docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SetK sets how many matches a query returns by default
+func (x *Index) SetK(k int) error {
+	if k < 1 || k > MaxIndexK {
+		return fmt.Errorf("k must be between 1 and %d", MaxIndexK)
+	}
+	return x.update(func(s *IndexSettings) { s.K = k })
+}
+
+// SetChunking sets the size of the chunks documents added from now on
+// are split into, and how far each overlaps the last, in characters
+func (x *Index) SetChunking(size, overlap int) error {
+	if size < 1 || size > MaxChunkSize {
+		return fmt.Errorf("chunk size must be between 1 and %d", MaxChunkSize)
+	}
+	if overlap < 0 || overlap >= size {
+		return errors.New("chunk overlap must be at least 0 and less than the chunk size")
+	}
+	return x.update(func(s *IndexSettings) { s.ChunkSize, s.Overlap = size, overlap })
+}
+
+// update changes the settings and saves them
+func (x *Index) update(change func(s *IndexSettings)) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	settings := x.settings
+	change(&settings)
+	if err := x.saveSettings(settings); err != nil {
+		return err
+	}
+	x.settings = settings
+	return nil
+}
+
+// checkModel checks the embedding model is the index's, if it has one
+func (x *Index) checkModel(settings IndexSettings) (string, error) {
+	if x.embedder == nil {
+		return "", ErrNoEmbedder
+	}
+	model := x.embedder.EmbedModel()
+	if settings.Model != "" && model != settings.Model {
+		return "", fmt.Errorf("index %s is embedded with %s, not %s (set embed/model to it)", x.name, settings.Model, model)
+	}
+	return model, nil
+}
+
+// Add chunks, embeds and indexes documents, replacing any already indexed
+// with the same IDs. It returns the number of chunks of each document.
+func (x *Index) Add(ctx context.Context, docs []Document) ([]int, error) {
+	if len(docs) == 0 {
+		return nil, errors.New("index: no documents")
+	}
+	settings := x.Settings()
+	model, err := x.checkModel(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var records []indexRecord
+	var texts []string
+	counts := make([]int, len(docs))
+	for i, doc := range docs {
+		if doc.ID == "" {
+			return nil, fmt.Errorf("index: document %d has no ID", i)
+		}
+		if strings.ContainsAny(doc.ID, "\r\n") {
+			return nil, fmt.Errorf("index: document ID %q has a line break", doc.ID)
+		}
+		if seen[doc.ID] {
+			return nil, fmt.Errorf("index: document %q is given twice", doc.ID)
+		}
+		seen[doc.ID] = true
+		pieces := chunkText(doc.Text, settings.ChunkSize, settings.Overlap)
+		if len(pieces) == 0 {
+			return nil, fmt.Errorf("index: document %q is empty", doc.ID)
+		}
+		counts[i] = len(pieces)
+		for n, text := range pieces {
+			records = append(records, indexRecord{ID: doc.ID, Chunk: n, Text: text})
+			texts = append(texts, text)
+		}
+	}
+
+	embeddings := &Embeddings{Model: model, Dimensions: settings.Dimensions}
+	for start := 0; start < len(texts); start += MaxEmbedInputs {
+		batch, err := x.embedder.Embed(ctx, texts[start:min(start+MaxEmbedInputs, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		if embeddings.Dimensions == 0 {
+			embeddings.Dimensions = batch.Dimensions
+		}
+		if batch.Dimensions != embeddings.Dimensions {
+			return nil, fmt.Errorf("index %s: embeddings have %d dimensions, not %d", x.name, batch.Dimensions, embeddings.Dimensions)
+		}
+		for _, v := range batch.Vectors {
+			embeddings.Vectors = append(embeddings.Vectors, normalize(v))
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.settings.Model != "" && (x.settings.Model != model || x.settings.Dimensions != embeddings.Dimensions) {
+		return nil, fmt.Errorf("index %s is embedded with %s, not %s", x.name, x.settings.Model, model)
+	}
+	if x.settings.Model == "" {
+		settings := x.settings
+		settings.Model, settings.Dimensions = model, embeddings.Dimensions
+		if err := x.saveSettings(settings); err != nil {
+			return nil, err
+		}
+		x.settings = settings
+	}
+
+	var removals []indexRecord
+	for _, doc := range docs {
+		if _, ok := x.docs[doc.ID]; ok {
+			removals = append(removals, indexRecord{ID: doc.ID, Removed: true})
+		}
+	}
+	if err := x.appendFiles(append(removals, records...), embeddings); err != nil {
+		return nil, err
+	}
+	for _, r := range removals {
+		x.remove(r.ID)
+	}
+	for i, r := range records {
+		x.insert(Chunk{ID: r.ID, Chunk: r.Chunk, Text: r.Text}, embeddings.Vectors[i])
+	}
+	return counts, nil
+}
+
+// insert adds a chunk and its vector
+func (x *Index) insert(c Chunk, v []float32) {
+	x.docs[c.ID] = append(x.docs[c.ID], len(x.chunks))
+	x.chunks = append(x.chunks, c)
+	x.vectors = append(x.vectors, v)
+	x.removed = append(x.removed, false)
+	if x.graph != nil {
+		x.graph.insert(x.vectors)
+	}
+}
+
+// remove drops the chunks of a document. An HNSW graph keeps them as
+// nodes, to search through, but they are not matched.
+func (x *Index) remove(id string) {
+	for _, i := range x.docs[id] {
+		x.removed[i] = true
+	}
+	delete(x.docs, id)
+}
+
+// Remove deletes a document from the index
+func (x *Index) Remove(id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.docs[id]; !ok {
+		return fmt.Errorf("index %s has no document %q", x.name, id)
+	}
+	if err := x.appendFiles([]indexRecord{{ID: id, Removed: true}}, nil); err != nil {
+		return err
+	}
+	x.remove(id)
+	return nil
+}
+
+// Query returns the k chunks most similar to text, best first; k = 0
+// means the index's default
+func (x *Index) Query(ctx context.Context, text string, k int) ([]Match, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("index: empty query")
+	}
+	settings := x.Settings()
+	if k == 0 {
+		k = settings.K
+	}
+	if k < 1 || k > MaxIndexK {
+		return nil, fmt.Errorf("k must be between 1 and %d", MaxIndexK)
+	}
+	if _, err := x.checkModel(settings); err != nil {
+		return nil, err
+	}
+	if settings.Model == "" {
+		return []Match{}, nil // nothing added yet
+	}
+	e, err := x.embedder.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	if e.Dimensions != settings.Dimensions {
+		return nil, fmt.Errorf("index %s: the query has %d dimensions, not %d", x.name, e.Dimensions, settings.Dimensions)
+	}
+	return x.search(normalize(e.Vectors[0]), k), nil
+}
+
+// search returns the k chunks nearest to q
+func (x *Index) search(q []float32, k int) []Match {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var found []candidate
+	if x.graph != nil {
+		unused := len(x.chunks)
+		for _, c := range x.docs {
+			unused -= len(c)
+		}
+		found = x.graph.search(x.vectors, q, max(hnswEfSearch, k)+unused)
+	} else {
+		for i, v := range x.vectors {
+			found = append(found, candidate{i, distance(q, v)})
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+	}
+
+	matches := make([]Match, 0, k)
+	for _, c := range found {
+		if len(matches) == k {
+			break
+		}
+		if !x.removed[c.node] {
+			matches = append(matches, Match{Chunk: x.chunks[c.node], Score: float64(1 - c.dist)})
+		}
+	}
+	return matches
+}
+
+// normalize returns v scaled to unit length, so that the dot product of
+// two vectors is their cosine similarity
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if sum == 0 {
+		return out
+	}
+	scale := 1 / math.Sqrt(sum)
+	for i, x := range v {
+		out[i] = float32(float64(x) * scale)
+	}
+	return out
+}
+
+// chunkText splits text into chunks of at most size characters, broken
+// between words. Each chunk after the first starts with the words of
+// the last that begin within overlap characters of its end. A word
+// longer than size is cut.
+func chunkText(text string, size, overlap int) []string {
+	runes := []rune(text)
+	type span struct{ start, end int }
+	var words []span
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		for ; start < i; start += size {
+			words = append(words, span{start, min(start+size, i)})
+		}
+	}
+
+	var chunks []string
+	for first := 0; first < len(words); {
+		start := words[first].start
+		last := first
+		for last+1 < len(words) && words[last+1].end-start <= size {
+			last++
+		}
+		chunks = append(chunks, string(runes[start:words[last].end]))
+		if last == len(words)-1 {
+			break
+		}
+		next := last + 1
+		for next-1 > first && words[next-1].start >= words[last].end-overlap {
+			next--
+		}
+		first = next
+	}
+	return chunks
+}
+
+// saveSettings writes the settings file of an index on disk
+func (x *Index) saveSettings(settings IndexSettings) error {
+	if x.dir == "" {
+		return nil
+	}
+	return x.saveMeta(indexMeta{settings, x.generation})
+}
+
+// saveMeta writes the settings file
+func (x *Index) saveMeta(meta indexMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(x.dir, indexSettingsFile), append(data, '\n'))
+}
+
+// path returns the path of a chunks or vectors file of a generation
+func (x *Index) path(file string, generation int) string {
+	return filepath.Join(x.dir, fmt.Sprintf(file, generation))
+}
+
+// appendFiles records chunks and the removal of documents on disk. The
+// vectors go first, so that a crash between the two leaves unused
+// vectors at the end, rather than chunks without them. If either append
+// fails, both files are cut back to where they were: vectors left in
+// the middle of the file would pair every later chunk with the wrong one.
+func (x *Index) appendFiles(records []indexRecord, vectors *Embeddings) error {
+	if x.dir == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("index: %w", err)
+		}
+	}
+	vectorsPath, chunksPath := x.path(indexVectorsFile, x.generation), x.path(indexChunksFile, x.generation)
+	vectorsSize, err := fileSize(vectorsPath)
+	if err != nil {
+		return err
+	}
+	chunksSize, err := fileSize(chunksPath)
+	if err != nil {
+		return err
+	}
+
+	if vectors != nil && len(vectors.Vectors) > 0 {
+		data, err := vectors.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("index: %w", err)
+		}
+		if err := appendFile(vectorsPath, data); err != nil {
+			return errors.Join(err, truncateFile(vectorsPath, vectorsSize))
+		}
+	}
+	if err := appendFile(chunksPath, buf.Bytes()); err != nil {
+		return errors.Join(err, truncateFile(chunksPath, chunksSize), truncateFile(vectorsPath, vectorsSize))
+	}
+	return nil
+}
+
+// fileSize returns the size of a file, 0 if it does not exist
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("index: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// truncateFile cuts a file back to size, if it exists, and syncs it
+func truncateFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	return nil
+}
+
+// loadIndex reads the index in dir. If its files were cut short or
+// disagree, as after a crash, or more of its chunks are unused than used,
+// it is compacted.
+func loadIndex(name, dir string, embedder Embedder) (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexSettingsFile))
+	if err != nil {
+		return nil, fmt.Errorf("index %s: %w", name, err)
+	}
+	var meta indexMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("index %s: %w", name, err)
+	}
+	if meta.Kind != IndexFlat && meta.Kind != IndexHNSW {
+		return nil, fmt.Errorf("index %s: unknown kind %q", name, meta.Kind)
+	}
+	x := newIndex(name, dir, meta.Kind, embedder)
+	x.settings, x.generation = meta.IndexSettings, meta.Generation
+	x.removeStale()
+
+	vectors, vectorsWhole, err := readVectors(x.path(indexVectorsFile, x.generation), meta.Dimensions)
+	if err != nil {
+		return nil, fmt.Errorf("index %s: %w", name, err)
+	}
+	records, recordsWhole, err := readRecords(x.path(indexChunksFile, x.generation))
+	if err != nil {
+		return nil, fmt.Errorf("index %s: %w", name, err)
+	}
+	used, complete := 0, vectorsWhole && recordsWhole
+	for _, r := range records {
+		if r.Removed {
+			x.remove(r.ID)
+			continue
+		}
+		if used == len(vectors) {
+			complete = false // a chunk written without its vector
+			break
+		}
+		x.insert(Chunk{ID: r.ID, Chunk: r.Chunk, Text: r.Text}, vectors[used])
+		used++
+	}
+
+	_, inUse := x.Size()
+	if complete && used == len(vectors) && len(x.chunks)-inUse <= inUse {
+		return x, nil
+	}
+	return x.compact()
+}
+
+// compact writes the chunks in use, and their vectors, to files of the
+// next generation, switches the settings file to them and deletes the
+// old ones. It returns the index as it is in the new files.
+func (x *Index) compact() (*Index, error) {
+	fresh := newIndex(x.name, x.dir, x.settings.Kind, x.embedder)
+	fresh.settings, fresh.generation = x.settings, x.generation+1
+	var chunks bytes.Buffer
+	enc := json.NewEncoder(&chunks)
+	vectors := &Embeddings{Dimensions: x.settings.Dimensions}
+	for i, c := range x.chunks {
+		if x.removed[i] {
+			continue
+		}
+		if err := enc.Encode(indexRecord{ID: c.ID, Chunk: c.Chunk, Text: c.Text}); err != nil {
+			return nil, fmt.Errorf("index %s: %w", x.name, err)
+		}
+		vectors.Vectors = append(vectors.Vectors, x.vectors[i])
+		fresh.insert(c, x.vectors[i])
+	}
+
+	var data []byte
+	if len(vectors.Vectors) > 0 {
+		var err error
+		if data, err = vectors.MarshalBinary(); err != nil {
+			return nil, fmt.Errorf("index %s: %w", x.name, err)
+		}
+	}
+	if err := writeFileAtomic(fresh.path(indexVectorsFile, fresh.generation), data); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(fresh.path(indexChunksFile, fresh.generation), chunks.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := fresh.saveSettings(fresh.settings); err != nil {
+		return nil, err
+	}
+	fresh.removeStale()
+	return fresh, nil
+}
+
+// removeStale deletes the chunks and vectors files of other generations
+// than the index's, left by a compaction
+func (x *Index) removeStale() {
+	for _, file := range []string{indexChunksFile, indexVectorsFile} {
+		paths, _ := filepath.Glob(filepath.Join(x.dir, strings.Replace(file, "%d", "*", 1)))
+		for _, path := range paths {
+			if path != x.path(file, x.generation) {
+				os.Remove(path)
+			}
+		}
+	}
+}
+
+// readVectors reads a vectors file, ignoring a last run of vectors cut
+// short by a crash; whole is false if there was one
+func readVectors(path string, dims int) (vectors [][]float32, whole bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return vectors, false, nil
+		}
+		n := uint64(binary.LittleEndian.Uint32(data[0:]))
+		size := 8 + 4*n*uint64(binary.LittleEndian.Uint32(data[4:]))
+		if uint64(len(data)) < size {
+			return vectors, false, nil
+		}
+		var e Embeddings
+		if err := e.UnmarshalBinary(data[:size]); err != nil {
+			return nil, false, err
+		}
+		if e.Dimensions != dims {
+			return nil, false, fmt.Errorf("%s: vectors of %d dimensions, not %d", path, e.Dimensions, dims)
+		}
+		vectors = append(vectors, e.Vectors...)
+		data = data[size:]
+	}
+	return vectors, true, nil
+}
+
+// readRecords reads a chunks file, ignoring a last line cut short by a
+// crash; whole is false if there was one
+func readRecords(path string) (records []indexRecord, whole bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return records, len(line) == 0, nil // no newline: an interrupted append
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var record indexRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, false, fmt.Errorf("%s line %d: %w", path, lineNo, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// appendFile adds data to the end of a file, creating it if needed, and
+// syncs it
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("index: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file with data: it is written to a
+// temporary file, synced and renamed over the old one
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("index: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	return nil
+}
+
+// IndexSet is a backend's named indexes, kept in subdirectories of a
+// directory, or in memory if it has none
+type IndexSet struct {
+	dir      string
+	embedder Embedder
+
+	mu      sync.Mutex
+	indexes map[string]*Index
+}
+
+// NewIndexSet opens the indexes in dir, creating the directory if needed;
+// dir "" keeps them in memory. embedder is nil if the backend cannot
+// embed: the indexes can then be listed and removed but not used. An
+// index that cannot be loaded is left out, and reported in the error
+// with the set.
+func NewIndexSet(dir string, embedder Embedder) (*IndexSet, error) {
+	s := &IndexSet{dir: dir, embedder: embedder, indexes: make(map[string]*Index)}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return s, fmt.Errorf("index: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return s, fmt.Errorf("index: %w", err)
+	}
+	var errs []error
+	for _, e := range entries {
+		if !e.IsDir() || !indexName.MatchString(e.Name()) {
+			continue
+		}
+		x, err := loadIndex(e.Name(), filepath.Join(dir, e.Name()), embedder)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.indexes[e.Name()] = x
+	}
+	return s, errors.Join(errs...)
+}
+
+// Names returns the names of the indexes, sorted
+func (s *IndexSet) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.indexes))
+	for name := range s.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the named index
+func (s *IndexSet) Get(name string) (*Index, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.indexes[name]
+	return x, ok
+}
+
+// Create adds an empty index of a kind, IndexFlat or IndexHNSW
+func (s *IndexSet) Create(name, kind string) (*Index, error) {
+	if !indexName.MatchString(name) {
+		return nil, fmt.Errorf("invalid index name %q", name)
+	}
+	if kind != IndexFlat && kind != IndexHNSW {
+		return nil, fmt.Errorf("unknown index kind %q (use %s or %s)", kind, IndexFlat, IndexHNSW)
+	}
+	if s.embedder == nil {
+		return nil, ErrNoEmbedder
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.indexes[name]; ok {
+		return nil, fmt.Errorf("index %s already exists", name)
+	}
+	dir := ""
+	if s.dir != "" {
+		dir = filepath.Join(s.dir, name)
+		if err := os.Mkdir(dir, 0700); err != nil {
+			return nil, fmt.Errorf("index: %w", err)
+		}
+	}
+	x := newIndex(name, dir, kind, s.embedder)
+	if err := x.saveSettings(x.settings); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	s.indexes[name] = x
+	return x, nil
+}
+
+// Remove deletes the named index, and its files
+func (s *IndexSet) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.indexes[name]
+	if !ok {
+		return fmt.Errorf("no index %q", name)
+	}
+	if x.dir != "" {
+		if err := os.RemoveAll(x.dir); err != nil {
+			return fmt.Errorf("index: %w", err)
+		}
+	}
+	delete(s.indexes, name)
+	return nil
+}
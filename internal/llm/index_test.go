@@ -0,0 +1,301 @@
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wordEmbedder embeds text as the counts of its words, hashed into 64
+// dimensions, so texts sharing words are similar
+type wordEmbedder struct {
+	model  string
+	inputs int
+}
+
+func (e *wordEmbedder) Embed(ctx context.Context, inputs []string) (*Embeddings, error) {
+	if err := checkEmbedInputs(inputs); err != nil {
+		return nil, err
+	}
+	e.inputs += len(inputs)
+	out := &Embeddings{Model: e.model, Dimensions: 64}
+	for _, input := range inputs {
+		v := make([]float32, 64)
+		for _, word := range strings.Fields(strings.ToLower(input)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(word, ".,")))
+			v[h.Sum32()%64]++
+		}
+		out.Vectors = append(out.Vectors, v)
+	}
+	return out, nil
+}
+
+func (e *wordEmbedder) EmbedModel() string         { return e.model }
+func (e *wordEmbedder) SetEmbedModel(model string) { e.model = model }
+
+var animals = []Document{
+	{ID: "zebra", Text: "The zebra has black and white stripes."},
+	{ID: "owl", Text: "An owl hunts at night and sleeps by day."},
+	{ID: "salmon", Text: "Salmon swim upstream to spawn in rivers."},
+}
+
+func TestChunkText(t *testing.T) {
+	got := chunkText("one two three four five six seven", 14, 5)
+	want := []string{"one two three", "three four", "four five six", "six seven"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("chunkText() = %q, want %q", got, want)
+	}
+	if got := chunkText("  a\n\nb  ", 100, 10); len(got) != 1 || got[0] != "a\n\nb" {
+		t.Errorf("chunkText() = %q", got)
+	}
+	if got := chunkText("abcdefghij", 4, 0); strings.Join(got, "|") != "abcd|efgh|ij" {
+		t.Errorf("long word = %q", got)
+	}
+	if got := chunkText(" \n ", 10, 0); len(got) != 0 {
+		t.Errorf("blank text = %q", got)
+	}
+}
+
+func TestIndex_AddQuery(t *testing.T) {
+	for _, kind := range []string{IndexFlat, IndexHNSW} {
+		t.Run(kind, func(t *testing.T) {
+			embedder := &wordEmbedder{model: "words"}
+			set, _ := NewIndexSet("", embedder)
+			x, err := set.Create("animals", kind)
+			if err != nil {
+				t.Fatalf("Create() error: %v", err)
+			}
+			counts, err := x.Add(context.Background(), animals)
+			if err != nil || len(counts) != 3 || counts[0] != 1 {
+				t.Fatalf("Add() = %v, %v", counts, err)
+			}
+
+			matches, err := x.Query(context.Background(), "which animal has stripes", 2)
+			if err != nil {
+				t.Fatalf("Query() error: %v", err)
+			}
+			if len(matches) != 2 || matches[0].ID != "zebra" || matches[0].Score <= matches[1].Score {
+				t.Errorf("Query() = %+v", matches)
+			}
+
+			// Adding a document again replaces it
+			x.Add(context.Background(), []Document{{ID: "zebra", Text: "Zebras graze on grass."}})
+			matches, _ = x.Query(context.Background(), "stripes", 0)
+			if docs, chunks := x.Size(); docs != 3 || chunks != 3 || len(matches) != 3 {
+				t.Errorf("after replacing: %d documents, %d chunks, %d matches", docs, chunks, len(matches))
+			}
+			for _, m := range matches {
+				if strings.Contains(m.Text, "stripes") {
+					t.Errorf("the replaced document matched: %+v", m)
+				}
+			}
+
+			if err := x.Remove("owl"); err != nil {
+				t.Fatalf("Remove() error: %v", err)
+			}
+			if x.Remove("owl") == nil {
+				t.Error("removing a missing document should fail")
+			}
+			if got := strings.Join(x.Documents(), " "); got != "salmon zebra" {
+				t.Errorf("Documents() = %s", got)
+			}
+
+			embedder.model = "other"
+			if _, err := x.Query(context.Background(), "owl", 0); err == nil || !strings.Contains(err.Error(), "words") {
+				t.Errorf("a query with another model should fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestIndex_Errors(t *testing.T) {
+	set, _ := NewIndexSet("", &wordEmbedder{model: "words"})
+	if _, err := set.Create("../up", IndexFlat); err == nil {
+		t.Error("Create() should refuse a path as a name")
+	}
+	if _, err := set.Create("x", "ivf"); err == nil {
+		t.Error("Create() should refuse an unknown kind")
+	}
+	x, _ := set.Create("x", IndexFlat)
+	if _, err := set.Create("x", IndexFlat); err == nil {
+		t.Error("Create() should refuse an existing name")
+	}
+	for _, docs := range [][]Document{nil, {{Text: "no id"}}, {{ID: "a", Text: " "}}, {{ID: "a", Text: "x"}, {ID: "a", Text: "y"}}} {
+		if _, err := x.Add(context.Background(), docs); err == nil {
+			t.Errorf("Add(%v) should fail", docs)
+		}
+	}
+	if matches, err := x.Query(context.Background(), "anything", 0); err != nil || len(matches) != 0 {
+		t.Errorf("Query() of an empty index = %v, %v", matches, err)
+	}
+	if _, err := x.Query(context.Background(), "anything", MaxIndexK+1); err == nil {
+		t.Error("Query() should refuse too large a k")
+	}
+	if x.SetChunking(100, 100) == nil || x.SetK(0) == nil {
+		t.Error("bad settings should fail")
+	}
+
+	none, _ := NewIndexSet("", nil)
+	if _, err := none.Create("x", IndexFlat); err != ErrNoEmbedder {
+		t.Errorf("Create() without an embedder error = %v", err)
+	}
+}
+
+func TestIndexSet_Persist(t *testing.T) {
+	dir := t.TempDir()
+	embedder := &wordEmbedder{model: "words"}
+	set, err := NewIndexSet(dir, embedder)
+	if err != nil {
+		t.Fatalf("NewIndexSet() error: %v", err)
+	}
+	x, _ := set.Create("animals", IndexHNSW)
+	x.SetK(2)
+	x.Add(context.Background(), animals[:2])
+	x.Add(context.Background(), animals[2:])
+
+	// Reopened, the index is as it was, and nothing is embedded again
+	embedded := embedder.inputs
+	set, err = NewIndexSet(dir, embedder)
+	if err != nil {
+		t.Fatalf("NewIndexSet() error: %v", err)
+	}
+	x, ok := set.Get("animals")
+	if !ok {
+		t.Fatalf("Names() = %v", set.Names())
+	}
+	if s := x.Settings(); s.Kind != IndexHNSW || s.Model != "words" || s.Dimensions != 64 || s.K != 2 {
+		t.Errorf("Settings() = %+v", s)
+	}
+	matches, _ := x.Query(context.Background(), "rivers", 0)
+	if len(matches) != 2 || matches[0].ID != "salmon" || embedder.inputs != embedded+1 {
+		t.Errorf("Query() = %+v", matches)
+	}
+
+	// Replacing and removing documents leaves more chunks unused than
+	// used, so the files are compacted into a new generation on loading
+	x.Add(context.Background(), []Document{{ID: "zebra", Text: "Zebras graze."}, {ID: "owl", Text: "Owls hoot."}})
+	x.Remove("salmon")
+	set, _ = NewIndexSet(dir, embedder)
+	x, _ = set.Get("animals")
+	if x.generation != 1 {
+		t.Errorf("generation = %d, want 1", x.generation)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "animals", "chunks.0.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("the old chunks file is still there (%v)", err)
+	}
+	if docs, chunks := x.Size(); docs != 2 || chunks != 2 {
+		t.Errorf("Size() = %d, %d", docs, chunks)
+	}
+
+	// A chunk cut short by a crash is dropped, and the files compacted
+	// so that later chunks are not appended to it
+	f, _ := os.OpenFile(filepath.Join(dir, "animals", "chunks.1.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"id":"cut`)
+	f.Close()
+	set, err = NewIndexSet(dir, embedder)
+	if x, _ = set.Get("animals"); err != nil || x == nil || x.generation != 2 {
+		t.Fatalf("NewIndexSet() = %v, error %v", x, err)
+	}
+	x.Add(context.Background(), animals[2:])
+	set, err = NewIndexSet(dir, embedder)
+	if x, _ = set.Get("animals"); err != nil || x == nil {
+		t.Fatalf("NewIndexSet() error: %v", err)
+	}
+	if matches, _ := x.Query(context.Background(), "owls hoot", 1); len(matches) != 1 || matches[0].ID != "owl" {
+		t.Errorf("Query() = %+v", matches)
+	}
+
+	if err := set.Remove("animals"); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "animals")); !os.IsNotExist(err) {
+		t.Errorf("the index directory is still there (%v)", err)
+	}
+}
+
+func TestIndex_FailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	embedder := &wordEmbedder{model: "words"}
+	set, _ := NewIndexSet(dir, embedder)
+	x, _ := set.Create("animals", IndexFlat)
+	if _, err := x.Add(context.Background(), animals[:1]); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	vectorsPath := filepath.Join(dir, "animals", "vectors.0.f32")
+	chunksPath := filepath.Join(dir, "animals", "chunks.0.jsonl")
+	before, _ := os.Stat(vectorsPath)
+
+	// The chunks cannot be appended, as a directory is in the way: the
+	// vectors written for them must not stay
+	os.Rename(chunksPath, chunksPath+".saved")
+	os.Mkdir(chunksPath, 0700)
+	if _, err := x.Add(context.Background(), animals[1:2]); err == nil {
+		t.Fatal("Add() should fail when the chunks cannot be written")
+	}
+	os.Remove(chunksPath)
+	os.Rename(chunksPath+".saved", chunksPath)
+	if after, _ := os.Stat(vectorsPath); after.Size() != before.Size() {
+		t.Errorf("vectors file is %d bytes, was %d", after.Size(), before.Size())
+	}
+
+	x.Add(context.Background(), animals[2:])
+	set, _ = NewIndexSet(dir, embedder)
+	x, _ = set.Get("animals")
+	if got := strings.Join(x.Documents(), " "); got != "salmon zebra" {
+		t.Errorf("Documents() = %s", got)
+	}
+	for _, doc := range []Document{animals[0], animals[2]} {
+		matches, _ := x.Query(context.Background(), doc.Text, 1)
+		if len(matches) != 1 || matches[0].ID != doc.ID || matches[0].Score < 0.999 {
+			t.Errorf("Query(%q) = %+v", doc.Text, matches)
+		}
+	}
+}
+
+func TestHNSW_Recall(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	var vectors [][]float32
+	g := newHNSW()
+	for i := 0; i < 2000; i++ {
+		v := make([]float32, 16)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		vectors = append(vectors, normalize(v))
+		g.insert(vectors)
+	}
+
+	found, total := 0, 0
+	for i := 0; i < 50; i++ {
+		q := vectors[rng.Intn(len(vectors))]
+		near := g.search(vectors, q, hnswEfSearch)
+		best := bruteForce(vectors, q, 10)
+		for _, c := range near[:10] {
+			if best[c.node] {
+				found++
+			}
+		}
+		total += 10
+	}
+	if recall := float64(found) / float64(total); recall < 0.95 {
+		t.Errorf("recall = %.2f", recall)
+	}
+}
+
+// bruteForce returns the k vectors nearest to q
+func bruteForce(vectors [][]float32, q []float32, k int) map[int]bool {
+	x := &Index{vectors: vectors, removed: make([]bool, len(vectors))}
+	for i := range vectors {
+		x.chunks = append(x.chunks, Chunk{Chunk: i})
+	}
+	best := make(map[int]bool)
+	for _, m := range x.search(q, k) {
+		best[m.Chunk.Chunk] = true
+	}
+	return best
+}
@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"net/http"
+	"time"
+)
+
+// Backend names, as given to -backend and reported in Meta
+const (
+	BackendAPI    = "api"
+	BackendCLI    = "cli"
+	BackendOllama = "ollama"
+	BackendOpenAI = "openai"
+)
+
+// Meta describes an exchange: which model answered, how the response
+// ended and what it used. Token counts cover all continuations.
+type Meta struct {
+	Model            string    `json:"model"`                // model that answered, as reported by the backend
+	Backend          string    `json:"backend"`              // BackendAPI, BackendCLI, ...
+	RequestID        string    `json:"request_id,omitempty"` // the server's ID for the (last) request, if any
+	StopReason       string    `json:"stop_reason"`          // StopEndTurn, StopMaxTokens, ... ("" if unknown)
+	Continuations    int       `json:"continuations"`        // follow-up requests joined into the response
+	ToolRounds       int       `json:"tool_rounds"`          // rounds of tool calls run before the response
+	SchemaRetries    int       `json:"schema_retries"`       // answers sent back for not matching the schema
+	InputTokens      int       `json:"input_tokens"`
+	OutputTokens     int       `json:"output_tokens"`
+	CacheReadTokens  int       `json:"cache_read_tokens"`
+	CacheWriteTokens int       `json:"cache_write_tokens"`
+	ContextTokens    int       `json:"context_tokens"`      // the context after the exchange: the last request's prompt and response
+	Estimated        bool      `json:"estimated,omitempty"` // token counts are estimated, not reported
+	LatencyMs        int64     `json:"latency_ms"`
+	Time             time.Time `json:"time"` // when the response completed
+}
+
+// done completes the meta of an exchange that began at start, passes
+// its figures to the metrics callback and counts it in the lifetime usage
+func (m *Meta) done(backend string, start time.Time) {
+	m.Backend = backend
+	m.LatencyMs = time.Since(start).Milliseconds()
+	m.Time = time.Now().UTC()
+	RecordMetrics(m.InputTokens, m.OutputTokens, m.LatencyMs)
+	recordLifetime(*m)
+}
+
+// requestID returns the request ID a response carries in header, or
+// fallback if there is none
+func requestID(resp *http.Response, header, fallback string) string {
+	if resp != nil {
+		if id := resp.Header.Get(header); id != "" {
+			return id
+		}
+	}
+	return fallback
+}
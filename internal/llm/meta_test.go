@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+func TestClient_Meta(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("request-id", "req_123")
+		fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":100,"cache_creation_input_tokens":20}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	if _, err := client.Ask(context.Background(), "hello"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+
+	meta := client.LastMeta()
+	want := Meta{
+		Model: "claude-test", Backend: BackendAPI, RequestID: "req_123", StopReason: StopEndTurn,
+		InputTokens: 10, OutputTokens: 5, CacheReadTokens: 100, CacheWriteTokens: 20,
+		ContextTokens: 135, // the whole prompt, cached or not, and the response
+	}
+	got := meta
+	got.LatencyMs, got.Time = 0, want.Time
+	if got != want {
+		t.Errorf("LastMeta() = %+v, want %+v", got, want)
+	}
+	if meta.Time.IsZero() {
+		t.Error("LastMeta().Time not set")
+	}
+
+	client.Reset()
+	if !client.LastMeta().Time.IsZero() {
+		t.Error("Reset() should clear the meta")
+	}
+}
+
+func TestOllamaClient_Meta(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"model":"llama3.2:latest","message":{"role":"assistant","content":"hi"},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":3}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	reply, err := client.Complete(context.Background(), nil, "hello")
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if reply.Model != "llama3.2:latest" || reply.Backend != BackendOllama || reply.StopReason != StopEndTurn ||
+		reply.InputTokens != 12 || reply.OutputTokens != 3 || reply.Estimated {
+		t.Errorf("Complete() meta = %+v", reply.Meta)
+	}
+}
+
+func TestOpenAIClient_Meta(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-request-id", "req_abc")
+		fmt.Fprint(w, `{"id":"chatcmpl-1","model":"gpt-test-0613","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"prompt_tokens_details":{"cached_tokens":8}}}`)
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient(server.URL, "")
+	client.SetModel("gpt-test")
+	if _, err := client.Ask(context.Background(), "hello"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	meta := client.LastMeta()
+	if meta.Model != "gpt-test-0613" || meta.Backend != BackendOpenAI || meta.RequestID != "req_abc" ||
+		meta.InputTokens != 10 || meta.OutputTokens != 2 || meta.CacheReadTokens != 8 {
+		t.Errorf("LastMeta() = %+v", meta)
+	}
+}
+
+func TestEstimatedMeta(t *testing.T) {
+	meta := estimatedMeta("sonnet", "12345678", "1234")
+	if meta.InputTokens != 3 || meta.OutputTokens != 2 || meta.ContextTokens != 5 || !meta.Estimated || meta.Model != "sonnet" {
+		t.Errorf("estimatedMeta() = %+v", meta)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"sync"
@@ -20,15 +19,28 @@ type OllamaClient struct {
 	baseURL      string
 	httpClient   *http.Client
 	model        string
+	embedModel   string // model for Embed
 	temperature  float64
 	systemPrompt string
 	prefill      string // Not supported by Ollama, stored but ignored
+	think        int    // ThinkingTokens setting; any non-zero value enables think
+	params       Params
+	continuation int // max automatic continuations at the length limit
+	lastThinking string
+	lastMeta     Meta
+	usage        Usage
+	compact      CompactPolicy // how Compact shrinks the history
 	messages     []Message
 	lastTokens   int
-	totalTokens  int
+	totalTokens  int          // context size, see TotalTokens
+	turn         exchangeLock // held by an exchange: Ask, stream or Compact
 	streaming    bool
 	streamChan   chan string
 	streamDone   chan struct{}
+	thinking     *thinkingStream
+	retry        *Retrier
+	tools        *Toolbox
+	schema       *Schema
 }
 
 // ollamaChatRequest represents a request to /api/chat
@@ -36,18 +48,41 @@ type ollamaChatRequest struct {
 	Model    string          `json:"model"`
 	Messages []ollamaMessage `json:"messages"`
 	Stream   bool            `json:"stream"`
+	Think    bool            `json:"think,omitempty"` // reasoning models only
 	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    []openAITool    `json:"tools,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"` // a JSON Schema the response must match
 }
 
 // ollamaMessage represents a message in the Ollama format
 type ollamaMessage struct {
-	Role    string `json:"role"` // "system", "user", or "assistant"
-	Content string `json:"content"`
+	Role      string           `json:"role"` // "system", "user", "assistant" or "tool"
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"` // from reasoning models
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // the tool a tool message answers
+	Images    []string         `json:"images,omitempty"`    // base64 images, for vision models
+}
+
+// ollamaToolCall is a call in an assistant message. Ollama identifies
+// calls only in recent versions, and answers by tool name.
+type ollamaToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
 }
 
 // ollamaOptions represents generation options
 type ollamaOptions struct {
-	Temperature float64 `json:"temperature,omitempty"`
+	Temperature float64  `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	TopP        float64  `json:"top_p,omitempty"`
+	TopK        int      `json:"top_k,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
 }
 
 // ollamaChatResponse represents a response from /api/chat
@@ -56,6 +91,7 @@ type ollamaChatResponse struct {
 	CreatedAt          string        `json:"created_at"`
 	Message            ollamaMessage `json:"message"`
 	Done               bool          `json:"done"`
+	DoneReason         string        `json:"done_reason,omitempty"` // "stop" or "length"
 	TotalDuration      int64         `json:"total_duration,omitempty"`
 	LoadDuration       int64         `json:"load_duration,omitempty"`
 	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
@@ -83,11 +119,32 @@ func NewOllamaClient(baseURL string) *OllamaClient {
 		baseURL:     baseURL,
 		httpClient:  &http.Client{Timeout: 5 * time.Minute},
 		model:       "llama3.2",
+		embedModel:  DefaultOllamaEmbedModel,
 		temperature: 0.7,
 		messages:    make([]Message, 0),
+		retry:       NewRetrier(DefaultRetryPolicy),
+		tools:       NewToolbox(),
+		schema:      NewSchema(),
+		compact:     DefaultCompactPolicy,
+		usage:       Usage{},
 	}
 }
 
+// Retrier returns the retry policy and status for this client's requests
+func (c *OllamaClient) Retrier() *Retrier {
+	return c.retry
+}
+
+// Toolbox returns the tools offered to this client's conversations
+func (c *OllamaClient) Toolbox() *Toolbox {
+	return c.tools
+}
+
+// Schema returns the JSON Schema this client's answers must match
+func (c *OllamaClient) Schema() *Schema {
+	return c.schema
+}
+
 // Model returns the current model name
 func (c *OllamaClient) Model() string {
 	c.mu.RLock()
@@ -120,14 +177,131 @@ func (c *OllamaClient) SetTemperature(temp float64) error {
 	return nil
 }
 
-// ThinkingTokens returns 0 - Ollama models don't have extended thinking
+// ThinkingTokens returns the thinking setting. Ollama has no budget:
+// any non-zero value asks reasoning models to think.
 func (c *OllamaClient) ThinkingTokens() int {
-	return 0
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.think
 }
 
-// SetThinkingTokens is a no-op for Ollama
+// SetThinkingTokens enables (non-zero) or disables (0) thinking for
+// reasoning models. Models without thinking support reject requests
+// while it is enabled.
 func (c *OllamaClient) SetThinkingTokens(tokens int) {
-	// No-op: Ollama models don't support extended thinking
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.think = tokens
+}
+
+// LastThinking returns the model's thinking for the last response
+func (c *OllamaClient) LastThinking() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastThinking
+}
+
+// LastMeta returns how the last response ended
+func (c *OllamaClient) LastMeta() Meta {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastMeta
+}
+
+// Usage returns the tokens the conversation has used, by model, since it
+// began or was last reset
+func (c *OllamaClient) Usage() Usage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.usage.clone()
+}
+
+// MaxContinuations returns the automatic continuation limit
+func (c *OllamaClient) MaxContinuations() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.continuation
+}
+
+// SetMaxContinuations sets how many times a response that stops at the
+// num_predict limit is continued
+func (c *OllamaClient) SetMaxContinuations(n int) error {
+	if err := validateContinuations(n); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.continuation = n
+	return nil
+}
+
+// CompactPolicy returns how Compact shrinks the history
+func (c *OllamaClient) CompactPolicy() CompactPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.compact
+}
+
+// SetCompactPolicy sets how Compact shrinks the history
+func (c *OllamaClient) SetCompactPolicy(p CompactPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compact = p
+	return nil
+}
+
+// ollamaParams are the generation parameters Ollama's options accept
+var ollamaParams = []string{ParamMaxTokens, ParamTopP, ParamTopK, ParamStop, ParamSeed, ParamNumCtx}
+
+// Params returns the generation parameters
+func (c *OllamaClient) Params() Params {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.params.clone()
+}
+
+// SetParams sets the generation parameters; max_tokens is sent as num_predict
+func (c *OllamaClient) SetParams(p Params) error {
+	if err := checkParams(p, ollamaParams); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.params = p.clone()
+	return nil
+}
+
+// Cache returns CacheOff: Ollama reuses its own KV cache, without
+// cache breakpoints
+func (c *OllamaClient) Cache() string {
+	return CacheOff
+}
+
+// SetCache accepts only CacheOff
+func (c *OllamaClient) SetCache(mode string) error {
+	return noCacheControl(BackendOllama, mode)
+}
+
+// SupportedParams lists the generation parameters Ollama applies
+func (c *OllamaClient) SupportedParams() []string {
+	return ollamaParams
+}
+
+// options builds the request options; the caller must hold c.mu
+func (c *OllamaClient) options() *ollamaOptions {
+	p := c.params.clone()
+	return &ollamaOptions{
+		Temperature: c.temperature,
+		NumPredict:  p.MaxTokens,
+		TopP:        p.TopP,
+		TopK:        p.TopK,
+		Stop:        p.Stop,
+		Seed:        p.Seed,
+		NumCtx:      p.NumCtx,
+	}
 }
 
 // Prefill returns the prefill string (not used by Ollama)
@@ -185,7 +359,23 @@ func (c *OllamaClient) MessagesJSON() ([]byte, error) {
 func (c *OllamaClient) AddSystemMessage(content string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.messages = append([]Message{{Role: "system", Content: content}}, c.messages...)
+	msg := newMessage("system", content)
+	c.messages = append([]Message{msg}, c.messages...)
+	c.totalTokens += estimateMessage(msg)
+}
+
+// SetMessages replaces the conversation history
+func (c *OllamaClient) SetMessages(msgs []Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(make([]Message, 0, len(msgs)), msgs...)
+	c.totalTokens = estimateContext(c.systemPrompt, c.messages)
+}
+
+// ValidateMessages checks that msgs has only roles the backend knows;
+// consecutive turns of the same role are accepted
+func (c *OllamaClient) ValidateMessages(msgs []Message) error {
+	return CheckMessages(msgs, false)
 }
 
 // Reset clears the conversation history
@@ -195,21 +385,29 @@ func (c *OllamaClient) Reset() {
 	c.messages = make([]Message, 0)
 	c.lastTokens = 0
 	c.totalTokens = 0
+	c.lastThinking = ""
+	c.lastMeta = Meta{}
+	c.usage = Usage{}
 }
 
-// TotalTokens returns cumulative token count for this conversation
+// TotalTokens returns the context size of the conversation
 func (c *OllamaClient) TotalTokens() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.totalTokens
 }
 
-// ContextLimit returns the model's context window limit
+// ContextLimit returns the model's context window limit, or num_ctx if set
 func (c *OllamaClient) ContextLimit() int {
 	c.mu.RLock()
 	model := c.model
+	numCtx := c.params.NumCtx
 	c.mu.RUnlock()
 
+	if numCtx > 0 {
+		return numCtx
+	}
+
 	// Try to get from Ollama API
 	limit := c.queryContextLimit(model)
 	if limit > 0 {
@@ -266,7 +464,7 @@ func contextLimitForOllamaModel(model string) int {
 }
 
 // buildOllamaMessages converts internal messages to Ollama format
-func (c *OllamaClient) buildOllamaMessages(history []Message, prompt string) []ollamaMessage {
+func (c *OllamaClient) buildOllamaMessages(history []Message, prompt Message) []ollamaMessage {
 	var msgs []ollamaMessage
 
 	// Add system prompt first if set
@@ -275,156 +473,387 @@ func (c *OllamaClient) buildOllamaMessages(history []Message, prompt string) []o
 	}
 
 	// Add history, handling our system messages
+	msgs = append(msgs, ollamaHistory(history)...)
+
+	// Add the new user prompt
+	msgs = append(msgs, ollamaUser(prompt))
+
+	return msgs
+}
+
+// ollamaHistory converts history messages. Tool results become tool
+// messages naming the tool of their call, before any text of their user
+// message; Ollama has no error flag, so failures say so in the content.
+func ollamaHistory(history []Message) []ollamaMessage {
+	var msgs []ollamaMessage
+	names := make(map[string]string) // call ID to tool name
 	for _, msg := range history {
 		switch msg.Role {
-		case "system":
-			msgs = append(msgs, ollamaMessage{Role: "system", Content: msg.Content})
+		case "system", "assistant":
+			m := ollamaMessage{Role: msg.Role, Content: msg.Content}
+			for _, call := range msg.ToolCalls {
+				var tc ollamaToolCall
+				tc.ID = call.ID
+				tc.Function.Name, tc.Function.Arguments = call.Name, call.Input
+				m.ToolCalls = append(m.ToolCalls, tc)
+				names[call.ID] = call.Name
+			}
+			msgs = append(msgs, m)
 		case "user":
-			msgs = append(msgs, ollamaMessage{Role: "user", Content: msg.Content})
-		case "assistant":
-			msgs = append(msgs, ollamaMessage{Role: "assistant", Content: msg.Content})
+			for _, result := range msg.ToolResults {
+				msgs = append(msgs, ollamaMessage{Role: "tool", Content: toolResultText(result), ToolName: names[result.CallID]})
+			}
+			if msg.Content != "" || len(msg.ToolResults) == 0 {
+				msgs = append(msgs, ollamaUser(msg))
+			}
 		}
 	}
+	return msgs
+}
 
-	// Add the new user prompt
-	msgs = append(msgs, ollamaMessage{Role: "user", Content: prompt})
+// ollamaUser converts the text of a user message and its attachments:
+// images go in the images array, and the text of the others before the
+// content
+func ollamaUser(msg Message) ollamaMessage {
+	m := ollamaMessage{Role: "user"}
+	var text []string
+	for _, a := range msg.Attachments {
+		if a.IsImage() && len(a.Data) > 0 {
+			m.Images = append(m.Images, a.base64())
+			continue
+		}
+		text = append(text, a.text())
+	}
+	if msg.Content != "" || len(text) == 0 {
+		text = append(text, msg.Content)
+	}
+	m.Content = strings.Join(text, "\n")
+	return m
+}
 
-	return msgs
+// ollamaCalls converts the tool calls of a response, identifying any
+// the server did not
+func ollamaCalls(calls []ollamaToolCall) []ToolCall {
+	var converted []ToolCall
+	for _, call := range calls {
+		input := call.Function.Arguments
+		if len(input) == 0 || string(input) == "null" {
+			input = json.RawMessage("{}")
+		}
+		id := call.ID
+		if id == "" {
+			id = newToolCallID()
+		}
+		converted = append(converted, ToolCall{ID: id, Name: call.Function.Name, Input: input})
+	}
+	return converted
 }
 
-// Compact summarizes the conversation to reduce token usage
+// Compact shrinks the conversation according to the compaction policy
 func (c *OllamaClient) Compact(ctx context.Context) error {
-	c.mu.Lock()
-	if len(c.messages) < 4 {
-		c.mu.Unlock()
-		return nil // Not enough to compact
+	if err := c.turn.lock(ctx); err != nil {
+		return err
 	}
+	defer c.turn.unlock()
+	c.mu.Lock()
+	msgs := append([]Message(nil), c.messages...)
+	policy := c.compact
+	c.mu.Unlock()
 
-	// Build conversation text for summarization
-	var conversationText string
-	for _, msg := range c.messages {
-		if msg.Role == "system" {
-			continue
-		}
-		conversationText += fmt.Sprintf("%s: %s\n\n", msg.Role, msg.Content)
+	compacted, err := compactMessages(ctx, msgs, policy, c.Summarize)
+	if err != nil || compacted == nil {
+		return err
 	}
 
-	model := c.model
+	c.mu.Lock()
+	c.messages = compacted
+	c.totalTokens = estimateContext(c.systemPrompt, compacted)
 	c.mu.Unlock()
+	return nil
+}
 
-	// Use Ollama to summarize
-	summaryPrompt := "Summarize this conversation concisely, preserving key facts, decisions, and context needed to continue:\n\n" + conversationText
+// Summarize asks model (the conversation's model if empty) for a summary
+// outside the conversation
+func (c *OllamaClient) Summarize(ctx context.Context, model, prompt string) (string, int, error) {
+	c.mu.RLock()
+	if model == "" {
+		model = c.model
+	}
+	numCtx := c.params.NumCtx
+	c.mu.RUnlock()
 
+	// Keep the configured context window so the whole conversation fits
 	req := ollamaChatRequest{
 		Model: model,
 		Messages: []ollamaMessage{
-			{Role: "user", Content: summaryPrompt},
+			{Role: "user", Content: prompt},
 		},
-		Stream: false,
+		Stream:  false,
+		Options: &ollamaOptions{NumCtx: numCtx},
 	}
 
-	reqBody, err := json.Marshal(req)
+	chatResp, err := c.chat(ctx, req)
 	if err != nil {
-		return fmt.Errorf("compaction failed: %w", err)
+		return "", 0, err
 	}
+	recordLifetime(Meta{Model: chatResp.Model, Backend: BackendOllama,
+		InputTokens: chatResp.PromptEvalCount, OutputTokens: chatResp.EvalCount})
+	return chatResp.Message.Content, chatResp.PromptEvalCount + chatResp.EvalCount, nil
+}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/chat", bytes.NewReader(reqBody))
-	if err != nil {
-		return fmt.Errorf("compaction failed: %w", err)
+// Ask sends a prompt, with any attachments, to Ollama and returns the
+// response. Vision models take images; PDFs cannot be sent.
+func (c *OllamaClient) Ask(ctx context.Context, prompt string, attachments ...Attachment) (string, error) {
+	if err := checkAttachments(BackendOllama, attachments, true, false); err != nil {
+		return "", err
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("compaction failed: %w", err)
+	if err := c.turn.lock(ctx); err != nil {
+		return "", err
 	}
-	defer resp.Body.Close()
+	defer c.turn.unlock()
+	c.mu.Lock()
+	c.messages = append(c.messages, newPrompt(prompt, attachments))
+	msgs := c.buildOllamaMessages(c.messages[:len(c.messages)-1], c.messages[len(c.messages)-1]) // Don't include the just-added msg
+	model := c.model
+	options := c.options()
+	think := c.think != 0
+	continuations := c.continuation
+	previous := c.totalTokens
+	c.mu.Unlock()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("compaction failed: HTTP %d: %s", resp.StatusCode, string(body))
+	req := ollamaChatRequest{
+		Model:    model,
+		Messages: msgs,
+		Stream:   false,
+		Think:    think,
+		Options:  options,
 	}
 
-	var chatResp ollamaChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return fmt.Errorf("compaction parse failed: %w", err)
+	startTime := time.Now()
+	reply, err := c.exchange(ctx, req, continuations, previous)
+	if err != nil {
+		c.removeLastMessage()
+		return "", err
 	}
 
-	summary := chatResp.Message.Content
+	// Record timing and metrics
+	reply.done(BackendOllama, startTime)
 
-	// Replace conversation with summary
+	// Update state
 	c.mu.Lock()
-	c.messages = []Message{{Role: "system", Content: "Previous conversation summary: " + summary}}
-	c.totalTokens = chatResp.PromptEvalCount + chatResp.EvalCount
+	c.messages = append(c.messages, reply.Steps...)
+	c.messages = append(c.messages, newMessage("assistant", reply.Text))
+	c.lastThinking = reply.Thinking
+	c.lastMeta = reply.Meta
+	c.usage.add(reply.Meta)
+	c.lastTokens = reply.Tokens
+	c.totalTokens = contextTokens(reply.Meta, c.systemPrompt, c.messages)
 	c.mu.Unlock()
 
-	return nil
+	return reply.Text, nil
 }
 
-// Ask sends a prompt to Ollama and returns the response
-func (c *OllamaClient) Ask(ctx context.Context, prompt string) (string, error) {
-	c.mu.Lock()
-	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
-	msgs := c.buildOllamaMessages(c.messages[:len(c.messages)-1], prompt) // Don't include the just-added msg
-	model := c.model
-	temp := c.temperature
-	c.mu.Unlock()
+// exchange sends a prompt: the requests of send, within the agent loop
+// when there are tools or a schema, which Ollama constrains the response
+// to. Each request extends the context of the last.
+func (c *OllamaClient) exchange(ctx context.Context, req ollamaChatRequest, maxContinuations, previous int) (*Reply, error) {
+	format := c.schema.format()
+	if format != nil {
+		req.Format = format.raw
+	}
+	return c.tools.runTools(ctx, format, func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error) {
+		req.Tools = openAITools(tools)
+		if round != nil {
+			req.Messages = append(req.Messages, ollamaHistory([]Message{round.call, round.result})...)
+			if round.last {
+				req.Tools = nil // Ollama has no tool_choice
+			}
+		}
+		reply, err := c.send(ctx, req, maxContinuations, previous)
+		if err != nil {
+			return nil, err
+		}
+		previous = reply.ContextTokens
+		return reply, nil
+	})
+}
 
-	req := ollamaChatRequest{
-		Model:    model,
-		Messages: msgs,
-		Stream:   false,
-		Options:  &ollamaOptions{Temperature: temp},
+// send makes a non-streaming request and, while the response stops at the
+// length limit, up to maxContinuations follow-up requests that end with the
+// text so far as an assistant message, which Ollama continues. previous is
+// the context size of the conversation the request extends, if known.
+func (c *OllamaClient) send(ctx context.Context, req ollamaChatRequest, maxContinuations, previous int) (*Reply, error) {
+	msgs := req.Messages
+	reply := &Reply{Meta: Meta{ContextTokens: previous}}
+	for {
+		chatResp, err := c.chat(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		reply.Text = joinContinuation(reply.Text, chatResp.Message.Content)
+		reply.Thinking += chatResp.Message.Thinking
+		addOllamaDone(&reply.Meta, chatResp)
+		reply.Calls = ollamaCalls(chatResp.Message.ToolCalls)
+
+		if reply.StopReason != StopMaxTokens || reply.Continuations >= maxContinuations {
+			break
+		}
+		reply.Continuations++
+		req = continuationRequest(req, msgs, reply.Text)
 	}
+	reply.Tokens = reply.InputTokens + reply.OutputTokens
+	return reply, nil
+}
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		c.removeLastMessage()
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+// addOllamaDone adds the model, done_reason and counts of a final message
+// to meta. Ollama has no request IDs and reports no cache use, but it
+// leaves out of prompt_eval_count the start of the prompt it still had
+// cached: a count below the context size in meta, that of the previous
+// request, means the prompt extended that context.
+func addOllamaDone(meta *Meta, final *ollamaChatResponse) {
+	meta.Model = final.Model
+	meta.StopReason = normalizeStopReason(final.DoneReason)
+	meta.InputTokens += final.PromptEvalCount
+	meta.OutputTokens += final.EvalCount
+	if final.PromptEvalCount < meta.ContextTokens {
+		meta.ContextTokens += final.PromptEvalCount + final.EvalCount
+	} else {
+		meta.ContextTokens = final.PromptEvalCount + final.EvalCount
 	}
+}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/chat", bytes.NewReader(reqBody))
+// continuationRequest ends msgs with the response so far, for Ollama to
+// continue. Thinking is not repeated for the continuation.
+func continuationRequest(req ollamaChatRequest, msgs []ollamaMessage, text string) ollamaChatRequest {
+	req.Messages = append(msgs[:len(msgs):len(msgs)], ollamaMessage{Role: "assistant", Content: continuationPrefix(text)})
+	req.Think = false
+	return req
+}
+
+// post sends a request to /api/chat and returns the successful response,
+// retrying connection failures and transient HTTP errors.
+// The caller must close the response body.
+func (c *OllamaClient) post(ctx context.Context, req ollamaChatRequest) (*http.Response, error) {
+	return c.postTo(ctx, "/api/chat", req)
+}
+
+// postTo is post for any endpoint and request body
+func (c *OllamaClient) postTo(ctx context.Context, path string, req any) (*http.Response, error) {
+	reqBody, err := json.Marshal(req)
 	if err != nil {
-		c.removeLastMessage()
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
 
-	startTime := time.Now()
-	resp, err := c.httpClient.Do(httpReq)
-	latencyMs := time.Since(startTime).Milliseconds()
+	var resp *http.Response
+	err = c.retry.Do(ctx, func(ctx context.Context) error {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewReader(reqBody))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
 
+		resp, err = c.httpClient.Do(httpReq)
+		if err != nil {
+			return fmt.Errorf("Ollama API error: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return newHTTPError("Ollama", resp)
+		}
+		return nil
+	})
 	if err != nil {
-		c.removeLastMessage()
-		return "", fmt.Errorf("Ollama API error: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return resp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		c.removeLastMessage()
-		return "", fmt.Errorf("Ollama API error: HTTP %d: %s", resp.StatusCode, string(body))
+// chat sends a non-streaming request to /api/chat and decodes the reply
+func (c *OllamaClient) chat(ctx context.Context, req ollamaChatRequest) (*ollamaChatResponse, error) {
+	resp, err := c.post(ctx, req)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var chatResp ollamaChatResponse
 	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		c.removeLastMessage()
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
+	return &chatResp, nil
+}
 
-	responseText := chatResp.Message.Content
+// ollamaEmbedRequest represents a request to /api/embed
+type ollamaEmbedRequest struct {
+	Model string `json:"model"`
+	Input any    `json:"input"` // a string or a list of them
+}
 
-	// Update state
+// ollamaEmbedResponse represents a response from /api/embed
+type ollamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// embed sends a request to /api/embed and decodes the reply
+func (c *OllamaClient) embed(ctx context.Context, req ollamaEmbedRequest) (*ollamaEmbedResponse, error) {
+	resp, err := c.postTo(ctx, "/api/embed", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embedResp ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &embedResp, nil
+}
+
+// EmbedModel returns the model Embed uses
+func (c *OllamaClient) EmbedModel() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.embedModel
+}
+
+// SetEmbedModel sets the model Embed uses
+func (c *OllamaClient) SetEmbedModel(model string) {
 	c.mu.Lock()
-	c.messages = append(c.messages, Message{Role: "assistant", Content: responseText})
-	c.lastTokens = chatResp.PromptEvalCount + chatResp.EvalCount
-	c.totalTokens += c.lastTokens
-	c.mu.Unlock()
+	defer c.mu.Unlock()
+	c.embedModel = model
+}
 
-	// Record metrics
-	RecordMetrics(chatResp.PromptEvalCount, chatResp.EvalCount, latencyMs)
+// Embed returns the embeddings of inputs from /api/embed
+func (c *OllamaClient) Embed(ctx context.Context, inputs []string) (*Embeddings, error) {
+	if err := checkEmbedInputs(inputs); err != nil {
+		return nil, err
+	}
+	model := c.EmbedModel()
+	resp, err := c.embed(ctx, ollamaEmbedRequest{Model: model, Input: inputs})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Model != "" {
+		model = resp.Model
+	}
+	return newEmbeddings(model, resp.Embeddings, resp.PromptEvalCount, len(inputs))
+}
 
-	return responseText, nil
+// CountTokens counts text as the model evaluates it for /api/embed,
+// which uses no chat template and no cache, falling back to an estimate
+// if the request fails (e.g. the model computes no embeddings)
+func (c *OllamaClient) CountTokens(ctx context.Context, text string) (TokenCount, error) {
+	if text == "" {
+		return estimateCount(text), nil
+	}
+	resp, err := c.embed(ctx, ollamaEmbedRequest{Model: c.Model(), Input: text})
+	if err != nil {
+		return countFallback(ctx, CountPromptEval, text, err)
+	}
+	return TokenCount{Tokens: resp.PromptEvalCount, Method: CountPromptEval}, nil
 }
 
 // removeLastMessage removes the last message from history (used on error)
@@ -438,10 +867,24 @@ func (c *OllamaClient) removeLastMessage() {
 
 // AskWithHistory sends a prompt with explicit message history for per-fid isolation.
 func (c *OllamaClient) AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error) {
+	reply, err := c.Complete(ctx, history, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.Text, reply.Tokens, nil
+}
+
+// Complete sends a prompt with explicit message history and returns the whole reply.
+func (c *OllamaClient) Complete(ctx context.Context, history []Message, prompt string, attachments ...Attachment) (*Reply, error) {
+	if err := checkAttachments(BackendOllama, attachments, true, false); err != nil {
+		return nil, err
+	}
 	c.mu.RLock()
 	model := c.model
-	temp := c.temperature
+	options := c.options()
 	systemPrompt := c.systemPrompt
+	think := c.think != 0
+	continuations := c.continuation
 	c.mu.RUnlock()
 
 	// Build Ollama messages
@@ -453,188 +896,172 @@ func (c *OllamaClient) AskWithHistory(ctx context.Context, history []Message, pr
 	}
 
 	// Add history
-	for _, msg := range history {
-		switch msg.Role {
-		case "system":
-			msgs = append(msgs, ollamaMessage{Role: "system", Content: msg.Content})
-		case "user":
-			msgs = append(msgs, ollamaMessage{Role: "user", Content: msg.Content})
-		case "assistant":
-			msgs = append(msgs, ollamaMessage{Role: "assistant", Content: msg.Content})
-		}
-	}
+	msgs = append(msgs, ollamaHistory(history)...)
 
 	// Add the new user prompt
-	msgs = append(msgs, ollamaMessage{Role: "user", Content: prompt})
+	msgs = append(msgs, ollamaUser(newPrompt(prompt, attachments)))
 
 	req := ollamaChatRequest{
 		Model:    model,
 		Messages: msgs,
 		Stream:   false,
-		Options:  &ollamaOptions{Temperature: temp},
+		Think:    think,
+		Options:  options,
 	}
 
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/chat", bytes.NewReader(reqBody))
-	if err != nil {
-		return "", 0, fmt.Errorf("failed to create request: %w", err)
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
 	startTime := time.Now()
-	resp, err := c.httpClient.Do(httpReq)
-	latencyMs := time.Since(startTime).Milliseconds()
-
+	reply, err := c.exchange(ctx, req, continuations, 0)
 	if err != nil {
-		return "", 0, fmt.Errorf("Ollama API error: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", 0, fmt.Errorf("Ollama API error: HTTP %d: %s", resp.StatusCode, string(body))
-	}
-
-	var chatResp ollamaChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return "", 0, fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	responseText := chatResp.Message.Content
-	tokens := chatResp.PromptEvalCount + chatResp.EvalCount
+	// Record timing and metrics
+	reply.done(BackendOllama, startTime)
 
-	// Record metrics
-	RecordMetrics(chatResp.PromptEvalCount, chatResp.EvalCount, latencyMs)
-
-	return responseText, tokens, nil
+	return reply, nil
 }
 
 // StartStream begins streaming a response for the given prompt
-func (c *OllamaClient) StartStream(ctx context.Context, prompt string) error {
-	c.mu.Lock()
-	if c.streaming {
-		c.mu.Unlock()
+func (c *OllamaClient) StartStream(ctx context.Context, prompt string, attachments ...Attachment) error {
+	if err := checkAttachments(BackendOllama, attachments, true, false); err != nil {
+		return err
+	}
+	if c.IsStreaming() {
 		return fmt.Errorf("stream already in progress")
 	}
+	// Held until the stream ends
+	if err := c.turn.lock(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
 
-	c.messages = append(c.messages, Message{Role: "user", Content: prompt})
-	msgs := c.buildOllamaMessages(c.messages[:len(c.messages)-1], prompt)
+	c.messages = append(c.messages, newPrompt(prompt, attachments))
+	msgs := c.buildOllamaMessages(c.messages[:len(c.messages)-1], c.messages[len(c.messages)-1])
 	model := c.model
-	temp := c.temperature
+	options := c.options()
+	think := c.think != 0
+	continuations := c.continuation
+	previous := c.totalTokens
 
 	c.streaming = true
 	c.streamChan = make(chan string, 100)
 	c.streamDone = make(chan struct{})
+	c.thinking = newThinkingStream()
+	thinking := c.thinking
 	c.mu.Unlock()
 
 	go func() {
-		var fullResponse string
-		var promptTokens, evalTokens int
+		var fullResponse, fullThinking string
+		meta := Meta{ContextTokens: previous}
+		startTime := time.Now()
 
 		defer func() {
+			if fullResponse != "" {
+				meta.done(BackendOllama, startTime)
+			}
 			c.mu.Lock()
 			if fullResponse != "" {
-				c.messages = append(c.messages, Message{Role: "assistant", Content: fullResponse})
-				c.lastTokens = promptTokens + evalTokens
-				c.totalTokens += c.lastTokens
+				c.messages = append(c.messages, newMessage("assistant", fullResponse))
+				c.lastThinking = fullThinking
+				c.lastMeta = meta
+				c.usage.add(meta)
+				c.lastTokens = meta.InputTokens + meta.OutputTokens
+				c.totalTokens = contextTokens(meta, c.systemPrompt, c.messages)
 			}
 			c.streaming = false
 			close(c.streamChan)
 			close(c.streamDone)
 			c.mu.Unlock()
+			thinking.close()
+			c.turn.unlock()
 		}()
 
-		req := ollamaChatRequest{
-			Model:    model,
-			Messages: msgs,
-			Stream:   true,
-			Options:  &ollamaOptions{Temperature: temp},
-		}
-
-		reqBody, err := json.Marshal(req)
-		if err != nil {
-			select {
-			case c.streamChan <- fmt.Sprintf("[Error: %v]", err):
-			case <-ctx.Done():
+		// fail reports an error, unless part of the response was already
+		// delivered, in which case that part is kept
+		fail := func(err error) {
+			if fullResponse != "" {
+				return
 			}
-			c.removeLastMessage()
-			return
-		}
-
-		httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/chat", bytes.NewReader(reqBody))
-		if err != nil {
 			select {
 			case c.streamChan <- fmt.Sprintf("[Error: %v]", err):
 			case <-ctx.Done():
 			}
 			c.removeLastMessage()
-			return
 		}
-		httpReq.Header.Set("Content-Type", "application/json")
 
-		resp, err := c.httpClient.Do(httpReq)
-		if err != nil {
-			select {
-			case c.streamChan <- fmt.Sprintf("[Error: %v]", err):
-			case <-ctx.Done():
-			}
-			c.removeLastMessage()
-			return
-		}
-		defer resp.Body.Close()
+		// round streams one request and returns its final message, or
+		// false if the stream did not complete
+		round := func(req ollamaChatRequest) (final ollamaChatResponse, ok bool) {
+			overlap := continuationOverlap(fullResponse)
 
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			select {
-			case c.streamChan <- fmt.Sprintf("[Error: HTTP %d: %s]", resp.StatusCode, string(body)):
-			case <-ctx.Done():
+			resp, err := c.post(ctx, req)
+			if err != nil {
+				fail(err)
+				return final, false
 			}
-			c.removeLastMessage()
-			return
-		}
+			defer resp.Body.Close()
+
+			// Read streaming NDJSON responses
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if line == "" {
+					continue
+				}
 
-		// Read streaming NDJSON responses
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				continue
-			}
+				var chatResp ollamaChatResponse
+				if err := json.Unmarshal([]byte(line), &chatResp); err != nil {
+					continue
+				}
 
-			var chatResp ollamaChatResponse
-			if err := json.Unmarshal([]byte(line), &chatResp); err != nil {
-				continue
-			}
+				if chatResp.Message.Thinking != "" {
+					fullThinking += chatResp.Message.Thinking
+					thinking.append(chatResp.Message.Thinking)
+				}
 
-			// Send content chunk
-			if chatResp.Message.Content != "" {
-				fullResponse += chatResp.Message.Content
-				select {
-				case c.streamChan <- chatResp.Message.Content:
-				case <-ctx.Done():
-					return
+				// Send content chunk
+				content := chatResp.Message.Content
+				content, overlap = dropOverlap(content, overlap)
+				if content != "" {
+					fullResponse += content
+					select {
+					case c.streamChan <- content:
+					case <-ctx.Done():
+						return final, false
+					}
+				}
+
+				// Capture final token counts
+				if chatResp.Done {
+					final = chatResp
 				}
 			}
 
-			// Capture final token counts
-			if chatResp.Done {
-				promptTokens = chatResp.PromptEvalCount
-				evalTokens = chatResp.EvalCount
+			if err := scanner.Err(); err != nil {
+				fail(err)
+				return final, false
 			}
+			return final, final.Done
 		}
 
-		if err := scanner.Err(); err != nil {
-			if fullResponse == "" {
-				select {
-				case c.streamChan <- fmt.Sprintf("[Error: %v]", err):
-				case <-ctx.Done():
-				}
-				c.removeLastMessage()
+		req := ollamaChatRequest{
+			Model:    model,
+			Messages: msgs,
+			Stream:   true,
+			Think:    think,
+			Options:  options,
+		}
+		for {
+			final, ok := round(req)
+			if !ok {
+				return
+			}
+			addOllamaDone(&meta, &final)
+			if meta.StopReason != StopMaxTokens || meta.Continuations >= continuations {
+				return
 			}
+			meta.Continuations++
+			req = continuationRequest(req, msgs, fullResponse)
 		}
 	}()
 
@@ -655,6 +1082,14 @@ func (c *OllamaClient) ReadStreamChunk() (string, bool) {
 	return chunk, ok
 }
 
+// ReadThinking returns the current stream's thinking text from offset
+func (c *OllamaClient) ReadThinking(ctx context.Context, offset int64) (string, bool) {
+	c.mu.RLock()
+	thinking := c.thinking
+	c.mu.RUnlock()
+	return thinking.read(ctx, offset)
+}
+
 // IsStreaming returns whether a stream is currently in progress
 func (c *OllamaClient) IsStreaming() bool {
 	c.mu.RLock()
@@ -0,0 +1,1188 @@
+// OpenAI-compatible backend for servers that speak /v1/chat/completions
+// (OpenAI, vLLM, llama.cpp server, LM Studio, ...).
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenAIClient uses the OpenAI chat completions API for LLM requests.
+type OpenAIClient struct {
+	mu           sync.RWMutex
+	baseURL      string
+	apiKey       string
+	httpClient   *http.Client
+	model        string
+	embedModel   string // model for Embed
+	temperature  float64
+	systemPrompt string
+	prefill      string // Not supported by chat completions, stored but ignored
+	params       Params
+	lastThinking string // reasoning_content of the last response
+	lastMeta     Meta
+	usage        Usage
+	compact      CompactPolicy // how Compact shrinks the history
+	messages     []Message
+	lastTokens   int
+	totalTokens  int          // context size, see TotalTokens
+	turn         exchangeLock // held by an exchange: Ask, stream or Compact
+	streaming    bool
+	streamChan   chan string
+	streamDone   chan struct{}
+	thinking     *thinkingStream
+	retry        *Retrier
+	tools        *Toolbox
+	schema       *Schema
+	limits       map[string]int // context limits by model, from /models
+}
+
+// openAIModelsTimeout bounds the /models request made for ContextLimit,
+// which has no context of its own
+const openAIModelsTimeout = 10 * time.Second
+
+// openAIChatRequest represents a request to /chat/completions
+type openAIChatRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	Stream         bool                  `json:"stream"`
+	StreamOptions  *openAIStreamOptions  `json:"stream_options,omitempty"`
+	Temperature    float64               `json:"temperature"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	TopP           float64               `json:"top_p,omitempty"`
+	Stop           []string              `json:"stop,omitempty"`
+	Seed           *int64                `json:"seed,omitempty"`
+	Tools          []openAITool          `json:"tools,omitempty"`
+	ToolChoice     string                `json:"tool_choice,omitempty"` // "none" forbids calls
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// openAIResponseFormat constrains a response to a JSON Schema
+type openAIResponseFormat struct {
+	Type       string           `json:"type"` // "json_schema"
+	JSONSchema openAIJSONSchema `json:"json_schema"`
+}
+
+// openAIJSONSchema is a named JSON Schema
+type openAIJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// withParams returns the request with the generation parameters applied
+func (r openAIChatRequest) withParams(p Params) openAIChatRequest {
+	r.MaxTokens = p.MaxTokens
+	r.TopP = p.TopP
+	r.Stop = p.Stop
+	r.Seed = p.Seed
+	return r
+}
+
+// openAIStreamOptions asks for a final usage chunk when streaming
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIMessage represents a message in the chat completions format.
+// Reasoning servers (vLLM, DeepSeek) return thinking as reasoning_content.
+type openAIMessage struct {
+	Role             string           `json:"role"` // "system", "user", "assistant" or "tool"
+	Content          string           `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string           `json:"tool_call_id,omitempty"` // the call a tool message answers
+	Parts            []openAIPart     `json:"-"`                      // sent as the content when set
+}
+
+// MarshalJSON sends the content as an array of parts when the message
+// has them
+func (m openAIMessage) MarshalJSON() ([]byte, error) {
+	type message openAIMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		message
+		Content []openAIPart `json:"content"`
+	}{message(m), m.Parts})
+}
+
+// openAIPart is a content part of a user message: text, an image as a
+// data URL, or a file
+type openAIPart struct {
+	Type     string          `json:"type"` // "text", "image_url" or "file"
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+	File     *openAIFile     `json:"file,omitempty"`
+}
+
+// openAIImageURL is the image of an image_url part
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+// openAIFile is the file of a file part
+type openAIFile struct {
+	Filename string `json:"filename"`
+	FileData string `json:"file_data"` // a data URL
+}
+
+// openAIUser converts a user message. Images and PDFs become image_url
+// and file parts, the text of other attachments goes before the content.
+func openAIUser(msg Message) openAIMessage {
+	m := openAIMessage{Role: "user"}
+	var text []string
+	for _, a := range msg.Attachments {
+		if len(a.Data) == 0 || a.MediaType == MediaText {
+			text = append(text, a.text())
+			continue
+		}
+		if len(text) > 0 {
+			m.Parts = append(m.Parts, openAIPart{Type: "text", Text: strings.Join(text, "\n")})
+			text = nil
+		}
+		if a.IsImage() {
+			m.Parts = append(m.Parts, openAIPart{Type: "image_url", ImageURL: &openAIImageURL{a.dataURL()}})
+		} else {
+			m.Parts = append(m.Parts, openAIPart{Type: "file", File: &openAIFile{a.Name, a.dataURL()}})
+		}
+	}
+	if msg.Content != "" || len(text) == 0 {
+		text = append(text, msg.Content)
+	}
+	m.Content = strings.Join(text, "\n")
+	if len(m.Parts) > 0 {
+		m.Parts = append(m.Parts, openAIPart{Type: "text", Text: m.Content})
+	}
+	return m
+}
+
+// openAITool is a function tool definition. Ollama takes the same form.
+type openAITool struct {
+	Type     string `json:"type"` // "function"
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+// openAIToolCall is a call in an assistant message. The arguments are
+// a JSON object encoded as a string.
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"` // "function"
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAITools converts tools to function definitions
+func openAITools(tools []Tool) []openAITool {
+	var defs []openAITool
+	for _, tool := range tools {
+		var def openAITool
+		def.Type = "function"
+		def.Function.Name = tool.Name
+		def.Function.Description = tool.Description
+		def.Function.Parameters = tool.schema()
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// openAICalls converts the tool calls of a response
+func openAICalls(calls []openAIToolCall) []ToolCall {
+	var converted []ToolCall
+	for _, call := range calls {
+		input := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		id := call.ID
+		if id == "" {
+			id = newToolCallID()
+		}
+		converted = append(converted, ToolCall{ID: id, Name: call.Function.Name, Input: input})
+	}
+	return converted
+}
+
+// openAIUsage is the usage block of a response
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"` // prompt tokens read from the cache
+	} `json:"prompt_tokens_details"`
+}
+
+// addTo adds the usage to meta. The prompt tokens include the cached ones.
+func (u openAIUsage) addTo(meta *Meta) {
+	meta.InputTokens += u.PromptTokens
+	meta.OutputTokens += u.CompletionTokens
+	meta.CacheReadTokens += u.PromptTokensDetails.CachedTokens
+	meta.ContextTokens = u.PromptTokens + u.CompletionTokens
+}
+
+// openAIChatResponse represents a response (or stream chunk) from /chat/completions
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// openAIChoice is one completion; streams carry Delta instead of Message
+type openAIChoice struct {
+	Index        int           `json:"index"`
+	Message      openAIMessage `json:"message"`
+	Delta        openAIMessage `json:"delta"`
+	FinishReason string        `json:"finish_reason"` // "stop", "length", ...
+}
+
+// openAIModelsResponse represents a response from /models.
+// vLLM reports the context window as max_model_len.
+type openAIModelsResponse struct {
+	Data []struct {
+		ID          string `json:"id"`
+		MaxModelLen int    `json:"max_model_len,omitempty"`
+	} `json:"data"`
+}
+
+// NewOpenAIClient creates a new client for an OpenAI-compatible server.
+// baseURL includes the API version prefix (e.g. http://localhost:8000/v1).
+// apiKey may be empty for local servers that don't check it.
+// If no model is set, the first model the server lists is used.
+func NewOpenAIClient(baseURL, apiKey string) *OpenAIClient {
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	// Remove trailing slash
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	return &OpenAIClient{
+		baseURL:     baseURL,
+		apiKey:      apiKey,
+		httpClient:  &http.Client{Timeout: 5 * time.Minute},
+		embedModel:  DefaultOpenAIEmbedModel,
+		temperature: 0.7,
+		messages:    make([]Message, 0),
+		retry:       NewRetrier(DefaultRetryPolicy),
+		tools:       NewToolbox(),
+		schema:      NewSchema(),
+		compact:     DefaultCompactPolicy,
+		usage:       Usage{},
+		limits:      make(map[string]int),
+	}
+}
+
+// Retrier returns the retry policy and status for this client's requests
+func (c *OpenAIClient) Retrier() *Retrier {
+	return c.retry
+}
+
+// Toolbox returns the tools offered to this client's conversations
+func (c *OpenAIClient) Toolbox() *Toolbox {
+	return c.tools
+}
+
+// Schema returns the JSON Schema this client's answers must match
+func (c *OpenAIClient) Schema() *Schema {
+	return c.schema
+}
+
+// Model returns the current model name
+func (c *OpenAIClient) Model() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// SetModel sets the model for subsequent requests
+func (c *OpenAIClient) SetModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model = model
+}
+
+// Temperature returns the current temperature
+func (c *OpenAIClient) Temperature() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.temperature
+}
+
+// SetTemperature sets the temperature for subsequent requests
+func (c *OpenAIClient) SetTemperature(temp float64) error {
+	if temp < 0.0 || temp > 2.0 {
+		return fmt.Errorf("temperature must be between 0.0 and 2.0")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.temperature = temp
+	return nil
+}
+
+// LastThinking returns the reasoning_content of the last response, for
+// servers that return one
+func (c *OpenAIClient) LastThinking() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastThinking
+}
+
+// openAIParams are the generation parameters chat completions accepts
+var openAIParams = []string{ParamMaxTokens, ParamTopP, ParamStop, ParamSeed}
+
+// Params returns the generation parameters
+func (c *OpenAIClient) Params() Params {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.params.clone()
+}
+
+// SetParams sets the generation parameters for subsequent requests
+func (c *OpenAIClient) SetParams(p Params) error {
+	if err := checkParams(p, openAIParams); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.params = p.clone()
+	return nil
+}
+
+// Cache returns CacheOff: OpenAI caches long prompts by itself,
+// without cache breakpoints
+func (c *OpenAIClient) Cache() string {
+	return CacheOff
+}
+
+// SetCache accepts only CacheOff
+func (c *OpenAIClient) SetCache(mode string) error {
+	return noCacheControl(BackendOpenAI, mode)
+}
+
+// SupportedParams lists the generation parameters chat completions applies
+func (c *OpenAIClient) SupportedParams() []string {
+	return openAIParams
+}
+
+// LastMeta returns how the last response ended
+func (c *OpenAIClient) LastMeta() Meta {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastMeta
+}
+
+// Usage returns the tokens the conversation has used, by model, since it
+// began or was last reset
+func (c *OpenAIClient) Usage() Usage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.usage.clone()
+}
+
+// MaxContinuations returns 0 - chat completions cannot continue a response
+func (c *OpenAIClient) MaxContinuations() int {
+	return 0
+}
+
+// SetMaxContinuations accepts only 0: chat completions has no way to
+// resume a partial assistant message
+func (c *OpenAIClient) SetMaxContinuations(n int) error {
+	if n != 0 {
+		return fmt.Errorf("continuation is not supported by this backend")
+	}
+	return nil
+}
+
+// CompactPolicy returns how Compact shrinks the history
+func (c *OpenAIClient) CompactPolicy() CompactPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.compact
+}
+
+// SetCompactPolicy sets how Compact shrinks the history
+func (c *OpenAIClient) SetCompactPolicy(p CompactPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compact = p
+	return nil
+}
+
+// ThinkingTokens returns 0 - chat completions has no thinking budget
+func (c *OpenAIClient) ThinkingTokens() int {
+	return 0
+}
+
+// SetThinkingTokens is a no-op for chat completions
+func (c *OpenAIClient) SetThinkingTokens(tokens int) {
+	// No-op: chat completions has no thinking budget
+}
+
+// Prefill returns the prefill string (not used by chat completions)
+func (c *OpenAIClient) Prefill() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.prefill
+}
+
+// SetPrefill stores the prefill but chat completions doesn't support it
+func (c *OpenAIClient) SetPrefill(prefill string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefill = prefill
+}
+
+// SystemPrompt returns the current system prompt
+func (c *OpenAIClient) SystemPrompt() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.systemPrompt
+}
+
+// SetSystemPrompt sets the system prompt for subsequent requests
+func (c *OpenAIClient) SetSystemPrompt(prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.systemPrompt = prompt
+}
+
+// LastTokens returns the token count from the last response
+func (c *OpenAIClient) LastTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastTokens
+}
+
+// TotalTokens returns the context size of the conversation
+func (c *OpenAIClient) TotalTokens() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.totalTokens
+}
+
+// Messages returns a copy of the conversation history
+func (c *OpenAIClient) Messages() []Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make([]Message, len(c.messages))
+	copy(result, c.messages)
+	return result
+}
+
+// MessagesJSON returns the conversation history as JSON
+func (c *OpenAIClient) MessagesJSON() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return json.MarshalIndent(c.messages, "", "  ")
+}
+
+// AddSystemMessage adds a system message to the context
+func (c *OpenAIClient) AddSystemMessage(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := newMessage("system", content)
+	c.messages = append([]Message{msg}, c.messages...)
+	c.totalTokens += estimateMessage(msg)
+}
+
+// SetMessages replaces the conversation history
+func (c *OpenAIClient) SetMessages(msgs []Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(make([]Message, 0, len(msgs)), msgs...)
+	c.totalTokens = estimateContext(c.systemPrompt, c.messages)
+}
+
+// ValidateMessages checks that msgs has only roles the backend knows;
+// consecutive turns of the same role are accepted
+func (c *OpenAIClient) ValidateMessages(msgs []Message) error {
+	return CheckMessages(msgs, false)
+}
+
+// Reset clears the conversation history
+func (c *OpenAIClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = make([]Message, 0)
+	c.lastTokens = 0
+	c.totalTokens = 0
+	c.lastThinking = ""
+	c.lastMeta = Meta{}
+	c.usage = Usage{}
+}
+
+// ContextLimit returns the model's context window limit. The server's
+// model list is asked once per model, and the answer kept.
+func (c *OpenAIClient) ContextLimit() int {
+	c.mu.RLock()
+	model := c.model
+	limit, known := c.limits[model]
+	c.mu.RUnlock()
+
+	// Try to get from the server's model list
+	if !known {
+		var err error
+		if limit, err = c.queryContextLimit(model); err == nil {
+			c.mu.Lock()
+			c.limits[model] = limit
+			c.mu.Unlock()
+		}
+	}
+	if limit > 0 {
+		return limit
+	}
+
+	return contextLimitForOpenAIModel(model)
+}
+
+// listModels fetches /models
+func (c *OpenAIClient) listModels(ctx context.Context) (*openAIModelsResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	}
+
+	var models openAIModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, err
+	}
+	return &models, nil
+}
+
+// queryContextLimit asks the server for the model's context length, 0
+// if it does not say
+func (c *OpenAIClient) queryContextLimit(model string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), openAIModelsTimeout)
+	defer cancel()
+	models, err := c.listModels(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range models.Data {
+		if m.ID == model {
+			return m.MaxModelLen, nil
+		}
+	}
+	return 0, nil
+}
+
+// contextLimitForOpenAIModel returns default context limits for known models
+func contextLimitForOpenAIModel(model string) int {
+	lower := strings.ToLower(model)
+	switch {
+	case strings.HasPrefix(lower, "gpt-4.1"):
+		return 1047576
+	case strings.HasPrefix(lower, "gpt-4o"), strings.HasPrefix(lower, "gpt-4-turbo"):
+		return 128000
+	case strings.HasPrefix(lower, "o1"), strings.HasPrefix(lower, "o3"), strings.HasPrefix(lower, "o4"):
+		return 200000
+	case strings.HasPrefix(lower, "gpt-4"):
+		return 8192
+	case strings.HasPrefix(lower, "gpt-3.5"):
+		return 16385
+	default:
+		// Self-hosted servers mostly run open models
+		return contextLimitForOllamaModel(model)
+	}
+}
+
+// resolveModel returns the model to use, defaulting to the first one
+// the server lists (local servers usually serve exactly one)
+func (c *OpenAIClient) resolveModel(ctx context.Context) (string, error) {
+	c.mu.RLock()
+	model := c.model
+	c.mu.RUnlock()
+	if model != "" {
+		return model, nil
+	}
+
+	models, err := c.listModels(ctx)
+	if err != nil {
+		return "", fmt.Errorf("no model set and listing models failed: %w", err)
+	}
+	if len(models.Data) == 0 {
+		return "", fmt.Errorf("no model set and the server lists none")
+	}
+
+	c.mu.Lock()
+	if c.model == "" {
+		c.model = models.Data[0].ID
+	}
+	model = c.model
+	c.mu.Unlock()
+	return model, nil
+}
+
+func (c *OpenAIClient) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
+
+// buildOpenAIMessages converts internal messages to chat completions format
+func buildOpenAIMessages(systemPrompt string, history []Message, prompt Message) []openAIMessage {
+	var msgs []openAIMessage
+
+	// Add system prompt first if set
+	if systemPrompt != "" {
+		msgs = append(msgs, openAIMessage{Role: "system", Content: systemPrompt})
+	}
+
+	// Add history, handling our system messages
+	msgs = append(msgs, openAIHistory(history)...)
+
+	// Add the new user prompt
+	msgs = append(msgs, openAIUser(prompt))
+
+	return msgs
+}
+
+// openAIHistory converts history messages. Tool results become tool
+// messages, one per call, before any text of their user message.
+func openAIHistory(history []Message) []openAIMessage {
+	var msgs []openAIMessage
+	for _, msg := range history {
+		switch msg.Role {
+		case "system", "assistant":
+			m := openAIMessage{Role: msg.Role, Content: msg.Content}
+			for _, call := range msg.ToolCalls {
+				var tc openAIToolCall
+				tc.ID, tc.Type = call.ID, "function"
+				tc.Function.Name, tc.Function.Arguments = call.Name, string(call.Input)
+				m.ToolCalls = append(m.ToolCalls, tc)
+			}
+			msgs = append(msgs, m)
+		case "user":
+			for _, result := range msg.ToolResults {
+				msgs = append(msgs, openAIMessage{Role: "tool", Content: toolResultText(result), ToolCallID: result.CallID})
+			}
+			if msg.Content != "" || len(msg.ToolResults) == 0 {
+				msgs = append(msgs, openAIUser(msg))
+			}
+		}
+	}
+	return msgs
+}
+
+// post sends a request to /chat/completions and returns the successful
+// response, retrying connection failures and transient HTTP errors.
+// The caller must close the response body.
+func (c *OpenAIClient) post(ctx context.Context, req openAIChatRequest) (*http.Response, error) {
+	return c.postTo(ctx, "/chat/completions", req, req.Stream)
+}
+
+// postTo is post for any endpoint and request body; stream asks for
+// server-sent events
+func (c *OpenAIClient) postTo(ctx context.Context, path string, req any, stream bool) (*http.Response, error) {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var resp *http.Response
+	err = c.retry.Do(ctx, func(ctx context.Context) error {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewReader(reqBody))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		c.setHeaders(httpReq)
+		if stream {
+			httpReq.Header.Set("Accept", "text/event-stream")
+		}
+
+		resp, err = c.httpClient.Do(httpReq)
+		if err != nil {
+			return fmt.Errorf("OpenAI API error: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return newHTTPError("OpenAI", resp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// chat sends a non-streaming request to model, or the conversation's
+// model if empty, and returns the first choice as a reply. With tools,
+// the agent loop runs the calls the model makes and asks again, and the
+// response is constrained to the schema, if one is set.
+func (c *OpenAIClient) chat(ctx context.Context, model string, msgs []openAIMessage, temp float64, params Params, tools bool) (*Reply, error) {
+	if model == "" {
+		var err error
+		if model, err = c.resolveModel(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	startTime := time.Now()
+	req := openAIChatRequest{
+		Model:       model,
+		Messages:    msgs,
+		Temperature: temp,
+	}.withParams(params)
+	var reply *Reply
+	var err error
+	if tools {
+		format := c.schema.format()
+		if format != nil {
+			req.ResponseFormat = &openAIResponseFormat{Type: "json_schema", JSONSchema: openAIJSONSchema{Name: "answer", Schema: format.raw}}
+		}
+		reply, err = c.tools.runTools(ctx, format, func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error) {
+			req.Tools = openAITools(tools)
+			if round != nil {
+				req.Messages = append(req.Messages, openAIHistory([]Message{round.call, round.result})...)
+				if round.last {
+					req.ToolChoice = "none"
+				}
+			}
+			return c.request(ctx, req)
+		})
+	} else {
+		reply, err = c.request(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Record timing and metrics
+	reply.done(BackendOpenAI, startTime)
+
+	return reply, nil
+}
+
+// request makes a non-streaming request and returns the first choice
+func (c *OpenAIClient) request(ctx context.Context, req openAIChatRequest) (*Reply, error) {
+	resp, err := c.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("OpenAI API error: response has no choices")
+	}
+
+	choice := chatResp.Choices[0]
+	reply := &Reply{
+		Text:     choice.Message.Content,
+		Thinking: choice.Message.ReasoningContent,
+		Calls:    openAICalls(choice.Message.ToolCalls),
+		Meta:     chatResp.meta(resp, req.Model),
+	}
+	reply.StopReason = normalizeStopReason(choice.FinishReason)
+	if chatResp.Usage != nil {
+		chatResp.Usage.addTo(&reply.Meta)
+	}
+	reply.Tokens = reply.InputTokens + reply.OutputTokens
+	return reply, nil
+}
+
+// meta starts the Meta of a response (or first stream chunk) to a request
+// for model; servers that do not name the model are taken to have used it
+func (r *openAIChatResponse) meta(resp *http.Response, model string) Meta {
+	if r.Model != "" {
+		model = r.Model
+	}
+	return Meta{Model: model, RequestID: requestID(resp, "x-request-id", r.ID)}
+}
+
+// Compact shrinks the conversation according to the compaction policy
+func (c *OpenAIClient) Compact(ctx context.Context) error {
+	if err := c.turn.lock(ctx); err != nil {
+		return err
+	}
+	defer c.turn.unlock()
+	c.mu.Lock()
+	msgs := append([]Message(nil), c.messages...)
+	policy := c.compact
+	c.mu.Unlock()
+
+	compacted, err := compactMessages(ctx, msgs, policy, c.Summarize)
+	if err != nil || compacted == nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.messages = compacted
+	c.totalTokens = estimateContext(c.systemPrompt, compacted)
+	c.mu.Unlock()
+	return nil
+}
+
+// Summarize asks model (the conversation's model if empty) for a summary
+// outside the conversation
+func (c *OpenAIClient) Summarize(ctx context.Context, model, prompt string) (string, int, error) {
+	reply, err := c.chat(ctx, model, []openAIMessage{{Role: "user", Content: prompt}}, 0, Params{}, false)
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.Text, reply.Tokens, nil
+}
+
+// openAIEmbedRequest represents a request to /embeddings
+type openAIEmbedRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	EncodingFormat string   `json:"encoding_format"` // "float"
+}
+
+// openAIEmbedResponse represents a response from /embeddings
+type openAIEmbedResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+// EmbedModel returns the model Embed uses
+func (c *OpenAIClient) EmbedModel() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.embedModel
+}
+
+// SetEmbedModel sets the model Embed uses
+func (c *OpenAIClient) SetEmbedModel(model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.embedModel = model
+}
+
+// Embed returns the embeddings of inputs from /embeddings
+func (c *OpenAIClient) Embed(ctx context.Context, inputs []string) (*Embeddings, error) {
+	if err := checkEmbedInputs(inputs); err != nil {
+		return nil, err
+	}
+	model := c.EmbedModel()
+	resp, err := c.postTo(ctx, "/embeddings", openAIEmbedRequest{Model: model, Input: inputs, EncodingFormat: "float"}, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embedResp openAIEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	// The data may come in any order
+	vectors := make([][]float64, len(embedResp.Data))
+	for _, d := range embedResp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	if embedResp.Model != "" {
+		model = embedResp.Model
+	}
+	return newEmbeddings(model, vectors, embedResp.Usage.PromptTokens, len(inputs))
+}
+
+// CountTokens estimates the tokens of text: the Chat Completions API
+// has no tokenizer endpoint
+func (c *OpenAIClient) CountTokens(ctx context.Context, text string) (TokenCount, error) {
+	return estimateCount(text), nil
+}
+
+// Ask sends a prompt, with any attachments, and returns the response
+func (c *OpenAIClient) Ask(ctx context.Context, prompt string, attachments ...Attachment) (string, error) {
+	if err := checkAttachments(BackendOpenAI, attachments, true, true); err != nil {
+		return "", err
+	}
+	if err := c.turn.lock(ctx); err != nil {
+		return "", err
+	}
+	defer c.turn.unlock()
+	c.mu.Lock()
+	user := newPrompt(prompt, attachments)
+	msgs := buildOpenAIMessages(c.systemPrompt, c.messages, user)
+	c.messages = append(c.messages, user)
+	temp := c.temperature
+	params := c.params.clone()
+	c.mu.Unlock()
+
+	reply, err := c.chat(ctx, "", msgs, temp, params, true)
+	if err != nil {
+		c.removeLastMessage()
+		return "", err
+	}
+
+	// Update state
+	c.mu.Lock()
+	c.messages = append(c.messages, reply.Steps...)
+	c.messages = append(c.messages, newMessage("assistant", reply.Text))
+	c.lastThinking = reply.Thinking
+	c.lastMeta = reply.Meta
+	c.usage.add(reply.Meta)
+	c.lastTokens = reply.Tokens
+	c.totalTokens = contextTokens(reply.Meta, c.systemPrompt, c.messages)
+	c.mu.Unlock()
+
+	return reply.Text, nil
+}
+
+// removeLastMessage removes the last message from history (used on error)
+func (c *OpenAIClient) removeLastMessage() {
+	c.mu.Lock()
+	if len(c.messages) > 0 {
+		c.messages = c.messages[:len(c.messages)-1]
+	}
+	c.mu.Unlock()
+}
+
+// AskWithHistory sends a prompt with explicit message history for per-fid isolation.
+func (c *OpenAIClient) AskWithHistory(ctx context.Context, history []Message, prompt string) (string, int, error) {
+	reply, err := c.Complete(ctx, history, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.Text, reply.Tokens, nil
+}
+
+// Complete sends a prompt with explicit message history and returns the whole reply.
+func (c *OpenAIClient) Complete(ctx context.Context, history []Message, prompt string, attachments ...Attachment) (*Reply, error) {
+	if err := checkAttachments(BackendOpenAI, attachments, true, true); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	msgs := buildOpenAIMessages(c.systemPrompt, history, newPrompt(prompt, attachments))
+	temp := c.temperature
+	params := c.params.clone()
+	c.mu.RUnlock()
+
+	return c.chat(ctx, "", msgs, temp, params, true)
+}
+
+// StartStream begins streaming a response for the given prompt
+func (c *OpenAIClient) StartStream(ctx context.Context, prompt string, attachments ...Attachment) error {
+	if err := checkAttachments(BackendOpenAI, attachments, true, true); err != nil {
+		return err
+	}
+	model, err := c.resolveModel(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.IsStreaming() {
+		return fmt.Errorf("stream already in progress")
+	}
+	// Held until the stream ends
+	if err := c.turn.lock(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+
+	user := newPrompt(prompt, attachments)
+	msgs := buildOpenAIMessages(c.systemPrompt, c.messages, user)
+	c.messages = append(c.messages, user)
+	temp := c.temperature
+	params := c.params.clone()
+
+	c.streaming = true
+	c.streamChan = make(chan string, 100)
+	c.streamDone = make(chan struct{})
+	c.thinking = newThinkingStream()
+	thinking := c.thinking
+	c.mu.Unlock()
+
+	go func() {
+		var fullResponse, fullThinking string
+		var usage openAIUsage
+		var meta Meta
+		var failed error // the stream was cut short
+		startTime := time.Now()
+
+		defer func() {
+			complete := fullResponse != "" && failed == nil
+			if complete {
+				usage.addTo(&meta)
+				meta.done(BackendOpenAI, startTime)
+			}
+			c.mu.Lock()
+			if complete {
+				c.messages = append(c.messages, newMessage("assistant", fullResponse))
+				c.lastThinking = fullThinking
+				c.lastMeta = meta
+				c.usage.add(meta)
+				c.lastTokens = meta.InputTokens + meta.OutputTokens
+				c.totalTokens = contextTokens(meta, c.systemPrompt, c.messages)
+			} else if len(c.messages) > 0 {
+				// Failed, cancelled or empty: drop the prompt, as Ask does,
+				// rather than keep part of a response as a whole one
+				c.messages = c.messages[:len(c.messages)-1]
+			}
+			c.streaming = false
+			close(c.streamChan)
+			close(c.streamDone)
+			c.mu.Unlock()
+			thinking.close()
+			c.turn.unlock()
+		}()
+
+		// fail ends the stream with err, after any text already sent
+		fail := func(err error) {
+			failed = err
+			msg := fmt.Sprintf("[Error: %v]", err)
+			if fullResponse != "" {
+				msg = "\n" + msg
+			}
+			select {
+			case c.streamChan <- msg:
+			case <-ctx.Done():
+			}
+		}
+
+		resp, err := c.post(ctx, openAIChatRequest{
+			Model:         model,
+			Messages:      msgs,
+			Stream:        true,
+			StreamOptions: &openAIStreamOptions{IncludeUsage: true},
+			Temperature:   temp,
+		}.withParams(params))
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer resp.Body.Close()
+
+		// Read server-sent events: "data: {json}" lines, ending with "data: [DONE]"
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue // blank separators, comments, event names
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				break
+			}
+
+			var chunk openAIChatResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				continue
+			}
+			if meta.Model == "" {
+				meta = chunk.meta(resp, model)
+			}
+
+			// The usage chunk comes last, with no choices
+			if chunk.Usage != nil {
+				usage = *chunk.Usage
+			}
+			if len(chunk.Choices) == 0 {
+				continue
+			}
+			if reason := chunk.Choices[0].FinishReason; reason != "" {
+				meta.StopReason = normalizeStopReason(reason)
+			}
+			if reasoning := chunk.Choices[0].Delta.ReasoningContent; reasoning != "" {
+				fullThinking += reasoning
+				thinking.append(reasoning)
+			}
+			content := chunk.Choices[0].Delta.Content
+			if content == "" {
+				continue
+			}
+
+			fullResponse += content
+			select {
+			case c.streamChan <- content:
+			case <-ctx.Done():
+				failed = ctx.Err()
+				return
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			failed = err // stopped
+		} else if err := scanner.Err(); err != nil {
+			fail(err)
+		}
+	}()
+
+	return nil
+}
+
+// ReadStreamChunk reads the next chunk from the stream
+func (c *OpenAIClient) ReadStreamChunk() (string, bool) {
+	c.mu.RLock()
+	streamChan := c.streamChan
+	c.mu.RUnlock()
+
+	if streamChan == nil {
+		return "", false
+	}
+
+	chunk, ok := <-streamChan
+	return chunk, ok
+}
+
+// ReadThinking returns the current stream's reasoning text from offset
+func (c *OpenAIClient) ReadThinking(ctx context.Context, offset int64) (string, bool) {
+	c.mu.RLock()
+	thinking := c.thinking
+	c.mu.RUnlock()
+	return thinking.read(ctx, offset)
+}
+
+// IsStreaming returns whether a stream is currently in progress
+func (c *OpenAIClient) IsStreaming() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.streaming
+}
+
+// WaitStream waits for the current stream to complete
+func (c *OpenAIClient) WaitStream() {
+	c.mu.RLock()
+	done := c.streamDone
+	c.mu.RUnlock()
+
+	if done != nil {
+		<-done
+	}
+}
@@ -0,0 +1,378 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newMockOpenAIServer serves /v1/models and /v1/chat/completions,
+// recording each chat request it receives
+func newMockOpenAIServer(t *testing.T, reply string, requests *[]openAIChatRequest) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer sk-test" {
+			http.Error(w, "bad key "+got, http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/models":
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"local-model","max_model_len":32768}]}`)
+
+		case "/v1/chat/completions":
+			var req openAIChatRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if requests != nil {
+				*requests = append(*requests, req)
+			}
+
+			if !req.Stream {
+				fmt.Fprintf(w, `{"id":"chatcmpl-1","model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+					req.Model, reply)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, word := range strings.SplitAfter(reply, " ") {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", word)
+			}
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":3,\"total_tokens\":10}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOpenAIClient_BasicOperations(t *testing.T) {
+	client := NewOpenAIClient("http://localhost:8000/v1/", "")
+
+	if client.baseURL != "http://localhost:8000/v1" {
+		t.Errorf("baseURL = %q, want trailing slash removed", client.baseURL)
+	}
+	if got := client.Temperature(); got != 0.7 {
+		t.Errorf("Temperature() = %v, want %v", got, 0.7)
+	}
+	if err := client.SetTemperature(3.0); err == nil {
+		t.Error("SetTemperature(3.0) should return error")
+	}
+	if got := client.ThinkingTokens(); got != 0 {
+		t.Errorf("ThinkingTokens() = %d, want 0", got)
+	}
+
+	client.SetModel("gpt-4o-mini")
+	if got := client.Model(); got != "gpt-4o-mini" {
+		t.Errorf("Model() after SetModel = %q, want %q", got, "gpt-4o-mini")
+	}
+}
+
+func TestOpenAIClient_ContextLimitDefaults(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4o", 128000},
+		{"gpt-4o-mini", 128000},
+		{"gpt-4", 8192},
+		{"o3-mini", 200000},
+		{"mistral-7b-instruct", 8192},
+		{"unknown", 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := contextLimitForOpenAIModel(tt.model); got != tt.want {
+				t.Errorf("contextLimitForOpenAIModel(%q) = %d, want %d", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIClient_AskAndHistory(t *testing.T) {
+	var requests []openAIChatRequest
+	server := newMockOpenAIServer(t, "Hello! 2+2=4", &requests)
+	client := NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetSystemPrompt("Be brief")
+
+	ctx := context.Background()
+	response, err := client.Ask(ctx, "What is 2+2?")
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if response != "Hello! 2+2=4" {
+		t.Errorf("Ask() = %q, want %q", response, "Hello! 2+2=4")
+	}
+
+	// With no model set, the first listed model is used
+	if client.Model() != "local-model" || requests[0].Model != "local-model" {
+		t.Errorf("model = %q (sent %q), want local-model", client.Model(), requests[0].Model)
+	}
+	if msgs := requests[0].Messages; len(msgs) != 2 || msgs[0].Role != "system" || msgs[1].Content != "What is 2+2?" {
+		t.Errorf("request messages = %+v", msgs)
+	}
+	if client.LastTokens() != 15 || client.TotalTokens() != 15 {
+		t.Errorf("tokens = %d/%d, want 15/15", client.LastTokens(), client.TotalTokens())
+	}
+	if got := len(client.Messages()); got != 2 {
+		t.Errorf("Messages() len = %d, want 2", got)
+	}
+
+	// AskWithHistory leaves the client's conversation alone
+	history := []Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}
+	_, tokens, err := client.AskWithHistory(ctx, history, "again")
+	if err != nil {
+		t.Fatalf("AskWithHistory() error = %v", err)
+	}
+	if tokens != 15 {
+		t.Errorf("AskWithHistory() tokens = %d, want 15", tokens)
+	}
+	if got := len(requests[1].Messages); got != 4 {
+		t.Errorf("AskWithHistory() sent %d messages, want 4", got)
+	}
+	if got := len(client.Messages()); got != 2 {
+		t.Errorf("AskWithHistory() changed history: %d messages", got)
+	}
+
+	if got := client.ContextLimit(); got != 32768 {
+		t.Errorf("ContextLimit() = %d, want max_model_len 32768", got)
+	}
+}
+
+func TestOpenAIClient_HTTPError(t *testing.T) {
+	server := newMockOpenAIServer(t, "unused", nil)
+	client := NewOpenAIClient(server.URL+"/v1", "wrong")
+	client.SetModel("local-model")
+
+	if _, err := client.Ask(context.Background(), "hi"); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Errorf("Ask() error = %v, want HTTP 401", err)
+	}
+	if got := len(client.Messages()); got != 0 {
+		t.Errorf("failed Ask() left %d messages in history", got)
+	}
+}
+
+func TestOpenAIClient_ConcurrentAsks(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var requests []openAIChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		requests = append(requests, req)
+		first := len(requests) == 1
+		mu.Unlock()
+		if first {
+			// The first prompt fails, once the second has been asked
+			close(arrived)
+			<-release
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"two"},"finish_reason":"stop"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	client := NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+
+	failed := make(chan error)
+	go func() {
+		_, err := client.Ask(context.Background(), "one")
+		failed <- err
+	}()
+	<-arrived
+	answered := make(chan error)
+	go func() {
+		_, err := client.Ask(context.Background(), "two?")
+		answered <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if err := <-failed; err == nil {
+		t.Fatal("first Ask() succeeded")
+	}
+	if err := <-answered; err != nil {
+		t.Fatalf("second Ask() error = %v", err)
+	}
+	// The second prompt waited for the first, and did not see it
+	if msgs := requests[1].Messages; len(msgs) != 1 || msgs[0].Content != "two?" {
+		t.Errorf("second request messages = %+v", msgs)
+	}
+	if msgs := client.Messages(); len(msgs) != 2 || msgs[0].Content != "two?" || msgs[1].Content != "two" {
+		t.Errorf("Messages() = %+v, want the second exchange alone", msgs)
+	}
+}
+
+func TestOpenAIClient_Stream(t *testing.T) {
+	server := newMockOpenAIServer(t, "one two three", nil)
+	client := NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+
+	if err := client.StartStream(context.Background(), "count"); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	var chunks []string
+	for {
+		chunk, ok := client.ReadStreamChunk()
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	client.WaitStream()
+
+	if got := strings.Join(chunks, ""); got != "one two three" || len(chunks) != 3 {
+		t.Errorf("chunks = %q, want three pieces of %q", chunks, "one two three")
+	}
+	msgs := client.Messages()
+	if len(msgs) != 2 || msgs[1].Content != "one two three" {
+		t.Errorf("Messages() after stream = %+v", msgs)
+	}
+	if client.LastTokens() != 10 {
+		t.Errorf("LastTokens() = %d, want usage from final chunk (10)", client.LastTokens())
+	}
+}
+
+func TestOpenAIClient_StreamCancelled(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	client := NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.StartStream(ctx, "count"); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	<-started
+	cancel()
+	client.WaitStream()
+	if msgs := client.Messages(); len(msgs) != 0 {
+		t.Errorf("a stream cancelled before any content left %+v", msgs)
+	}
+
+	// So does one that ends without content
+	empty := newMockOpenAIServer(t, "", nil)
+	client = NewOpenAIClient(empty.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+	client.StartStream(context.Background(), "count")
+	client.WaitStream()
+	if msgs := client.Messages(); len(msgs) != 0 {
+		t.Errorf("an empty stream left %+v", msgs)
+	}
+}
+
+func TestOpenAIClient_StreamCutShort(t *testing.T) {
+	// Part of a response, then a line too long to read
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"one \"}}]}\n\n")
+		fmt.Fprintf(w, "data: %s\n\n", strings.Repeat("x", 2<<20))
+	}))
+	t.Cleanup(server.Close)
+	client := NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+
+	if err := client.StartStream(context.Background(), "count"); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	var chunks []string
+	for {
+		chunk, ok := client.ReadStreamChunk()
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	client.WaitStream()
+
+	if len(chunks) != 2 || chunks[0] != "one " || !strings.Contains(chunks[1], "[Error:") {
+		t.Errorf("chunks = %q, want the text then the error", chunks)
+	}
+	if msgs := client.Messages(); len(msgs) != 0 {
+		t.Errorf("a stream cut short left %+v", msgs)
+	}
+
+	// So does one stopped after some content
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"one \"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	client = NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+	ctx, cancel := context.WithCancel(context.Background())
+	client.StartStream(ctx, "count")
+	if chunk, _ := client.ReadStreamChunk(); chunk != "one " {
+		t.Fatalf("first chunk = %q", chunk)
+	}
+	cancel()
+	client.WaitStream()
+	if msgs := client.Messages(); len(msgs) != 0 {
+		t.Errorf("a stream stopped part way left %+v", msgs)
+	}
+}
+
+func TestOpenAIClient_ContextLimitCached(t *testing.T) {
+	lists := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lists++
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"local-model","max_model_len":32768}]}`)
+	}))
+	t.Cleanup(server.Close)
+	client := NewOpenAIClient(server.URL+"/v1", "")
+
+	client.SetModel("local-model")
+	for i := 0; i < 3; i++ {
+		if got := client.ContextLimit(); got != 32768 {
+			t.Errorf("ContextLimit() = %d, want 32768", got)
+		}
+	}
+	client.SetModel("gpt-4o")
+	client.ContextLimit()
+	if got := client.ContextLimit(); got != 128000 || lists != 2 {
+		t.Errorf("ContextLimit() = %d after %d lists, want 128000 after 2", got, lists)
+	}
+}
+
+func TestOpenAIClient_Compact(t *testing.T) {
+	server := newMockOpenAIServer(t, "short summary", nil)
+	client := NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Ask(context.Background(), "question"); err != nil {
+			t.Fatalf("Ask() error = %v", err)
+		}
+	}
+	if err := client.Compact(context.Background()); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	msgs := client.Messages()
+	if len(msgs) != 1 || msgs[0].Role != "system" || !strings.Contains(msgs[0].Content, "short summary") {
+		t.Errorf("Messages() after Compact = %+v", msgs)
+	}
+	if client.TotalTokens() != 12 {
+		t.Errorf("TotalTokens() after Compact = %d, want the estimate of the summary (12)", client.TotalTokens())
+	}
+}
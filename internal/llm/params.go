@@ -0,0 +1,169 @@
+package llm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Generation parameter names, as listed by Backend.SupportedParams
+const (
+	ParamMaxTokens = "max_tokens"
+	ParamTopP      = "top_p"
+	ParamTopK      = "top_k"
+	ParamStop      = "stop"
+	ParamSeed      = "seed"
+	ParamNumCtx    = "num_ctx"
+)
+
+// Params are optional generation parameters. A zero value (nil for Seed,
+// empty for Stop) leaves the parameter to the backend's default.
+type Params struct {
+	MaxTokens int      `json:"max_tokens,omitempty"` // response token limit (Ollama num_predict)
+	TopP      float64  `json:"top_p,omitempty"`      // nucleus sampling, 0-1
+	TopK      int      `json:"top_k,omitempty"`      // sample from the K most likely tokens
+	Stop      []string `json:"stop,omitempty"`       // stop sequences
+	Seed      *int64   `json:"seed,omitempty"`       // sampling seed, for repeatable output
+	NumCtx    int      `json:"num_ctx,omitempty"`    // context window to allocate (Ollama)
+}
+
+// Validate checks that the parameters are in range
+func (p Params) Validate() error {
+	switch {
+	case p.MaxTokens < 0:
+		return fmt.Errorf("max_tokens must not be negative")
+	case p.TopP < 0 || p.TopP > 1:
+		return fmt.Errorf("top_p must be between 0.0 and 1.0")
+	case p.TopK < 0:
+		return fmt.Errorf("top_k must not be negative")
+	case p.NumCtx < 0:
+		return fmt.Errorf("num_ctx must not be negative")
+	}
+	for _, s := range p.Stop {
+		if s == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	return nil
+}
+
+// IsSet reports whether the named parameter has a non-default value
+func (p Params) IsSet(name string) bool {
+	switch name {
+	case ParamMaxTokens:
+		return p.MaxTokens != 0
+	case ParamTopP:
+		return p.TopP != 0
+	case ParamTopK:
+		return p.TopK != 0
+	case ParamStop:
+		return len(p.Stop) > 0
+	case ParamSeed:
+		return p.Seed != nil
+	case ParamNumCtx:
+		return p.NumCtx != 0
+	}
+	return false
+}
+
+// Get returns the named parameter as text: stop sequences one per line,
+// "" when unset
+func (p Params) Get(name string) string {
+	if !p.IsSet(name) {
+		return ""
+	}
+	switch name {
+	case ParamMaxTokens:
+		return strconv.Itoa(p.MaxTokens)
+	case ParamTopP:
+		return strconv.FormatFloat(p.TopP, 'g', -1, 64)
+	case ParamTopK:
+		return strconv.Itoa(p.TopK)
+	case ParamStop:
+		return strings.Join(p.Stop, "\n")
+	case ParamSeed:
+		return strconv.FormatInt(*p.Seed, 10)
+	case ParamNumCtx:
+		return strconv.Itoa(p.NumCtx)
+	}
+	return ""
+}
+
+// Set parses value into the named parameter, in the format Get returns.
+// An empty value resets the parameter to the backend default.
+func (p *Params) Set(name, value string) error {
+	value = strings.TrimSpace(value)
+	var err error
+	switch name {
+	case ParamMaxTokens:
+		p.MaxTokens, err = parseParamInt(value)
+	case ParamTopP:
+		p.TopP = 0
+		if value != "" {
+			p.TopP, err = strconv.ParseFloat(value, 64)
+		}
+	case ParamTopK:
+		p.TopK, err = parseParamInt(value)
+	case ParamStop:
+		p.Stop = nil
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				p.Stop = append(p.Stop, line)
+			}
+		}
+	case ParamSeed:
+		p.Seed = nil
+		if value != "" {
+			var seed int64
+			seed, err = strconv.ParseInt(value, 10, 64)
+			p.Seed = &seed
+		}
+	case ParamNumCtx:
+		p.NumCtx, err = parseParamInt(value)
+	default:
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q", name, value)
+	}
+	return p.Validate()
+}
+
+func parseParamInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// clone returns a copy that shares no memory with p
+func (p Params) clone() Params {
+	if p.Stop != nil {
+		p.Stop = append([]string(nil), p.Stop...)
+	}
+	if p.Seed != nil {
+		seed := *p.Seed
+		p.Seed = &seed
+	}
+	return p
+}
+
+// checkParams validates p and rejects parameters the backend ignores
+func checkParams(p Params, supported []string) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	for _, name := range []string{ParamMaxTokens, ParamTopP, ParamTopK, ParamStop, ParamSeed, ParamNumCtx} {
+		if !p.IsSet(name) {
+			continue
+		}
+		found := false
+		for _, s := range supported {
+			found = found || s == name
+		}
+		if !found {
+			return fmt.Errorf("%s is not supported by this backend", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+func TestParams_SetGet(t *testing.T) {
+	var p Params
+	for name, value := range map[string]string{
+		ParamMaxTokens: "1000",
+		ParamTopP:      "0.9",
+		ParamTopK:      "40",
+		ParamStop:      "END\n\n###\n",
+		ParamSeed:      "0",
+		ParamNumCtx:    "8192",
+	} {
+		if err := p.Set(name, value); err != nil {
+			t.Fatalf("Set(%s, %q) error = %v", name, value, err)
+		}
+	}
+	if p.MaxTokens != 1000 || p.TopP != 0.9 || p.TopK != 40 || p.NumCtx != 8192 {
+		t.Errorf("Params = %+v", p)
+	}
+	if p.Seed == nil || *p.Seed != 0 {
+		t.Error("seed 0 should be set, not default")
+	}
+	if got := p.Get(ParamStop); got != "END\n###" {
+		t.Errorf("Get(stop) = %q", got)
+	}
+
+	if err := p.Set(ParamSeed, ""); err != nil || p.IsSet(ParamSeed) {
+		t.Errorf("empty seed should reset to default (err %v)", err)
+	}
+	if p.Get(ParamSeed) != "" {
+		t.Error("unset parameter should read as empty")
+	}
+
+	for name, value := range map[string]string{
+		ParamTopP:      "1.5",
+		ParamMaxTokens: "-1",
+		ParamTopK:      "many",
+		"bogus":        "1",
+	} {
+		q := p
+		if err := q.Set(name, value); err == nil {
+			t.Errorf("Set(%s, %q) should fail", name, value)
+		}
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	if err := checkParams(Params{MaxTokens: 10, TopK: 5}, anthropicParams); err != nil {
+		t.Errorf("supported params rejected: %v", err)
+	}
+	if err := checkParams(Params{NumCtx: 4096}, anthropicParams); err == nil || !strings.Contains(err.Error(), "num_ctx") {
+		t.Errorf("num_ctx on Anthropic error = %v, want unsupported", err)
+	}
+	if err := NewCLIClient().SetParams(Params{MaxTokens: 10}); err == nil {
+		t.Error("CLI should reject every parameter")
+	}
+}
+
+func TestClient_Params(t *testing.T) {
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = nil
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"m","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+
+	if _, err := client.Ask(context.Background(), "hi"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if request["max_tokens"] != float64(4096) {
+		t.Errorf("default max_tokens = %v, want 4096", request["max_tokens"])
+	}
+
+	if err := client.SetParams(Params{MaxTokens: 500, TopP: 0.5, TopK: 20, Stop: []string{"END"}}); err != nil {
+		t.Fatalf("SetParams() error = %v", err)
+	}
+	client.Ask(context.Background(), "hi")
+	if request["max_tokens"] != float64(500) || request["top_p"] != 0.5 || request["top_k"] != float64(20) {
+		t.Errorf("request = %v", request)
+	}
+	if stop, _ := request["stop_sequences"].([]any); len(stop) != 1 || stop[0] != "END" {
+		t.Errorf("stop_sequences = %v", request["stop_sequences"])
+	}
+
+	// Thinking adds its budget on top and drops sampling settings
+	client.SetThinkingTokens(2000)
+	client.Ask(context.Background(), "hi")
+	if request["max_tokens"] != float64(2500) {
+		t.Errorf("max_tokens with thinking = %v, want 2500", request["max_tokens"])
+	}
+	if _, ok := request["top_k"]; ok {
+		t.Error("top_k must not be sent with thinking enabled")
+	}
+}
+
+func TestOllamaClient_Params(t *testing.T) {
+	var req ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"ok"},"done":true}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	seed := int64(7)
+	if err := client.SetParams(Params{MaxTokens: 100, TopK: 40, Stop: []string{"\n\n"}, Seed: &seed, NumCtx: 16384}); err != nil {
+		t.Fatalf("SetParams() error = %v", err)
+	}
+	if _, err := client.Ask(context.Background(), "hi"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	opts := req.Options
+	if opts.NumPredict != 100 || opts.TopK != 40 || opts.NumCtx != 16384 || opts.Seed == nil || *opts.Seed != 7 || len(opts.Stop) != 1 {
+		t.Errorf("options = %+v", opts)
+	}
+	if got := client.ContextLimit(); got != 16384 {
+		t.Errorf("ContextLimit() = %d, want num_ctx 16384", got)
+	}
+
+	// Params returns a copy
+	p := client.Params()
+	*p.Seed = 99
+	if *client.Params().Seed != 7 {
+		t.Error("Params() should not share memory with the client")
+	}
+}
+
+func TestOpenAIClient_Params(t *testing.T) {
+	var requests []openAIChatRequest
+	server := newMockOpenAIServer(t, "ok", &requests)
+	client := NewOpenAIClient(server.URL+"/v1", "sk-test")
+	client.SetModel("local-model")
+
+	if err := client.SetParams(Params{TopK: 5}); err == nil {
+		t.Error("top_k should be rejected by chat completions")
+	}
+	seed := int64(1)
+	if err := client.SetParams(Params{MaxTokens: 64, Stop: []string{"END"}, Seed: &seed}); err != nil {
+		t.Fatalf("SetParams() error = %v", err)
+	}
+	if _, err := client.Ask(context.Background(), "hi"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if r := requests[0]; r.MaxTokens != 64 || len(r.Stop) != 1 || r.Seed == nil || *r.Seed != 1 {
+		t.Errorf("request = %+v", r)
+	}
+}
@@ -0,0 +1,349 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// RetryPolicy controls how failed requests are retried.
+// Delays grow exponentially from BaseDelay up to MaxDelay, with jitter;
+// a server's Retry-After takes precedence when it asks for longer.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first (1 = no retries)
+	MaxElapsed  time.Duration // give up rather than wait past this (0 = no limit)
+	BaseDelay   time.Duration // delay before the first retry
+	MaxDelay    time.Duration // cap on a single backoff delay
+}
+
+// DefaultRetryPolicy is used by all backends unless changed
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MaxElapsed:  2 * time.Minute,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+}
+
+// Validate checks that the policy is usable
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("attempts must be at least 1")
+	case p.MaxElapsed < 0, p.BaseDelay < 0, p.MaxDelay < 0:
+		return fmt.Errorf("durations must not be negative")
+	case p.MaxDelay < p.BaseDelay:
+		return fmt.Errorf("max delay must not be less than base delay")
+	}
+	return nil
+}
+
+// backoff returns the delay before retry number n (1-based):
+// half the exponential step plus a random part of the other half
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// RetryState describes the outcome of the most recent request
+type RetryState string
+
+const (
+	RetryIdle     RetryState = "idle"     // no request yet
+	RetryOK       RetryState = "ok"       // last request succeeded
+	RetryWaiting  RetryState = "retrying" // backing off before another attempt
+	RetryFailed   RetryState = "failed"   // last request gave up
+	RetryCanceled RetryState = "canceled" // last request was cancelled by the client
+)
+
+// RetryStatus is a snapshot of the most recent request's retry history
+type RetryStatus struct {
+	State      RetryState
+	Attempts   int           // attempts made by the last request
+	LastError  string        // most recent error, even if a retry then succeeded
+	Retryable  bool          // whether LastError was classified retryable
+	RetryAfter time.Duration // delay chosen before the pending/last retry
+	Updated    time.Time
+}
+
+// Retrier runs backend requests under a RetryPolicy and records their
+// status. Each backend owns one; it is safe for concurrent use.
+type Retrier struct {
+	mu     sync.Mutex
+	policy RetryPolicy
+	status RetryStatus
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetrier creates a retrier with the given policy
+func NewRetrier(policy RetryPolicy) *Retrier {
+	return &Retrier{
+		policy: policy,
+		status: RetryStatus{State: RetryIdle},
+		sleep:  sleepContext,
+	}
+}
+
+// Policy returns the current policy
+func (r *Retrier) Policy() RetryPolicy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.policy
+}
+
+// SetPolicy replaces the policy for subsequent requests
+func (r *Retrier) SetPolicy(p RetryPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = p
+	return nil
+}
+
+// Status returns the status of the most recent request
+func (r *Retrier) Status() RetryStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *Retrier) setStatus(s RetryStatus) {
+	s.Updated = time.Now()
+	r.mu.Lock()
+	r.status = s
+	r.mu.Unlock()
+}
+
+// Do calls op until it succeeds, fails with an error that is not
+// retryable, or the policy's attempt or time budget runs out.
+// The error of the last attempt is returned.
+func (r *Retrier) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	policy := r.Policy()
+	start := time.Now()
+	var status RetryStatus
+
+	for attempt := 1; ; attempt++ {
+		status.Attempts = attempt
+		err := op(ctx)
+		if err == nil {
+			status.State = RetryOK
+			r.setStatus(status)
+			return nil
+		}
+
+		retryable, retryAfter := ClassifyError(err)
+		status.LastError = err.Error()
+		status.Retryable = retryable
+		if ctx.Err() != nil {
+			status.State = RetryCanceled
+			r.setStatus(status)
+			return err
+		}
+		if !retryable || attempt >= policy.MaxAttempts {
+			status.State = RetryFailed
+			r.setStatus(status)
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		if policy.MaxElapsed > 0 && time.Since(start)+delay > policy.MaxElapsed {
+			status.State = RetryFailed
+			r.setStatus(status)
+			return err
+		}
+
+		status.State = RetryWaiting
+		status.RetryAfter = delay
+		r.setStatus(status)
+
+		if serr := r.sleep(ctx, delay); serr != nil {
+			status.State = RetryCanceled
+			r.setStatus(status)
+			return err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HTTPError is a non-200 response from an HTTP backend
+type HTTPError struct {
+	Backend    string // e.g. "Ollama", "OpenAI"
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s API error: HTTP %d: %s", e.Backend, e.StatusCode, e.Body)
+}
+
+// newHTTPError builds an HTTPError from a response, consuming its body
+func newHTTPError(backend string, resp *http.Response) *HTTPError {
+	body, _ := io.ReadAll(resp.Body)
+	return &HTTPError{
+		Backend:    backend,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header),
+	}
+}
+
+// parseRetryAfter reads retry-after-ms or Retry-After (seconds or HTTP date)
+func parseRetryAfter(h http.Header) time.Duration {
+	if h == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryableStatus reports whether an HTTP status is worth retrying:
+// timeouts, rate limits, transient server errors and Anthropic's 529
+// overloaded. Other server errors, such as 501 Not Implemented, would
+// fail again.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529:
+		return true
+	}
+	return false
+}
+
+// CLIError is a failed run of the claude CLI, with what it printed on
+// standard error
+type CLIError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *CLIError) Error() string {
+	return fmt.Sprintf("claude CLI error: %v (stderr: %s)", e.Err, e.Stderr)
+}
+
+func (e *CLIError) Unwrap() error { return e.Err }
+
+// transient reports whether the CLI failed on a rate limit or overload,
+// as it says on standard error; it exits non-zero for any failure
+func (e *CLIError) transient() bool {
+	msg := strings.ToLower(e.Stderr)
+	for _, s := range []string{"rate limit", "rate_limit", "too many requests", "overloaded"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifyError reports whether err is transient and worth retrying, and
+// how long the server asked us to wait, if it said.
+func ClassifyError(err error) (retryable bool, retryAfter time.Duration) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+
+	var fatalErr *fatalError
+	if errors.As(err, &fatalErr) {
+		return false, 0
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return retryableStatus(httpErr.StatusCode), httpErr.RetryAfter
+	}
+
+	var apiErr *anthropic.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Response != nil {
+			retryAfter = parseRetryAfter(apiErr.Response.Header)
+		}
+		return retryableStatus(apiErr.StatusCode), retryAfter
+	}
+
+	var cliErr *CLIError
+	if errors.As(err, &cliErr) {
+		return cliErr.transient(), 0
+	}
+
+	// Connection-level failures
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true, 0
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+
+	return false, 0
+}
+
+// fatalError marks an error as not worth retrying whatever its cause,
+// e.g. a stream that failed after part of the response was delivered.
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string { return e.err.Error() }
+func (e *fatalError) Unwrap() error { return e.err }
+
+func fatal(err error) error {
+	return &fatalError{err: err}
+}
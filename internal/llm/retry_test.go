@@ -0,0 +1,201 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestRetrier records requested delays instead of sleeping
+func newTestRetrier(policy RetryPolicy, delays *[]time.Duration) *Retrier {
+	r := NewRetrier(policy)
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return ctx.Err()
+	}
+	return r
+}
+
+func TestRetrier_RetriesTransientErrors(t *testing.T) {
+	var delays []time.Duration
+	r := newTestRetrier(RetryPolicy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 4 * time.Second}, &delays)
+
+	calls := 0
+	err := r.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &HTTPError{Backend: "Test", StatusCode: 529, Body: "overloaded"}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Do() = %v after %d calls, want success after 3", err, calls)
+	}
+
+	// Exponential backoff with jitter: half the step plus up to the other half
+	if len(delays) != 2 {
+		t.Fatalf("slept %d times, want 2", len(delays))
+	}
+	if delays[0] < 500*time.Millisecond || delays[0] > time.Second {
+		t.Errorf("first delay %v outside [0.5s, 1s]", delays[0])
+	}
+	if delays[1] < time.Second || delays[1] > 2*time.Second {
+		t.Errorf("second delay %v outside [1s, 2s]", delays[1])
+	}
+
+	st := r.Status()
+	if st.State != RetryOK || st.Attempts != 3 || st.LastError == "" {
+		t.Errorf("Status() = %+v, want ok after 3 attempts with the last error kept", st)
+	}
+}
+
+func TestRetrier_StopsOnFatalAndBudget(t *testing.T) {
+	var delays []time.Duration
+	r := newTestRetrier(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, &delays)
+
+	// Not retryable: one attempt
+	calls := 0
+	err := r.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return &HTTPError{Backend: "Test", StatusCode: 400, Body: "bad request"}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("fatal error: %d calls, err %v; want 1 call", calls, err)
+	}
+	if st := r.Status(); st.State != RetryFailed || st.Retryable {
+		t.Errorf("Status() = %+v, want failed and not retryable", st)
+	}
+
+	// Attempts exhausted
+	calls = 0
+	r.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return &HTTPError{Backend: "Test", StatusCode: 503}
+	})
+	if calls != 3 {
+		t.Errorf("retryable error: %d calls, want 3", calls)
+	}
+
+	// Retry-After beyond the elapsed budget gives up at once
+	r.SetPolicy(RetryPolicy{MaxAttempts: 5, MaxElapsed: time.Second, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	calls = 0
+	r.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return &HTTPError{Backend: "Test", StatusCode: 429, RetryAfter: time.Minute}
+	})
+	if calls != 1 {
+		t.Errorf("Retry-After past budget: %d calls, want 1", calls)
+	}
+}
+
+func TestRetrier_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewRetrier(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- r.Do(ctx, func(ctx context.Context) error {
+			calls++
+			return &HTTPError{Backend: "Test", StatusCode: 503}
+		})
+	}()
+
+	// Cancelling during the backoff ends the request
+	for r.Status().State != RetryWaiting {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("Do() should fail when cancelled")
+	}
+	if calls != 1 || r.Status().State != RetryCanceled {
+		t.Errorf("calls = %d, state = %s; want 1 call and canceled", calls, r.Status().State)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"rate limited", &HTTPError{StatusCode: 429}, true},
+		{"overloaded", fmt.Errorf("API error: %w", &HTTPError{StatusCode: 529}), true},
+		{"server error", &HTTPError{StatusCode: 502}, true},
+		{"gateway timeout", &HTTPError{StatusCode: 504}, true},
+		{"not implemented", &HTTPError{StatusCode: 501}, false},
+		{"version not supported", &HTTPError{StatusCode: 505}, false},
+		{"conflict", &HTTPError{StatusCode: 409}, false},
+		{"bad request", &HTTPError{StatusCode: 400}, false},
+		{"unauthorized", &HTTPError{StatusCode: 401}, false},
+		{"cli rate limited", &CLIError{Err: &exec.ExitError{}, Stderr: "API Error: Rate limit reached"}, true},
+		{"cli overloaded", fmt.Errorf("ask: %w", &CLIError{Err: &exec.ExitError{}, Stderr: `{"type":"overloaded_error"}`}), true},
+		{"cli bad model", &CLIError{Err: &exec.ExitError{}, Stderr: "Error: invalid model name"}, false},
+		{"cli exit", &exec.ExitError{}, false},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"cancelled", fmt.Errorf("request: %w", context.Canceled), false},
+		{"fatal", fatal(&HTTPError{StatusCode: 503}), false},
+		{"other", errors.New("parse error"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := ClassifyError(tt.err); got != tt.retryable {
+				t.Errorf("ClassifyError() = %v, want %v", got, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", "3")
+	if got := parseRetryAfter(h); got != 3*time.Second {
+		t.Errorf("seconds: got %v, want 3s", got)
+	}
+
+	h.Set("Retry-After-Ms", "250")
+	if got := parseRetryAfter(h); got != 250*time.Millisecond {
+		t.Errorf("retry-after-ms: got %v, want 250ms", got)
+	}
+
+	h = http.Header{}
+	h.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	if got := parseRetryAfter(h); got < 58*time.Second || got > time.Minute {
+		t.Errorf("HTTP date: got %v, want about 1m", got)
+	}
+}
+
+func TestOllamaClient_RetriesRateLimit(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"ok"},"done":true,"prompt_eval_count":1,"eval_count":1}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	client.Retrier().SetPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	response, err := client.Ask(context.Background(), "hi")
+	if err != nil || response != "ok" {
+		t.Fatalf("Ask() = %q, %v; want ok after a retry", response, err)
+	}
+	if calls != 2 {
+		t.Errorf("server saw %d requests, want 2", calls)
+	}
+	if st := client.Retrier().Status(); st.State != RetryOK || st.Attempts != 2 {
+		t.Errorf("Status() = %+v, want ok after 2 attempts", st)
+	}
+}
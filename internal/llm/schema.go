@@ -0,0 +1,738 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema retry limits
+const (
+	// DefaultSchemaRetries is how many times an answer that does not
+	// match the schema is sent back to be fixed
+	DefaultSchemaRetries = 2
+	// MaxSchemaRetries caps SetRetries
+	MaxSchemaRetries = 10
+)
+
+// Schema holds the JSON Schema that constrains a backend's answers, if
+// any. While one is set, each prompt's answer is asked for as JSON
+// valid against it, in whatever way the backend has of asking; the
+// answer is validated here, and one that fails is sent back with the
+// validator's error up to the retry limit. It is shared, like the
+// Toolbox, by every conversation on the backend.
+type Schema struct {
+	mu      sync.RWMutex
+	raw     json.RawMessage // nil when no schema is set
+	schema  any             // raw, decoded
+	retries int
+}
+
+// NewSchema creates an empty schema with the default retry limit
+func NewSchema() *Schema {
+	return &Schema{retries: DefaultSchemaRetries}
+}
+
+// Get returns the schema, or nil if none is set
+func (s *Schema) Get() json.RawMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.raw
+}
+
+// Set constrains answers to the JSON Schema raw, or lifts the
+// constraint if raw is empty. The schema must be a JSON object (or
+// boolean) whose types, patterns and local $refs are all understood.
+func (s *Schema) Set(raw json.RawMessage) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		s.mu.Lock()
+		s.raw, s.schema = nil, nil
+		s.mu.Unlock()
+		return nil
+	}
+	schema, err := decodeJSON(raw)
+	if err != nil {
+		return fmt.Errorf("schema: invalid JSON: %w", err)
+	}
+	if err := checkSchema(schema, schema, "#"); err != nil {
+		return fmt.Errorf("schema: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.raw, s.schema = append(json.RawMessage(nil), raw...), schema
+	return nil
+}
+
+// Retries returns how many times a failed answer is sent back
+func (s *Schema) Retries() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.retries
+}
+
+// SetRetries sets the retry limit
+func (s *Schema) SetRetries(n int) error {
+	if n < 0 || n > MaxSchemaRetries {
+		return fmt.Errorf("schema retries must be between 0 and %d", MaxSchemaRetries)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries = n
+	return nil
+}
+
+// format returns the schema as it stands for an exchange; nil if no
+// schema is set (or s is nil, for backends without one)
+func (s *Schema) format() *answerFormat {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.raw == nil {
+		return nil
+	}
+	return &answerFormat{raw: s.raw, schema: s.schema, retries: s.retries}
+}
+
+// SchemaError is the failure of a prompt whose answer never matched the
+// schema. It marshals to the JSON that ask returns in place of the answer.
+type SchemaError struct {
+	Reason   string `json:"error"`    // the validator's error for the last answer
+	Attempts int    `json:"attempts"` // answers asked for
+	Response string `json:"response"` // the last answer
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("no valid JSON after %d attempts: %s", e.Attempts, e.Reason)
+}
+
+// answerFormat is a schema an exchange's answer must match
+type answerFormat struct {
+	raw     json.RawMessage
+	schema  any
+	retries int
+}
+
+// answerToolName is the tool the Anthropic API is made to call with a
+// structured answer
+const answerToolName = "structured_answer"
+
+// tool is the schema as the input of a tool. A tool's input is an
+// object, so any other schema is wrapped as the property "answer", its
+// definitions moved up for its $refs.
+func (f *answerFormat) tool() Tool {
+	tool := Tool{Name: answerToolName, Description: "Give the answer, as the input of this tool", InputSchema: f.raw}
+	if !f.wrapped() {
+		return tool
+	}
+	wrapper := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"answer": f.schema},
+		"required":   []string{"answer"},
+	}
+	if schema, ok := f.schema.(map[string]any); ok {
+		for _, key := range []string{"$defs", "definitions"} {
+			if defs, ok := schema[key]; ok {
+				wrapper[key] = defs
+			}
+		}
+	}
+	tool.InputSchema, _ = json.Marshal(wrapper)
+	return tool
+}
+
+// wrapped reports whether tool wraps the schema
+func (f *answerFormat) wrapped() bool {
+	schema, ok := f.schema.(map[string]any)
+	return !ok || schema["type"] != "object"
+}
+
+// fromTool returns the answer given as the input of the tool
+func (f *answerFormat) fromTool(input json.RawMessage) string {
+	if f.wrapped() {
+		var wrapper struct {
+			Answer json.RawMessage `json:"answer"`
+		}
+		if json.Unmarshal(input, &wrapper) == nil && wrapper.Answer != nil {
+			return string(wrapper.Answer)
+		}
+	}
+	return string(input)
+}
+
+// instruction asks for an answer in the format, for backends that
+// cannot be made to give one
+func (f *answerFormat) instruction() string {
+	return "Answer with only a JSON value, without any other text or code fences, that is valid against this JSON Schema:\n" + string(f.raw)
+}
+
+// check returns the JSON in an answer, if it is valid against the
+// schema: the whole text, a fenced block in it, or the outermost object
+// or array. With no format the text is returned as it is.
+func (f *answerFormat) check(text string) (string, error) {
+	if f == nil {
+		return text, nil
+	}
+	answer, value, ok := extractJSON(text)
+	if !ok {
+		return "", errors.New("the answer is not JSON")
+	}
+	v := &validator{root: f.schema}
+	if err := v.validate(f.schema, value, ""); err != nil {
+		return "", err
+	}
+	return answer, nil
+}
+
+// repair is the round that sends back an answer that failed check, with
+// the reason, for the model to fix
+func (f *answerFormat) repair(text string, err error) *toolRound {
+	now := time.Now().UTC()
+	return &toolRound{
+		call: Message{Role: "assistant", Content: text, Time: now},
+		result: Message{Role: "user", Content: fmt.Sprintf(
+			"That answer is not valid against the schema: %v. Answer again with only the corrected JSON.", err), Time: now},
+	}
+}
+
+// jsonFence matches a fenced code block
+var jsonFence = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*\\n(.*?)```")
+
+// extractJSON finds the JSON in text, returning it and its value
+func extractJSON(text string) (string, any, bool) {
+	candidates := []string{strings.TrimSpace(text)}
+	for _, m := range jsonFence.FindAllStringSubmatch(text, -1) {
+		candidates = append(candidates, strings.TrimSpace(m[1]))
+	}
+	for _, pair := range []string{"{}", "[]"} {
+		start, end := strings.IndexByte(text, pair[0]), strings.LastIndexByte(text, pair[1])
+		if start >= 0 && end > start {
+			candidates = append(candidates, text[start:end+1])
+		}
+	}
+	for _, c := range candidates {
+		if value, err := decodeJSON([]byte(c)); err == nil {
+			return c, value, true
+		}
+	}
+	return "", nil, false
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers exact
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("data after the JSON value")
+	}
+	return value, nil
+}
+
+// schemaTypes are the types a schema may name
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// Keywords whose values are subschemas: one, a list, or a map of them
+var (
+	schemaKeywords     = []string{"additionalProperties", "additionalItems", "contains", "propertyNames", "not", "if", "then", "else"}
+	schemaListKeywords = []string{"items", "prefixItems", "allOf", "anyOf", "oneOf"}
+	schemaMapKeywords  = []string{"properties", "patternProperties", "$defs", "definitions", "dependentSchemas"}
+)
+
+// checkSchema checks a schema at path, and its subschemas, for what the
+// validator would trip over: unknown types, bad patterns and $refs that
+// do not resolve
+func checkSchema(root, schema any, path string) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	m, ok := schema.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: a schema must be an object or a boolean", path)
+	}
+	if ref, ok := m["$ref"]; ok {
+		s, _ := ref.(string)
+		if _, err := resolveRef(root, s); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if t, ok := m["type"]; ok {
+		types, ok := t.([]any)
+		if !ok {
+			types = []any{t}
+		}
+		for _, t := range types {
+			if name, _ := t.(string); !schemaTypes[name] {
+				return fmt.Errorf("%s: unknown type %v", path, t)
+			}
+		}
+	}
+	if p, ok := m["pattern"]; ok {
+		s, _ := p.(string)
+		if _, err := regexp.Compile(s); err != nil {
+			return fmt.Errorf("%s: bad pattern %q", path, p)
+		}
+	}
+	if pp, ok := m["patternProperties"].(map[string]any); ok {
+		for p := range pp {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("%s: bad pattern %q", path, p)
+			}
+		}
+	}
+	for _, key := range schemaKeywords {
+		if sub, ok := m[key]; ok {
+			if err := checkSchema(root, sub, path+"/"+key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range schemaListKeywords {
+		switch sub := m[key].(type) {
+		case []any:
+			for i, s := range sub {
+				if err := checkSchema(root, s, fmt.Sprintf("%s/%s/%d", path, key, i)); err != nil {
+					return err
+				}
+			}
+		case nil:
+		default:
+			if err := checkSchema(root, sub, path+"/"+key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range schemaMapKeywords {
+		if sub, ok := m[key].(map[string]any); ok {
+			for name, s := range sub {
+				if err := checkSchema(root, s, path+"/"+key+"/"+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef returns the subschema a local $ref ("#/$defs/item") points to
+func resolveRef(root any, ref string) (any, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("$ref %q: only local references (#/...) are supported", ref)
+	}
+	node := root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch n := node.(type) {
+		case map[string]any:
+			node = n[token]
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("$ref %q does not resolve", ref)
+			}
+			node = n[i]
+		default:
+			node = nil
+		}
+		if node == nil {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+	}
+	return node, nil
+}
+
+// validator checks values against a schema: the structural keywords of
+// JSON Schema 2020-12, and the array form of items of draft 7. Formats
+// and other annotations are not checked.
+type validator struct {
+	root any
+	refs int // $refs followed, against cycles
+}
+
+// maxRefs caps the $refs followed in validating one value
+const maxRefs = 10000
+
+// validate checks value, found at the JSON pointer path, against schema.
+// The error names the first place that fails.
+func (v *validator) validate(schema, value any, path string) error {
+	if b, ok := schema.(bool); ok {
+		if !b {
+			return v.fail(path, "no value is allowed here")
+		}
+		return nil
+	}
+	m, _ := schema.(map[string]any)
+
+	if ref, ok := m["$ref"].(string); ok {
+		if v.refs++; v.refs > maxRefs {
+			return errors.New("the schema's $refs loop")
+		}
+		sub, err := resolveRef(v.root, ref)
+		if err != nil {
+			return err
+		}
+		if err := v.validate(sub, value, path); err != nil {
+			return err
+		}
+	}
+
+	if t, ok := m["type"]; ok {
+		types, ok := t.([]any)
+		if !ok {
+			types = []any{t}
+		}
+		actual := jsonType(value)
+		match := false
+		var names []string
+		for _, t := range types {
+			name, _ := t.(string)
+			names = append(names, name)
+			match = match || name == actual || name == "number" && actual == "integer"
+		}
+		if !match {
+			return v.fail(path, "expected %s, got %s", strings.Join(names, " or "), actual)
+		}
+	}
+	if enum, ok := m["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || jsonEqual(e, value)
+		}
+		if !found {
+			return v.fail(path, "must be one of %s", compactJSON(enum))
+		}
+	}
+	if c, ok := m["const"]; ok && !jsonEqual(c, value) {
+		return v.fail(path, "must be %s", compactJSON(c))
+	}
+
+	var err error
+	switch value := value.(type) {
+	case string:
+		err = v.validateString(m, value, path)
+	case json.Number:
+		err = v.validateNumber(m, value, path)
+	case map[string]any:
+		err = v.validateObject(m, value, path)
+	case []any:
+		err = v.validateArray(m, value, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if all, ok := m["allOf"].([]any); ok {
+		for _, sub := range all {
+			if err := v.validate(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := m["anyOf"].([]any); ok {
+		var first error
+		for _, sub := range anyOf {
+			err := v.validate(sub, value, path)
+			if err == nil {
+				first = nil
+				break
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		if first != nil {
+			return v.fail(path, "matches none of anyOf (first: %v)", first)
+		}
+	}
+	if oneOf, ok := m["oneOf"].([]any); ok {
+		matched := 0
+		for _, sub := range oneOf {
+			if v.validate(sub, value, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return v.fail(path, "matches %d of oneOf, not exactly one", matched)
+		}
+	}
+	if not, ok := m["not"]; ok && v.validate(not, value, path) == nil {
+		return v.fail(path, "must not match %s", compactJSON(not))
+	}
+	if cond, ok := m["if"]; ok {
+		branch := "else"
+		if v.validate(cond, value, path) == nil {
+			branch = "then"
+		}
+		if sub, ok := m[branch]; ok {
+			if err := v.validate(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateString(m map[string]any, s, path string) error {
+	n := utf8.RuneCountInString(s)
+	if limit, ok := schemaInt(m, "minLength"); ok && n < limit {
+		return v.fail(path, "must be at least %d characters, not %d", limit, n)
+	}
+	if limit, ok := schemaInt(m, "maxLength"); ok && n > limit {
+		return v.fail(path, "must be at most %d characters, not %d", limit, n)
+	}
+	if p, ok := m["pattern"].(string); ok {
+		if re, err := regexp.Compile(p); err == nil && !re.MatchString(s) {
+			return v.fail(path, "must match the pattern %q", p)
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateNumber(m map[string]any, n json.Number, path string) error {
+	x, ok := new(big.Rat).SetString(n.String())
+	if !ok {
+		return v.fail(path, "bad number %s", n)
+	}
+	bound := func(key string) (*big.Rat, bool) {
+		b, ok := m[key].(json.Number)
+		if !ok {
+			return nil, false // including the booleans of draft 4
+		}
+		return new(big.Rat).SetString(b.String())
+	}
+	if b, ok := bound("minimum"); ok && x.Cmp(b) < 0 {
+		return v.fail(path, "must be at least %s", b.RatString())
+	}
+	if b, ok := bound("maximum"); ok && x.Cmp(b) > 0 {
+		return v.fail(path, "must be at most %s", b.RatString())
+	}
+	if b, ok := bound("exclusiveMinimum"); ok && x.Cmp(b) <= 0 {
+		return v.fail(path, "must be more than %s", b.RatString())
+	}
+	if b, ok := bound("exclusiveMaximum"); ok && x.Cmp(b) >= 0 {
+		return v.fail(path, "must be less than %s", b.RatString())
+	}
+	if b, ok := bound("multipleOf"); ok && b.Sign() > 0 {
+		if q := new(big.Rat).Quo(x, b); !q.IsInt() {
+			return v.fail(path, "must be a multiple of %s", b.RatString())
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateObject(m map[string]any, obj map[string]any, path string) error {
+	if limit, ok := schemaInt(m, "minProperties"); ok && len(obj) < limit {
+		return v.fail(path, "must have at least %d properties", limit)
+	}
+	if limit, ok := schemaInt(m, "maxProperties"); ok && len(obj) > limit {
+		return v.fail(path, "must have at most %d properties", limit)
+	}
+	if required, ok := m["required"].([]any); ok {
+		for _, r := range required {
+			if name, _ := r.(string); name != "" {
+				if _, ok := obj[name]; !ok {
+					return v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+	properties, _ := m["properties"].(map[string]any)
+	patterns, _ := m["patternProperties"].(map[string]any)
+	additional, hasAdditional := m["additionalProperties"]
+	names := m["propertyNames"]
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		at := path + "/" + strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+		if names != nil {
+			if err := v.validate(names, k, at); err != nil {
+				return v.fail(at, "bad property name: %v", err)
+			}
+		}
+		matched := false
+		if sub, ok := properties[k]; ok {
+			matched = true
+			if err := v.validate(sub, obj[k], at); err != nil {
+				return err
+			}
+		}
+		for p, sub := range patterns {
+			if re, err := regexp.Compile(p); err == nil && re.MatchString(k) {
+				matched = true
+				if err := v.validate(sub, obj[k], at); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched && hasAdditional {
+			if b, ok := additional.(bool); ok && !b {
+				return v.fail(path, "property %q is not allowed", k)
+			}
+			if err := v.validate(additional, obj[k], at); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateArray(m map[string]any, arr []any, path string) error {
+	if limit, ok := schemaInt(m, "minItems"); ok && len(arr) < limit {
+		return v.fail(path, "must have at least %d items, not %d", limit, len(arr))
+	}
+	if limit, ok := schemaInt(m, "maxItems"); ok && len(arr) > limit {
+		return v.fail(path, "must have at most %d items, not %d", limit, len(arr))
+	}
+	if unique, _ := m["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := 0; j < i; j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					return v.fail(path, "items %d and %d are the same", j, i)
+				}
+			}
+		}
+	}
+
+	// Items are checked by position (prefixItems, or the array form of
+	// items), then the rest by items (or additionalItems)
+	prefix, _ := m["prefixItems"].([]any)
+	rest, hasRest := m["items"]
+	if tuple, ok := rest.([]any); ok {
+		prefix = tuple
+		rest, hasRest = m["additionalItems"]
+	}
+	for i, item := range arr {
+		at := path + "/" + strconv.Itoa(i)
+		sub, ok := rest, hasRest
+		if i < len(prefix) {
+			sub, ok = prefix[i], true
+		}
+		if !ok {
+			continue
+		}
+		if b, isBool := sub.(bool); isBool && !b {
+			return v.fail(path, "must have at most %d items", i)
+		}
+		if err := v.validate(sub, item, at); err != nil {
+			return err
+		}
+	}
+
+	if contains, ok := m["contains"]; ok {
+		found := false
+		for _, item := range arr {
+			found = found || v.validate(contains, item, path) == nil
+		}
+		if !found {
+			return v.fail(path, "must contain an item matching %s", compactJSON(contains))
+		}
+	}
+	return nil
+}
+
+// fail is a validation error at path
+func (v *validator) fail(path, format string, args ...any) error {
+	if path == "" {
+		path = "(root)"
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+}
+
+// schemaInt returns an integer keyword of a schema
+func schemaInt(m map[string]any, key string) (int, bool) {
+	n, ok := m[key].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return int(i), err == nil
+}
+
+// jsonType names the JSON type of a decoded value, integer for whole
+// numbers
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if x, ok := new(big.Rat).SetString(value.String()); ok && x.IsInt() {
+			return "integer"
+		}
+		return "number"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual compares decoded values, numbers by value (1 is 1.0)
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		n, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okA := new(big.Rat).SetString(a.String())
+		y, okB := new(big.Rat).SetString(n.String())
+		return okA && okB && x.Cmp(y) == 0
+	case map[string]any:
+		o, ok := b.(map[string]any)
+		if !ok || len(a) != len(o) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := o[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		o, ok := b.([]any)
+		if !ok || len(a) != len(o) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], o[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// compactJSON is a decoded value as JSON, for error messages
+func compactJSON(value any) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
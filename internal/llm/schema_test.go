@@ -0,0 +1,291 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// cityTemp is a schema for a city and its temperature
+const cityTemp = `{"type":"object","properties":{"city":{"type":"string","minLength":1},"temp":{"type":"number","minimum":-90}},"required":["city","temp"],"additionalProperties":false}`
+
+func TestSchema_Set(t *testing.T) {
+	s := NewSchema()
+	if s.Get() != nil || s.format() != nil {
+		t.Error("a new schema should be empty")
+	}
+	if err := s.Set(json.RawMessage(cityTemp)); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	if string(s.Get()) != cityTemp || s.format() == nil {
+		t.Errorf("Get() = %s", s.Get())
+	}
+
+	for _, bad := range []string{
+		`{"type":`,
+		`[1]`,
+		`{"type":"float"}`,
+		`{"type":"string","pattern":"(("}`,
+		`{"$ref":"#/$defs/missing"}`,
+		`{"$ref":"https://example.com/schema.json"}`,
+		`{"properties":{"a":3}}`,
+	} {
+		if err := s.Set(json.RawMessage(bad)); err == nil {
+			t.Errorf("Set(%s) should fail", bad)
+		}
+	}
+	if string(s.Get()) != cityTemp {
+		t.Error("a failed Set() should keep the schema")
+	}
+
+	if err := s.Set(nil); err != nil || s.Get() != nil {
+		t.Errorf("Set(nil) = %v, left %s", err, s.Get())
+	}
+	if s.SetRetries(MaxSchemaRetries+1) == nil || s.SetRetries(-1) == nil {
+		t.Error("SetRetries() should reject limits out of range")
+	}
+}
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		schema, value string
+		want          string // part of the error, or "" if valid
+	}{
+		{cityTemp, `{"city":"Oslo","temp":4.5}`, ""},
+		{cityTemp, `{"city":"Oslo"}`, `missing required property "temp"`},
+		{cityTemp, `{"city":"Oslo","temp":"4"}`, "/temp: expected number, got string"},
+		{cityTemp, `{"city":"","temp":4}`, "/city: must be at least 1 characters"},
+		{cityTemp, `{"city":"Oslo","temp":-100}`, "/temp: must be at least -90"},
+		{cityTemp, `{"city":"Oslo","temp":4,"wind":3}`, `property "wind" is not allowed`},
+		{`{"type":"integer"}`, `3.0`, ""},
+		{`{"type":"integer"}`, `3.5`, "expected integer, got number"},
+		{`{"type":["string","null"]}`, `null`, ""},
+		{`{"enum":["a",1]}`, `1.0`, ""},
+		{`{"enum":["a",1]}`, `"b"`, `must be one of ["a",1]`},
+		{`{"const":{"a":[1]}}`, `{"a":[1]}`, ""},
+		{`{"type":"number","multipleOf":0.1}`, `0.3`, ""},
+		{`{"type":"number","exclusiveMaximum":1}`, `1`, "must be less than 1"},
+		{`{"type":"string","pattern":"^[A-Z]{3}$"}`, `"NOK"`, ""},
+		{`{"type":"string","pattern":"^[A-Z]{3}$"}`, `"nok"`, "must match the pattern"},
+		{`{"type":"array","items":{"type":"string"},"minItems":1,"uniqueItems":true}`, `["a","b"]`, ""},
+		{`{"type":"array","items":{"type":"string"}}`, `["a",2]`, "/1: expected string"},
+		{`{"type":"array","uniqueItems":true}`, `[1,1.0]`, "items 0 and 1 are the same"},
+		{`{"prefixItems":[{"type":"string"},{"type":"number"}],"items":false}`, `["a",1]`, ""},
+		{`{"prefixItems":[{"type":"string"},{"type":"number"}],"items":false}`, `["a",1,2]`, "at most 2 items"},
+		{`{"items":[{"type":"string"}],"additionalItems":{"type":"number"}}`, `["a",1,"b"]`, "/2: expected number"},
+		{`{"type":"array","contains":{"const":3}}`, `[1,2]`, "must contain"},
+		{`{"patternProperties":{"^x-":{"type":"string"}},"additionalProperties":false}`, `{"x-a":"1"}`, ""},
+		{`{"patternProperties":{"^x-":{"type":"string"}},"additionalProperties":false}`, `{"y":"1"}`, `property "y" is not allowed`},
+		{`{"anyOf":[{"type":"string"},{"type":"number"}]}`, `true`, "matches none of anyOf"},
+		{`{"oneOf":[{"type":"integer"},{"type":"number"}]}`, `1`, "matches 2 of oneOf"},
+		{`{"allOf":[{"minimum":1},{"maximum":3}]}`, `4`, "must be at most 3"},
+		{`{"not":{"type":"null"}}`, `null`, "must not match"},
+		{`{"if":{"properties":{"kind":{"const":"a"}}},"then":{"required":["a"]},"else":{"required":["b"]}}`, `{"kind":"a","b":1}`, `missing required property "a"`},
+		{`{"$defs":{"item":{"type":"object","properties":{"sku":{"type":"string"}},"required":["sku"]}},"type":"array","items":{"$ref":"#/$defs/item"}}`, `[{"sku":"a"},{}]`, `/1: missing required property "sku"`},
+		{`{"properties":{"next":{"$ref":"#"}},"required":["v"]}`, `{"v":1,"next":{"v":2,"next":{}}}`, `/next/next: missing required property "v"`},
+		{`false`, `1`, "no value is allowed"},
+	}
+	for _, tt := range tests {
+		format := &answerFormat{}
+		schema, err := decodeJSON([]byte(tt.schema))
+		if err != nil || checkSchema(schema, schema, "#") != nil {
+			t.Fatalf("bad test schema %s", tt.schema)
+		}
+		format.schema = schema
+		_, err = format.check(tt.value)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s against %s: %v", tt.value, tt.schema, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s against %s: error %v, want %q", tt.value, tt.schema, err, tt.want)
+		}
+	}
+}
+
+func TestAnswerFormat_Check(t *testing.T) {
+	s := NewSchema()
+	s.Set(json.RawMessage(cityTemp))
+	format := s.format()
+	for _, text := range []string{
+		`{"city":"Oslo","temp":4}`,
+		"Here you are:\n```json\n{\"city\":\"Oslo\",\"temp\":4}\n```",
+		`The answer is {"city":"Oslo","temp":4}.`,
+	} {
+		if got, err := format.check(text); err != nil || got != `{"city":"Oslo","temp":4}` {
+			t.Errorf("check(%q) = %q, %v", text, got, err)
+		}
+	}
+	if _, err := format.check("Oslo, 4°C"); err == nil {
+		t.Error("check() should fail without JSON")
+	}
+	var none *answerFormat
+	if got, err := none.check("free text"); got != "free text" || err != nil {
+		t.Errorf("check() with no format = %q, %v", got, err)
+	}
+}
+
+func TestToolbox_SchemaRetries(t *testing.T) {
+	s := NewSchema()
+	s.Set(json.RawMessage(cityTemp))
+	answers := []string{`{"city":"Oslo"}`, `{"city":"Oslo","temp":4}`}
+	var rounds []*toolRound
+	reply, err := NewToolbox().runTools(context.Background(), s.format(), func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error) {
+		rounds = append(rounds, round)
+		return &Reply{Text: answers[len(rounds)-1], Meta: Meta{InputTokens: 1}}, nil
+	})
+	if err != nil {
+		t.Fatalf("runTools() error: %v", err)
+	}
+	if reply.Text != answers[1] || reply.SchemaRetries != 1 || len(reply.Steps) != 0 || reply.InputTokens != 2 {
+		t.Errorf("reply = %+v", reply)
+	}
+	repair := rounds[1]
+	if repair.call.Content != answers[0] || !strings.Contains(repair.result.Content, `missing required property "temp"`) {
+		t.Errorf("repair round = %+v", repair)
+	}
+
+	// Once the retries are spent the exchange fails
+	s.SetRetries(1)
+	_, err = NewToolbox().runTools(context.Background(), s.format(), func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error) {
+		return &Reply{Text: "Oslo, 4°C"}, nil
+	})
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || schemaErr.Attempts != 2 || schemaErr.Response != "Oslo, 4°C" {
+		t.Errorf("runTools() error = %v", err)
+	}
+}
+
+func TestClient_Schema(t *testing.T) {
+	var requests []toolRequestBody
+	var toolChoice struct {
+		ToolChoice struct {
+			Name string `json:"name"`
+		} `json:"tool_choice"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		var req toolRequestBody
+		json.Unmarshal(body, &req)
+		json.Unmarshal(body, &toolChoice)
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+		input := `{"city":"Oslo"}`
+		if len(requests) > 1 {
+			input = `{"city":"Oslo","temp":4}`
+		}
+		fmt.Fprintf(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[{"type":"tool_use","id":"toolu_1","name":"structured_answer","input":%s}],"stop_reason":"tool_use","usage":{"input_tokens":20,"output_tokens":10}}`, input)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	client.Schema().Set(json.RawMessage(cityTemp))
+
+	response, err := client.Ask(context.Background(), "Weather in Oslo?")
+	if err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	if response != `{"city":"Oslo","temp":4}` {
+		t.Errorf("Ask() = %q", response)
+	}
+	first := requests[0]
+	if len(first.Tools) != 1 || first.Tools[0].Name != answerToolName || first.ToolChoice.Type != "tool" || toolChoice.ToolChoice.Name != answerToolName {
+		t.Errorf("first request tools = %+v, choice %+v", first.Tools, first.ToolChoice)
+	}
+	second := requests[1].Messages
+	if len(second) != 3 || second[1].Content[0].Text != `{"city":"Oslo"}` || !strings.Contains(second[2].Content[0].Text, `"temp"`) {
+		t.Errorf("repair request messages = %+v", second)
+	}
+
+	// The history holds the prompt and the valid answer only
+	msgs := client.Messages()
+	if len(msgs) != 2 || msgs[1].Content != response || client.LastMeta().SchemaRetries != 1 {
+		t.Errorf("Messages() = %+v, meta %+v", msgs, client.LastMeta())
+	}
+	if client.LastMeta().StopReason != StopEndTurn {
+		t.Errorf("stop reason = %q", client.LastMeta().StopReason)
+	}
+}
+
+func TestAnswerFormat_Tool(t *testing.T) {
+	s := NewSchema()
+	s.Set(json.RawMessage(`{"type":"array","items":{"$ref":"#/$defs/n"},"$defs":{"n":{"type":"integer"}}}`))
+	format := s.format()
+	tool := format.tool()
+	if err := tool.Validate(); err != nil {
+		t.Fatalf("tool() = %s: %v", tool.InputSchema, err)
+	}
+	var wrapper map[string]any
+	json.Unmarshal(tool.InputSchema, &wrapper)
+	if wrapper["$defs"] == nil || wrapper["required"] == nil {
+		t.Errorf("wrapped schema = %s", tool.InputSchema)
+	}
+	if got := format.fromTool(json.RawMessage(`{"answer":[1,2]}`)); got != "[1,2]" {
+		t.Errorf("fromTool() = %s", got)
+	}
+}
+
+func TestOllamaClient_Schema(t *testing.T) {
+	var requests []ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		fmt.Fprint(w, `{"model":"llama3.2","message":{"role":"assistant","content":"{\"city\":\"Oslo\"}"},"done":true,"done_reason":"stop"}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	client.Schema().Set(json.RawMessage(cityTemp))
+	client.Schema().SetRetries(1)
+
+	_, err := client.Ask(context.Background(), "Weather in Oslo?")
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || schemaErr.Attempts != 2 {
+		t.Fatalf("Ask() error = %v, want a SchemaError", err)
+	}
+	if len(requests) != 2 || string(requests[0].Format) != cityTemp {
+		t.Fatalf("requests = %+v", requests)
+	}
+	if msgs := requests[1].Messages; len(msgs) != 3 || msgs[1].Role != "assistant" {
+		t.Errorf("repair request messages = %+v", msgs)
+	}
+	if len(client.Messages()) != 0 {
+		t.Errorf("a failed prompt should leave no history: %+v", client.Messages())
+	}
+}
+
+func TestOpenAIClient_Schema(t *testing.T) {
+	var req openAIChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = openAIChatRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		fmt.Fprint(w, `{"id":"c1","model":"gpt-4o","choices":[{"message":{"role":"assistant","content":"{\"city\":\"Oslo\",\"temp\":4}"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+	client := NewOpenAIClient(server.URL, "")
+	client.SetModel("gpt-4o")
+	client.Schema().Set(json.RawMessage(cityTemp))
+
+	response, err := client.Ask(context.Background(), "Weather in Oslo?")
+	if err != nil || response != `{"city":"Oslo","temp":4}` {
+		t.Fatalf("Ask() = %q, %v", response, err)
+	}
+	if f := req.ResponseFormat; f == nil || f.Type != "json_schema" || string(f.JSONSchema.Schema) != cityTemp {
+		t.Errorf("response_format = %+v", f)
+	}
+
+	// Summaries are not held to the schema
+	if _, _, err := client.Summarize(context.Background(), "", "Sum up"); err != nil || req.ResponseFormat != nil {
+		t.Errorf("Summarize() sent response_format %+v (error %v)", req.ResponseFormat, err)
+	}
+}
@@ -4,6 +4,11 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
 	"sync"
 )
 
@@ -13,8 +18,12 @@ type Session struct {
 	ID           uint32
 	messages     []Message
 	lastResponse string
+	lastThinking string
+	lastMeta     Meta
+	usage        Usage
 	lastTokens   int
 	totalTokens  int
+	turn         exchangeLock // held by an Ask or Compact
 	mu           sync.RWMutex
 }
 
@@ -23,6 +32,7 @@ func NewSession(fid uint32) *Session {
 	return &Session{
 		ID:       fid,
 		messages: make([]Message, 0),
+		usage:    Usage{},
 	}
 }
 
@@ -46,14 +56,24 @@ func (s *Session) MessagesJSON() ([]byte, error) {
 func (s *Session) AddMessage(role, content string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.messages = append(s.messages, Message{Role: role, Content: content})
+	s.messages = append(s.messages, newMessage(role, content))
+}
+
+// AppendMessages adds messages, such as the tool rounds of an exchange,
+// to the end of the session's history.
+func (s *Session) AppendMessages(msgs ...Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msgs...)
 }
 
 // AddSystemMessage adds a system message to the session's history.
 func (s *Session) AddSystemMessage(content string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.messages = append([]Message{{Role: "system", Content: content}}, s.messages...)
+	msg := newMessage("system", content)
+	s.messages = append([]Message{msg}, s.messages...)
+	s.totalTokens += estimateMessage(msg)
 }
 
 // SetLastResponse sets the last response for this session.
@@ -70,6 +90,42 @@ func (s *Session) LastResponse() string {
 	return s.lastResponse
 }
 
+// SetLastThinking sets the model's thinking for the last response.
+func (s *Session) SetLastThinking(thinking string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastThinking = thinking
+}
+
+// LastThinking returns the model's thinking for the last response.
+func (s *Session) LastThinking() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastThinking
+}
+
+// SetLastMeta records how the last response ended and counts its tokens.
+func (s *Session) SetLastMeta(meta Meta) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMeta = meta
+	s.usage.add(meta)
+}
+
+// LastMeta returns how the last response ended.
+func (s *Session) LastMeta() Meta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastMeta
+}
+
+// Usage returns the tokens this session has used, by model.
+func (s *Session) Usage() Usage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.usage.clone()
+}
+
 // LastTokens returns the token count from the last response.
 func (s *Session) LastTokens() int {
 	s.mu.RLock()
@@ -77,7 +133,7 @@ func (s *Session) LastTokens() int {
 	return s.lastTokens
 }
 
-// TotalTokens returns cumulative token count for this session.
+// TotalTokens returns the context size of this session's conversation.
 func (s *Session) TotalTokens() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -92,12 +148,12 @@ func (s *Session) SetTokens(last, total int) {
 	s.totalTokens = total
 }
 
-// AddTokens adds to the token counts for this session.
-func (s *Session) AddTokens(tokens int) {
+// Replace swaps the session's history, e.g. for a compacted summary.
+func (s *Session) Replace(messages []Message, totalTokens int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.lastTokens = tokens
-	s.totalTokens += tokens
+	s.messages = messages
+	s.totalTokens = totalTokens
 }
 
 // Reset clears the session's conversation history.
@@ -106,6 +162,9 @@ func (s *Session) Reset() {
 	defer s.mu.Unlock()
 	s.messages = make([]Message, 0)
 	s.lastResponse = ""
+	s.lastThinking = ""
+	s.lastMeta = Meta{}
+	s.usage = Usage{}
 	s.lastTokens = 0
 	s.totalTokens = 0
 }
@@ -113,7 +172,8 @@ func (s *Session) Reset() {
 // SessionManager maps fids to sessions and delegates to a shared backend.
 type SessionManager struct {
 	sessions map[uint32]*Session
-	backend  Backend // shared backend for API calls and global settings
+	backend  Backend   // shared backend for API calls and global settings
+	rec      *recorder // records session histories, if there is a store
 	mu       sync.RWMutex
 }
 
@@ -130,6 +190,58 @@ func (sm *SessionManager) Backend() Backend {
 	return sm.backend
 }
 
+// SetStore records every change to a session's history in store, under
+// the session id in decimal. Removing a session deletes it from the store.
+func (sm *SessionManager) SetStore(store Store) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.rec = newRecorder(store)
+}
+
+// Restore recreates the sessions kept in the store and returns their ids
+// in order. Stored conversations whose names are not session ids are
+// left alone.
+func (sm *SessionManager) Restore() ([]uint32, error) {
+	sm.mu.RLock()
+	rec := sm.rec
+	sm.mu.RUnlock()
+	if rec == nil {
+		return nil, nil
+	}
+
+	names, err := rec.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, name := range names {
+		id, err := strconv.ParseUint(name, 10, 32)
+		if err != nil || strconv.FormatUint(id, 10) != name {
+			continue
+		}
+		msgs, err := rec.load(name)
+		if err != nil {
+			return ids, err
+		}
+		sm.GetOrCreate(uint32(id)).Replace(msgs, estimateContext(sm.backend.SystemPrompt(), msgs))
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// save records the session's history in the store, if there is one and
+// the session has not been removed
+func (sm *SessionManager) save(s *Session) {
+	sm.mu.RLock()
+	rec := sm.rec
+	current := sm.sessions[s.ID] == s
+	sm.mu.RUnlock()
+	if rec != nil && current {
+		rec.logRecord(strconv.FormatUint(uint64(s.ID), 10), s.Messages())
+	}
+}
+
 // GetOrCreate returns the session for the given fid, creating one if necessary.
 func (sm *SessionManager) GetOrCreate(fid uint32) *Session {
 	sm.mu.Lock()
@@ -149,44 +261,311 @@ func (sm *SessionManager) Get(fid uint32) *Session {
 	return sm.sessions[fid]
 }
 
-// Remove removes the session for the given fid.
+// Remove removes the session for the given fid, and its stored history.
 func (sm *SessionManager) Remove(fid uint32) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
 	delete(sm.sessions, fid)
+	rec := sm.rec
+	sm.mu.Unlock()
+	if rec != nil {
+		if err := rec.remove(strconv.FormatUint(uint64(fid), 10)); err != nil {
+			log.Printf("llm9p: conversation %d not removed from store: %v", fid, err)
+		}
+	}
 }
 
 // Reset clears the session for the given fid (but keeps the session).
 func (sm *SessionManager) Reset(fid uint32) {
-	session := sm.GetOrCreate(fid)
-	session.Reset()
+	sm.reset(sm.GetOrCreate(fid))
+}
+
+func (sm *SessionManager) reset(s *Session) {
+	s.Reset()
+	sm.save(s)
+}
+
+// SetMessages replaces the session's history.
+func (sm *SessionManager) SetMessages(fid uint32, msgs []Message) {
+	sm.setMessages(sm.GetOrCreate(fid), msgs)
+}
+
+func (sm *SessionManager) setMessages(s *Session, msgs []Message) {
+	s.Replace(append([]Message(nil), msgs...), estimateContext(sm.backend.SystemPrompt(), msgs))
+	sm.save(s)
 }
 
-// Ask sends a prompt using the session's conversation history.
-// The response is stored in the session and returned.
-func (sm *SessionManager) Ask(ctx context.Context, fid uint32, prompt string) (string, error) {
-	session := sm.GetOrCreate(fid)
+// AddSystemMessage adds a system message to the session's history.
+func (sm *SessionManager) AddSystemMessage(fid uint32, content string) {
+	sm.addSystemMessage(sm.GetOrCreate(fid), content)
+}
+
+func (sm *SessionManager) addSystemMessage(s *Session, content string) {
+	s.AddSystemMessage(content)
+	sm.save(s)
+}
+
+// Ask sends a prompt, with any attachments, using the session's
+// conversation history. The response is stored in the session and returned.
+func (sm *SessionManager) Ask(ctx context.Context, fid uint32, prompt string, attachments ...Attachment) (string, error) {
+	return sm.ask(ctx, sm.GetOrCreate(fid), prompt, attachments...)
+}
+
+func (sm *SessionManager) ask(ctx context.Context, session *Session, prompt string, attachments ...Attachment) (string, error) {
+	if err := session.turn.lock(ctx); err != nil {
+		return "", err
+	}
+	defer session.turn.unlock()
 
 	// Get current history before adding new message
 	history := session.Messages()
 
-	// Use backend's AskWithHistory - it doesn't modify backend state
-	response, tokens, err := sm.backend.AskWithHistory(ctx, history, prompt)
+	// Use backend's Complete - it doesn't modify backend state
+	reply, err := sm.backend.Complete(ctx, history, prompt, attachments...)
 	if err != nil {
 		session.SetLastResponse("Error: " + err.Error())
 		return "", err
 	}
 
 	// Add user message and assistant response to session history
-	session.AddMessage("user", prompt)
-	session.AddMessage("assistant", response)
-	session.AddTokens(tokens)
-	session.SetLastResponse(response)
+	session.AppendMessages(newPrompt(prompt, attachments))
+	session.AppendMessages(reply.Steps...)
+	session.AddMessage("assistant", reply.Text)
+	session.SetTokens(reply.Tokens, contextTokens(reply.Meta, sm.backend.SystemPrompt(), session.Messages()))
+	session.SetLastResponse(reply.Text)
+	session.SetLastThinking(reply.Thinking)
+	session.SetLastMeta(reply.Meta)
+	sm.save(session)
 
-	return response, nil
+	return reply.Text, nil
 }
 
 // ContextLimit returns the model's context window limit from the backend.
 func (sm *SessionManager) ContextLimit() int {
 	return sm.backend.ContextLimit()
 }
+
+// Compact shrinks the session's conversation according to the backend's
+// compaction policy, as Backend.Compact does for the shared conversation.
+func (sm *SessionManager) Compact(ctx context.Context, fid uint32) error {
+	return sm.compact(ctx, sm.GetOrCreate(fid))
+}
+
+func (sm *SessionManager) compact(ctx context.Context, session *Session) error {
+	if err := session.turn.lock(ctx); err != nil {
+		return err
+	}
+	defer session.turn.unlock()
+	compacted, err := compactMessages(ctx, session.Messages(), sm.backend.CompactPolicy(), sm.backend.Summarize)
+	if err != nil || compacted == nil {
+		return err
+	}
+	session.Replace(compacted, estimateContext(sm.backend.SystemPrompt(), compacted))
+	sm.save(session)
+	return nil
+}
+
+// Client returns a Backend view of the session for the given id,
+// creating the session if necessary.
+func (sm *SessionManager) Client(id uint32) *SessionClient {
+	return &SessionClient{
+		Backend: sm.backend,
+		sm:      sm,
+		id:      id,
+		s:       sm.GetOrCreate(id),
+	}
+}
+
+// ErrSessionRemoved is returned by a SessionClient whose session has been
+// removed, such as a conversation that was hung up.
+var ErrSessionRemoved = errors.New("conversation has been removed")
+
+// SessionClient adapts one session to the Backend interface, so files
+// written against Backend can serve an isolated conversation. Settings
+// (model, temperature, system prompt, ...) are those of the shared backend;
+// history, token counts and streams belong to the session.
+type SessionClient struct {
+	Backend // shared backend for settings and ContextLimit
+	sm      *SessionManager
+	id      uint32
+	s       *Session // still read, but never changed, once removed
+
+	mu         sync.Mutex
+	streaming  bool
+	streamChan chan string
+	streamDone chan struct{}
+	thinking   *thinkingStream
+}
+
+// ID returns the id of the session this client serves.
+func (c *SessionClient) ID() uint32 {
+	return c.id
+}
+
+// session returns the client's session to change, or ErrSessionRemoved
+// once it has been removed: a fid left open on a hung-up conversation
+// must not bring it back, nor write it to the store again
+func (c *SessionClient) session() (*Session, error) {
+	if c.sm.Get(c.id) != c.s {
+		return nil, ErrSessionRemoved
+	}
+	return c.s, nil
+}
+
+// LastTokens returns token count from the session's last response
+func (c *SessionClient) LastTokens() int {
+	return c.s.LastTokens()
+}
+
+// TotalTokens returns the context size of the session's conversation
+func (c *SessionClient) TotalTokens() int {
+	return c.s.TotalTokens()
+}
+
+// LastThinking returns the model's thinking for the session's last response
+func (c *SessionClient) LastThinking() string {
+	return c.s.LastThinking()
+}
+
+// LastMeta returns how the session's last response ended
+func (c *SessionClient) LastMeta() Meta {
+	return c.s.LastMeta()
+}
+
+// Usage returns the tokens the session has used, by model
+func (c *SessionClient) Usage() Usage {
+	return c.s.Usage()
+}
+
+// Compact summarizes the session's conversation
+func (c *SessionClient) Compact(ctx context.Context) error {
+	s, err := c.session()
+	if err != nil {
+		return err
+	}
+	return c.sm.compact(ctx, s)
+}
+
+// Messages returns the session's conversation history
+func (c *SessionClient) Messages() []Message {
+	return c.s.Messages()
+}
+
+// MessagesJSON returns the session's conversation history as JSON
+func (c *SessionClient) MessagesJSON() ([]byte, error) {
+	return c.s.MessagesJSON()
+}
+
+// AddSystemMessage adds a system message to the session's history,
+// unless the session has been removed
+func (c *SessionClient) AddSystemMessage(content string) {
+	if s, err := c.session(); err == nil {
+		c.sm.addSystemMessage(s, content)
+	}
+}
+
+// SetMessages replaces the session's history, unless the session has
+// been removed
+func (c *SessionClient) SetMessages(msgs []Message) {
+	if s, err := c.session(); err == nil {
+		c.sm.setMessages(s, msgs)
+	}
+}
+
+// Reset clears the session's history, unless the session has been removed
+func (c *SessionClient) Reset() {
+	if s, err := c.session(); err == nil {
+		c.sm.reset(s)
+	}
+}
+
+// Ask sends a prompt within the session's conversation
+func (c *SessionClient) Ask(ctx context.Context, prompt string, attachments ...Attachment) (string, error) {
+	s, err := c.session()
+	if err != nil {
+		return "", err
+	}
+	return c.sm.ask(ctx, s, prompt, attachments...)
+}
+
+// StartStream asks within the session in the background. The backend has
+// no history-aware streaming call, so the whole response arrives as a
+// single chunk once it is complete, as does its thinking.
+func (c *SessionClient) StartStream(ctx context.Context, prompt string, attachments ...Attachment) error {
+	if _, err := c.session(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.streaming {
+		c.mu.Unlock()
+		return fmt.Errorf("stream already in progress")
+	}
+	c.streaming = true
+	c.streamChan = make(chan string, 1)
+	c.streamDone = make(chan struct{})
+	c.thinking = newThinkingStream()
+	streamChan, streamDone, thinking := c.streamChan, c.streamDone, c.thinking
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			c.streaming = false
+			c.mu.Unlock()
+			close(streamChan)
+			close(streamDone)
+			thinking.close()
+		}()
+
+		response, err := c.Ask(ctx, prompt, attachments...)
+		if err != nil {
+			streamChan <- fmt.Sprintf("\n[Error: %v]", err)
+			return
+		}
+		thinking.append(c.LastThinking())
+		streamChan <- response
+	}()
+
+	return nil
+}
+
+// ReadStreamChunk reads the next streaming chunk
+func (c *SessionClient) ReadStreamChunk() (string, bool) {
+	c.mu.Lock()
+	ch := c.streamChan
+	c.mu.Unlock()
+
+	if ch == nil {
+		return "", false
+	}
+	chunk, ok := <-ch
+	return chunk, ok
+}
+
+// ReadThinking returns the stream's thinking text from offset
+func (c *SessionClient) ReadThinking(ctx context.Context, offset int64) (string, bool) {
+	c.mu.Lock()
+	thinking := c.thinking
+	c.mu.Unlock()
+	return thinking.read(ctx, offset)
+}
+
+// IsStreaming returns whether a stream is in progress. The single chunk
+// usually lands after the request finishes, so an unread chunk counts too.
+func (c *SessionClient) IsStreaming() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streaming || len(c.streamChan) > 0
+}
+
+// WaitStream waits for the stream to complete
+func (c *SessionClient) WaitStream() {
+	c.mu.Lock()
+	done := c.streamDone
+	c.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+var _ Backend = (*SessionClient)(nil)
@@ -0,0 +1,444 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store persists conversation histories so that they survive a restart
+// of the server. Conversations are named by IDs such as "shared" or "3".
+type Store interface {
+	// List returns the IDs of the stored conversations
+	List() ([]string, error)
+	// Load returns a conversation's history (empty if it is not stored)
+	Load(id string) ([]Message, error)
+	// Append records messages added to the end of a conversation
+	Append(id string, msgs []Message) error
+	// Save replaces a conversation's whole history, e.g. after compaction
+	Save(id string, msgs []Message) error
+	// Remove deletes a conversation
+	Remove(id string) error
+}
+
+// FileStore is a Store keeping each conversation in a directory as an
+// append-only JSONL file, ID.jsonl, with one message per line. Appends
+// are synced before returning; a history that is replaced is written to
+// a temporary file, synced and renamed over the old one, so a crash
+// leaves either the old history or the new one.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var _ Store = (*FileStore)(nil)
+
+// storeExt is the extension of a FileStore conversation file
+const storeExt = ".jsonl"
+
+// NewFileStore opens the store in dir, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of conversation id
+func (s *FileStore) path(id string) (string, error) {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("store: invalid conversation id %q", id)
+	}
+	return filepath.Join(s.dir, id+storeExt), nil
+}
+
+// List returns the IDs of the stored conversations, sorted
+func (s *FileStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	var ids []string
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), storeExt); ok && !e.IsDir() && id != "" && !strings.HasPrefix(id, ".") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Load reads a conversation's history. A last line cut short by a crash
+// during an append is ignored, and the file rewritten without it, so that
+// the next append does not run on from it.
+func (s *FileStore) Load(id string) ([]Message, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	defer f.Close()
+
+	msgs := make([]Message, 0)
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) == 0 {
+				return msgs, nil
+			}
+			// No newline: an interrupted append, unless it parses
+			var msg Message
+			if json.Unmarshal(line, &msg) == nil {
+				msgs = append(msgs, msg)
+			}
+			data, err := encodeMessages(msgs)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.saveLocked(id, path, data); err != nil {
+				return nil, err
+			}
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("store: %s line %d: %w", path, lineNo, err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// Append adds messages to the end of a conversation's file
+func (s *FileStore) Append(id string, msgs []Message) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := encodeMessages(msgs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if errors.Is(statErr, os.ErrNotExist) {
+		return s.syncDir() // make the new file's name durable
+	}
+	return nil
+}
+
+// Save replaces a conversation's file atomically
+func (s *FileStore) Save(id string, msgs []Message) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := encodeMessages(msgs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveLocked(id, path, data)
+}
+
+// saveLocked writes data as the file of conversation id, at path
+func (s *FileStore) saveLocked(id, path string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, "."+id+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return s.syncDir()
+}
+
+// Remove deletes a conversation's file
+func (s *FileStore) Remove(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("store: %w", err)
+	}
+	return s.syncDir()
+}
+
+// syncDir makes renames, creations and removals in the directory durable
+func (s *FileStore) syncDir() error {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
+
+// encodeMessages renders messages as JSONL
+func encodeMessages(msgs []Message) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, msg := range msgs {
+		if err := enc.Encode(msg); err != nil {
+			return nil, fmt.Errorf("store: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// recorder keeps the histories of conversations in a Store up to date.
+// It remembers what it last wrote for each conversation, so a history
+// that has only grown is appended to, and any other change - compaction,
+// reset, a prepended system message - saves the whole history, as does
+// the first record of a conversation it has not loaded.
+type recorder struct {
+	store Store
+	mu    sync.Mutex
+	saved map[string][]Message
+}
+
+func newRecorder(store Store) *recorder {
+	return &recorder{store: store, saved: make(map[string][]Message)}
+}
+
+// load returns a stored history and remembers it as written
+func (r *recorder) load(id string) ([]Message, error) {
+	msgs, err := r.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.saved[id] = msgs
+	r.mu.Unlock()
+	return msgs, nil
+}
+
+// record brings the stored history of id up to msgs
+func (r *recorder) record(id string, msgs []Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved, known := r.saved[id]
+	var err error
+	switch {
+	case known && len(msgs) == len(saved) && hasPrefix(msgs, saved):
+		return nil // unchanged
+	case known && hasPrefix(msgs, saved):
+		err = r.store.Append(id, msgs[len(saved):])
+	default:
+		err = r.store.Save(id, msgs)
+	}
+	if err != nil {
+		delete(r.saved, id) // unknown state: save in full next time
+		return err
+	}
+	r.saved[id] = append([]Message(nil), msgs...)
+	return nil
+}
+
+// remove deletes the stored history of id
+func (r *recorder) remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.saved, id)
+	return r.store.Remove(id)
+}
+
+// logRecord records msgs, logging a failure: the conversation itself has
+// gone ahead and there is no request to fail
+func (r *recorder) logRecord(id string, msgs []Message) {
+	if err := r.record(id, msgs); err != nil {
+		log.Printf("llm9p: conversation %s not saved: %v", id, err)
+	}
+}
+
+// hasPrefix reports whether msgs begins with prefix
+func hasPrefix(msgs, prefix []Message) bool {
+	if len(prefix) > len(msgs) {
+		return false
+	}
+	for i := range prefix {
+		m, p := msgs[i], prefix[i]
+		if m.Role != p.Role || m.Content != p.Content || !m.Time.Equal(p.Time) || m.Pinned != p.Pinned {
+			return false
+		}
+		if !sameToolCalls(m.ToolCalls, p.ToolCalls) || !sameToolResults(m.ToolResults, p.ToolResults) {
+			return false
+		}
+		if !sameAttachments(m.Attachments, p.Attachments) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameToolCalls reports whether a and b are the same calls
+func sameToolCalls(a, b []ToolCall) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Name != b[i].Name || !bytes.Equal(a[i].Input, b[i].Input) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameToolResults reports whether a and b are the same results
+func sameToolResults(a, b []ToolResult) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameAttachments reports whether a and b are the same attachments, as
+// recorded: by name, type and size, the data not being stored
+func sameAttachments(a, b []Attachment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].MediaType != b[i].MediaType || a[i].Size != b[i].Size {
+			return false
+		}
+	}
+	return true
+}
+
+// StoredBackend is a Backend whose own conversation is recorded in a
+// Store under an ID, and restored from it when created.
+type StoredBackend struct {
+	Backend
+	rec *recorder
+	id  string
+}
+
+// NewStoredBackend wraps b so that its conversation is kept in store as
+// id, restoring any history already stored there
+func NewStoredBackend(b Backend, store Store, id string) (*StoredBackend, error) {
+	sb := &StoredBackend{Backend: b, rec: newRecorder(store), id: id}
+	msgs, err := sb.rec.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) > 0 {
+		b.SetMessages(msgs)
+	}
+	return sb, nil
+}
+
+func (b *StoredBackend) save() {
+	b.rec.logRecord(b.id, b.Backend.Messages())
+}
+
+// Ask sends a prompt and records the exchange
+func (b *StoredBackend) Ask(ctx context.Context, prompt string, attachments ...Attachment) (string, error) {
+	defer b.save()
+	return b.Backend.Ask(ctx, prompt, attachments...)
+}
+
+// StartStream begins streaming and records the exchange when it completes
+func (b *StoredBackend) StartStream(ctx context.Context, prompt string, attachments ...Attachment) error {
+	if err := b.Backend.StartStream(ctx, prompt, attachments...); err != nil {
+		return err
+	}
+	go func() {
+		b.Backend.WaitStream()
+		b.save()
+	}()
+	return nil
+}
+
+// Compact summarizes the conversation and records the summary
+func (b *StoredBackend) Compact(ctx context.Context) error {
+	defer b.save()
+	return b.Backend.Compact(ctx)
+}
+
+// AddSystemMessage adds a system message and records it
+func (b *StoredBackend) AddSystemMessage(content string) {
+	b.Backend.AddSystemMessage(content)
+	b.save()
+}
+
+// SetMessages replaces the history and records it
+func (b *StoredBackend) SetMessages(msgs []Message) {
+	b.Backend.SetMessages(msgs)
+	b.save()
+}
+
+// Reset clears the conversation and records that
+func (b *StoredBackend) Reset() {
+	b.Backend.Reset()
+	b.save()
+}
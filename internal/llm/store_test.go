@@ -0,0 +1,175 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	if msgs, err := store.Load("7"); err != nil || len(msgs) != 0 {
+		t.Errorf("Load() of a missing conversation = %v, %v", msgs, err)
+	}
+
+	turn := []Message{{Role: "user", Content: "hi\nthere"}, {Role: "assistant", Content: "hello"}}
+	if err := store.Append("7", turn); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err := store.Append("7", turn[:1]); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	msgs, err := store.Load("7")
+	if err != nil || !reflect.DeepEqual(msgs, append(turn, turn[0])) {
+		t.Errorf("Load() = %v, %v", msgs, err)
+	}
+
+	// A torn last line from a crash is dropped
+	f, _ := os.OpenFile(filepath.Join(dir, "7.jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"role":"user","con`)
+	f.Close()
+	if msgs, err := store.Load("7"); err != nil || len(msgs) != 3 {
+		t.Errorf("Load() with a torn line = %d messages, %v", len(msgs), err)
+	}
+	// and the next append starts a line of its own
+	if err := store.Append("7", turn[1:]); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if msgs, err := store.Load("7"); err != nil || len(msgs) != 4 || msgs[3].Content != "hello" {
+		t.Errorf("Load() after appending to a torn line = %d messages, %v", len(msgs), err)
+	}
+
+	summary := []Message{{Role: "system", Content: "summary"}}
+	if err := store.Save("7", summary); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if msgs, _ := store.Load("7"); !reflect.DeepEqual(msgs, summary) {
+		t.Errorf("Load() after Save() = %v", msgs)
+	}
+
+	store.Save("shared", summary)
+	if ids, err := store.List(); err != nil || !reflect.DeepEqual(ids, []string{"7", "shared"}) {
+		t.Errorf("List() = %v, %v", ids, err)
+	}
+	if err := store.Remove("7"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if ids, _ := store.List(); !reflect.DeepEqual(ids, []string{"shared"}) {
+		t.Errorf("List() after Remove() = %v", ids)
+	}
+
+	if err := store.Append("../x", turn); err == nil {
+		t.Error("Append() should reject an id with a path separator")
+	}
+}
+
+// countingStore is a Store in memory that counts appends and saves
+type countingStore struct {
+	convs          map[string][]Message
+	appends, saves int
+}
+
+func (s *countingStore) List() ([]string, error) { return nil, nil }
+func (s *countingStore) Load(id string) ([]Message, error) {
+	return s.convs[id], nil
+}
+func (s *countingStore) Append(id string, msgs []Message) error {
+	s.appends++
+	s.convs[id] = append(s.convs[id], msgs...)
+	return nil
+}
+func (s *countingStore) Save(id string, msgs []Message) error {
+	s.saves++
+	s.convs[id] = append([]Message(nil), msgs...)
+	return nil
+}
+func (s *countingStore) Remove(id string) error {
+	delete(s.convs, id)
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	store := &countingStore{convs: map[string][]Message{}}
+	rec := newRecorder(store)
+
+	h := []Message{{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}}
+	rec.record("1", h)
+	rec.record("1", h)
+	h = append(h, Message{Role: "user", Content: "c"}, Message{Role: "assistant", Content: "d"})
+	rec.record("1", h)
+	if store.saves != 1 || store.appends != 1 {
+		t.Errorf("first record and growth: %d saves, %d appends; want 1, 1", store.saves, store.appends)
+	}
+
+	h = append([]Message{{Role: "system", Content: "be brief"}}, h...)
+	rec.record("1", h)
+	if store.saves != 2 || !reflect.DeepEqual(store.convs["1"], h) {
+		t.Errorf("prepended system message: %d saves, stored %v", store.saves, store.convs["1"])
+	}
+}
+
+func TestSessionManager_Store(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewFileStore(dir)
+
+	sm := NewSessionManager(NewOllamaClient("http://127.0.0.1:0"))
+	sm.SetStore(store)
+	sm.AddSystemMessage(3, "be brief")
+	sm.SetMessages(5, []Message{{Role: "user", Content: "q"}, {Role: "assistant", Content: "a"}})
+	hungUp := sm.Client(4)
+	hungUp.AddSystemMessage("temporary")
+	sm.Remove(4)
+
+	// A client left on a removed session neither brings it back nor stores it
+	hungUp.AddSystemMessage("again")
+	hungUp.Reset()
+	if _, err := hungUp.Ask(context.Background(), "hi"); err != ErrSessionRemoved {
+		t.Errorf("Ask() on a removed session error = %v, want ErrSessionRemoved", err)
+	}
+	if sm.Get(4) != nil {
+		t.Error("removed session 4 recreated")
+	}
+
+	restored := NewSessionManager(NewOllamaClient("http://127.0.0.1:0"))
+	restored.SetStore(store)
+	ids, err := restored.Restore()
+	if err != nil || !reflect.DeepEqual(ids, []uint32{3, 5}) {
+		t.Fatalf("Restore() = %v, %v", ids, err)
+	}
+	if msgs := restored.Get(5).Messages(); len(msgs) != 2 || msgs[1].Content != "a" {
+		t.Errorf("restored session 5 = %v", msgs)
+	}
+}
+
+func TestStoredBackend(t *testing.T) {
+	store, _ := NewFileStore(t.TempDir())
+	store.Save("shared", []Message{{Role: "user", Content: "q"}, {Role: "assistant", Content: "a"}})
+
+	client := NewOllamaClient("http://127.0.0.1:0")
+	b, err := NewStoredBackend(client, store, "shared")
+	if err != nil {
+		t.Fatalf("NewStoredBackend() error = %v", err)
+	}
+	if msgs := client.Messages(); len(msgs) != 2 {
+		t.Fatalf("restored history = %v", msgs)
+	}
+
+	b.AddSystemMessage("be brief")
+	if msgs, _ := store.Load("shared"); len(msgs) != 3 || msgs[0].Role != "system" {
+		t.Errorf("stored after AddSystemMessage = %v", msgs)
+	}
+	if err := b.Compact(context.Background()); err != nil {
+		t.Fatalf("Compact() of a short conversation error = %v", err)
+	}
+	b.Reset()
+	if msgs, _ := store.Load("shared"); len(msgs) != 0 {
+		t.Errorf("stored after Reset = %v", msgs)
+	}
+}
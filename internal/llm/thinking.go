@@ -0,0 +1,95 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// Extended thinking limits for the Anthropic API
+const (
+	maxThinkingBudget = 31999 // budget used for ThinkingTokens() == -1
+	minThinkingBudget = 1024  // smallest budget the API accepts
+	maxResponseTokens = 4096  // response tokens requested on top of the budget
+)
+
+// thinkingBudget converts a ThinkingTokens setting into an API budget:
+// 0 when disabled, otherwise at least minThinkingBudget
+func thinkingBudget(tokens int) int {
+	switch {
+	case tokens == 0:
+		return 0
+	case tokens < 0:
+		return maxThinkingBudget
+	case tokens < minThinkingBudget:
+		return minThinkingBudget
+	}
+	return tokens
+}
+
+// thinkingStream is the thinking text of one streamed response. Unlike
+// response chunks it is kept whole, so a reader may read it at its own
+// pace by offset, or not at all, without holding up the response.
+type thinkingStream struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed, and replaced, when text or done changes
+	text    string
+	done    bool
+}
+
+func newThinkingStream() *thinkingStream {
+	return &thinkingStream{changed: make(chan struct{})}
+}
+
+// closedThinkingStream returns a finished stream holding text
+func closedThinkingStream(text string) *thinkingStream {
+	s := newThinkingStream()
+	s.text = text
+	s.done = true
+	return s
+}
+
+func (s *thinkingStream) append(chunk string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.text += chunk
+	s.wakeLocked()
+}
+
+func (s *thinkingStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.wakeLocked()
+}
+
+// wakeLocked wakes the readers waiting for a change
+func (s *thinkingStream) wakeLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// read blocks until there is text past offset, the stream is done or
+// ctx is cancelled. It returns false once the stream is done and offset
+// is at the end, or if ctx was cancelled first.
+func (s *thinkingStream) read(ctx context.Context, offset int64) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for int64(len(s.text)) <= offset && !s.done {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			s.mu.Lock()
+			return "", false
+		}
+		s.mu.Lock()
+	}
+	if offset < 0 || int64(len(s.text)) <= offset {
+		return "", false
+	}
+	return s.text[offset:], true
+}
@@ -0,0 +1,212 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+func TestThinkingBudget(t *testing.T) {
+	tests := []struct {
+		tokens int
+		want   int
+	}{
+		{0, 0},
+		{-1, 31999},
+		{100, 1024},
+		{8000, 8000},
+	}
+	for _, tt := range tests {
+		if got := thinkingBudget(tt.tokens); got != tt.want {
+			t.Errorf("thinkingBudget(%d) = %d, want %d", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestThinkingStream(t *testing.T) {
+	var s *thinkingStream
+	if _, ok := s.read(context.Background(), 0); ok {
+		t.Error("read on no stream should report no more")
+	}
+
+	s = newThinkingStream()
+	done := make(chan string)
+	go func() {
+		text, _ := s.read(context.Background(), 0)
+		done <- text
+	}()
+	s.append("step one")
+	if got := <-done; got != "step one" {
+		t.Errorf("blocked read = %q, want %q", got, "step one")
+	}
+
+	s.append(", step two")
+	s.close()
+	if got, ok := s.read(context.Background(), 8); !ok || got != ", step two" {
+		t.Errorf("read(8) = %q, %v", got, ok)
+	}
+	if _, ok := s.read(context.Background(), 18); ok {
+		t.Error("read at end of a closed stream should report no more")
+	}
+
+	// A blocked read returns when its context is cancelled
+	s = newThinkingStream()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		text, ok := s.read(ctx, 0)
+		done <- fmt.Sprint(text, ok)
+	}()
+	cancel()
+	if got := <-done; got != "false" {
+		t.Errorf("cancelled read = %q", got)
+	}
+}
+
+func TestClient_Thinking(t *testing.T) {
+	var request map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = nil
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514",
+			"content":[{"type":"thinking","thinking":"2 plus 2 is 4","signature":"sig"},{"type":"text","text":"4"}],
+			"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":20}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+
+	// Disabled: no thinking config, temperature sent
+	if _, err := client.Ask(context.Background(), "2+2?"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if _, ok := request["thinking"]; ok {
+		t.Error("thinking sent while disabled")
+	}
+	if _, ok := request["temperature"]; !ok {
+		t.Error("temperature missing while thinking is disabled")
+	}
+
+	client.SetThinkingTokens(2000)
+	response, err := client.Ask(context.Background(), "2+2?")
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	thinking, _ := request["thinking"].(map[string]any)
+	if thinking["type"] != "enabled" || thinking["budget_tokens"] != float64(2000) {
+		t.Errorf("thinking = %v, want enabled with budget 2000", request["thinking"])
+	}
+	if request["max_tokens"] != float64(2000+maxResponseTokens) {
+		t.Errorf("max_tokens = %v, want budget plus %d", request["max_tokens"], maxResponseTokens)
+	}
+	if _, ok := request["temperature"]; ok {
+		t.Error("temperature must not be sent with thinking enabled")
+	}
+	if response != "4" {
+		t.Errorf("Ask() = %q, want text blocks only", response)
+	}
+	if got := client.LastThinking(); got != "2 plus 2 is 4" {
+		t.Errorf("LastThinking() = %q", got)
+	}
+
+	reply, err := client.Complete(context.Background(), nil, "again")
+	if err != nil || reply.Thinking != "2 plus 2 is 4" || reply.Text != "4" {
+		t.Errorf("Complete() = %+v, %v", reply, err)
+	}
+
+	client.Reset()
+	if client.LastThinking() != "" {
+		t.Error("Reset() should clear the last thinking")
+	}
+}
+
+func TestOllamaClient_Think(t *testing.T) {
+	var requests []ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		if !req.Stream {
+			fmt.Fprint(w, `{"model":"qwen3","message":{"role":"assistant","content":"4","thinking":"add them"},"done":true,"prompt_eval_count":5,"eval_count":3}`)
+			return
+		}
+		for _, line := range []string{
+			`{"message":{"role":"assistant","content":"","thinking":"add "},"done":false}`,
+			`{"message":{"role":"assistant","content":"","thinking":"them"},"done":false}`,
+			`{"message":{"role":"assistant","content":"4"},"done":false}`,
+			`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":5,"eval_count":3}`,
+		} {
+			fmt.Fprintln(w, line)
+		}
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	client.SetModel("qwen3")
+	if _, err := client.Ask(context.Background(), "2+2?"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if requests[0].Think {
+		t.Error("think sent while disabled")
+	}
+
+	client.SetThinkingTokens(-1)
+	if client.ThinkingTokens() != -1 {
+		t.Errorf("ThinkingTokens() = %d, want -1", client.ThinkingTokens())
+	}
+	if _, err := client.Ask(context.Background(), "2+2?"); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if !requests[1].Think {
+		t.Error("think not sent while enabled")
+	}
+	if got := client.LastThinking(); got != "add them" {
+		t.Errorf("LastThinking() = %q, want %q", got, "add them")
+	}
+
+	if err := client.StartStream(context.Background(), "2+2?"); err != nil {
+		t.Fatalf("StartStream() error = %v", err)
+	}
+	for {
+		if _, ok := client.ReadStreamChunk(); !ok {
+			break
+		}
+	}
+	client.WaitStream()
+	var streamed strings.Builder
+	for {
+		text, ok := client.ReadThinking(context.Background(), int64(streamed.Len()))
+		if !ok {
+			break
+		}
+		streamed.WriteString(text)
+	}
+	if streamed.String() != "add them" || client.LastThinking() != "add them" {
+		t.Errorf("streamed thinking = %q, last = %q", streamed.String(), client.LastThinking())
+	}
+}
+
+func TestOpenAIClient_ReasoningContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"4","reasoning_content":"add them"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}}`)
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient(server.URL, "")
+	client.SetModel("deepseek-reasoner")
+	reply, err := client.Complete(context.Background(), nil, "2+2?")
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if reply.Text != "4" || reply.Thinking != "add them" || reply.Tokens != 8 {
+		t.Errorf("Complete() = %+v", reply)
+	}
+}
@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"context"
+	"log"
+	"unicode"
+)
+
+// Token accounting. After each exchange a backend reports the size of
+// the conversation's context in Meta.ContextTokens, from the usage of
+// its last request: the whole prompt, including any part read from or
+// written to a cache, and the response. That size, not a running sum of
+// every request, is TotalTokens. When a backend reports no usage, and
+// when the history is edited between exchanges, the size is estimated
+// locally with EstimateTokens.
+
+// TokenCount is the size of a text for a model
+type TokenCount struct {
+	Tokens int
+	Method string // how the tokens were counted: CountAPI, CountPromptEval or CountEstimate
+}
+
+// Token counting methods, as reported in TokenCount
+const (
+	CountAPI        = "count_tokens"      // the Anthropic count_tokens endpoint
+	CountPromptEval = "prompt_eval_count" // Ollama evaluating the text
+	CountEstimate   = "estimate"          // EstimateTokens
+)
+
+// estimateCount is the TokenCount of text without a tokenizer
+func estimateCount(text string) TokenCount {
+	return TokenCount{Tokens: EstimateTokens(text), Method: CountEstimate}
+}
+
+// countFallback is the count of text when counting it by method failed
+// with err: an estimate, unless the request was cancelled
+func countFallback(ctx context.Context, method, text string, err error) (TokenCount, error) {
+	if ctx.Err() != nil {
+		return TokenCount{}, ctx.Err()
+	}
+	log.Printf("llm9p: %s failed, estimating tokens: %v", method, err)
+	return estimateCount(text), nil
+}
+
+// EstimateTokens approximates the number of tokens in s without the
+// model's tokenizer, splitting it the way BPE tokenizers tend to: a word
+// and the space before it make one token, or one per seven letters of
+// a long word; numbers go in groups of three digits; punctuation, symbols
+// and CJK characters are a token each, and so is a run of whitespace
+// other than a single space.
+func EstimateTokens(s string) int {
+	tokens := 0
+	letters, digits := 0, 0
+	flush := func() {
+		tokens += (letters+6)/7 + (digits+2)/3
+		letters, digits = 0, 0
+	}
+	space, run := false, false // after whitespace; in a run of it
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			flush()
+			if !run && (space || r != ' ') {
+				run = true
+				tokens++
+			}
+			space = true
+			continue
+		}
+		space, run = false, false
+		switch {
+		case unicode.IsDigit(r):
+			if letters > 0 {
+				flush()
+			}
+			digits++
+		case unicode.IsLetter(r) && !isCJK(r):
+			if digits > 0 {
+				flush()
+			}
+			letters++
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// isCJK reports whether r is a Chinese, Japanese or Korean character,
+// which tokenizers rarely merge
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// messageOverhead approximates the tokens framing each message of a
+// prompt: its role and the separators around it
+const messageOverhead = 4
+
+// estimateMessage approximates the tokens msg adds to a prompt
+func estimateMessage(msg Message) int {
+	tokens := EstimateTokens(msg.Text()) + messageOverhead
+	for _, a := range msg.Attachments {
+		tokens += a.tokens()
+	}
+	return tokens
+}
+
+// estimateContext approximates the context size of a conversation with
+// the system prompt system and the history msgs
+func estimateContext(system string, msgs []Message) int {
+	tokens := EstimateTokens(system)
+	for _, msg := range msgs {
+		tokens += estimateMessage(msg)
+	}
+	return tokens
+}
+
+// contextTokens is the context size after an exchange: as reported in
+// meta, or estimated from the system prompt and the history msgs when
+// the backend reported no usage
+func contextTokens(meta Meta, system string, msgs []Message) int {
+	if meta.ContextTokens > 0 {
+		return meta.ContextTokens
+	}
+	return estimateContext(system, msgs)
+}
@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"Be brief.", 3},
+		{"Hello, world!", 4},
+		{"internationalization", 3},
+		{"12345678", 3},
+		{"q1", 2},
+		{"one\n\ntwo", 3},
+		{"    return", 2},
+		{"日本語", 3},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestClient_CountTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages/count_tokens" {
+			t.Errorf("request to %s", r.URL.Path)
+		}
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "claude-test" {
+			t.Errorf("model = %q", req.Model)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"input_tokens":12}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	client.SetModel("claude-test")
+	count, err := client.CountTokens(context.Background(), "how many tokens?")
+	if err != nil || count != (TokenCount{Tokens: 12, Method: CountAPI}) {
+		t.Errorf("CountTokens() = %+v, %v", count, err)
+	}
+}
+
+func TestOllamaClient_ContextTokens(t *testing.T) {
+	// The second prompt extends the first exchange, which Ollama has cached
+	counts := []string{`"prompt_eval_count":100,"eval_count":20`, `"prompt_eval_count":8,"eval_count":10`}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"message":{"role":"assistant","content":"ok"},"done":true,%s}`, counts[requests])
+		requests++
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	if _, err := client.Ask(context.Background(), "first"); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	if client.TotalTokens() != 120 {
+		t.Errorf("TotalTokens() = %d, want prompt and response (120)", client.TotalTokens())
+	}
+	if _, err := client.Ask(context.Background(), "second"); err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	if client.TotalTokens() != 138 {
+		t.Errorf("TotalTokens() = %d, want the cached context and the new tokens (138)", client.TotalTokens())
+	}
+	if client.LastTokens() != 18 {
+		t.Errorf("LastTokens() = %d, want 18", client.LastTokens())
+	}
+
+	// Editing the history estimates it
+	client.SetMessages(turnsHistory(1))
+	if want := 7 + 6 + 6; client.TotalTokens() != want {
+		t.Errorf("TotalTokens() after SetMessages = %d, want %d", client.TotalTokens(), want)
+	}
+	client.Reset()
+	if client.TotalTokens() != 0 {
+		t.Errorf("TotalTokens() after Reset = %d", client.TotalTokens())
+	}
+}
+
+func TestOllamaClient_CountTokens(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("request to %s, want /api/embed", r.URL.Path)
+		}
+		if fail {
+			http.Error(w, `{"error":"this model does not support embeddings"}`, http.StatusBadRequest)
+			return
+		}
+		var req ollamaEmbedRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "llama3" || req.Input != "how many tokens?" {
+			t.Errorf("request = %+v", req)
+		}
+		fmt.Fprint(w, `{"embeddings":[[0.1]],"prompt_eval_count":5}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	client.SetModel("llama3")
+	count, err := client.CountTokens(context.Background(), "how many tokens?")
+	if err != nil || count != (TokenCount{Tokens: 5, Method: CountPromptEval}) {
+		t.Errorf("CountTokens() = %+v, %v", count, err)
+	}
+
+	// A model that cannot embed is estimated instead
+	fail = true
+	count, err = client.CountTokens(context.Background(), "how many tokens?")
+	if err != nil || count != (TokenCount{Tokens: 4, Method: CountEstimate}) {
+		t.Errorf("CountTokens() = %+v, %v, want an estimate", count, err)
+	}
+}
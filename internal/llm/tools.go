@@ -0,0 +1,325 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tool is a function the model may call. InputSchema is the JSON Schema
+// of the call's input, which must describe an object.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+// toolName is what the APIs accept as a tool name
+var toolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Validate checks that the tool can be offered to the model
+func (t Tool) Validate() error {
+	if !toolName.MatchString(t.Name) {
+		return fmt.Errorf("invalid tool name %q (1-64 letters, digits, _ or -)", t.Name)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(t.InputSchema, &schema); err != nil || schema == nil {
+		return fmt.Errorf("tool %s: input_schema must be a JSON object", t.Name)
+	}
+	if typ, ok := schema["type"]; ok && typ != "object" {
+		return fmt.Errorf("tool %s: input_schema must have type object", t.Name)
+	}
+	return nil
+}
+
+// schema returns the input schema as a map, with its type set
+func (t Tool) schema() map[string]any {
+	var schema map[string]any
+	json.Unmarshal(t.InputSchema, &schema)
+	if schema == nil {
+		schema = map[string]any{}
+	}
+	schema["type"] = "object"
+	return schema
+}
+
+// ToolCall is a call the model made to a tool. Input is a JSON object.
+type ToolCall struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+// ToolResult answers the ToolCall with the same ID
+type ToolResult struct {
+	CallID  string `json:"call_id"`
+	Content string `json:"content"`
+	IsError bool   `json:"is_error,omitempty"` // the call failed; Content says why
+}
+
+// ToolHandler runs the calls of a tool. The text returned is the result
+// the model sees; an error is reported to the model as a failed call.
+type ToolHandler interface {
+	Run(ctx context.Context, call ToolCall) (string, error)
+}
+
+// CommandHandler runs an executable for each call, with the call's input
+// on standard input and LLM9P_TOOL and LLM9P_CALL_ID in the environment.
+// Its standard output is the result; if it exits non-zero the call
+// fails with its standard error.
+type CommandHandler struct {
+	Path string
+}
+
+// Run implements ToolHandler
+func (h CommandHandler) Run(ctx context.Context, call ToolCall) (string, error) {
+	cmd := exec.CommandContext(ctx, h.Path)
+	cmd.Stdin = bytes.NewReader(call.Input)
+	cmd.Env = append(os.Environ(), "LLM9P_TOOL="+call.Name, "LLM9P_CALL_ID="+call.ID)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// Tool round limits
+const (
+	// DefaultToolRounds is how many rounds of tool calls an exchange may
+	// run before the model is made to answer
+	DefaultToolRounds = 10
+	// MaxToolRoundsLimit caps SetRounds
+	MaxToolRoundsLimit = 100
+)
+
+// Toolbox holds the tools offered to a backend's conversations and runs
+// the agent loop of each exchange. It is shared, like the Retrier, by
+// every conversation on the backend.
+type Toolbox struct {
+	mu     sync.RWMutex
+	tools  map[string]toolEntry
+	rounds int
+}
+
+// toolEntry is a registered tool
+type toolEntry struct {
+	tool    Tool
+	handler ToolHandler
+}
+
+// NewToolbox creates an empty toolbox with the default round limit
+func NewToolbox() *Toolbox {
+	return &Toolbox{tools: make(map[string]toolEntry), rounds: DefaultToolRounds}
+}
+
+// Register adds a tool, or replaces the one with its name
+func (tb *Toolbox) Register(tool Tool, handler ToolHandler) error {
+	if err := tool.Validate(); err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("tool %s has no handler", tool.Name)
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.tools[tool.Name] = toolEntry{tool, handler}
+	return nil
+}
+
+// Remove deletes the named tool
+func (tb *Toolbox) Remove(name string) error {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if _, ok := tb.tools[name]; !ok {
+		return fmt.Errorf("no tool %q", name)
+	}
+	delete(tb.tools, name)
+	return nil
+}
+
+// Tools returns the registered tools by name
+func (tb *Toolbox) Tools() []Tool {
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+	tools := make([]Tool, 0, len(tb.tools))
+	for _, e := range tb.tools {
+		tools = append(tools, e.tool)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+// Lookup returns the named tool and its handler
+func (tb *Toolbox) Lookup(name string) (Tool, ToolHandler, bool) {
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+	e, ok := tb.tools[name]
+	return e.tool, e.handler, ok
+}
+
+// Rounds returns how many rounds of tool calls an exchange may run
+// (0 = tools are not offered)
+func (tb *Toolbox) Rounds() int {
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+	return tb.rounds
+}
+
+// SetRounds sets the round limit
+func (tb *Toolbox) SetRounds(n int) error {
+	if n < 0 || n > MaxToolRoundsLimit {
+		return fmt.Errorf("tool rounds must be between 0 and %d", MaxToolRoundsLimit)
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.rounds = n
+	return nil
+}
+
+// call runs a tool call, turning failures into error results
+func (tb *Toolbox) call(ctx context.Context, call ToolCall) ToolResult {
+	result := ToolResult{CallID: call.ID}
+	_, handler, ok := tb.Lookup(call.Name)
+	if !ok {
+		result.Content, result.IsError = fmt.Sprintf("no tool named %q", call.Name), true
+		return result
+	}
+	content, err := handler.Run(ctx, call)
+	if err != nil {
+		result.Content, result.IsError = err.Error(), true
+		return result
+	}
+	result.Content = content
+	return result
+}
+
+// toolRound is a round of tool calls: the assistant message making the
+// calls and the user message with their results
+type toolRound struct {
+	call, result Message
+	// last is set once the round limit is reached: the request the
+	// round goes back in must not let the model call tools again
+	last bool
+}
+
+// toolRequest makes one request of an exchange, offering tools (none if
+// empty). round is nil for the first request; each later request adds
+// the messages of a round to those of the one before.
+type toolRequest func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error)
+
+// runTools is the agent loop of an exchange. While a response calls
+// tools, the calls are run in order and their results sent back in a
+// further request; once the round limit is reached that request forbids
+// more calls. With a format, a final response that does not match its
+// schema is sent back with the reason, up to its retry limit, and the
+// exchange fails with a *SchemaError if none matches. The reply returned
+// is the final response with the usage of every request, and Steps
+// holds the tool call and result messages that go in the history
+// between the prompt and the response; the answers sent back are left
+// out. tb may be nil, for backends that cannot call tools.
+func (tb *Toolbox) runTools(ctx context.Context, format *answerFormat, request toolRequest) (*Reply, error) {
+	var tools []Tool
+	var limit int
+	if tb != nil {
+		tools, limit = tb.Tools(), tb.Rounds()
+	}
+	if limit == 0 {
+		tools = nil
+	}
+	var total *Reply
+	var round *toolRound
+	for {
+		reply, err := request(ctx, tools, round)
+		if err != nil {
+			return nil, err
+		}
+		total = total.then(reply)
+		if len(reply.Calls) == 0 || len(tools) == 0 || round != nil && round.last {
+			total.Calls = nil // any calls made past the limit are dropped
+			answer, err := format.check(reply.Text)
+			if err == nil {
+				total.Text = answer
+				return total, nil
+			}
+			if total.SchemaRetries >= format.retries {
+				return nil, &SchemaError{Reason: err.Error(), Attempts: total.SchemaRetries + 1, Response: reply.Text}
+			}
+			total.SchemaRetries++
+			last := round != nil && round.last
+			round = format.repair(reply.Text, err)
+			round.last = last
+			continue
+		}
+
+		var results []ToolResult
+		for _, call := range reply.Calls {
+			results = append(results, tb.call(ctx, call))
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		total.ToolRounds++
+		round = &toolRound{
+			call:   Message{Role: "assistant", Content: reply.Text, ToolCalls: reply.Calls, Time: now},
+			result: Message{Role: "user", ToolResults: results, Time: now},
+			last:   total.ToolRounds >= limit,
+		}
+		total.Steps = append(total.Steps, round.call, round.result)
+	}
+}
+
+// then adds the next reply of an exchange to r (nil for the first): the
+// text and stop reason are the next reply's, the usage covers both
+func (r *Reply) then(next *Reply) *Reply {
+	if r == nil {
+		return next
+	}
+	meta := next.Meta
+	meta.InputTokens += r.InputTokens
+	meta.OutputTokens += r.OutputTokens
+	meta.CacheReadTokens += r.CacheReadTokens
+	meta.CacheWriteTokens += r.CacheWriteTokens
+	meta.Continuations += r.Continuations
+	meta.ToolRounds = r.ToolRounds
+	meta.SchemaRetries = r.SchemaRetries
+	return &Reply{
+		Text:     next.Text,
+		Thinking: r.Thinking + next.Thinking,
+		Tokens:   r.Tokens + next.Tokens,
+		Calls:    next.Calls,
+		Steps:    r.Steps,
+		Meta:     meta,
+	}
+}
+
+// toolResultText is a result for backends without an error flag
+func toolResultText(result ToolResult) string {
+	if result.IsError {
+		return "error: " + result.Content
+	}
+	return result.Content
+}
+
+// newToolCallID identifies a call for backends that do not
+func newToolCallID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "call_" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,283 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+)
+
+// toolFunc is a ToolHandler for tests
+type toolFunc func(ctx context.Context, call ToolCall) (string, error)
+
+func (f toolFunc) Run(ctx context.Context, call ToolCall) (string, error) { return f(ctx, call) }
+
+// addTool adds the numbers a and b
+var addTool = Tool{
+	Name:        "add",
+	Description: "Add two numbers",
+	InputSchema: json.RawMessage(`{"type":"object","properties":{"a":{"type":"number"},"b":{"type":"number"}},"required":["a","b"]}`),
+}
+
+func addHandler(ctx context.Context, call ToolCall) (string, error) {
+	var in struct{ A, B float64 }
+	if err := json.Unmarshal(call.Input, &in); err != nil {
+		return "", err
+	}
+	return fmt.Sprint(in.A + in.B), nil
+}
+
+// toolRequestBody is the part of a Messages API request that shows tools
+type toolRequestBody struct {
+	Tools []struct {
+		Name string `json:"name"`
+	} `json:"tools"`
+	ToolChoice struct {
+		Type string `json:"type"`
+	} `json:"tool_choice"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content []struct {
+			Type      string          `json:"type"`
+			Text      string          `json:"text"`
+			ID        string          `json:"id"`
+			ToolUseID string          `json:"tool_use_id"`
+			Content   json.RawMessage `json:"content"`
+		} `json:"content"`
+	} `json:"messages"`
+}
+
+func TestClient_Tools(t *testing.T) {
+	var requests []toolRequestBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req toolRequestBody
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[{"type":"text","text":"Adding."},{"type":"tool_use","id":"toolu_1","name":"add","input":{"a":2,"b":3}}],"stop_reason":"tool_use","usage":{"input_tokens":20,"output_tokens":10}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"msg_2","type":"message","role":"assistant","model":"claude-test","content":[{"type":"text","text":"The sum is 5."}],"stop_reason":"end_turn","usage":{"input_tokens":40,"output_tokens":5}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key")
+	client.client = anthropic.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	if err := client.Toolbox().Register(addTool, toolFunc(addHandler)); err != nil {
+		t.Fatalf("Register() error: %v", err)
+	}
+
+	response, err := client.Ask(context.Background(), "What is 2+3?")
+	if err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	if response != "The sum is 5." {
+		t.Errorf("Ask() = %q", response)
+	}
+	if len(requests) != 2 || len(requests[0].Tools) != 1 || requests[0].Tools[0].Name != "add" {
+		t.Fatalf("requests = %+v", requests)
+	}
+	second := requests[1].Messages
+	if len(second) != 3 || second[1].Content[1].ID != "toolu_1" || second[2].Content[0].ToolUseID != "toolu_1" {
+		t.Fatalf("second request messages = %+v", second)
+	}
+	if got := string(second[2].Content[0].Content); got != `"5"` && !strings.Contains(got, `"text":"5"`) {
+		t.Errorf("tool result content = %s", got)
+	}
+
+	msgs := client.Messages()
+	if len(msgs) != 4 || len(msgs[1].ToolCalls) != 1 || len(msgs[2].ToolResults) != 1 || msgs[3].Content != "The sum is 5." {
+		t.Fatalf("Messages() = %+v", msgs)
+	}
+	if msgs[2].ToolResults[0] != (ToolResult{CallID: "toolu_1", Content: "5"}) {
+		t.Errorf("tool result = %+v", msgs[2].ToolResults[0])
+	}
+	meta := client.LastMeta()
+	if meta.ToolRounds != 1 || meta.InputTokens != 60 || meta.OutputTokens != 15 {
+		t.Errorf("LastMeta() = %+v", meta)
+	}
+
+	// Pop drops the whole exchange, tool rounds and all
+	if err := Pop(client); err != nil || len(client.Messages()) != 0 {
+		t.Errorf("Pop() left %v (error %v)", client.Messages(), err)
+	}
+}
+
+func TestToolbox_RoundLimit(t *testing.T) {
+	tb := NewToolbox()
+	tb.Register(addTool, toolFunc(addHandler))
+	if err := tb.SetRounds(2); err != nil {
+		t.Fatalf("SetRounds() error: %v", err)
+	}
+	if tb.SetRounds(MaxToolRoundsLimit+1) == nil || tb.SetRounds(-1) == nil {
+		t.Error("SetRounds() should reject limits out of range")
+	}
+
+	var last []bool
+	reply, err := tb.runTools(context.Background(), nil, func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error) {
+		last = append(last, round != nil && round.last)
+		// The model never stops calling
+		calls := []ToolCall{{ID: fmt.Sprint("c", len(last)), Name: "add", Input: json.RawMessage(`{"a":1,"b":1}`)}}
+		return &Reply{Text: "again", Calls: calls, Meta: Meta{InputTokens: 1}}, nil
+	})
+	if err != nil {
+		t.Fatalf("runTools() error: %v", err)
+	}
+	if fmt.Sprint(last) != "[false false true]" {
+		t.Errorf("requests last = %v, want [false false true]", last)
+	}
+	if reply.ToolRounds != 2 || len(reply.Steps) != 4 || reply.Calls != nil || reply.InputTokens != 3 {
+		t.Errorf("reply = %+v", reply)
+	}
+
+	// Rounds 0 offers no tools
+	tb.SetRounds(0)
+	tb.runTools(context.Background(), nil, func(ctx context.Context, tools []Tool, round *toolRound) (*Reply, error) {
+		if tools != nil {
+			t.Errorf("tools offered with rounds 0: %v", tools)
+		}
+		return &Reply{}, nil
+	})
+}
+
+func TestToolbox_Call(t *testing.T) {
+	tb := NewToolbox()
+	tb.Register(Tool{Name: "fail", InputSchema: json.RawMessage(`{}`)}, toolFunc(func(ctx context.Context, call ToolCall) (string, error) {
+		return "", errors.New("out of order")
+	}))
+	if got := tb.call(context.Background(), ToolCall{ID: "1", Name: "fail"}); got != (ToolResult{CallID: "1", Content: "out of order", IsError: true}) {
+		t.Errorf("failing call = %+v", got)
+	}
+	if got := tb.call(context.Background(), ToolCall{ID: "2", Name: "nope"}); !got.IsError {
+		t.Errorf("unknown tool = %+v", got)
+	}
+	if tb.Remove("nope") == nil {
+		t.Error("Remove() should fail for an unknown tool")
+	}
+}
+
+func TestTool_Validate(t *testing.T) {
+	tests := []struct {
+		tool Tool
+		ok   bool
+	}{
+		{addTool, true},
+		{Tool{Name: "no_props", InputSchema: json.RawMessage(`{}`)}, true},
+		{Tool{Name: "bad name", InputSchema: json.RawMessage(`{}`)}, false},
+		{Tool{Name: "", InputSchema: json.RawMessage(`{}`)}, false},
+		{Tool{Name: "array", InputSchema: json.RawMessage(`{"type":"array"}`)}, false},
+		{Tool{Name: "none"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.tool.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%s) error = %v, want ok %v", tt.tool.Name, err, tt.ok)
+		}
+	}
+}
+
+func TestCommandHandler(t *testing.T) {
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	echo := CommandHandler{Path: script("echo", `printf '%s %s ' "$LLM9P_TOOL" "$LLM9P_CALL_ID"; cat`)}
+	out, err := echo.Run(context.Background(), ToolCall{ID: "c1", Name: "echo", Input: json.RawMessage(`{"x":1}`)})
+	if err != nil || out != `echo c1 {"x":1}` {
+		t.Errorf("Run() = %q, %v", out, err)
+	}
+
+	fail := CommandHandler{Path: script("fail", "echo 'no such city' >&2; exit 1")}
+	if _, err := fail.Run(context.Background(), ToolCall{Input: json.RawMessage(`{}`)}); err == nil || err.Error() != "no such city" {
+		t.Errorf("failing Run() error = %v", err)
+	}
+}
+
+func TestOpenAIClient_Tools(t *testing.T) {
+	var requests []openAIChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","model":"gpt-test","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"add","arguments":"{\"a\":2,\"b\":3}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"chatcmpl-2","model":"gpt-test","choices":[{"index":0,"message":{"role":"assistant","content":"5"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	client := NewOpenAIClient(server.URL, "")
+	client.SetModel("gpt-test")
+	client.Toolbox().Register(addTool, toolFunc(addHandler))
+
+	reply, err := client.Complete(context.Background(), nil, "2+3?")
+	if err != nil {
+		t.Fatalf("Complete() error: %v", err)
+	}
+	if reply.Text != "5" || reply.ToolRounds != 1 || len(reply.Steps) != 2 {
+		t.Errorf("reply = %+v", reply)
+	}
+	if len(requests) != 2 || len(requests[0].Tools) != 1 || requests[0].Tools[0].Function.Name != "add" {
+		t.Fatalf("requests = %+v", requests)
+	}
+	msgs := requests[1].Messages
+	result := msgs[len(msgs)-1]
+	if result.Role != "tool" || result.ToolCallID != "call_1" || result.Content != "5" {
+		t.Errorf("tool message = %+v", result)
+	}
+	if calls := msgs[len(msgs)-2].ToolCalls; len(calls) != 1 || calls[0].Function.Arguments != `{"a":2,"b":3}` {
+		t.Errorf("assistant tool calls = %+v", calls)
+	}
+}
+
+func TestOllamaClient_Tools(t *testing.T) {
+	var requests []ollamaChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"model":"llama3.2","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"add","arguments":{"a":2,"b":3}}}]},"done":true,"done_reason":"stop","prompt_eval_count":10,"eval_count":5}`)
+			return
+		}
+		fmt.Fprint(w, `{"model":"llama3.2","message":{"role":"assistant","content":"5"},"done":true,"done_reason":"stop","prompt_eval_count":20,"eval_count":1}`)
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL)
+	client.Toolbox().Register(addTool, toolFunc(addHandler))
+
+	response, err := client.Ask(context.Background(), "2+3?")
+	if err != nil {
+		t.Fatalf("Ask() error: %v", err)
+	}
+	if response != "5" || len(requests) != 2 || len(requests[0].Tools) != 1 {
+		t.Fatalf("Ask() = %q after %d requests", response, len(requests))
+	}
+	msgs := requests[1].Messages
+	if result := msgs[len(msgs)-1]; result.Role != "tool" || result.ToolName != "add" || result.Content != "5" {
+		t.Errorf("tool message = %+v", result)
+	}
+	history := client.Messages()
+	if len(history) != 4 || !strings.HasPrefix(history[1].ToolCalls[0].ID, "call_") {
+		t.Errorf("Messages() = %+v", history)
+	}
+	if history[2].ToolResults[0].CallID != history[1].ToolCalls[0].ID {
+		t.Errorf("result answers %q, want %q", history[2].ToolResults[0].CallID, history[1].ToolCalls[0].ID)
+	}
+}
@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// transcriptRoles are the role prefixes of a block transcript
+var transcriptRoles = []string{"system", "user", "assistant"}
+
+// IsTranscript reports whether text is written as messages, in one of
+// the forms ParseTranscript reads, rather than as plain text
+func IsTranscript(text string) bool {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if strings.HasPrefix(line, "{") {
+		return true
+	}
+	_, _, ok := roleLine(line)
+	return ok
+}
+
+// roleLine splits a line starting with a role prefix such as "user:"
+func roleLine(line string) (role, rest string, ok bool) {
+	for _, r := range transcriptRoles {
+		if rest, ok := strings.CutPrefix(line, r+":"); ok {
+			return r, rest, true
+		}
+	}
+	return "", "", false
+}
+
+// ParseTranscript reads messages written in one of two forms: JSON
+// objects one per line, {"role":"user","content":"..."}, or blocks
+// that each start with "user:", "assistant:" or "system:" at the
+// beginning of a line and run to the next such line.
+func ParseTranscript(text string) ([]Message, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		return parseJSONLines(text)
+	}
+
+	var msgs []Message
+	var content []string
+	flush := func() {
+		if len(msgs) > 0 {
+			msgs[len(msgs)-1].Content = strings.TrimSpace(strings.Join(content, "\n"))
+		}
+		content = content[:0]
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if role, rest, ok := roleLine(line); ok {
+			flush()
+			msgs = append(msgs, Message{Role: role})
+			line = rest
+		} else if len(msgs) == 0 {
+			return nil, fmt.Errorf("transcript must start with a role: user:, assistant: or system:")
+		}
+		content = append(content, line)
+	}
+	flush()
+	return msgs, nil
+}
+
+// parseJSONLines reads one JSON message per non-empty line
+func parseJSONLines(text string) ([]Message, error) {
+	var msgs []Message
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(nil, len(text)+1)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, scanner.Err()
+}
+
+// CheckMessages checks that every message is from the system, the user
+// or the assistant and, if alternate is set, that the user and assistant
+// messages alternate starting with the user and end with the assistant,
+// so that the next prompt keeps the alternation. System messages may
+// appear anywhere. Tool calls must come from the assistant, and their
+// results in the user message straight after.
+func CheckMessages(msgs []Message, alternate bool) error {
+	want := "user"
+	var calls []ToolCall // of the last assistant message
+	for i, msg := range msgs {
+		switch msg.Role {
+		case "system":
+			continue
+		case "user", "assistant":
+		default:
+			return fmt.Errorf("message %d: unknown role %q", i, msg.Role)
+		}
+		if len(msg.ToolCalls) > 0 && msg.Role != "assistant" {
+			return fmt.Errorf("message %d: only assistant messages may call tools", i)
+		}
+		if len(msg.Attachments) > 0 && (msg.Role != "user" || len(msg.ToolResults) > 0) {
+			return fmt.Errorf("message %d: only prompts may carry attachments", i)
+		}
+		if len(msg.ToolResults) > 0 {
+			if msg.Role != "user" {
+				return fmt.Errorf("message %d: only user messages may carry tool results", i)
+			}
+			if err := checkResults(calls, msg.ToolResults); err != nil {
+				return fmt.Errorf("message %d: %w", i, err)
+			}
+		}
+		calls = msg.ToolCalls
+		if !alternate {
+			continue
+		}
+		if msg.Role != want {
+			return fmt.Errorf("message %d: %s turn expected (user and assistant turns must alternate, starting with user)", i, want)
+		}
+		if want == "user" {
+			want = "assistant"
+		} else {
+			want = "user"
+		}
+	}
+	if alternate && want == "assistant" {
+		return fmt.Errorf("history must end with an assistant message")
+	}
+	return nil
+}
+
+// checkResults checks that results answer calls, each once
+func checkResults(calls []ToolCall, results []ToolResult) error {
+	pending := make(map[string]bool)
+	for _, call := range calls {
+		pending[call.ID] = true
+	}
+	for _, result := range results {
+		if !pending[result.CallID] {
+			return fmt.Errorf("tool result for %q answers no call of the message before", result.CallID)
+		}
+		delete(pending, result.CallID)
+	}
+	return nil
+}
+
+// Import adds msgs to the end of b's history, or replaces the history
+// with them, once b has accepted the result. Messages without a time
+// are stamped with the current one.
+func Import(b Backend, msgs []Message, replace bool) error {
+	var history []Message
+	if !replace {
+		history = b.Messages()
+	}
+	now := time.Now().UTC()
+	for _, msg := range msgs {
+		if msg.Time.IsZero() {
+			msg.Time = now
+		}
+		history = append(history, msg)
+	}
+	if err := b.ValidateMessages(history); err != nil {
+		return err
+	}
+	b.SetMessages(history)
+	return nil
+}
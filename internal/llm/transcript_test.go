@@ -0,0 +1,132 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTranscript(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Message
+	}{
+		{
+			name: "blocks",
+			text: "user: What is 2+2?\nassistant: 4\nuser:  Name a prime.\n\nJust one.\nassistant:\n7\n",
+			want: []Message{
+				{Role: "user", Content: "What is 2+2?"},
+				{Role: "assistant", Content: "4"},
+				{Role: "user", Content: "Name a prime.\n\nJust one."},
+				{Role: "assistant", Content: "7"},
+			},
+		},
+		{
+			name: "json lines",
+			text: "{\"role\":\"system\",\"content\":\"Answer in French.\"}\n\n{\"role\":\"user\",\"content\":\"Hi\"}\n",
+			want: []Message{
+				{Role: "system", Content: "Answer in French."},
+				{Role: "user", Content: "Hi"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsTranscript(tt.text) {
+				t.Error("IsTranscript() = false")
+			}
+			got, err := ParseTranscript(tt.text)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTranscript() = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	if IsTranscript("You are a pirate.\nuser: is not a prefix here") {
+		t.Error("plain text taken for a transcript")
+	}
+	if _, err := ParseTranscript("{\"role\":\"user\"}\nnot json"); err == nil {
+		t.Error("ParseTranscript() should fail on a bad JSON line")
+	}
+}
+
+func TestCheckMessages(t *testing.T) {
+	msgs := func(roles ...string) []Message {
+		var m []Message
+		for _, r := range roles {
+			m = append(m, Message{Role: r, Content: r})
+		}
+		return m
+	}
+	tests := []struct {
+		roles     []string
+		alternate bool
+		err       string
+	}{
+		{nil, true, ""},
+		{[]string{"system", "user", "assistant", "system", "user", "assistant"}, true, ""},
+		{[]string{"assistant", "user"}, true, "user turn expected"},
+		{[]string{"user", "user", "assistant"}, true, "assistant turn expected"},
+		{[]string{"user", "assistant", "user"}, true, "must end with an assistant"},
+		{[]string{"user", "user"}, false, ""},
+		{[]string{"user", "tool"}, false, "unknown role"},
+	}
+	for _, tt := range tests {
+		err := CheckMessages(msgs(tt.roles...), tt.alternate)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("CheckMessages(%v, %v) = %v, want %q", tt.roles, tt.alternate, err, tt.err)
+		}
+	}
+
+	call := Message{Role: "assistant", ToolCalls: []ToolCall{{ID: "c1", Name: "add"}}}
+	result := Message{Role: "user", ToolResults: []ToolResult{{CallID: "c1", Content: "5"}}}
+	prompt, answer := Message{Role: "user", Content: "q"}, Message{Role: "assistant", Content: "a"}
+	toolTests := []struct {
+		msgs []Message
+		err  string
+	}{
+		{[]Message{prompt, call, result, answer}, ""},
+		{[]Message{prompt, answer, result, answer}, "answers no call"},
+		{[]Message{prompt, call, result, result, answer}, "answers no call"},
+		{[]Message{{Role: "user", ToolCalls: call.ToolCalls}, answer}, "only assistant messages"},
+		{[]Message{prompt, {Role: "assistant", ToolResults: result.ToolResults}}, "only user messages"},
+	}
+	for i, tt := range toolTests {
+		err := CheckMessages(tt.msgs, true)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("tool case %d: CheckMessages() = %v, want %q", i, err, tt.err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	client := NewClient("test-key")
+	client.SetMessages([]Message{{Role: "user", Content: "q"}, {Role: "assistant", Content: "a"}})
+
+	examples := []Message{{Role: "user", Content: "2+2"}, {Role: "assistant", Content: "4"}}
+	if err := Import(client, examples, false); err != nil {
+		t.Fatalf("Import() error: %v", err)
+	}
+	msgs := client.Messages()
+	if len(msgs) != 4 || msgs[2].Content != "2+2" || msgs[3].Time.IsZero() {
+		t.Errorf("after append: %v", msgs)
+	}
+
+	if err := Import(client, examples[:1], false); err == nil {
+		t.Error("Import() should reject a history ending with the user")
+	}
+	if len(client.Messages()) != 4 {
+		t.Error("rejected import changed the history")
+	}
+
+	if err := Import(client, examples, true); err != nil || len(client.Messages()) != 2 {
+		t.Errorf("replace: %v, %v", err, client.Messages())
+	}
+
+	// Other backends accept consecutive turns of one role
+	ollama := NewOllamaClient("http://localhost:11434")
+	if err := Import(ollama, examples[:1], false); err != nil {
+		t.Errorf("Ollama Import() error: %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package llmfs
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"strings"
@@ -11,26 +13,59 @@ import (
 	"github.com/NERVsystems/llm9p/internal/protocol"
 )
 
-// CompactThreshold is the percentage of context limit at which auto-compaction triggers
-const CompactThreshold = 0.80
-
-// AskFile is the main interaction file - write a prompt, read the response
+// AskFile is the main interaction file - write a prompt, read the response.
+// Over 9P, writes are buffered per fid and the whole prompt is sent when the
+// fid is clunked, so a prompt spanning several Twrites is one LLM call. A
+// read on the fid that wrote sends the prompt too, so that a read-write
+// file can ask and then read the answer; its read offsets are then taken
+// from the first read, as on an IsolatedAskFile.
 type AskFile struct {
 	*protocol.BaseFile
 	client       llm.Backend
+	attach       *AttachDir // files for the next prompt, if any
 	mu           sync.RWMutex
 	lastResponse string
+	pending      *fidBuffers
+	readers      map[uint32]*fidAnswer // fids that sent a prompt by reading
+	commits      sync.RWMutex          // held for reading while a prompt is committed
 }
 
+var _ protocol.ContextFidAwareFile = (*AskFile)(nil)
+
 // NewAskFile creates the ask file
 func NewAskFile(client llm.Backend) *AskFile {
 	return &AskFile{
 		BaseFile: protocol.NewBaseFile("ask", 0666),
 		client:   client,
+		pending:  newFidBuffers(),
+		readers:  make(map[uint32]*fidAnswer),
 	}
 }
 
 func (f *AskFile) Read(p []byte, offset int64) (int, error) {
+	return f.ReadContext(context.Background(), p, offset)
+}
+
+// waitCommits waits for prompts being committed by a concurrent clunk,
+// so that "echo q > ask; cat ask" never reads the previous answer, or
+// until ctx is done
+func (f *AskFile) waitCommits(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		f.commits.Lock()
+		f.commits.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// content returns the last response, ending in a newline
+func (f *AskFile) content() string {
 	f.mu.RLock()
 	content := f.lastResponse
 	f.mu.RUnlock()
@@ -39,7 +74,21 @@ func (f *AskFile) Read(p []byte, offset int64) (int, error) {
 	if content != "" && !strings.HasSuffix(content, "\n") {
 		content += "\n"
 	}
+	return content
+}
 
+func (f *AskFile) Write(p []byte, offset int64) (int, error) {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// ReadContext implements protocol.ContextAwareFile - flushing the read
+// stops its wait for a prompt being sent
+func (f *AskFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	if err := f.waitCommits(ctx); err != nil {
+		return 0, err
+	}
+
+	content := f.content()
 	if offset >= int64(len(content)) {
 		return 0, io.EOF
 	}
@@ -47,22 +96,102 @@ func (f *AskFile) Read(p []byte, offset int64) (int, error) {
 	return n, nil
 }
 
-func (f *AskFile) Write(p []byte, offset int64) (int, error) {
+// ReadFid implements protocol.FidAwareFile
+func (f *AskFile) ReadFid(fid uint32, p []byte, offset int64) (int, error) {
+	return f.ReadFidContext(context.Background(), fid, p, offset)
+}
+
+// ReadFidContext implements protocol.ContextFidAwareFile - sends the
+// prompt the fid wrote, if any, then returns the last response; flushing
+// the read cancels the request
+func (f *AskFile) ReadFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	if data, ok := f.pending.take(fid); ok {
+		if err := f.commit(ctx, data); err != nil {
+			return 0, err
+		}
+		f.mu.Lock()
+		f.readers[fid] = &fidAnswer{readBase: offset, writeBase: -1}
+		f.mu.Unlock()
+	}
+
+	f.mu.Lock()
+	if fa, ok := f.readers[fid]; ok {
+		offset = fa.readAt(offset)
+	}
+	f.mu.Unlock()
+	return f.ReadContext(ctx, p, offset)
+}
+
+// WriteFid implements protocol.FidAwareFile - buffers until clunk or a read
+func (f *AskFile) WriteFid(fid uint32, p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	if fa, ok := f.readers[fid]; ok {
+		offset = fa.writeAt(offset)
+	}
+	f.mu.Unlock()
+	return f.pending.write(fid, p, offset)
+}
+
+// WriteFidContext implements protocol.ContextFidAwareFile - buffers until
+// clunk or a read
+func (f *AskFile) WriteFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return f.WriteFid(fid, p, offset)
+}
+
+// CloseFid implements protocol.FidAwareFile - sends the buffered prompt
+func (f *AskFile) CloseFid(fid uint32) error {
+	return f.CloseFidContext(context.Background(), fid)
+}
+
+// CloseFidContext implements protocol.ContextFidAwareFile.
+// The assembled prompt is sent as a single request; flushing the
+// clunk cancels it.
+func (f *AskFile) CloseFidContext(ctx context.Context, fid uint32) error {
+	f.mu.Lock()
+	delete(f.readers, fid)
+	f.mu.Unlock()
+	data, ok := f.pending.take(fid)
+	if !ok {
+		return nil
+	}
+	return f.commit(ctx, data)
+}
+
+// AbortFid implements protocol.FidAborter - discards the buffered
+// prompt, which may be cut short
+func (f *AskFile) AbortFid(fid uint32) {
+	f.mu.Lock()
+	delete(f.readers, fid)
+	f.mu.Unlock()
+	f.pending.take(fid)
+}
+
+// commit sends a prompt assembled from a fid's writes
+func (f *AskFile) commit(ctx context.Context, data []byte) error {
+	f.commits.RLock()
+	defer f.commits.RUnlock()
+	_, err := f.WriteContext(ctx, data, 0)
+	return err
+}
+
+// WriteContext implements protocol.ContextAwareFile.
+// The context is cancelled when the client flushes the write (e.g. Ctrl-C),
+// which aborts the backend request.
+func (f *AskFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
 	prompt := strings.TrimSpace(string(p))
 	if prompt == "" {
 		return len(p), nil // Empty write is a no-op
 	}
 
-	ctx := context.Background()
-
 	// Check if we need to auto-compact before processing
 	tokens := f.client.TotalTokens()
 	limit := f.client.ContextLimit()
-	threshold := int(float64(limit) * CompactThreshold)
+	policy := f.client.CompactPolicy()
+	threshold := int(float64(limit) * policy.Threshold)
 
-	if tokens > threshold {
-		log.Printf("llm9p: auto-compacting at %d/%d tokens (%.0f%% threshold)",
-			tokens, limit, CompactThreshold*100)
+	if policy.Threshold > 0 && tokens > threshold {
+		log.Printf("llm9p: auto-compacting at %d/%d tokens (%.0f%% threshold, %s)",
+			tokens, limit, policy.Threshold*100, policy.Strategy)
 		if err := f.client.Compact(ctx); err != nil {
 			log.Printf("llm9p: auto-compact failed: %v", err)
 			// Continue anyway - better to try than to fail
@@ -72,12 +201,18 @@ func (f *AskFile) Write(p []byte, offset int64) (int, error) {
 		}
 	}
 
-	// Make the API call
-	response, err := f.client.Ask(ctx, prompt)
+	// Make the API call, with the files staged in attach/
+	attachments := f.attach.take()
+	response, err := f.client.Ask(ctx, prompt, attachments...)
 	if err != nil {
+		f.attach.restore(attachments)
+		if ctx.Err() != nil {
+			// Flushed by the client; nobody is waiting for the answer
+			return 0, ctx.Err()
+		}
 		// Store error as response so it can be read
 		f.mu.Lock()
-		f.lastResponse = "Error: " + err.Error()
+		f.lastResponse = errorResponse(err)
 		f.mu.Unlock()
 		return len(p), nil // Return success so client knows write completed
 	}
@@ -89,16 +224,46 @@ func (f *AskFile) Write(p []byte, offset int64) (int, error) {
 	return len(p), nil
 }
 
-// Stat returns the file's metadata
-func (f *AskFile) Stat() protocol.Stat {
-	f.mu.RLock()
-	length := len(f.lastResponse)
-	if length > 0 && !strings.HasSuffix(f.lastResponse, "\n") {
-		length++
+// retry asks the last prompt of the history again, for a ctl's retry,
+// and ask then reads the new response. A failed request is read as an
+// error, as a failed prompt is, and returned too.
+func (f *AskFile) retry(ctx context.Context) error {
+	f.commits.RLock()
+	defer f.commits.RUnlock()
+	response, err := llm.Retry(ctx, f.client)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !errors.Is(err, llm.ErrNoExchange) {
+			f.mu.Lock()
+			f.lastResponse = errorResponse(err)
+			f.mu.Unlock()
+		}
+		return err
 	}
-	f.mu.RUnlock()
 
+	f.mu.Lock()
+	f.lastResponse = response
+	f.mu.Unlock()
+	return nil
+}
+
+// errorResponse is what ask reads after a failed prompt: the error, or
+// the JSON of a *llm.SchemaError, so that a reader expecting JSON gets
+// JSON either way
+func errorResponse(err error) string {
+	var schemaErr *llm.SchemaError
+	if errors.As(err, &schemaErr) {
+		data, _ := json.Marshal(schemaErr)
+		return string(data)
+	}
+	return "Error: " + err.Error()
+}
+
+// Stat returns the file's metadata
+func (f *AskFile) Stat() protocol.Stat {
 	s := f.BaseFile.Stat()
-	s.Length = uint64(length)
+	s.Length = uint64(len(f.content()))
 	return s
 }
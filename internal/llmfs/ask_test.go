@@ -1,8 +1,11 @@
 package llmfs
 
 import (
+	"context"
 	"io"
 	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
 )
 
 func TestAskFile_Read_Empty(t *testing.T) {
@@ -147,8 +150,67 @@ func TestAskFile_ResponseNewline(t *testing.T) {
 }
 
 func TestCompactThreshold(t *testing.T) {
-	// Verify the threshold constant
-	if CompactThreshold != 0.80 {
-		t.Errorf("CompactThreshold = %f, want 0.80", CompactThreshold)
+	// The default policy compacts at 80% of the context window
+	if llm.DefaultCompactPolicy.Threshold != 0.80 {
+		t.Errorf("default threshold = %f, want 0.80", llm.DefaultCompactPolicy.Threshold)
+	}
+
+	mock := NewMockBackend()
+	mock.askResponse = "ok"
+	mock.totalTokens = 150000
+	ask := NewAskFile(mock)
+	ask.Write([]byte("below the threshold"), 0)
+	if mock.compactCalled {
+		t.Error("compacted below the threshold")
+	}
+
+	policy := mock.CompactPolicy()
+	policy.Threshold = 0.5
+	mock.SetCompactPolicy(policy)
+	ask.Write([]byte("above the threshold"), 0)
+	if !mock.compactCalled {
+		t.Error("did not compact above the threshold")
+	}
+
+	mock.compactCalled = false
+	mock.totalTokens = 190000
+	policy.Threshold = 0
+	mock.SetCompactPolicy(policy)
+	ask.Write([]byte("auto-compaction off"), 0)
+	if mock.compactCalled {
+		t.Error("compacted with the threshold off")
+	}
+}
+
+func TestAskFile_WriteContext_Cancelled(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "should not be stored"
+	ask := NewAskFile(mock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A flushed write reports the cancellation and leaves no response behind
+	if _, err := ask.WriteContext(ctx, []byte("test"), 0); err != context.Canceled {
+		t.Fatalf("WriteContext() error = %v, want context.Canceled", err)
+	}
+
+	buf := make([]byte, 100)
+	if n, err := ask.Read(buf, 0); err != io.EOF || n != 0 {
+		t.Errorf("Read() after flushed write = %q, %v; want empty EOF", buf[:n], err)
+	}
+}
+
+func TestAskFile_ReadContext_FlushedWait(t *testing.T) {
+	ask := NewAskFile(NewMockBackend())
+
+	// A prompt is being committed; flushing a read waiting for it returns
+	ask.commits.RLock()
+	defer ask.commits.RUnlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf := make([]byte, 100)
+	if _, err := ask.ReadContext(ctx, buf, 0); err != context.Canceled {
+		t.Errorf("ReadContext() error = %v, want context.Canceled", err)
 	}
 }
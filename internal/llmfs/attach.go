@@ -0,0 +1,266 @@
+package llmfs
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// AttachDir stages files for a conversation's next prompt. A file
+// created in attach/ (cp shot.png attach/) is staged when the fid that
+// wrote it is clunked, its type sniffed from its content; clients that
+// cannot create files write to attach/new instead, which stages the data
+// under a name made from its type. The next prompt written to the
+// conversation's ask or stream/ask takes the staged files, and gets them
+// back if it cannot be sent. Removing a file unstages it.
+type AttachDir struct {
+	*protocol.BaseFile
+	newFile *AttachNewFile
+
+	mu    sync.Mutex
+	files []*AttachFile // in the order created
+	next  int           // numbers the files written to new
+}
+
+var _ protocol.Creator = (*AttachDir)(nil)
+
+// NewAttachDir creates an empty attach directory
+func NewAttachDir() *AttachDir {
+	d := &AttachDir{BaseFile: protocol.NewBaseFile("attach", protocol.DMDIR|0777)}
+	d.newFile = newAttachNewFile(d)
+	return d
+}
+
+func (d *AttachDir) Children() []protocol.File {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	children := []protocol.File{d.newFile}
+	for _, f := range d.files {
+		children = append(children, f)
+	}
+	return children
+}
+
+func (d *AttachDir) Lookup(name string) (protocol.File, error) {
+	if name == "new" {
+		return d.newFile, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f := d.lookupLocked(name); f != nil {
+		return f, nil
+	}
+	return nil, protocol.ErrNotFound
+}
+
+func (d *AttachDir) lookupLocked(name string) *AttachFile {
+	for _, f := range d.files {
+		if f.Stat().Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (d *AttachDir) Read(p []byte, offset int64) (int, error) {
+	return protocol.ReadDir(d.Children(), p, offset)
+}
+
+// Create implements protocol.Creator - adds an empty file, staged once
+// it is written
+func (d *AttachDir) Create(name string, perm uint32, mode uint8) (protocol.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.createLocked(name)
+}
+
+func (d *AttachDir) createLocked(name string) (*AttachFile, error) {
+	if name == "new" || d.lookupLocked(name) != nil {
+		return nil, protocol.ErrExists
+	}
+	if len(d.files) >= llm.MaxAttachments {
+		return nil, fmt.Errorf("at most %d attachments", llm.MaxAttachments)
+	}
+	f := newAttachFile(d, name)
+	d.files = append(d.files, f)
+	return f, nil
+}
+
+// remove unstages f, if it is still staged
+func (d *AttachDir) remove(f *AttachFile) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, staged := range d.files {
+		if staged == f {
+			d.files = append(d.files[:i], d.files[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// take unstages and returns the attachments for a prompt. Files not yet
+// written stay.
+func (d *AttachDir) take() []llm.Attachment {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var attachments []llm.Attachment
+	var unwritten []*AttachFile
+	for _, f := range d.files {
+		if a, ok := f.attachment(); ok {
+			attachments = append(attachments, a)
+		} else {
+			unwritten = append(unwritten, f)
+		}
+	}
+	d.files = unwritten
+	return attachments
+}
+
+// restore stages again the attachments of a prompt that was not sent,
+// except any whose name has been taken since
+func (d *AttachDir) restore(attachments []llm.Attachment) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, a := range attachments {
+		if f, err := d.createLocked(a.Name); err == nil {
+			f.staged = a
+		}
+	}
+}
+
+// attachmentExt names the files written to new by type
+var attachmentExt = map[string]string{
+	llm.MediaPNG:  "png",
+	llm.MediaJPEG: "jpg",
+	llm.MediaGIF:  "gif",
+	llm.MediaWebP: "webp",
+	llm.MediaPDF:  "pdf",
+	llm.MediaText: "txt",
+}
+
+// AttachNewFile stages the data written to it under the next free name
+// N.EXT, EXT given by its type (write-only, committed on clunk over 9P)
+type AttachNewFile struct {
+	*protocol.BaseFile
+	*commitOnClunk
+	dir *AttachDir
+}
+
+func newAttachNewFile(dir *AttachDir) *AttachNewFile {
+	f := &AttachNewFile{
+		BaseFile: protocol.NewBaseFile("new", 0222),
+		dir:      dir,
+	}
+	f.commitOnClunk = &commitOnClunk{file: f, pending: newFidBuffersMax(llm.MaxDocumentSize)}
+	return f
+}
+
+func (f *AttachNewFile) Read(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+func (f *AttachNewFile) Write(p []byte, offset int64) (int, error) {
+	a, err := llm.NewAttachment("new", append([]byte(nil), p...))
+	if err != nil {
+		return 0, err
+	}
+	d := f.dir
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		d.next++
+		a.Name = strconv.Itoa(d.next) + "." + attachmentExt[a.MediaType]
+		if d.lookupLocked(a.Name) == nil {
+			break
+		}
+	}
+	staged, err := d.createLocked(a.Name)
+	if err != nil {
+		return 0, err
+	}
+	staged.staged = a
+	return len(p), nil
+}
+
+// AttachFile is a file in attach/. Its data, written over 9P, replaces
+// what it held when the fid is clunked, and is staged if it is a
+// supported type and size.
+type AttachFile struct {
+	*protocol.BaseFile
+	*commitOnClunk
+	dir *AttachDir
+
+	mu     sync.RWMutex
+	staged llm.Attachment // no data until written
+}
+
+var _ protocol.Remover = (*AttachFile)(nil)
+
+func newAttachFile(dir *AttachDir, name string) *AttachFile {
+	f := &AttachFile{
+		BaseFile: protocol.NewBaseFile(name, 0666),
+		dir:      dir,
+	}
+	f.commitOnClunk = &commitOnClunk{file: f, pending: newFidBuffersMax(llm.MaxDocumentSize)}
+	return f
+}
+
+// attachment returns the staged attachment; false if nothing valid has
+// been written
+func (f *AttachFile) attachment() (llm.Attachment, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.staged, f.staged.Data != nil
+}
+
+func (f *AttachFile) Read(p []byte, offset int64) (int, error) {
+	a, _ := f.attachment()
+	if offset >= int64(len(a.Data)) {
+		return 0, io.EOF
+	}
+	return copy(p, a.Data[offset:]), nil
+}
+
+// Write stages p as the file's content. A file that was never staged is
+// removed when p is not a supported attachment.
+func (f *AttachFile) Write(p []byte, offset int64) (int, error) {
+	a, err := llm.NewAttachment(f.Stat().Name, append([]byte(nil), p...))
+	if err != nil {
+		if _, ok := f.attachment(); !ok {
+			f.dir.remove(f)
+		}
+		return 0, err
+	}
+	f.mu.Lock()
+	f.staged = a
+	f.mu.Unlock()
+	return len(p), nil
+}
+
+func (f *AttachFile) Stat() protocol.Stat {
+	f.mu.RLock()
+	size := len(f.staged.Data)
+	f.mu.RUnlock()
+	s := f.BaseFile.Stat()
+	s.Length = uint64(size)
+	return s
+}
+
+// Remove implements protocol.Remover - unstages the file
+func (f *AttachFile) Remove() error {
+	if !f.dir.remove(f) {
+		return protocol.ErrNotFound
+	}
+	return nil
+}
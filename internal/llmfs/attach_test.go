@@ -0,0 +1,103 @@
+package llmfs
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+const testPDF = "%PDF-1.4\n1 0 obj << /Type /Page >> endobj\n%%EOF\n"
+
+// childNames lists the names in a directory
+func childNames(d protocol.Dir) string {
+	var names []string
+	for _, f := range d.Children() {
+		names = append(names, f.Stat().Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestAttachDir(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "a paper and a list"
+	root := NewRoot(mock)
+	attach := walkTo(t, root, "attach").(*AttachDir)
+
+	// cp paper.pdf attach/
+	created, err := attach.Create("paper.pdf", 0644, protocol.OWRITE)
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	paper := created.(*AttachFile)
+	paper.WriteFid(1, []byte(testPDF[:10]), 0)
+	paper.WriteFid(1, []byte(testPDF[10:]), 10)
+	if err := paper.CloseFid(1); err != nil {
+		t.Fatalf("clunk error: %v", err)
+	}
+	if got := readAll(t, paper); got != testPDF {
+		t.Errorf("read back %q", got)
+	}
+	if _, err := attach.Create("paper.pdf", 0644, protocol.OWRITE); err != protocol.ErrExists {
+		t.Errorf("Create() of a staged name error = %v", err)
+	}
+
+	// Files of an unsupported type are refused, and not left behind
+	bad, _ := attach.Create("core", 0644, protocol.OWRITE)
+	bad.(*AttachFile).WriteFid(2, []byte{0, 1, 2, 0xff}, 0)
+	if err := bad.(*AttachFile).CloseFid(2); err == nil {
+		t.Error("an unsupported file should fail at clunk")
+	}
+
+	// Clients that cannot create write to new
+	if _, err := attach.newFile.Write([]byte("buy milk\n"), 0); err != nil {
+		t.Fatalf("write to new error: %v", err)
+	}
+	extra, _ := attach.Create("extra.txt", 0644, protocol.OWRITE)
+	extra.(*AttachFile).Write([]byte("unwanted"), 0)
+	if err := extra.(protocol.Remover).Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if got := childNames(attach); got != "new paper.pdf 1.txt" {
+		t.Errorf("attach/ = %q", got)
+	}
+
+	// A failed prompt gives the files back
+	mock.askError = io.ErrUnexpectedEOF
+	walkTo(t, root, "ask").Write([]byte("What are these?"), 0)
+	if got := childNames(attach); got != "new paper.pdf 1.txt" {
+		t.Errorf("attach/ after a failed prompt = %q", got)
+	}
+
+	// The next prompt takes them
+	mock.askError = nil
+	walkTo(t, root, "ask").Write([]byte("What are these?"), 0)
+	if got := childNames(attach); got != "new" {
+		t.Errorf("attach/ after the prompt = %q", got)
+	}
+	msgs := mock.Messages()
+	sent := msgs[len(msgs)-2].Attachments
+	if len(sent) != 2 || sent[0].Name != "paper.pdf" || sent[0].MediaType != llm.MediaPDF || sent[1].Name != "1.txt" || string(sent[1].Data) != "buy milk\n" {
+		t.Errorf("attachments sent = %+v", sent)
+	}
+}
+
+func TestAttachDir_Conversation(t *testing.T) {
+	mock := NewMockBackend()
+	root := NewRoot(mock)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+	n := strings.TrimSpace(readFid(t, clone, 1))
+
+	// Each conversation stages its own files
+	conv := walkTo(t, root, n, "attach").(*AttachDir)
+	conv.newFile.Write([]byte("notes"), 0)
+	if got := childNames(walkTo(t, root, "attach").(*AttachDir)); got != "new" {
+		t.Errorf("root attach/ = %q", got)
+	}
+	if got := childNames(conv); got != "new 1.txt" {
+		t.Errorf("%s/attach/ = %q", n, got)
+	}
+}
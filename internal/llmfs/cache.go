@@ -0,0 +1,51 @@
+package llmfs
+
+import (
+	"io"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// CacheFile exposes the prompt caching mode (read/write): on caches the
+// system prompt and the conversation, system only the system prompt
+type CacheFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+}
+
+// NewCacheFile creates the cache file
+func NewCacheFile(client llm.Backend) *CacheFile {
+	return &CacheFile{
+		BaseFile: protocol.NewBaseFile("cache", 0666),
+		client:   client,
+	}
+}
+
+func (f *CacheFile) content() string {
+	return f.client.Cache() + "\n"
+}
+
+func (f *CacheFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *CacheFile) Write(p []byte, offset int64) (int, error) {
+	mode := strings.ToLower(strings.TrimSpace(string(p)))
+	if err := f.client.SetCache(mode); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *CacheFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
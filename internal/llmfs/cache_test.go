@@ -0,0 +1,21 @@
+package llmfs
+
+import "testing"
+
+func TestCacheFile(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewCacheFile(mock)
+
+	if got := readAll(t, f); got != "on\n" {
+		t.Errorf("cache = %q, want on", got)
+	}
+	if _, err := f.Write([]byte("System\n"), 0); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if got := readAll(t, f); got != "system\n" || f.Stat().Length != 7 {
+		t.Errorf("cache = %q (length %d), want system", got, f.Stat().Length)
+	}
+	if _, err := f.Write([]byte("sometimes"), 0); err == nil {
+		t.Error("Write() should reject an unknown mode")
+	}
+}
@@ -0,0 +1,506 @@
+package llmfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// conversations tracks the numbered conversation directories created
+// through clone, in the manner of Plan 9's /net/tcp/clone. Each
+// conversation is a session in a SessionManager; the shared backend
+// supplies the settings (model, temperature, system prompt).
+//
+// A conversation lives while any fid has its clone or ctl file open.
+// When the last one is clunked it is removed, unless it was marked
+// persistent; "hangup" removes it at once either way.
+type conversations struct {
+	sessions *llm.SessionManager
+	prices   llm.PriceTable // for the cost files
+	mu       sync.Mutex
+	next     uint32
+	convs    map[uint32]*conversation
+	opens    map[uint32]*conversation // fid -> conversation it holds open
+}
+
+// conversation is one numbered directory
+type conversation struct {
+	id         uint32
+	dir        *protocol.StaticDir
+	client     *llm.SessionClient
+	ask        *AskFile
+	refs       int
+	persistent bool
+}
+
+func newConversations(sessions *llm.SessionManager, prices llm.PriceTable) *conversations {
+	return &conversations{
+		sessions: sessions,
+		prices:   prices,
+		convs:    make(map[uint32]*conversation),
+		opens:    make(map[uint32]*conversation),
+	}
+}
+
+// create allocates a new conversation and its directory
+func (cs *conversations) create() *conversation {
+	cs.mu.Lock()
+	id := cs.next
+	cs.next++
+	cs.mu.Unlock()
+	return cs.add(id)
+}
+
+// add makes the directory of conversation id
+func (cs *conversations) add(id uint32) *conversation {
+	c := &conversation{
+		id:     id,
+		client: cs.sessions.Client(id),
+	}
+	c.dir = newConversationDir(cs, c)
+
+	cs.mu.Lock()
+	cs.convs[id] = c
+	cs.mu.Unlock()
+	return c
+}
+
+// restore brings back the conversations kept in the session store. No
+// fid holds them, so they are persistent until hung up, and new
+// conversations are numbered after them.
+func (cs *conversations) restore() error {
+	ids, err := cs.sessions.Restore()
+	for _, id := range ids {
+		c := cs.add(id)
+		cs.mu.Lock()
+		c.persistent = true
+		if id >= cs.next {
+			cs.next = id + 1
+		}
+		cs.mu.Unlock()
+	}
+	return err
+}
+
+// lookup returns the conversation directory named name
+func (cs *conversations) lookup(name string) (*conversation, bool) {
+	id, err := strconv.ParseUint(name, 10, 32)
+	if err != nil || strconv.FormatUint(id, 10) != name {
+		return nil, false
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c, ok := cs.convs[uint32(id)]
+	return c, ok
+}
+
+// dirs returns the live conversation directories in numeric order
+func (cs *conversations) dirs() []protocol.File {
+	cs.mu.Lock()
+	ids := make([]uint32, 0, len(cs.convs))
+	for id := range cs.convs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	result := make([]protocol.File, len(ids))
+	for i, id := range ids {
+		result[i] = cs.convs[id].dir
+	}
+	cs.mu.Unlock()
+	return result
+}
+
+// hold records that fid has c open
+func (cs *conversations) hold(fid uint32, c *conversation) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.opens[fid] = c
+	c.refs++
+}
+
+// held returns the conversation fid has open, if any
+func (cs *conversations) held(fid uint32) (*conversation, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c, ok := cs.opens[fid]
+	return c, ok
+}
+
+// release drops fid's hold, removing the conversation on the last one
+func (cs *conversations) release(fid uint32) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c, ok := cs.opens[fid]
+	if !ok {
+		return
+	}
+	delete(cs.opens, fid)
+	c.refs--
+	if c.refs <= 0 && !c.persistent {
+		cs.removeLocked(c)
+	}
+}
+
+func (cs *conversations) removeLocked(c *conversation) {
+	if cs.convs[c.id] != c {
+		return // already hung up
+	}
+	delete(cs.convs, c.id)
+	cs.sessions.Remove(c.id)
+}
+
+// ctl executes control commands, one per line:
+//
+//	hangup    remove the conversation now
+//	persist   keep the conversation after its last clunk
+//	transient remove the conversation on its last clunk (the default)
+//
+// and the history commands listed on conversations.history.
+func (cs *conversations) ctl(ctx context.Context, c *conversation, p []byte) error {
+	return runCtl(p, ctlArgs, func(cmd string, args []string) error {
+		switch cmd {
+		case "hangup":
+			cs.mu.Lock()
+			cs.removeLocked(c)
+			cs.mu.Unlock()
+		case "persist":
+			cs.mu.Lock()
+			c.persistent = true
+			cs.mu.Unlock()
+		case "transient":
+			cs.mu.Lock()
+			c.persistent = false
+			cs.mu.Unlock()
+		default:
+			return cs.history(ctx, c.client, c.ask, cmd, args)
+		}
+		return nil
+	})
+}
+
+// history executes a history command on the conversation of client,
+// whose responses are read from ask:
+//
+//	reset     clear the conversation history
+//	fork N    replace the history with a copy of conversation N's
+//	          (or the shared conversation's, for N = shared)
+//	rewind N  keep only the first N messages of the history
+//	pop       drop the last exchange: the last prompt and its response
+//	retry     drop the last exchange and ask its prompt again
+func (cs *conversations) history(ctx context.Context, client llm.Backend, ask *AskFile, cmd string, args []string) error {
+	switch cmd {
+	case "reset":
+		client.Reset()
+	case "fork":
+		src, err := cs.source(args[0])
+		if err != nil {
+			return err
+		}
+		llm.Fork(client, src)
+	case "rewind":
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("rewind: invalid message count %q", args[0])
+		}
+		return llm.Rewind(client, n)
+	case "pop":
+		return llm.Pop(client)
+	case "retry":
+		return ask.retry(ctx)
+	}
+	return nil
+}
+
+// historyArgs names the arguments of each history command, and ctlArgs
+// those of each command of /N/ctl
+var (
+	historyArgs = map[string][]string{
+		"reset":  nil,
+		"fork":   {"N"},
+		"rewind": {"N"},
+		"pop":    nil,
+		"retry":  nil,
+	}
+	ctlArgs = map[string][]string{
+		"hangup":    nil,
+		"persist":   nil,
+		"transient": nil,
+		"reset":     nil,
+		"fork":      {"N"},
+		"rewind":    {"N"},
+		"pop":       nil,
+		"retry":     nil,
+	}
+)
+
+// runCtl checks each line of p against the commands of want and calls
+// do with it, stopping at the first error
+func runCtl(p []byte, want map[string][]string, do func(cmd string, args []string) error) error {
+	for _, line := range strings.Split(string(p), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		names, ok := want[cmd]
+		if !ok {
+			return fmt.Errorf("unknown ctl command: %s", strings.TrimSpace(line))
+		}
+		if len(args) != len(names) {
+			return fmt.Errorf("usage: %s", strings.Join(append([]string{cmd}, names...), " "))
+		}
+		if err := do(cmd, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// source returns the conversation named by a fork: a number, or shared
+func (cs *conversations) source(name string) (llm.Backend, error) {
+	if name == sharedConversation {
+		return cs.sessions.Backend(), nil
+	}
+	if c, ok := cs.lookup(name); ok {
+		return c.client, nil
+	}
+	return nil, fmt.Errorf("fork: no conversation %s", name)
+}
+
+// newConversationDir builds the /N/ directory of a conversation
+func newConversationDir(cs *conversations, c *conversation) *protocol.StaticDir {
+	dir := protocol.NewStaticDir(strconv.FormatUint(uint64(c.id), 10))
+	attach := NewAttachDir()
+	c.ask = NewAskFile(c.client)
+	c.ask.attach = attach
+	dir.AddChild(c.ask)
+	dir.AddChild(attach)
+	dir.AddChild(newCtlFile(cs, c))
+	dir.AddChild(NewContextFile(c.client))
+	dir.AddChild(NewMessagesDir(c.client))
+	dir.AddChild(NewExportDir(c.client))
+	dir.AddChild(NewImportFile(c.client))
+	dir.AddChild(NewReasoningFile(c.client))
+	dir.AddChild(NewMetaFile(c.client))
+	dir.AddChild(NewTokensFile(c.client))
+	dir.AddChild(NewUsageFile(c.client))
+	dir.AddChild(NewTokenizeFile(c.client))
+	dir.AddChild(NewCostFile(c.client, cs.prices))
+	dir.AddChild(NewCompactFile(c.client))
+
+	streamDir := protocol.NewStaticDir("stream")
+	streamAsk := NewStreamAskFile(c.client)
+	streamAsk.attach = attach
+	streamDir.AddChild(streamAsk)
+	chunk := NewChunkFile(c.client)
+	chunk.stream = streamAsk
+	streamDir.AddChild(chunk)
+	streamDir.AddChild(NewStreamThinkingFile(c.client))
+	dir.AddChild(streamDir)
+	return dir
+}
+
+// readNumber serves a conversation number the way Plan 9 ctl files do
+func readNumber(c *conversation, p []byte, offset int64) (int, error) {
+	content := fmt.Sprintf("%d\n", c.id)
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+// CloneFile allocates a new conversation each time it is opened.
+// Reading the open fid returns the conversation number N, writing to it
+// sends ctl commands to /N/ctl, and the fid keeps /N/ alive until clunked.
+type CloneFile struct {
+	*protocol.BaseFile
+	convs *conversations
+}
+
+var (
+	_ protocol.ContextFidAwareFile = (*CloneFile)(nil)
+	_ protocol.FidOpener           = (*CloneFile)(nil)
+)
+
+func newCloneFile(convs *conversations) *CloneFile {
+	return &CloneFile{
+		BaseFile: protocol.NewBaseFile("clone", 0666),
+		convs:    convs,
+	}
+}
+
+// OpenFid implements protocol.FidOpener - allocates the conversation
+func (f *CloneFile) OpenFid(fid uint32, mode uint8) error {
+	f.convs.hold(fid, f.convs.create())
+	return nil
+}
+
+// ReadFid implements protocol.FidAwareFile - returns the conversation number
+func (f *CloneFile) ReadFid(fid uint32, p []byte, offset int64) (int, error) {
+	c, ok := f.convs.held(fid)
+	if !ok {
+		return 0, io.EOF
+	}
+	return readNumber(c, p, offset)
+}
+
+// WriteFid implements protocol.FidAwareFile - ctl commands for the conversation
+func (f *CloneFile) WriteFid(fid uint32, p []byte, offset int64) (int, error) {
+	return f.WriteFidContext(context.Background(), fid, p, offset)
+}
+
+// CloseFid implements protocol.FidAwareFile
+func (f *CloneFile) CloseFid(fid uint32) error {
+	f.convs.release(fid)
+	return nil
+}
+
+// ReadFidContext implements protocol.ContextFidAwareFile
+func (f *CloneFile) ReadFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return f.ReadFid(fid, p, offset)
+}
+
+// WriteFidContext implements protocol.ContextFidAwareFile; a flush
+// cancels a retry in progress
+func (f *CloneFile) WriteFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	c, ok := f.convs.held(fid)
+	if !ok {
+		return 0, protocol.ErrPermission
+	}
+	if err := f.convs.ctl(ctx, c, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CloseFidContext implements protocol.ContextFidAwareFile
+func (f *CloneFile) CloseFidContext(ctx context.Context, fid uint32) error {
+	return f.CloseFid(fid)
+}
+
+// CtlFile is a conversation's ctl file. Reading returns the conversation
+// number; writing accepts the commands listed on conversations.ctl.
+// An open ctl fid keeps the conversation alive, like an open clone fid.
+type CtlFile struct {
+	*protocol.BaseFile
+	convs *conversations
+	conv  *conversation
+}
+
+var (
+	_ protocol.ContextFidAwareFile = (*CtlFile)(nil)
+	_ protocol.FidOpener           = (*CtlFile)(nil)
+)
+
+func newCtlFile(convs *conversations, c *conversation) *CtlFile {
+	return &CtlFile{
+		BaseFile: protocol.NewBaseFile("ctl", 0666),
+		convs:    convs,
+		conv:     c,
+	}
+}
+
+// OpenFid implements protocol.FidOpener
+func (f *CtlFile) OpenFid(fid uint32, mode uint8) error {
+	f.convs.hold(fid, f.conv)
+	return nil
+}
+
+func (f *CtlFile) Read(p []byte, offset int64) (int, error) {
+	return readNumber(f.conv, p, offset)
+}
+
+func (f *CtlFile) Write(p []byte, offset int64) (int, error) {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// WriteContext executes ctl commands; a flush cancels a retry in progress
+func (f *CtlFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	if err := f.convs.ctl(ctx, f.conv, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFid implements protocol.FidAwareFile
+func (f *CtlFile) ReadFid(fid uint32, p []byte, offset int64) (int, error) {
+	return f.Read(p, offset)
+}
+
+// WriteFid implements protocol.FidAwareFile
+func (f *CtlFile) WriteFid(fid uint32, p []byte, offset int64) (int, error) {
+	return f.Write(p, offset)
+}
+
+// CloseFid implements protocol.FidAwareFile
+func (f *CtlFile) CloseFid(fid uint32) error {
+	f.convs.release(fid)
+	return nil
+}
+
+// ReadFidContext implements protocol.ContextFidAwareFile
+func (f *CtlFile) ReadFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return f.Read(p, offset)
+}
+
+// WriteFidContext implements protocol.ContextFidAwareFile
+func (f *CtlFile) WriteFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return f.WriteContext(ctx, p, offset)
+}
+
+// CloseFidContext implements protocol.ContextFidAwareFile
+func (f *CtlFile) CloseFidContext(ctx context.Context, fid uint32) error {
+	return f.CloseFid(fid)
+}
+
+// SharedCtlFile is the root ctl file. Writing accepts the history
+// commands listed on conversations.history, applied to the shared
+// conversation on /ask; it cannot be read.
+type SharedCtlFile struct {
+	*protocol.BaseFile
+	convs *conversations
+	ask   *AskFile
+}
+
+var _ protocol.ContextAwareFile = (*SharedCtlFile)(nil)
+
+func newSharedCtlFile(convs *conversations, ask *AskFile) *SharedCtlFile {
+	return &SharedCtlFile{
+		BaseFile: protocol.NewBaseFile("ctl", 0222),
+		convs:    convs,
+		ask:      ask,
+	}
+}
+
+func (f *SharedCtlFile) Read(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+func (f *SharedCtlFile) Write(p []byte, offset int64) (int, error) {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// ReadContext implements protocol.ContextAwareFile
+func (f *SharedCtlFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	return f.Read(p, offset)
+}
+
+// WriteContext executes history commands; a flush cancels a retry in
+// progress
+func (f *SharedCtlFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	err := runCtl(p, historyArgs, func(cmd string, args []string) error {
+		return f.convs.history(ctx, f.ask.client, f.ask, cmd, args)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
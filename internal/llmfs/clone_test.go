@@ -0,0 +1,276 @@
+package llmfs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// readFid reads a file from offset 0 until EOF via the fid-aware path
+func readFid(t *testing.T, f protocol.FidAwareFile, fid uint32) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	var out []byte
+	for {
+		n, err := f.ReadFid(fid, buf, int64(len(out)))
+		out = append(out, buf[:n]...)
+		if err == io.EOF || n == 0 {
+			return string(out)
+		}
+		if err != nil {
+			t.Fatalf("ReadFid() error: %v", err)
+		}
+	}
+}
+
+func walkTo(t *testing.T, dir protocol.Dir, names ...string) protocol.File {
+	t.Helper()
+	var f protocol.File = dir
+	for _, name := range names {
+		d, ok := f.(protocol.Dir)
+		if !ok {
+			t.Fatalf("%s: not a directory", name)
+		}
+		var err error
+		if f, err = d.Lookup(name); err != nil {
+			t.Fatalf("Lookup(%q) error: %v", name, err)
+		}
+	}
+	return f
+}
+
+func TestClone_AllocatesConversations(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "pong"
+	root := NewRoot(mock)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+
+	clone.OpenFid(1, protocol.ORDWR)
+	clone.OpenFid(2, protocol.ORDWR)
+	if got := readFid(t, clone, 1); got != "0\n" {
+		t.Errorf("first clone read = %q, want %q", got, "0\n")
+	}
+	if got := readFid(t, clone, 2); got != "1\n" {
+		t.Errorf("second clone read = %q, want %q", got, "1\n")
+	}
+
+	// Each conversation has its own history
+	ask0 := walkTo(t, root, "0", "ask")
+	ask0.Write([]byte("ping"), 0)
+	var ctx0, ctx1 [4096]byte
+	n0, _ := walkTo(t, root, "0", "context").Read(ctx0[:], 0)
+	n1, _ := walkTo(t, root, "1", "context").Read(ctx1[:], 0)
+	if !strings.Contains(string(ctx0[:n0]), "ping") {
+		t.Errorf("conversation 0 context missing prompt: %s", ctx0[:n0])
+	}
+	if strings.Contains(string(ctx1[:n1]), "ping") {
+		t.Errorf("conversation 1 context leaked prompt: %s", ctx1[:n1])
+	}
+	if len(mock.messages) != 0 {
+		t.Error("conversation asks should not touch the shared conversation")
+	}
+
+	// Conversations are listed in the root
+	var names []string
+	for _, f := range root.Children() {
+		names = append(names, f.Stat().Name)
+	}
+	if got := strings.Join(names, " "); !strings.HasSuffix(got, " 0 1") {
+		t.Errorf("root children = %q, want conversations 0 and 1 last", got)
+	}
+
+	// The last clunk removes the directory
+	clone.CloseFid(1)
+	if _, err := root.Lookup("0"); err == nil {
+		t.Error("conversation 0 should vanish after its last clunk")
+	}
+	if _, err := root.Lookup("1"); err != nil {
+		t.Error("conversation 1 should survive while its clone fid is open")
+	}
+}
+
+func TestClone_CtlHoldsConversation(t *testing.T) {
+	root := NewRoot(NewMockBackend())
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+
+	ctl := walkTo(t, root, "0", "ctl").(*CtlFile)
+	ctl.OpenFid(2, protocol.OREAD)
+	if got := readFid(t, ctl, 2); got != "0\n" {
+		t.Errorf("ctl read = %q, want %q", got, "0\n")
+	}
+
+	clone.CloseFid(1)
+	if _, err := root.Lookup("0"); err != nil {
+		t.Fatal("an open ctl fid should keep the conversation")
+	}
+	ctl.CloseFid(2)
+	if _, err := root.Lookup("0"); err == nil {
+		t.Error("conversation should vanish after the ctl fid is clunked")
+	}
+}
+
+func TestClone_PersistAndHangup(t *testing.T) {
+	root := NewRoot(NewMockBackend())
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+
+	if _, err := clone.WriteFid(1, []byte("persist\n"), 0); err != nil {
+		t.Fatalf("persist error: %v", err)
+	}
+	clone.CloseFid(1)
+	if _, err := root.Lookup("0"); err != nil {
+		t.Fatal("persistent conversation should survive its last clunk")
+	}
+
+	ctl := walkTo(t, root, "0", "ctl")
+	if _, err := ctl.Write([]byte("bogus"), 0); err == nil {
+		t.Error("unknown ctl command should fail")
+	}
+	if _, err := ctl.Write([]byte("hangup\n"), 0); err != nil {
+		t.Fatalf("hangup error: %v", err)
+	}
+	if _, err := root.Lookup("0"); err == nil {
+		t.Error("hangup should remove the conversation")
+	}
+}
+
+func TestClone_Stream(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "streamed"
+	root := NewRoot(mock)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+
+	if _, err := walkTo(t, root, "0", "stream", "ask").Write([]byte("go"), 0); err != nil {
+		t.Fatalf("stream ask error: %v", err)
+	}
+	buf := make([]byte, 100)
+	n, err := walkTo(t, root, "0", "stream", "chunk").Read(buf, 0)
+	if err != nil || string(buf[:n]) != "streamed" {
+		t.Errorf("chunk read = %q, %v; want %q", buf[:n], err, "streamed")
+	}
+}
+
+func TestClone_HistoryCommands(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "pong"
+	root := NewRoot(mock)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+	ask := walkTo(t, root, "0", "ask")
+	for _, prompt := range []string{"one", "two", "three"} {
+		ask.Write([]byte(prompt), 0)
+	}
+	conv := func(id string) []llm.Message {
+		c, _ := root.(*rootDir).convs.lookup(id)
+		return c.client.Messages()
+	}
+
+	// fork copies into a fresh conversation
+	clone.OpenFid(2, protocol.ORDWR)
+	if _, err := clone.WriteFid(2, []byte("fork 0\n"), 0); err != nil {
+		t.Fatalf("fork error: %v", err)
+	}
+	if got := len(conv("1")); got != 6 {
+		t.Errorf("forked conversation has %d messages, want 6", got)
+	}
+
+	ctl := walkTo(t, root, "1", "ctl")
+	if _, err := ctl.Write([]byte("pop"), 0); err != nil {
+		t.Fatalf("pop error: %v", err)
+	}
+	if msgs := conv("1"); len(msgs) != 4 || msgs[3].Content != "pong" {
+		t.Errorf("after pop: %v", msgs)
+	}
+	if _, err := ctl.Write([]byte("rewind 1"), 0); err != nil {
+		t.Fatalf("rewind error: %v", err)
+	}
+	if msgs := conv("1"); len(msgs) != 1 || msgs[0].Content != "one" {
+		t.Errorf("after rewind 1: %v", msgs)
+	}
+	if len(conv("0")) != 6 {
+		t.Error("editing the fork changed the original")
+	}
+
+	// retry asks the last prompt again
+	mock.askResponse = "PONG"
+	if _, err := walkTo(t, root, "0", "ctl").Write([]byte("retry"), 0); err != nil {
+		t.Fatalf("retry error: %v", err)
+	}
+	if msgs := conv("0"); len(msgs) != 6 || msgs[4].Content != "three" || msgs[5].Content != "PONG" {
+		t.Errorf("after retry: %v", msgs)
+	}
+	if got := readAll(t, ask); got != "PONG\n" {
+		t.Errorf("ask after retry = %q", got)
+	}
+	mock.askError = errors.New("overloaded")
+	if _, err := walkTo(t, root, "0", "ctl").Write([]byte("retry"), 0); err == nil {
+		t.Error("a failed retry should fail")
+	}
+	mock.askError = nil
+	if got := readAll(t, ask); got != "Error: overloaded\n" {
+		t.Errorf("ask after a failed retry = %q", got)
+	}
+
+	for _, cmd := range []string{"rewind 99", "rewind x", "fork", "fork 42", "pop now"} {
+		if _, err := ctl.Write([]byte(cmd), 0); err == nil {
+			t.Errorf("%q should fail", cmd)
+		}
+	}
+	if _, err := ctl.Write([]byte("rewind 0\npop"), 0); err == nil {
+		t.Error("pop on an empty history should fail")
+	}
+}
+
+func TestSharedCtl(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "pong"
+	root := NewRoot(mock)
+	ask := walkTo(t, root, "ask")
+	for _, prompt := range []string{"one", "two"} {
+		ask.Write([]byte(prompt), 0)
+	}
+
+	ctl := walkTo(t, root, "ctl")
+	mock.askResponse = "PONG"
+	if _, err := ctl.Write([]byte("retry"), 0); err != nil {
+		t.Fatalf("retry error: %v", err)
+	}
+	if msgs := mock.Messages(); len(msgs) != 4 || msgs[3].Content != "PONG" {
+		t.Errorf("after retry: %v", msgs)
+	}
+	if got := readAll(t, ask); got != "PONG\n" {
+		t.Errorf("ask after retry = %q", got)
+	}
+	if _, err := ctl.Write([]byte("pop\nrewind 1"), 0); err != nil {
+		t.Fatalf("pop error: %v", err)
+	}
+	if msgs := mock.Messages(); len(msgs) != 1 || msgs[0].Content != "one" {
+		t.Errorf("after pop and rewind: %v", msgs)
+	}
+
+	// fork brings in a numbered conversation's history
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+	walkTo(t, root, "0", "ask").Write([]byte("three"), 0)
+	if _, err := ctl.Write([]byte("fork 0"), 0); err != nil {
+		t.Fatalf("fork error: %v", err)
+	}
+	if msgs := mock.Messages(); len(msgs) != 2 || msgs[0].Content != "three" {
+		t.Errorf("after fork: %v", msgs)
+	}
+
+	for _, cmd := range []string{"hangup", "persist", "rewind 99", "pop now"} {
+		if _, err := ctl.Write([]byte(cmd), 0); err == nil {
+			t.Errorf("%q should fail", cmd)
+		}
+	}
+	if _, err := ctl.Read(make([]byte, 10), 0); err == nil {
+		t.Error("ctl should not be readable")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -11,9 +12,21 @@ import (
 	"github.com/NERVsystems/llm9p/internal/protocol"
 )
 
-// CompactFile allows manual compaction trigger
-// Write anything to trigger compaction
-// Read returns status ("ok" or "error: ...")
+// CompactFile controls compaction (read/write). Reading returns the
+// result of the last compaction ("ready", "ok: tokens/limit" or
+// "error: ...") followed by the policy, one "key value" line per
+// setting. Writes are lines of:
+//
+//	summary N      summarize older turns, keeping the last N verbatim
+//	window N       drop all but the last N turns
+//	truncate N     drop the oldest turns until the history fits N tokens
+//	keep N         turns kept verbatim (summary, window, truncate)
+//	threshold F    compact before a prompt once the conversation fills
+//	               this fraction of the context window (off = never)
+//	model NAME     model that writes summaries (default = the conversation's)
+//
+// Anything else, such as "1" or "now", compacts the conversation now,
+// after any settings on earlier lines.
 type CompactFile struct {
 	*protocol.BaseFile
 	client     llm.Backend
@@ -30,11 +43,26 @@ func NewCompactFile(client llm.Backend) *CompactFile {
 	}
 }
 
-func (f *CompactFile) Read(p []byte, offset int64) (int, error) {
+func (f *CompactFile) content() string {
 	f.mu.RLock()
-	content := f.lastResult
+	result := f.lastResult
 	f.mu.RUnlock()
 
+	p := f.client.CompactPolicy()
+	threshold := "off"
+	if p.Threshold > 0 {
+		threshold = strconv.FormatFloat(p.Threshold, 'g', -1, 64)
+	}
+	model := p.Model
+	if model == "" {
+		model = "default"
+	}
+	return fmt.Sprintf("%sstrategy %s\nkeep %d\nbudget %d\nthreshold %s\nmodel %s\n",
+		result, p.Strategy, p.Keep, p.Budget, threshold, model)
+}
+
+func (f *CompactFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
 	if offset >= int64(len(content)) {
 		return 0, io.EOF
 	}
@@ -43,13 +71,45 @@ func (f *CompactFile) Read(p []byte, offset int64) (int, error) {
 }
 
 func (f *CompactFile) Write(p []byte, offset int64) (int, error) {
-	cmd := strings.TrimSpace(string(p))
-	if cmd == "" {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// ReadContext implements protocol.ContextAwareFile
+func (f *CompactFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	return f.Read(p, offset)
+}
+
+// WriteContext implements protocol.ContextAwareFile
+func (f *CompactFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	policy := f.client.CompactPolicy()
+	compactNow := false
+	for _, line := range strings.Split(string(p), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !isCompactSetting(fields[0]) {
+			compactNow = true
+			continue
+		}
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("usage: %s N", fields[0])
+		}
+		if err := setCompactOption(&policy, fields[0], fields[1]); err != nil {
+			return 0, err
+		}
+	}
+	if policy != f.client.CompactPolicy() {
+		if err := f.client.SetCompactPolicy(policy); err != nil {
+			return 0, err
+		}
+	}
+	if !compactNow {
 		return len(p), nil
 	}
 
 	// Trigger compaction
-	err := f.client.Compact(context.Background())
+	err := f.client.Compact(ctx)
 
 	f.mu.Lock()
 	if err != nil {
@@ -64,10 +124,56 @@ func (f *CompactFile) Write(p []byte, offset int64) (int, error) {
 	return len(p), nil
 }
 
+// isCompactSetting reports whether word starts a compact setting line
+func isCompactSetting(word string) bool {
+	switch word {
+	case llm.CompactSummary, llm.CompactWindow, llm.CompactTruncate, "keep", "budget", "threshold", "model":
+		return true
+	}
+	return false
+}
+
+// setCompactOption applies one compact setting to policy
+func setCompactOption(policy *llm.CompactPolicy, key, value string) error {
+	switch key {
+	case "model":
+		if value == "default" {
+			value = ""
+		}
+		policy.Model = value
+		return nil
+	case "threshold":
+		if value == "off" {
+			policy.Threshold = 0
+			return nil
+		}
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid threshold: %w", err)
+		}
+		policy.Threshold = t
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	switch key {
+	case llm.CompactSummary, llm.CompactWindow:
+		policy.Strategy, policy.Keep = key, n
+	case llm.CompactTruncate:
+		policy.Strategy, policy.Budget = key, n
+	case "keep":
+		policy.Keep = n
+	case "budget":
+		policy.Budget = n
+	}
+	return nil
+}
+
 func (f *CompactFile) Stat() protocol.Stat {
 	s := f.BaseFile.Stat()
-	f.mu.RLock()
-	s.Length = uint64(len(f.lastResult))
-	f.mu.RUnlock()
+	s.Length = uint64(len(f.content()))
 	return s
 }
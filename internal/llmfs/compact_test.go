@@ -5,13 +5,15 @@ import (
 	"io"
 	"strings"
 	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
 )
 
 func TestCompactFile_Read_Initial(t *testing.T) {
 	mock := NewMockBackend()
 	compact := NewCompactFile(mock)
 
-	// Initial read should return "ready"
+	// Initial read should return "ready" and the default policy
 	buf := make([]byte, 100)
 	n, err := compact.Read(buf, 0)
 	if err != nil {
@@ -19,7 +21,7 @@ func TestCompactFile_Read_Initial(t *testing.T) {
 	}
 
 	content := string(buf[:n])
-	expected := "ready\n"
+	expected := "ready\nstrategy summary\nkeep 0\nbudget 0\nthreshold 0.8\nmodel default\n"
 	if content != expected {
 		t.Errorf("Read() = %q, want %q", content, expected)
 	}
@@ -140,8 +142,8 @@ func TestCompactFile_Stat(t *testing.T) {
 
 	stat := compact.Stat()
 
-	// Initial content is "ready\n" = 6 chars
-	expected := uint64(6)
+	// Initial content is "ready\n" and the default policy
+	expected := uint64(len("ready\nstrategy summary\nkeep 0\nbudget 0\nthreshold 0.8\nmodel default\n"))
 	if stat.Length != expected {
 		t.Errorf("Stat().Length = %d, want %d", stat.Length, expected)
 	}
@@ -179,3 +181,36 @@ func TestCompactFile_MultipleCompactions(t *testing.T) {
 		t.Errorf("Read() = %q, should contain reduced token count", content)
 	}
 }
+
+func TestCompactFile_Policy(t *testing.T) {
+	mock := NewMockBackend()
+	compact := NewCompactFile(mock)
+
+	// Settings change the policy without compacting
+	if _, err := compact.Write([]byte("window 6\nthreshold 0.5\nmodel claude-haiku-4-5\n"), 0); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if mock.compactCalled {
+		t.Error("settings alone should not compact")
+	}
+	want := llm.CompactPolicy{Strategy: llm.CompactWindow, Keep: 6, Threshold: 0.5, Model: "claude-haiku-4-5"}
+	if got := mock.CompactPolicy(); got != want {
+		t.Errorf("policy = %+v, want %+v", got, want)
+	}
+
+	// A setting followed by any other word compacts with it
+	compact.Write([]byte("summary 2\nnow"), 0)
+	if !mock.compactCalled || mock.CompactPolicy().Strategy != llm.CompactSummary {
+		t.Errorf("compacted %v with %+v", mock.compactCalled, mock.CompactPolicy())
+	}
+	compact.Write([]byte("threshold off\nmodel default"), 0)
+	if p := mock.CompactPolicy(); p.Threshold != 0 || p.Model != "" {
+		t.Errorf("policy = %+v", p)
+	}
+
+	for _, bad := range []string{"truncate 0", "keep x", "window", "threshold 2", "keep -1"} {
+		if _, err := compact.Write([]byte(bad), 0); err == nil {
+			t.Errorf("Write(%q) should fail", bad)
+		}
+	}
+}
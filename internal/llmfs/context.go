@@ -1,6 +1,8 @@
 package llmfs
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"strings"
 	"sync"
@@ -9,21 +11,33 @@ import (
 	"github.com/NERVsystems/llm9p/internal/protocol"
 )
 
+// turnsHeader is the first line of a write to context that appends turns
+const turnsHeader = "turns"
+
 // ContextFile exposes the conversation history.
 // Read: returns JSON of conversation history
-// Write: appends a system message to the context
+// Write (committed on clunk over 9P): a JSON array of messages, such as
+// one read from the file, replaces the history; after a first line
+// "turns", JSON message lines or "user:"/"assistant:" blocks are
+// appended to it; other text is added as a system message, whatever
+// its lines start with. Text starting with "[{" (spaces aside) is taken
+// for an array of messages, and refused if it is not valid JSON. The
+// backend checks the resulting history.
 type ContextFile struct {
 	*protocol.BaseFile
+	*commitOnClunk
 	client llm.Backend
 	mu     sync.RWMutex
 }
 
 // NewContextFile creates the context file
 func NewContextFile(client llm.Backend) *ContextFile {
-	return &ContextFile{
+	f := &ContextFile{
 		BaseFile: protocol.NewBaseFile("context", 0666),
 		client:   client,
 	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
 }
 
 // Read implements File.Read
@@ -45,13 +59,40 @@ func (f *ContextFile) Read(p []byte, offset int64) (int, error) {
 	return n, nil
 }
 
-// Write implements File.Write - appends a system message to context
+// Write implements File.Write - imports messages or adds a system message
 func (f *ContextFile) Write(p []byte, offset int64) (int, error) {
-	msg := strings.TrimSpace(string(p))
-	if msg != "" {
-		f.mu.Lock()
-		f.client.AddSystemMessage(msg)
-		f.mu.Unlock()
+	text := strings.TrimSpace(string(p))
+	if text == "" {
+		return len(p), nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var history []llm.Message
+	header, turns, _ := strings.Cut(text, "\n")
+	switch {
+	case strings.HasPrefix(text, "["):
+		err := json.Unmarshal([]byte(text), &history)
+		if err != nil && strings.HasPrefix(strings.TrimSpace(text[1:]), "{") {
+			return 0, fmt.Errorf("context: invalid message array: %w", err)
+		}
+		if err != nil {
+			f.client.AddSystemMessage(text)
+			break
+		}
+		if err := llm.Import(f.client, history, true); err != nil {
+			return 0, err
+		}
+	case strings.TrimSpace(header) == turnsHeader:
+		msgs, err := llm.ParseTranscript(turns)
+		if err != nil {
+			return 0, fmt.Errorf("context: %w", err)
+		}
+		if err := llm.Import(f.client, msgs, false); err != nil {
+			return 0, err
+		}
+	default:
+		f.client.AddSystemMessage(text)
 	}
 	return len(p), nil
 }
@@ -0,0 +1,73 @@
+package llmfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+)
+
+func TestContextFile_Write(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewContextFile(mock)
+
+	if _, err := f.Write([]byte("Be terse."), 0); err != nil {
+		t.Fatalf("system message: %v", err)
+	}
+	few := "turns\nuser: Capital of France?\nassistant: Paris\nuser: Of Italy?\nassistant: Rome\n"
+	if _, err := f.Write([]byte(few), 0); err != nil {
+		t.Fatalf("transcript: %v", err)
+	}
+	if len(mock.messages) != 5 || mock.messages[0].Role != "system" || mock.messages[4].Content != "Rome" {
+		t.Errorf("after transcript: %v", mock.messages)
+	}
+
+	// Without the header, text that looks like turns is a system message
+	mock.messages = nil
+	prompt := "user: a customer\nassistant: you, a support agent"
+	if _, err := f.Write([]byte(prompt), 0); err != nil {
+		t.Fatalf("system message of role lines: %v", err)
+	}
+	if len(mock.messages) != 1 || mock.messages[0].Role != "system" || mock.messages[0].Content != prompt {
+		t.Errorf("role lines without the header: %v", mock.messages)
+	}
+	if _, err := f.Write([]byte("turns\nno role here"), 0); err == nil {
+		t.Error("turns without a role should be refused")
+	}
+	f.Write([]byte(few), 0)
+
+	// A transcript that breaks the backend's alternation is refused
+	if _, err := f.Write([]byte("turns\n"+`{"role":"user","content":"Of Spain?"}`), 0); err == nil {
+		t.Error("history ending with the user should be refused")
+	}
+	if len(mock.messages) != 5 {
+		t.Error("refused write changed the history")
+	}
+
+	// A JSON array replaces the history
+	history := `[{"role":"user","content":"2+2?"},{"role":"assistant","content":"4"}]`
+	if _, err := f.Write([]byte(history), 0); err != nil {
+		t.Fatalf("JSON array: %v", err)
+	}
+	if len(mock.messages) != 2 || mock.messages[1].Content != "4" {
+		t.Errorf("after replace: %v", mock.messages)
+	}
+
+	// Text in brackets that is not JSON is still a system message
+	if _, err := f.Write([]byte("[Veltro] stay in character"), 0); err != nil {
+		t.Fatalf("bracketed system message: %v", err)
+	}
+	if !reflect.DeepEqual(mock.messages[0], llm.Message{Role: "system", Content: "[Veltro] stay in character"}) {
+		t.Errorf("first message = %v", mock.messages[0])
+	}
+
+	// An array of messages that is not valid JSON is refused, not added
+	// as a system message
+	before := len(mock.messages)
+	if _, err := f.Write([]byte(`[ {"role":"user","content":"cut`), 0); err == nil {
+		t.Error("a broken message array should be refused")
+	}
+	if len(mock.messages) != before {
+		t.Errorf("refused write changed the history: %v", mock.messages)
+	}
+}
@@ -0,0 +1,57 @@
+package llmfs
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// ContinueFile exposes how many times a response cut off at the token
+// limit is continued automatically (read/write). 0 or "off" disables it.
+type ContinueFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+}
+
+// NewContinueFile creates the continue file
+func NewContinueFile(client llm.Backend) *ContinueFile {
+	return &ContinueFile{
+		BaseFile: protocol.NewBaseFile("continue", 0666),
+		client:   client,
+	}
+}
+
+func (f *ContinueFile) Read(p []byte, offset int64) (int, error) {
+	content := fmt.Sprintf("%d\n", f.client.MaxContinuations())
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *ContinueFile) Write(p []byte, offset int64) (int, error) {
+	input := strings.ToLower(strings.TrimSpace(string(p)))
+	n := 0
+	if input != "off" {
+		var err error
+		n, err = strconv.Atoi(input)
+		if err != nil {
+			return 0, fmt.Errorf("invalid continue value: use a number or 'off'")
+		}
+	}
+	if err := f.client.SetMaxContinuations(n); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *ContinueFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(fmt.Sprintf("%d\n", f.client.MaxContinuations())))
+	return s
+}
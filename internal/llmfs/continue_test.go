@@ -0,0 +1,23 @@
+package llmfs
+
+import "testing"
+
+func TestContinueFile(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewContinueFile(mock)
+
+	if _, err := f.Write([]byte("3\n"), 0); err != nil {
+		t.Fatalf("Write(3) error = %v", err)
+	}
+	buf := make([]byte, 16)
+	n, _ := f.Read(buf, 0)
+	if string(buf[:n]) != "3\n" {
+		t.Errorf("continue = %q, want %q", buf[:n], "3\n")
+	}
+	if _, err := f.Write([]byte("off"), 0); err != nil || mock.MaxContinuations() != 0 {
+		t.Errorf("Write(off) error = %v, limit %d", err, mock.MaxContinuations())
+	}
+	if _, err := f.Write([]byte("lots"), 0); err == nil {
+		t.Error("Write(lots) should fail")
+	}
+}
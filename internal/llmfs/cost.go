@@ -0,0 +1,82 @@
+package llmfs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// CostFile reports what the tokens used have cost, in US dollars (read-only).
+// Each line gives a scope, a model and the cost of its input, output,
+// cache read and cache write tokens, then their total:
+//
+//	last claude-sonnet-4-20250514 0.003600 0.005250 0.000000 0.000000 0.008850
+//	last total 0.008850
+//
+// The scopes are the last exchange, the conversation since it began or
+// was reset, and everything since the server started. A model without a
+// price shows "-" and is left out of the totals.
+type CostFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+	prices llm.PriceTable
+}
+
+// NewCostFile creates the cost file, pricing tokens from prices
+func NewCostFile(client llm.Backend, prices llm.PriceTable) *CostFile {
+	return &CostFile{
+		BaseFile: protocol.NewBaseFile("cost", 0444),
+		client:   client,
+		prices:   prices,
+	}
+}
+
+func (f *CostFile) content() string {
+	var b strings.Builder
+	f.scope(&b, "last", llm.UsageOf(f.client.LastMeta()))
+	f.scope(&b, "conversation", f.client.Usage())
+	f.scope(&b, "lifetime", llm.LifetimeUsage())
+	return b.String()
+}
+
+// scope writes the lines of one scope. The last exchange of a fresh
+// conversation has no model and no tokens, and is only a total.
+func (f *CostFile) scope(b *strings.Builder, name string, usage llm.Usage) {
+	var total llm.Cost
+	for _, model := range usage.Models() {
+		if model == "" && usage[model] == (llm.TokenUsage{}) {
+			continue
+		}
+		cost, ok := f.prices.Cost(model, usage[model])
+		if !ok {
+			fmt.Fprintf(b, "%s %s - - - - -\n", name, model)
+			continue
+		}
+		fmt.Fprintf(b, "%s %s %.6f %.6f %.6f %.6f %.6f\n", name, model,
+			cost.Input, cost.Output, cost.CacheRead, cost.CacheWrite, cost.Total())
+		total = total.Add(cost)
+	}
+	fmt.Fprintf(b, "%s total %.6f\n", name, total.Total())
+}
+
+func (f *CostFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *CostFile) Write(p []byte, offset int64) (int, error) {
+	return 0, fmt.Errorf("cost is read-only")
+}
+
+func (f *CostFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
@@ -0,0 +1,65 @@
+package llmfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestCostFile(t *testing.T) {
+	mock := NewMockBackend()
+	prices := llm.PriceTable{"claude-sonnet-4": {Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}}
+	f := NewCostFile(mock, prices)
+
+	got := readAll(t, f)
+	if !strings.HasPrefix(got, "last total 0.000000\nconversation total 0.000000\nlifetime ") {
+		t.Errorf("cost of a fresh conversation = %q", got)
+	}
+
+	meta := llm.Meta{Model: "claude-sonnet-4-20250514", Backend: llm.BackendAPI,
+		InputTokens: 1000, OutputTokens: 500, CacheReadTokens: 20000}
+	mock.lastMeta = meta
+	mock.usage = llm.UsageOf(meta)
+	mock.usage["llama3.2"] = llm.TokenUsage{Input: 50, Output: 10}
+
+	got = readAll(t, f)
+	want := "last claude-sonnet-4-20250514 0.003000 0.007500 0.006000 0.000000 0.016500\n" +
+		"last total 0.016500\n" +
+		"conversation claude-sonnet-4-20250514 0.003000 0.007500 0.006000 0.000000 0.016500\n" +
+		"conversation llama3.2 - - - - -\n" +
+		"conversation total 0.016500\n"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("cost = %q, want it to start %q", got, want)
+	}
+	if !strings.HasSuffix(got, "\n") || !strings.Contains(got, "lifetime total ") {
+		t.Errorf("cost has no lifetime total: %q", got)
+	}
+	if f.Stat().Length != uint64(len(got)) {
+		t.Errorf("Stat().Length = %d, want %d", f.Stat().Length, len(got))
+	}
+	if _, err := f.Write([]byte("0"), 0); err == nil {
+		t.Error("Write() should fail on the read-only cost file")
+	}
+}
+
+func TestCostFile_Conversation(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "pong"
+	mock.askMeta = llm.Meta{Model: "claude-sonnet-4-20250514", InputTokens: 1000, OutputTokens: 100}
+	root := NewRoot(mock)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+	readFid(t, clone, 1)
+
+	walkTo(t, root, "0", "ask").Write([]byte("ping"), 0)
+	walkTo(t, root, "0", "ask").Write([]byte("ping"), 0)
+	got := readAll(t, walkTo(t, root, "0", "cost"))
+	if want := "conversation claude-sonnet-4-20250514 0.006000 0.003000 0.000000 0.000000 0.009000\n"; !strings.Contains(got, want) {
+		t.Errorf("conversation cost = %q, want a line %q", got, want)
+	}
+	if want := "last total 0.004500\n"; !strings.Contains(got, want) {
+		t.Errorf("conversation cost = %q, want a line %q", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package llmfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// errNoEmbedder is what the embed files say on a backend that cannot embed
+var errNoEmbedder = fmt.Errorf("embed: %w", llm.ErrNoEmbedder)
+
+// NewEmbedDir creates the embed directory. Text written to json or f32
+// is embedded with the model in model, and the vectors read back: as
+// JSON, or in the binary encoding of llm.Embeddings. embedder is nil if
+// the backend cannot embed, and the reads then fail saying so.
+func NewEmbedDir(embedder llm.Embedder) *protocol.StaticDir {
+	dir := protocol.NewStaticDir("embed")
+	dir.AddChild(newEmbedFile("json", embedder, false))
+	dir.AddChild(newEmbedFile("f32", embedder, true))
+	dir.AddChild(NewEmbedModelFile(embedder))
+	return dir
+}
+
+// embedInputs splits what was written to embed into its inputs: the
+// strings of a JSON array, or else each line that is not blank
+func embedInputs(text string) ([]string, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "[") {
+		var inputs []string
+		if err := json.Unmarshal([]byte(text), &inputs); err != nil {
+			return nil, fmt.Errorf("embed: not a JSON array of strings: %w", err)
+		}
+		return inputs, nil
+	}
+	var inputs []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			inputs = append(inputs, line)
+		}
+	}
+	return inputs, nil
+}
+
+// EmbedFile embeds text - write one input per line, or a JSON array of
+// strings, and read back a vector for each on the same open file. The
+// text is assembled from all writes and embedded on the fid's next
+// read, and only that fid reads the result (see answerOnRead).
+// embed/json reads as
+//
+//	{"model": "...", "dimensions": N, "tokens": N, "embeddings": [[...], ...]}
+//
+// and embed/f32 as the vectors alone, in llm.Embeddings' binary
+// encoding: the count and the dimensions as little-endian uint32s, then
+// the values as little-endian float32s.
+type EmbedFile struct {
+	*protocol.BaseFile
+	*answerOnRead
+	embedder llm.Embedder
+	binary   bool
+}
+
+func newEmbedFile(name string, embedder llm.Embedder, binary bool) *EmbedFile {
+	f := &EmbedFile{
+		BaseFile: protocol.NewBaseFile(name, 0666),
+		embedder: embedder,
+		binary:   binary,
+	}
+	f.answerOnRead = newAnswerOnRead(f.embed, false)
+	return f
+}
+
+// embed embeds the inputs in p and encodes the result
+func (f *EmbedFile) embed(ctx context.Context, fid uint32, p []byte) ([]byte, error) {
+	if f.embedder == nil {
+		return nil, errNoEmbedder
+	}
+	inputs, err := embedInputs(string(p))
+	if err != nil {
+		return nil, err
+	}
+	embeddings, err := f.embedder.Embed(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+	if f.binary {
+		return embeddings.MarshalBinary()
+	}
+	data, err := json.Marshal(embeddings)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Read implements File.Read. Results exist only per fid.
+func (f *EmbedFile) Read(p []byte, offset int64) (int, error) {
+	return 0, io.EOF
+}
+
+// Write implements File.Write. Results exist only per fid.
+func (f *EmbedFile) Write(p []byte, offset int64) (int, error) {
+	return 0, errNoFid
+}
+
+// EmbedModelFile exposes the embedding model (read/write), which is set
+// apart from the chat model
+type EmbedModelFile struct {
+	*protocol.BaseFile
+	embedder llm.Embedder
+}
+
+// NewEmbedModelFile creates the embed/model file
+func NewEmbedModelFile(embedder llm.Embedder) *EmbedModelFile {
+	return &EmbedModelFile{
+		BaseFile: protocol.NewBaseFile("model", 0666),
+		embedder: embedder,
+	}
+}
+
+func (f *EmbedModelFile) content() string {
+	if f.embedder == nil {
+		return ""
+	}
+	return f.embedder.EmbedModel() + "\n"
+}
+
+func (f *EmbedModelFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *EmbedModelFile) Write(p []byte, offset int64) (int, error) {
+	if f.embedder == nil {
+		return 0, errNoEmbedder
+	}
+	model := strings.TrimSpace(string(p))
+	if model == "" {
+		return 0, errors.New("embed: empty model name")
+	}
+	f.embedder.SetEmbedModel(model)
+	return len(p), nil
+}
+
+func (f *EmbedModelFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
@@ -0,0 +1,100 @@
+package llmfs
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// embeddingBackend is a MockBackend that embeds each input as its
+// length and its number
+type embeddingBackend struct {
+	*MockBackend
+	model  string
+	inputs []string
+}
+
+func (b *embeddingBackend) Embed(ctx context.Context, inputs []string) (*llm.Embeddings, error) {
+	b.inputs = inputs
+	e := &llm.Embeddings{Model: b.model, Dimensions: 2}
+	for i, input := range inputs {
+		e.Vectors = append(e.Vectors, []float32{float32(len(input)), float32(i)})
+	}
+	return e, nil
+}
+
+func (b *embeddingBackend) EmbedModel() string         { return b.model }
+func (b *embeddingBackend) SetEmbedModel(model string) { b.model = model }
+
+func TestEmbedDir(t *testing.T) {
+	backend := &embeddingBackend{MockBackend: NewMockBackend(), model: "embedder"}
+	root := NewRoot(backend)
+	jsonFile := walkTo(t, root, "embed", "json").(*EmbedFile)
+
+	// Lines over several writes, embedded on the fid's read
+	jsonFile.WriteFid(1, []byte("a cat\n\n"), 0)
+	jsonFile.WriteFid(1, []byte("a dog\n"), 7)
+	if backend.inputs != nil {
+		t.Error("inputs should not be embedded before a read")
+	}
+	want := `{"model":"embedder","dimensions":2,"tokens":0,"embeddings":[[5,0],[5,1]]}` + "\n"
+	if got := readFid(t, jsonFile, 1); got != want {
+		t.Errorf("json = %q, want %q", got, want)
+	}
+	if strings.Join(backend.inputs, "|") != "a cat|a dog" {
+		t.Errorf("inputs = %q", backend.inputs)
+	}
+
+	// Another fid sees nothing of it, and the same fid can embed again,
+	// writing on past the answer as a read-write descriptor does
+	if got := readFid(t, jsonFile, 2); got != "" {
+		t.Errorf("json on another fid = %q", got)
+	}
+	jsonFile.WriteFid(1, []byte("a bird"), int64(12+len(want)))
+	var e llm.Embeddings
+	if err := json.Unmarshal([]byte(readFid(t, jsonFile, 1)), &e); err != nil || len(e.Vectors) != 1 || e.Vectors[0][0] != 6 {
+		t.Errorf("second embedding = %+v (%v)", e, err)
+	}
+	jsonFile.CloseFid(1)
+	if _, err := jsonFile.Write([]byte("a cat"), 0); err == nil {
+		t.Error("a write without a fid should fail")
+	}
+
+	// A JSON array keeps inputs with newlines whole
+	f32 := walkTo(t, root, "embed", "f32").(*EmbedFile)
+	f32.WriteFid(3, []byte(`["two\nlines"]`), 0)
+	if err := e.UnmarshalBinary([]byte(readFid(t, f32, 3))); err != nil || len(e.Vectors) != 1 || e.Vectors[0][0] != 9 {
+		t.Errorf("f32 = %+v (%v)", e, err)
+	}
+	f32.WriteFid(4, []byte(`["unclosed"`), 0)
+	if _, err := f32.ReadFid(4, make([]byte, 100), 0); err == nil {
+		t.Error("a bad JSON array should fail")
+	}
+
+	model := walkTo(t, root, "embed", "model")
+	model.Write([]byte("other-embedder\n"), 0)
+	if got := readAll(t, model); got != "other-embedder\n" || backend.Model() == "other-embedder" {
+		t.Errorf("embed/model = %q, chat model %q", got, backend.Model())
+	}
+}
+
+func TestEmbedDir_Unsupported(t *testing.T) {
+	root := NewRoot(NewMockBackend())
+	for _, name := range []string{"json", "f32"} {
+		f := walkTo(t, root, "embed", name).(*EmbedFile)
+		f.WriteFid(1, []byte("a cat"), 0)
+		if _, err := f.ReadFid(1, make([]byte, 100), 0); err != errNoEmbedder {
+			t.Errorf("ReadFid() of %s error = %v", name, err)
+		}
+	}
+	if _, err := walkTo(t, root, "embed", "model").Write([]byte("a cat"), 0); err != errNoEmbedder {
+		t.Errorf("Write() to model error = %v", err)
+	}
+	if _, ok := walkTo(t, root, "embed").(protocol.Dir); !ok {
+		t.Error("embed should be a directory")
+	}
+}
@@ -10,6 +10,7 @@ const exampleContent = `LLM 9P Filesystem Usage Examples
 Basic Interaction:
   echo "What is 2+2?" > ask     # Send prompt to LLM
   cat ask                        # Read response
+  cat bigfile.txt > ask          # Large prompts are sent once, on close
 
 Configuration:
   cat model                      # View current model
@@ -0,0 +1,91 @@
+package llmfs
+
+import (
+	"io"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// NewExportDir creates the export directory with one read-only file per
+// transcript format (see llm.ExportFormats)
+func NewExportDir(client llm.Backend) *protocol.StaticDir {
+	dir := protocol.NewStaticDir("export")
+	for _, format := range llm.ExportFormats {
+		dir.AddChild(NewExportFile(client, format))
+	}
+	return dir
+}
+
+// ExportFile renders the conversation history in one format (read-only)
+type ExportFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+	format string
+}
+
+// NewExportFile creates the export file for format
+func NewExportFile(client llm.Backend, format string) *ExportFile {
+	return &ExportFile{
+		BaseFile: protocol.NewBaseFile(format, 0444),
+		client:   client,
+		format:   format,
+	}
+}
+
+func (f *ExportFile) Read(p []byte, offset int64) (int, error) {
+	content, err := llm.Export(f.client, f.format)
+	if err != nil {
+		return 0, err
+	}
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *ExportFile) Write(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+func (f *ExportFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	content, _ := llm.Export(f.client, f.format)
+	s.Length = uint64(len(content))
+	return s
+}
+
+// ImportFile replaces the conversation history with a transcript in any
+// export format (write-only). Over 9P the transcript is assembled from
+// all writes and imported on clunk; the backend checks it first.
+type ImportFile struct {
+	*protocol.BaseFile
+	*commitOnClunk
+	client llm.Backend
+}
+
+// NewImportFile creates the import file
+func NewImportFile(client llm.Backend) *ImportFile {
+	f := &ImportFile{
+		BaseFile: protocol.NewBaseFile("import", 0222),
+		client:   client,
+	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
+}
+
+func (f *ImportFile) Read(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+func (f *ImportFile) Write(p []byte, offset int64) (int, error) {
+	msgs, err := llm.ParseExport(p)
+	if err != nil {
+		return 0, err
+	}
+	if err := llm.Import(f.client, msgs, true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,39 @@
+package llmfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	mock := NewMockBackend()
+	root := NewRoot(mock)
+
+	imp := walkTo(t, root, "import").(*ImportFile)
+	transcript := "## User\n\nHello\n\n## Assistant\n\nHi there.\n"
+	imp.WriteFid(1, []byte(transcript), 0)
+	if len(mock.messages) != 0 {
+		t.Error("history changed before clunk")
+	}
+	if err := imp.CloseFid(1); err != nil {
+		t.Fatalf("import error: %v", err)
+	}
+	if len(mock.messages) != 2 || mock.messages[1].Content != "Hi there." {
+		t.Errorf("after import: %v", mock.messages)
+	}
+
+	if got := readAll(t, walkTo(t, root, "export", "markdown")); got != transcript {
+		t.Errorf("export/markdown = %q, want %q", got, transcript)
+	}
+	if got := readAll(t, walkTo(t, root, "export", "openai")); !strings.Contains(got, `"content": "Hello"`) {
+		t.Errorf("export/openai = %s", got)
+	}
+
+	// The backend refuses histories it could not send
+	if _, err := imp.Write([]byte(`{"role":"assistant","content":"first"}`), 0); err == nil {
+		t.Error("import starting with the assistant should fail")
+	}
+	if len(mock.messages) != 2 {
+		t.Error("refused import changed the history")
+	}
+}
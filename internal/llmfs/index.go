@@ -0,0 +1,430 @@
+package llmfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// IndexDir holds the vector indexes for semantic search. Writing
+// "new NAME" to ctl creates an index, which then has a directory NAME/:
+// documents written to its add file are chunked, embedded and indexed,
+// and a query written to its query file reads back the most similar
+// chunks. Removing NAME/ (rmdir) deletes the index. The indexes are the
+// backend's, embedded with its embed/model.
+type IndexDir struct {
+	*protocol.BaseFile
+	indexes *llm.IndexSet
+	ctl     *IndexCtlFile
+
+	mu   sync.Mutex
+	dirs map[string]*IndexNameDir // by name, created on first use
+}
+
+// NewIndexDir creates the index directory for a set of indexes
+func NewIndexDir(indexes *llm.IndexSet) *IndexDir {
+	return &IndexDir{
+		BaseFile: protocol.NewBaseFile("index", protocol.DMDIR|0777),
+		indexes:  indexes,
+		ctl:      NewIndexCtlFile(indexes),
+		dirs:     make(map[string]*IndexNameDir),
+	}
+}
+
+// dir returns the directory of an index, replacing the one of an earlier
+// index of the same name
+func (d *IndexDir) dir(index *llm.Index) *IndexNameDir {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dir, ok := d.dirs[index.Name()]; ok && dir.index == index {
+		return dir
+	}
+	dir := newIndexNameDir(d.indexes, index)
+	d.dirs[index.Name()] = dir
+	return dir
+}
+
+func (d *IndexDir) Children() []protocol.File {
+	children := []protocol.File{d.ctl}
+	for _, name := range d.indexes.Names() {
+		if index, ok := d.indexes.Get(name); ok {
+			children = append(children, d.dir(index))
+		}
+	}
+	return children
+}
+
+func (d *IndexDir) Lookup(name string) (protocol.File, error) {
+	if name == "ctl" {
+		return d.ctl, nil
+	}
+	index, ok := d.indexes.Get(name)
+	if !ok {
+		return nil, protocol.ErrNotFound
+	}
+	return d.dir(index), nil
+}
+
+func (d *IndexDir) Read(p []byte, offset int64) (int, error) {
+	return protocol.ReadDir(d.Children(), p, offset)
+}
+
+// IndexCtlFile creates and removes indexes (read/write). Reading lists
+// each index with its kind and size; writing takes commands, one per line:
+//
+//	new NAME [flat|hnsw]   create an index (flat, exact search, by default)
+//	remove NAME            delete an index
+type IndexCtlFile struct {
+	*protocol.BaseFile
+	indexes *llm.IndexSet
+}
+
+// NewIndexCtlFile creates the index/ctl file
+func NewIndexCtlFile(indexes *llm.IndexSet) *IndexCtlFile {
+	return &IndexCtlFile{
+		BaseFile: protocol.NewBaseFile("ctl", 0666),
+		indexes:  indexes,
+	}
+}
+
+func (f *IndexCtlFile) content() string {
+	var b strings.Builder
+	for _, name := range f.indexes.Names() {
+		if index, ok := f.indexes.Get(name); ok {
+			docs, chunks := index.Size()
+			fmt.Fprintf(&b, "%s %s %d documents %d chunks\n", name, index.Settings().Kind, docs, chunks)
+		}
+	}
+	return b.String()
+}
+
+func (f *IndexCtlFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *IndexCtlFile) Write(p []byte, offset int64) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "new" && (len(fields) == 2 || len(fields) == 3):
+			if fields[1] == "ctl" {
+				return 0, errors.New("index: ctl is not a valid index name")
+			}
+			kind := llm.IndexFlat
+			if len(fields) == 3 {
+				kind = fields[2]
+			}
+			if _, err := f.indexes.Create(fields[1], kind); err != nil {
+				return 0, err
+			}
+		case fields[0] == "remove" && len(fields) == 2:
+			if err := f.indexes.Remove(fields[1]); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("index: unknown command %q (use new NAME [flat|hnsw] or remove NAME)", strings.TrimSpace(line))
+		}
+	}
+	return len(p), nil
+}
+
+func (f *IndexCtlFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
+
+// IndexNameDir is one index, index/NAME
+type IndexNameDir struct {
+	*protocol.StaticDir
+	indexes *llm.IndexSet
+	index   *llm.Index
+}
+
+func newIndexNameDir(indexes *llm.IndexSet, index *llm.Index) *IndexNameDir {
+	d := &IndexNameDir{
+		StaticDir: protocol.NewStaticDir(index.Name()),
+		indexes:   indexes,
+		index:     index,
+	}
+	d.AddChild(NewIndexAddFile(index))
+	d.AddChild(NewIndexQueryFile(index))
+	d.AddChild(NewIndexSettingsFile(index))
+	d.AddChild(protocol.NewDynamicFile("docs", func() []byte {
+		var b strings.Builder
+		for _, id := range index.Documents() {
+			b.WriteString(id + "\n")
+		}
+		return []byte(b.String())
+	}))
+	return d
+}
+
+var _ protocol.Remover = (*IndexNameDir)(nil)
+
+// Remove implements protocol.Remover - deletes the index
+func (d *IndexNameDir) Remove() error {
+	return d.indexes.Remove(d.index.Name())
+}
+
+// IndexAddFile adds documents to an index. A write is either JSON - a
+// document, an array of documents, or one document per line:
+//
+//	{"id": "...", "text": "..."}
+//
+// or plain text, added as one document numbered by the index. A document
+// whose ID is already indexed replaces it. Over 9P the writes are added
+// on the fid's next read, which shows each document with its number of
+// chunks, or on clunk if the fid is not read (see answerOnRead).
+type IndexAddFile struct {
+	*protocol.BaseFile
+	*answerOnRead
+	index *llm.Index
+}
+
+// NewIndexAddFile creates an index's add file
+func NewIndexAddFile(index *llm.Index) *IndexAddFile {
+	f := &IndexAddFile{
+		BaseFile: protocol.NewBaseFile("add", 0666),
+		index:    index,
+	}
+	f.answerOnRead = newAnswerOnRead(f.add, true)
+	return f
+}
+
+// add adds the documents in p, and lists them with their chunk counts
+func (f *IndexAddFile) add(ctx context.Context, fid uint32, p []byte) ([]byte, error) {
+	docs, err := indexDocuments(p, f.index)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := f.index.Add(ctx, docs)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for i, doc := range docs {
+		fmt.Fprintf(&b, "%s %d\n", doc.ID, counts[i])
+	}
+	return b.Bytes(), nil
+}
+
+// Read implements File.Read. Results exist only per fid.
+func (f *IndexAddFile) Read(p []byte, offset int64) (int, error) {
+	return 0, io.EOF
+}
+
+// Write implements File.Write - adds the documents
+func (f *IndexAddFile) Write(p []byte, offset int64) (int, error) {
+	if _, err := f.add(context.Background(), 0, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// indexDocuments reads the documents written to an add file. Plain text
+// is one document, given the lowest number not already an ID.
+func indexDocuments(p []byte, index *llm.Index) ([]llm.Document, error) {
+	text := bytes.TrimSpace(p)
+	var docs []llm.Document
+	switch {
+	case bytes.HasPrefix(text, []byte("[")):
+		if err := json.Unmarshal(text, &docs); err != nil {
+			return nil, fmt.Errorf("index: not a JSON array of documents: %w", err)
+		}
+	case bytes.HasPrefix(text, []byte("{")):
+		dec := json.NewDecoder(bytes.NewReader(text))
+		for {
+			var doc llm.Document
+			err := dec.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("index: document %d: %w", len(docs)+1, err)
+			}
+			docs = append(docs, doc)
+		}
+	default:
+		used := make(map[string]bool)
+		for _, id := range index.Documents() {
+			used[id] = true
+		}
+		n := 1
+		for used[strconv.Itoa(n)] {
+			n++
+		}
+		docs = append(docs, llm.Document{ID: strconv.Itoa(n), Text: string(p)})
+	}
+	return docs, nil
+}
+
+// IndexQueryFile searches an index. Write a query, as text or as
+//
+//	{"query": "...", "k": N}
+//
+// and read back the k chunks most similar to it, best first:
+//
+//	{"query": "...", "matches": [{"id": "...", "chunk": N, "text": "...", "score": F}, ...]}
+//
+// The score is the cosine similarity of the chunk to the query. k
+// defaults to the index's, set in its ctl file. The query is run on the
+// next read of the fid that wrote it, and only that fid reads the
+// matches (see answerOnRead).
+type IndexQueryFile struct {
+	*protocol.BaseFile
+	*answerOnRead
+	index *llm.Index
+}
+
+// indexResult is what a query file reads
+type indexResult struct {
+	Query   string      `json:"query"`
+	Matches []llm.Match `json:"matches"`
+}
+
+// NewIndexQueryFile creates an index's query file
+func NewIndexQueryFile(index *llm.Index) *IndexQueryFile {
+	f := &IndexQueryFile{
+		BaseFile: protocol.NewBaseFile("query", 0666),
+		index:    index,
+	}
+	f.answerOnRead = newAnswerOnRead(f.query, false)
+	return f
+}
+
+// query runs the query in p and encodes the matches
+func (f *IndexQueryFile) query(ctx context.Context, fid uint32, p []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(p))
+	var query struct {
+		Query string `json:"query"`
+		K     int    `json:"k"`
+	}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &query); err != nil {
+			return nil, fmt.Errorf("index: invalid query: %w", err)
+		}
+	} else {
+		query.Query = text
+	}
+	matches, err := f.index.Query(ctx, query.Query, query.K)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(indexResult{Query: query.Query, Matches: matches})
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Read implements File.Read. Results exist only per fid.
+func (f *IndexQueryFile) Read(p []byte, offset int64) (int, error) {
+	return 0, io.EOF
+}
+
+// Write implements File.Write. Results exist only per fid.
+func (f *IndexQueryFile) Write(p []byte, offset int64) (int, error) {
+	return 0, errNoFid
+}
+
+// IndexSettingsFile is an index's ctl file (read/write). Reading shows its
+// settings and size; writing takes commands, one per line:
+//
+//	k N                    return N matches to a query by default
+//	chunk SIZE [OVERLAP]   chunk documents added from now on into SIZE
+//	                       characters, each overlapping the last by OVERLAP
+//	                       (a fifth of SIZE if not given)
+//	remove ID              delete a document
+type IndexSettingsFile struct {
+	*protocol.BaseFile
+	index *llm.Index
+}
+
+// NewIndexSettingsFile creates an index's ctl file
+func NewIndexSettingsFile(index *llm.Index) *IndexSettingsFile {
+	return &IndexSettingsFile{
+		BaseFile: protocol.NewBaseFile("ctl", 0666),
+		index:    index,
+	}
+}
+
+func (f *IndexSettingsFile) content() string {
+	s := f.index.Settings()
+	docs, chunks := f.index.Size()
+	var b strings.Builder
+	fmt.Fprintf(&b, "kind %s\n", s.Kind)
+	if s.Model != "" {
+		fmt.Fprintf(&b, "model %s\ndimensions %d\n", s.Model, s.Dimensions)
+	}
+	fmt.Fprintf(&b, "documents %d\nchunks %d\nk %d\nchunk %d %d\n", docs, chunks, s.K, s.ChunkSize, s.Overlap)
+	return b.String()
+}
+
+func (f *IndexSettingsFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *IndexSettingsFile) Write(p []byte, offset int64) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch {
+		case fields[0] == "k" && len(fields) == 2:
+			var k int
+			if k, err = strconv.Atoi(fields[1]); err != nil {
+				return 0, fmt.Errorf("k: %q is not a number", fields[1])
+			}
+			err = f.index.SetK(k)
+		case fields[0] == "chunk" && (len(fields) == 2 || len(fields) == 3):
+			size, sizeErr := strconv.Atoi(fields[1])
+			overlap := size / 5
+			if len(fields) == 3 {
+				overlap, err = strconv.Atoi(fields[2])
+			}
+			if sizeErr != nil || err != nil {
+				return 0, fmt.Errorf("chunk: %q is not a size and overlap", strings.Join(fields[1:], " "))
+			}
+			err = f.index.SetChunking(size, overlap)
+		case fields[0] == "remove" && len(fields) > 1:
+			err = f.index.Remove(strings.TrimSpace(strings.TrimSpace(line)[len("remove"):]))
+		default:
+			return 0, fmt.Errorf("index: unknown command %q (use k N, chunk SIZE [OVERLAP] or remove ID)", strings.TrimSpace(line))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (f *IndexSettingsFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
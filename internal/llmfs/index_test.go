@@ -0,0 +1,143 @@
+package llmfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// wordBackend is a MockBackend that embeds text as the counts of its
+// words, hashed into 32 dimensions, so texts sharing words are similar
+type wordBackend struct {
+	*MockBackend
+}
+
+func (b *wordBackend) Embed(ctx context.Context, inputs []string) (*llm.Embeddings, error) {
+	e := &llm.Embeddings{Model: "words", Dimensions: 32}
+	for _, input := range inputs {
+		v := make([]float32, 32)
+		for _, word := range strings.Fields(strings.ToLower(input)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(word, ".,")))
+			v[h.Sum32()%32]++
+		}
+		e.Vectors = append(e.Vectors, v)
+	}
+	return e, nil
+}
+
+func (b *wordBackend) EmbedModel() string         { return "words" }
+func (b *wordBackend) SetEmbedModel(model string) {}
+
+// queryIndex writes a query to an index and returns the matches read back
+func queryIndex(t *testing.T, root protocol.Dir, name, query string) []llm.Match {
+	t.Helper()
+	f := walkTo(t, root, "index", name, "query").(*IndexQueryFile)
+	defer f.CloseFid(1)
+	f.WriteFid(1, []byte(query), 0)
+	var result struct {
+		Query   string      `json:"query"`
+		Matches []llm.Match `json:"matches"`
+	}
+	if err := json.Unmarshal([]byte(readFid(t, f, 1)), &result); err != nil {
+		t.Fatalf("query should read as JSON: %v", err)
+	}
+	return result.Matches
+}
+
+func TestIndexDir(t *testing.T) {
+	root := NewRoot(&wordBackend{NewMockBackend()})
+	ctl := walkTo(t, root, "index", "ctl")
+	if _, err := ctl.Write([]byte("new animals hnsw\n"), 0); err != nil {
+		t.Fatalf("new error: %v", err)
+	}
+
+	// Documents over several writes, added on the fid's read
+	add := walkTo(t, root, "index", "animals", "add").(*IndexAddFile)
+	docs := `{"id": "zebra", "text": "The zebra has black and white stripes."}
+{"id": "owl", "text": "An owl hunts at night."}
+`
+	add.WriteFid(1, []byte(docs[:30]), 0)
+	add.WriteFid(1, []byte(docs[30:]), 30)
+	if got := readFid(t, add, 1); got != "zebra 1\nowl 1\n" {
+		t.Errorf("add = %q", got)
+	}
+	if got := readFid(t, add, 2); got != "" {
+		t.Errorf("add on another fid = %q", got)
+	}
+	add.CloseFid(1)
+
+	// or on clunk, if the fid is not read
+	add.WriteFid(3, []byte("Salmon swim upstream to spawn."), 0)
+	if err := add.CloseFid(3); err != nil {
+		t.Fatalf("clunk error: %v", err)
+	}
+	if got := readAll(t, walkTo(t, root, "index", "animals", "docs")); got != "1\nowl\nzebra\n" {
+		t.Errorf("docs after plain text add = %q", got)
+	}
+
+	matches := queryIndex(t, root, "animals", "stripes")
+	if len(matches) != 3 || matches[0].ID != "zebra" || matches[0].Text != "The zebra has black and white stripes." {
+		t.Errorf("matches = %+v", matches)
+	}
+	if matches := queryIndex(t, root, "animals", `{"query": "at night", "k": 1}`); len(matches) != 1 || matches[0].ID != "owl" {
+		t.Errorf("matches = %+v", matches)
+	}
+
+	settings := walkTo(t, root, "index", "animals", "ctl")
+	if _, err := settings.Write([]byte("k 2\nchunk 50\nremove owl\n"), 0); err != nil {
+		t.Fatalf("ctl error: %v", err)
+	}
+	want := "kind hnsw\nmodel words\ndimensions 32\ndocuments 2\nchunks 2\nk 2\nchunk 50 10\n"
+	if got := readAll(t, settings); got != want {
+		t.Errorf("ctl = %q, want %q", got, want)
+	}
+	if got := readAll(t, walkTo(t, root, "index", "animals", "docs")); got != "1\nzebra\n" {
+		t.Errorf("docs = %q", got)
+	}
+	for _, bad := range []string{"k 0", "chunk 10 10", "remove owl", "rebuild"} {
+		if _, err := settings.Write([]byte(bad), 0); err == nil {
+			t.Errorf("Write(%q) should fail", bad)
+		}
+	}
+
+	if got := readAll(t, ctl); got != "animals hnsw 2 documents 2 chunks\n" {
+		t.Errorf("index/ctl = %q", got)
+	}
+	for _, bad := range []string{"new animals", "new ctl", "new x ivf", "remove nope", "drop x"} {
+		if _, err := ctl.Write([]byte(bad), 0); err == nil {
+			t.Errorf("Write(%q) should fail", bad)
+		}
+	}
+	if err := walkTo(t, root, "index", "animals").(protocol.Remover).Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if got := readAll(t, ctl); got != "" {
+		t.Errorf("index/ctl after rmdir = %q", got)
+	}
+}
+
+func TestIndexDir_Persist(t *testing.T) {
+	dir := t.TempDir()
+	root := NewRootWithOptions(&wordBackend{NewMockBackend()}, Options{IndexDir: dir})
+	walkTo(t, root, "index", "ctl").Write([]byte("new notes"), 0)
+	walkTo(t, root, "index", "notes", "add").Write([]byte(`[{"id": "a", "text": "buy milk"}, {"id": "b", "text": "call the plumber"}]`), 0)
+
+	root = NewRootWithOptions(&wordBackend{NewMockBackend()}, Options{IndexDir: dir})
+	if matches := queryIndex(t, root, "notes", "plumber"); len(matches) != 2 || matches[0].ID != "b" {
+		t.Errorf("matches = %+v", matches)
+	}
+}
+
+func TestIndexDir_Unsupported(t *testing.T) {
+	root := NewRoot(NewMockBackend())
+	if _, err := walkTo(t, root, "index", "ctl").Write([]byte("new notes"), 0); !errors.Is(err, llm.ErrNoEmbedder) {
+		t.Errorf("new error = %v", err)
+	}
+}
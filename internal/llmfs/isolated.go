@@ -0,0 +1,104 @@
+package llmfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// IsolatedAskFile is an ask file on which every open fid is its own
+// conversation, backed by an llm.Session. Two scripts sharing a mount
+// never see each other's answers or history.
+//
+// Because the conversation ends when the fid is clunked, the prompt and
+// the answer travel over the same open file: writes are collected, and
+// the next read sends them and returns the response (see answerOnRead).
+// A prompt that is written but never read is discarded with the session.
+//
+//	exec 3<>/mnt/llm/ask
+//	echo "What is 2+2?" >&3
+//	cat <&3
+type IsolatedAskFile struct {
+	*protocol.BaseFile
+	*answerOnRead
+	sessions *llm.SessionManager
+	attach   *AttachDir // files for the next prompt of any fid, if any
+	mu       sync.Mutex
+	asks     map[uint32]*AskFile // ask semantics over each fid's session
+}
+
+var _ protocol.ContextFidAwareFile = (*IsolatedAskFile)(nil)
+
+// NewIsolatedAskFile creates an ask file with per-fid conversations
+func NewIsolatedAskFile(sessions *llm.SessionManager) *IsolatedAskFile {
+	f := &IsolatedAskFile{
+		BaseFile: protocol.NewBaseFile("ask", 0666),
+		sessions: sessions,
+		asks:     make(map[uint32]*AskFile),
+	}
+	f.answerOnRead = newAnswerOnRead(f.ask, false)
+	return f
+}
+
+// ask sends a fid's prompt in its conversation and returns the response
+func (f *IsolatedAskFile) ask(ctx context.Context, fid uint32, p []byte) ([]byte, error) {
+	f.mu.Lock()
+	ask, ok := f.asks[fid]
+	if !ok {
+		ask = NewAskFile(f.sessions.Client(fid))
+		ask.attach = f.attach
+		f.asks[fid] = ask
+	}
+	f.mu.Unlock()
+
+	if _, err := ask.WriteContext(ctx, p, 0); err != nil {
+		return nil, err
+	}
+	return []byte(ask.content()), nil
+}
+
+// Read implements File.Read. Conversations exist only per fid.
+func (f *IsolatedAskFile) Read(p []byte, offset int64) (int, error) {
+	return 0, io.EOF
+}
+
+// Write implements File.Write. Conversations exist only per fid.
+func (f *IsolatedAskFile) Write(p []byte, offset int64) (int, error) {
+	return 0, fmt.Errorf("isolated ask requires an open fid")
+}
+
+// CloseFid implements protocol.FidAwareFile - ends the fid's conversation
+func (f *IsolatedAskFile) CloseFid(fid uint32) error {
+	return f.CloseFidContext(context.Background(), fid)
+}
+
+// CloseFidContext implements protocol.ContextFidAwareFile
+func (f *IsolatedAskFile) CloseFidContext(ctx context.Context, fid uint32) error {
+	f.answerOnRead.CloseFidContext(ctx, fid)
+	f.mu.Lock()
+	delete(f.asks, fid)
+	f.mu.Unlock()
+	f.sessions.Remove(fid)
+	return nil
+}
+
+// AbortFid implements protocol.FidAborter - ends the fid's conversation,
+// discarding any prompt it wrote
+func (f *IsolatedAskFile) AbortFid(fid uint32) {
+	f.answerOnRead.AbortFid(fid)
+	f.mu.Lock()
+	delete(f.asks, fid)
+	f.mu.Unlock()
+	f.sessions.Remove(fid)
+}
+
+// Stat returns the file's metadata
+func (f *IsolatedAskFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = 0
+	return s
+}
@@ -0,0 +1,102 @@
+package llmfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestIsolatedAskFile_PerFidConversations(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "answer"
+	sessions := llm.NewSessionManager(mock)
+	ask := NewIsolatedAskFile(sessions)
+
+	// Two fids write interleaved prompts
+	ask.WriteFid(1, []byte("first "), 0)
+	ask.WriteFid(2, []byte("other"), 0)
+	ask.WriteFid(1, []byte("question\n"), 6)
+
+	// The read sends the prompt; offsets continue from the write
+	if got := readFid(t, ask, 1); got != "answer\n" {
+		t.Errorf("fid 1 read = %q, want %q", got, "answer\n")
+	}
+	buf := make([]byte, 100)
+	n, _ := ask.ReadFid(2, buf, 5)
+	if string(buf[:n]) != "answer\n" {
+		t.Errorf("fid 2 read at write offset = %q, want %q", buf[:n], "answer\n")
+	}
+
+	h1 := sessions.Get(1).Messages()
+	if len(h1) != 2 || h1[0].Content != "first question" {
+		t.Errorf("fid 1 history = %+v, want its own prompt", h1)
+	}
+	h2 := sessions.Get(2).Messages()
+	if len(h2) != 2 || h2[0].Content != "other" {
+		t.Errorf("fid 2 history = %+v, want its own prompt", h2)
+	}
+	if len(mock.messages) != 0 {
+		t.Error("isolated asks should not touch the shared conversation")
+	}
+
+	// Writes land at their offsets, taken from the first write after the
+	// answer was read, so one written twice is not repeated
+	ask.WriteFid(2, []byte("and "), 12)
+	ask.WriteFid(2, []byte("again"), 16)
+	ask.WriteFid(2, []byte("again"), 16)
+	if n, _ := ask.ReadFid(2, buf, 21); string(buf[:n]) != "answer\n" {
+		t.Errorf("fid 2 second read = %q", buf[:n])
+	}
+	if h2 := sessions.Get(2).Messages(); len(h2) != 4 || h2[2].Content != "and again" {
+		t.Errorf("fid 2 history = %+v", h2)
+	}
+
+	// Clunk tears the session down
+	ask.CloseFid(1)
+	if sessions.Get(1) != nil {
+		t.Error("CloseFid() should remove the fid's session")
+	}
+	if sessions.Get(2) == nil {
+		t.Error("CloseFid() removed another fid's session")
+	}
+}
+
+func TestRoot_AttachViews(t *testing.T) {
+	root := NewRoot(NewMockBackend()).(protocol.Attacher)
+
+	shared, err := root.Attach("glenda", "")
+	if err != nil {
+		t.Fatalf("Attach(\"\") error: %v", err)
+	}
+	if _, ok := walkTo(t, shared, "ask").(*AskFile); !ok {
+		t.Error("default view should serve the shared ask file")
+	}
+
+	isolated, err := root.Attach("glenda", "isolate")
+	if err != nil {
+		t.Fatalf("Attach(isolate) error: %v", err)
+	}
+	if _, ok := walkTo(t, isolated, "ask").(*IsolatedAskFile); !ok {
+		t.Error("isolate view should serve the per-fid ask file")
+	}
+	if walkTo(t, isolated, "model") != walkTo(t, shared, "model") {
+		t.Error("views should share everything but ask")
+	}
+
+	if _, err := root.Attach("glenda", "bogus"); err == nil || !strings.Contains(err.Error(), "aname") {
+		t.Errorf("Attach(bogus) error = %v, want unknown aname", err)
+	}
+
+	// The flag makes isolation the default, with shared still reachable
+	root = NewRootWithOptions(NewMockBackend(), Options{IsolateAsk: true}).(protocol.Attacher)
+	def, _ := root.Attach("glenda", "")
+	if _, ok := walkTo(t, def, "ask").(*IsolatedAskFile); !ok {
+		t.Error("IsolateAsk should make the per-fid ask file the default")
+	}
+	shared, _ = root.Attach("glenda", "shared")
+	if _, ok := walkTo(t, shared, "ask").(*AskFile); !ok {
+		t.Error("aname shared should still serve the shared ask file")
+	}
+}
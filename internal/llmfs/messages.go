@@ -0,0 +1,220 @@
+package llmfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// MessagesDir shows the conversation history one message per numbered
+// directory, messages/0, messages/1, ... in order. Each holds role,
+// content, tools, tokens, time and pinned files; role, content and
+// pinned can be rewritten in place, and removing the directory (rmdir) deletes the
+// message. Compaction keeps pinned messages and their turns. The
+// numbers follow the current history, so removing messages/2 renumbers
+// the messages after it.
+type MessagesDir struct {
+	*protocol.BaseFile
+	client llm.Backend
+
+	mu   sync.Mutex
+	dirs []*MessageDir // by index, created on first use
+}
+
+// NewMessagesDir creates the messages directory
+func NewMessagesDir(client llm.Backend) *MessagesDir {
+	return &MessagesDir{
+		BaseFile: protocol.NewBaseFile("messages", protocol.DMDIR|0777),
+		client:   client,
+	}
+}
+
+// dir returns the directory of message i
+func (d *MessagesDir) dir(i int) *MessageDir {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.dirs) <= i {
+		d.dirs = append(d.dirs, newMessageDir(d.client, len(d.dirs)))
+	}
+	return d.dirs[i]
+}
+
+func (d *MessagesDir) Children() []protocol.File {
+	n := len(d.client.Messages())
+	children := make([]protocol.File, n)
+	for i := range children {
+		children[i] = d.dir(i)
+	}
+	return children
+}
+
+func (d *MessagesDir) Lookup(name string) (protocol.File, error) {
+	i, err := strconv.Atoi(name)
+	if err != nil || i < 0 || strconv.Itoa(i) != name || i >= len(d.client.Messages()) {
+		return nil, protocol.ErrNotFound
+	}
+	return d.dir(i), nil
+}
+
+func (d *MessagesDir) Read(p []byte, offset int64) (int, error) {
+	return protocol.ReadDir(d.Children(), p, offset)
+}
+
+// MessageDir is one message of the history, messages/N
+type MessageDir struct {
+	*protocol.StaticDir
+	client llm.Backend
+	index  int
+}
+
+func newMessageDir(client llm.Backend, index int) *MessageDir {
+	d := &MessageDir{
+		StaticDir: protocol.NewStaticDir(strconv.Itoa(index)),
+		client:    client,
+		index:     index,
+	}
+	for _, field := range []string{"role", "content", "tools", "tokens", "time", "pinned"} {
+		d.AddChild(NewMessageFile(client, index, field))
+	}
+	return d
+}
+
+var _ protocol.Remover = (*MessageDir)(nil)
+
+// Remove implements protocol.Remover - deletes the message from the history
+func (d *MessageDir) Remove() error {
+	return llm.RemoveMessage(d.client, d.index)
+}
+
+// MessageFile exposes one field of a message: role, content and pinned
+// ("true" or "false") are read/write (content is replaced on clunk over
+// 9P), tools (the tool calls or results as JSON, empty if none), tokens
+// (an estimate) and time are read-only.
+type MessageFile struct {
+	*protocol.BaseFile
+	*commitOnClunk
+	client llm.Backend
+	index  int
+	field  string
+}
+
+// NewMessageFile creates the file for a field of message index
+func NewMessageFile(client llm.Backend, index int, field string) *MessageFile {
+	mode := uint32(0444)
+	if field == "role" || field == "content" || field == "pinned" {
+		mode = 0666
+	}
+	f := &MessageFile{
+		BaseFile: protocol.NewBaseFile(field, mode),
+		client:   client,
+		index:    index,
+		field:    field,
+	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
+}
+
+// message returns the message as it is now
+func (f *MessageFile) message() (llm.Message, error) {
+	msgs := f.client.Messages()
+	if f.index >= len(msgs) {
+		return llm.Message{}, protocol.ErrNotFound
+	}
+	return msgs[f.index], nil
+}
+
+func (f *MessageFile) content() (string, error) {
+	msg, err := f.message()
+	if err != nil {
+		return "", err
+	}
+	var value string
+	switch f.field {
+	case "role":
+		value = msg.Role
+	case "content":
+		value = msg.Content
+	case "tools":
+		var tools any
+		if len(msg.ToolCalls) > 0 {
+			tools = msg.ToolCalls
+		} else if len(msg.ToolResults) > 0 {
+			tools = msg.ToolResults
+		}
+		if tools != nil {
+			data, err := json.MarshalIndent(tools, "", "  ")
+			if err != nil {
+				return "", err
+			}
+			value = string(data)
+		}
+	case "tokens":
+		value = strconv.Itoa(llm.EstimateTokens(msg.Text()))
+	case "time":
+		if !msg.Time.IsZero() {
+			value = msg.Time.Format(time.RFC3339)
+		}
+	case "pinned":
+		value = strconv.FormatBool(msg.Pinned)
+	}
+	if value != "" {
+		value += "\n"
+	}
+	return value, nil
+}
+
+func (f *MessageFile) Read(p []byte, offset int64) (int, error) {
+	content, err := f.content()
+	if err != nil {
+		return 0, err
+	}
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *MessageFile) Write(p []byte, offset int64) (int, error) {
+	msg, err := f.message()
+	if err != nil {
+		return 0, err
+	}
+	switch f.field {
+	case "role":
+		role := strings.TrimSpace(string(p))
+		if role != "user" && role != "assistant" && role != "system" {
+			return 0, fmt.Errorf("invalid role %q (use user, assistant or system)", role)
+		}
+		msg.Role = role
+	case "content":
+		// Verbatim but for the newline echo adds
+		msg.Content = strings.TrimSuffix(string(p), "\n")
+	case "pinned":
+		pinned, err := strconv.ParseBool(strings.TrimSpace(string(p)))
+		if err != nil {
+			return 0, fmt.Errorf("pinned: write true or false")
+		}
+		msg.Pinned = pinned
+	default:
+		return 0, protocol.ErrPermission
+	}
+	if err := llm.SetMessage(f.client, f.index, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *MessageFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	content, _ := f.content()
+	s.Length = uint64(len(content))
+	return s
+}
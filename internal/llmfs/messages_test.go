@@ -0,0 +1,127 @@
+package llmfs
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func readAll(t *testing.T, f protocol.File) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	n, err := f.Read(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatalf("read %s: %v", f.Stat().Name, err)
+	}
+	return string(buf[:n])
+}
+
+func TestMessagesDir(t *testing.T) {
+	mock := NewMockBackend()
+	mock.SetMessages([]llm.Message{
+		{Role: "user", Content: "What is 2+2?"},
+		{Role: "assistant", Content: "5"},
+		{Role: "user", Content: "Really?"},
+	})
+	root := NewRoot(mock)
+
+	messages := walkTo(t, root, "messages").(*MessagesDir)
+	if n := len(messages.Children()); n != 3 {
+		t.Fatalf("messages has %d entries, want 3", n)
+	}
+	if _, err := messages.Lookup("3"); err == nil {
+		t.Error("Lookup(3) past the end should fail")
+	}
+	if _, err := messages.Lookup("01"); err == nil {
+		t.Error("Lookup(01) should fail")
+	}
+
+	if got := readAll(t, walkTo(t, root, "messages", "1", "role")); got != "assistant\n" {
+		t.Errorf("role = %q", got)
+	}
+	if got := readAll(t, walkTo(t, root, "messages", "1", "tokens")); got != "1\n" {
+		t.Errorf("tokens = %q", got)
+	}
+	if got := readAll(t, walkTo(t, root, "messages", "1", "time")); got != "" {
+		t.Errorf("time of a message without one = %q", got)
+	}
+
+	// Content is replaced on clunk
+	content := walkTo(t, root, "messages", "1", "content").(*MessageFile)
+	content.WriteFid(1, []byte("4\n"), 0)
+	if mock.messages[1].Content != "5" {
+		t.Error("content changed before clunk")
+	}
+	if err := content.CloseFid(1); err != nil {
+		t.Fatalf("CloseFid() error: %v", err)
+	}
+	if mock.messages[1].Content != "4" {
+		t.Errorf("content after edit = %q, want 4", mock.messages[1].Content)
+	}
+
+	role := walkTo(t, root, "messages", "2", "role")
+	if _, err := role.Write([]byte("robot"), 0); err == nil {
+		t.Error("invalid role should fail")
+	}
+	if _, err := role.Write([]byte("system\n"), 0); err != nil || mock.messages[2].Role != "system" {
+		t.Errorf("role write: %v, role %q", err, mock.messages[2].Role)
+	}
+	pinned := walkTo(t, root, "messages", "1", "pinned")
+	if _, err := pinned.Write([]byte("true\n"), 0); err != nil || !mock.messages[1].Pinned {
+		t.Errorf("pinning: %v, pinned %v", err, mock.messages[1].Pinned)
+	}
+	if got := readAll(t, pinned); got != "true\n" {
+		t.Errorf("pinned = %q", got)
+	}
+	if _, err := pinned.Write([]byte("maybe"), 0); err == nil {
+		t.Error("pinned takes only true or false")
+	}
+	if _, err := walkTo(t, root, "messages", "0", "tokens").Write([]byte("9"), 0); err == nil {
+		t.Error("tokens should be read-only")
+	}
+
+	// Removing a message renumbers the rest
+	if err := walkTo(t, root, "messages", "0").(protocol.Remover).Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if len(mock.messages) != 2 || mock.messages[0].Content != "4" {
+		t.Errorf("after remove: %v", mock.messages)
+	}
+	if got := readAll(t, walkTo(t, root, "messages", "0", "content")); got != "4\n" {
+		t.Errorf("messages/0/content = %q", got)
+	}
+	stale := walkTo(t, root, "messages", "1", "content")
+	mock.SetMessages(nil)
+	if _, err := stale.Read(make([]byte, 10), 0); err == nil {
+		t.Error("reading a message that is gone should fail")
+	}
+}
+
+func TestMessagesDir_Conversation(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "pong"
+	root := NewRoot(mock)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+	walkTo(t, root, "0", "ask").Write([]byte("ping"), 0)
+
+	if got := readAll(t, walkTo(t, root, "0", "messages", "1", "content")); got != "pong\n" {
+		t.Errorf("0/messages/1/content = %q", got)
+	}
+	if got := readAll(t, walkTo(t, root, "0", "messages", "0", "time")); !strings.HasSuffix(got, "Z\n") {
+		t.Errorf("0/messages/0/time = %q", got)
+	}
+	if err := walkTo(t, root, "0", "messages", "1").(protocol.Remover).Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	c, _ := root.(*rootDir).convs.lookup("0")
+	if msgs := c.client.Messages(); len(msgs) != 1 || msgs[0].Content != "ping" {
+		t.Errorf("conversation after remove: %v", msgs)
+	}
+	if len(mock.messages) != 0 {
+		t.Error("editing a conversation changed the shared history")
+	}
+}
@@ -0,0 +1,56 @@
+package llmfs
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// MetaFile describes the last exchange as JSON (read-only): the model
+// that answered, stop reason, continuations, token usage, latency,
+// backend, request ID and time. Empty until there has been a response.
+type MetaFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+}
+
+// NewMetaFile creates the meta file
+func NewMetaFile(client llm.Backend) *MetaFile {
+	return &MetaFile{
+		BaseFile: protocol.NewBaseFile("meta", 0444),
+		client:   client,
+	}
+}
+
+func (f *MetaFile) content() string {
+	meta := f.client.LastMeta()
+	if meta.Time.IsZero() {
+		return ""
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return ""
+	}
+	return string(data) + "\n"
+}
+
+func (f *MetaFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *MetaFile) Write(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+func (f *MetaFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
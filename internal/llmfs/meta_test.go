@@ -0,0 +1,43 @@
+package llmfs
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestMetaFile(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewMetaFile(mock)
+
+	buf := make([]byte, 1024)
+	if _, err := f.Read(buf, 0); err != io.EOF {
+		t.Errorf("meta before any response: error = %v, want EOF", err)
+	}
+
+	mock.askResponse = "4"
+	mock.askMeta = llm.Meta{
+		Model: "m", Backend: llm.BackendAPI, StopReason: llm.StopMaxTokens,
+		InputTokens: 10, OutputTokens: 5, LatencyMs: 42, Time: time.Now(),
+	}
+	NewAskFile(mock).Write([]byte("2+2?"), 0)
+
+	n, _ := f.Read(buf, 0)
+	var got llm.Meta
+	if err := json.Unmarshal(buf[:n], &got); err != nil {
+		t.Fatalf("meta is not JSON: %v (%q)", err, buf[:n])
+	}
+	if got.StopReason != llm.StopMaxTokens || got.InputTokens != 10 || got.OutputTokens != 5 || got.LatencyMs != 42 {
+		t.Errorf("meta = %+v", got)
+	}
+	if f.Stat().Length != uint64(n) {
+		t.Errorf("Stat().Length = %d, want %d", f.Stat().Length, n)
+	}
+	if _, err := f.Write([]byte("x"), 0); err != protocol.ErrPermission {
+		t.Errorf("Write() error = %v, want ErrPermission", err)
+	}
+}
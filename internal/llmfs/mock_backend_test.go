@@ -18,10 +18,22 @@ type MockBackend struct {
 	totalTokens    int
 	contextLimit   int
 	thinkingTokens int
+	params         llm.Params
 	compactCalled  bool
 	compactError   error
 	askResponse    string
+	askThinking    string
 	askError       error
+	lastThinking   string
+	askMeta        llm.Meta
+	lastMeta       llm.Meta
+	usage          llm.Usage
+	continuations  int
+	compactPolicy  llm.CompactPolicy
+	cache          string
+	retrier        *llm.Retrier
+	tools          *llm.Toolbox
+	schema         *llm.Schema
 }
 
 func NewMockBackend() *MockBackend {
@@ -30,6 +42,11 @@ func NewMockBackend() *MockBackend {
 		temperature:  0.7,
 		contextLimit: 200000,
 		messages:     make([]llm.Message, 0),
+		retrier:      llm.NewRetrier(llm.DefaultRetryPolicy),
+		tools:        llm.NewToolbox(),
+		schema:       llm.NewSchema(),
+		compactPolicy: llm.DefaultCompactPolicy,
+		cache:         llm.CacheOn,
 	}
 }
 
@@ -52,6 +69,63 @@ func (m *MockBackend) SetPrefill(prefill string)     { m.prefill = prefill }
 func (m *MockBackend) LastTokens() int               { return m.lastTokens }
 func (m *MockBackend) TotalTokens() int              { return m.totalTokens }
 func (m *MockBackend) ContextLimit() int             { return m.contextLimit }
+func (m *MockBackend) LastThinking() string          { return m.lastThinking }
+func (m *MockBackend) Params() llm.Params            { return m.params }
+func (m *MockBackend) LastMeta() llm.Meta            { return m.lastMeta }
+func (m *MockBackend) Usage() llm.Usage               { return m.usage }
+func (m *MockBackend) MaxContinuations() int         { return m.continuations }
+func (m *MockBackend) SetMaxContinuations(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid continuations")
+	}
+	m.continuations = n
+	return nil
+}
+func (m *MockBackend) SetParams(p llm.Params) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	m.params = p
+	return nil
+}
+func (m *MockBackend) SupportedParams() []string {
+	return []string{llm.ParamMaxTokens, llm.ParamTopP, llm.ParamStop}
+}
+
+func (m *MockBackend) CompactPolicy() llm.CompactPolicy { return m.compactPolicy }
+
+func (m *MockBackend) SetCompactPolicy(p llm.CompactPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	m.compactPolicy = p
+	return nil
+}
+
+func (m *MockBackend) Summarize(ctx context.Context, model, prompt string) (string, int, error) {
+	if m.askError != nil {
+		return "", 0, m.askError
+	}
+	return "summary by " + model, 10, nil
+}
+
+func (m *MockBackend) Cache() string { return m.cache }
+func (m *MockBackend) SetCache(mode string) error {
+	for _, c := range llm.CacheModes {
+		if mode == c {
+			m.cache = mode
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cache mode")
+}
+
+func (m *MockBackend) CountTokens(ctx context.Context, text string) (llm.TokenCount, error) {
+	if m.askError != nil {
+		return llm.TokenCount{}, m.askError
+	}
+	return llm.TokenCount{Tokens: llm.EstimateTokens(text), Method: llm.CountEstimate}, nil
+}
 
 func (m *MockBackend) Compact(ctx context.Context) error {
 	m.compactCalled = true
@@ -78,32 +152,55 @@ func (m *MockBackend) AddSystemMessage(content string) {
 	m.messages = append([]llm.Message{{Role: "system", Content: content}}, m.messages...)
 }
 
+func (m *MockBackend) SetMessages(msgs []llm.Message) {
+	m.messages = append([]llm.Message(nil), msgs...)
+}
+
+func (m *MockBackend) ValidateMessages(msgs []llm.Message) error {
+	return llm.CheckMessages(msgs, true)
+}
+
 func (m *MockBackend) Reset() {
 	m.messages = make([]llm.Message, 0)
 	m.lastTokens = 0
 	m.totalTokens = 0
+	m.lastThinking = ""
+	m.lastMeta = llm.Meta{}
 }
 
-func (m *MockBackend) Ask(ctx context.Context, prompt string) (string, error) {
+func (m *MockBackend) Ask(ctx context.Context, prompt string, attachments ...llm.Attachment) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if m.askError != nil {
 		return "", m.askError
 	}
-	m.messages = append(m.messages, llm.Message{Role: "user", Content: prompt})
+	m.messages = append(m.messages, llm.Message{Role: "user", Content: prompt, Attachments: attachments})
 	m.messages = append(m.messages, llm.Message{Role: "assistant", Content: m.askResponse})
 	m.lastTokens = len(prompt) + len(m.askResponse)
 	m.totalTokens += m.lastTokens
+	m.lastThinking = m.askThinking
+	m.lastMeta = m.askMeta
 	return m.askResponse, nil
 }
 
 func (m *MockBackend) AskWithHistory(ctx context.Context, history []llm.Message, prompt string) (string, int, error) {
+	reply, err := m.Complete(ctx, history, prompt)
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.Text, reply.Tokens, nil
+}
+
+func (m *MockBackend) Complete(ctx context.Context, history []llm.Message, prompt string, attachments ...llm.Attachment) (*llm.Reply, error) {
 	if m.askError != nil {
-		return "", 0, m.askError
+		return nil, m.askError
 	}
 	tokens := len(prompt) + len(m.askResponse)
-	return m.askResponse, tokens, nil
+	return &llm.Reply{Text: m.askResponse, Thinking: m.askThinking, Tokens: tokens, Meta: m.askMeta}, nil
 }
 
-func (m *MockBackend) StartStream(ctx context.Context, prompt string) error {
+func (m *MockBackend) StartStream(ctx context.Context, prompt string, attachments ...llm.Attachment) error {
 	return fmt.Errorf("streaming not implemented in mock")
 }
 
@@ -117,5 +214,15 @@ func (m *MockBackend) IsStreaming() bool {
 
 func (m *MockBackend) WaitStream() {}
 
+func (m *MockBackend) ReadThinking(ctx context.Context, offset int64) (string, bool) {
+	return "", false
+}
+
+func (m *MockBackend) Retrier() *llm.Retrier { return m.retrier }
+
+func (m *MockBackend) Toolbox() *llm.Toolbox { return m.tools }
+
+func (m *MockBackend) Schema() *llm.Schema { return m.schema }
+
 // Verify MockBackend implements Backend
 var _ llm.Backend = (*MockBackend)(nil)
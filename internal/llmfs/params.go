@@ -0,0 +1,71 @@
+package llmfs
+
+import (
+	"io"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// NewParamsDir creates the params directory with one file per generation
+// parameter the backend supports, so listing it shows what can be set.
+func NewParamsDir(client llm.Backend) *protocol.StaticDir {
+	dir := protocol.NewStaticDir("params")
+	for _, name := range client.SupportedParams() {
+		dir.AddChild(NewParamFile(client, name))
+	}
+	return dir
+}
+
+// ParamFile exposes one generation parameter (read/write)
+// Reading returns the value, or nothing when the backend default is used.
+// Writing sets it; an empty write restores the default. The stop file
+// takes one stop sequence per line.
+type ParamFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+	name   string
+}
+
+// NewParamFile creates the file for the named parameter
+func NewParamFile(client llm.Backend, name string) *ParamFile {
+	return &ParamFile{
+		BaseFile: protocol.NewBaseFile(name, 0666),
+		client:   client,
+		name:     name,
+	}
+}
+
+func (f *ParamFile) content() string {
+	value := f.client.Params().Get(f.name)
+	if value != "" {
+		value += "\n"
+	}
+	return value
+}
+
+func (f *ParamFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *ParamFile) Write(p []byte, offset int64) (int, error) {
+	params := f.client.Params()
+	if err := params.Set(f.name, string(p)); err != nil {
+		return 0, err
+	}
+	if err := f.client.SetParams(params); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *ParamFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
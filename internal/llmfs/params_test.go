@@ -0,0 +1,54 @@
+package llmfs
+
+import (
+	"io"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestParamsDir(t *testing.T) {
+	mock := NewMockBackend()
+	root := NewRoot(mock)
+
+	// Only the parameters the backend supports are listed
+	params := walkTo(t, root, "params").(protocol.Dir)
+	var names []string
+	for _, f := range params.Children() {
+		names = append(names, f.Stat().Name)
+	}
+	if len(names) != 3 || names[0] != "max_tokens" || names[2] != "stop" {
+		t.Errorf("params/ = %v, want the mock's supported params", names)
+	}
+	if _, err := params.Lookup("num_ctx"); err == nil {
+		t.Error("unsupported parameter should not be listed")
+	}
+
+	maxTokens := walkTo(t, root, "params", "max_tokens")
+	buf := make([]byte, 100)
+	if _, err := maxTokens.Read(buf, 0); err != io.EOF {
+		t.Errorf("unset parameter read error = %v, want EOF", err)
+	}
+	if _, err := maxTokens.Write([]byte("1000\n"), 0); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	n, _ := maxTokens.Read(buf, 0)
+	if string(buf[:n]) != "1000\n" || mock.params.MaxTokens != 1000 {
+		t.Errorf("max_tokens = %q (backend %d)", buf[:n], mock.params.MaxTokens)
+	}
+	if _, err := maxTokens.Write([]byte("lots"), 0); err == nil {
+		t.Error("invalid value should be rejected")
+	}
+
+	stop := walkTo(t, root, "params", "stop")
+	stop.Write([]byte("END\n###\n"), 0)
+	if got := mock.params.Stop; len(got) != 2 || got[1] != "###" {
+		t.Errorf("stop = %q", got)
+	}
+
+	// An empty write restores the default
+	maxTokens.Write([]byte("\n"), 0)
+	if mock.params.MaxTokens != 0 || maxTokens.Stat().Length != 0 {
+		t.Errorf("max_tokens after reset = %d", mock.params.MaxTokens)
+	}
+}
@@ -0,0 +1,96 @@
+package llmfs
+
+import (
+	"context"
+	"io"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// ReasoningFile exposes the model's thinking for the last response (read-only)
+// Empty when thinking is off or the backend does not return it.
+type ReasoningFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+}
+
+// NewReasoningFile creates the reasoning file
+func NewReasoningFile(client llm.Backend) *ReasoningFile {
+	return &ReasoningFile{
+		BaseFile: protocol.NewBaseFile("reasoning", 0444),
+		client:   client,
+	}
+}
+
+func (f *ReasoningFile) Read(p []byte, offset int64) (int, error) {
+	content := f.client.LastThinking()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *ReasoningFile) Write(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+func (f *ReasoningFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.client.LastThinking()))
+	return s
+}
+
+// StreamThinkingFile provides the thinking of the current stream
+// Reading blocks until more thinking arrives or the stream ends, and
+// returns EOF at the end. Unlike stream/chunk it is read by offset, so
+// it can be read alongside, after, or not at all without stalling chunks.
+// Flushing a blocked read cancels it.
+type StreamThinkingFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+}
+
+var _ protocol.ContextAwareFile = (*StreamThinkingFile)(nil)
+
+// NewStreamThinkingFile creates the stream/thinking file
+func NewStreamThinkingFile(client llm.Backend) *StreamThinkingFile {
+	return &StreamThinkingFile{
+		BaseFile: protocol.NewBaseFile("thinking", 0444),
+		client:   client,
+	}
+}
+
+func (f *StreamThinkingFile) Read(p []byte, offset int64) (int, error) {
+	return f.ReadContext(context.Background(), p, offset)
+}
+
+func (f *StreamThinkingFile) Write(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+// ReadContext implements protocol.ContextAwareFile
+func (f *StreamThinkingFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	text, ok := f.client.ReadThinking(ctx, offset)
+	if !ok {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, text)
+	return n, nil
+}
+
+// WriteContext implements protocol.ContextAwareFile
+func (f *StreamThinkingFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	return f.Write(p, offset)
+}
+
+func (f *StreamThinkingFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	// Length is unknown for streaming
+	s.Length = 0
+	return s
+}
@@ -0,0 +1,71 @@
+package llmfs
+
+import (
+	"io"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestReasoningFile(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewReasoningFile(mock)
+
+	buf := make([]byte, 100)
+	if _, err := f.Read(buf, 0); err != io.EOF {
+		t.Errorf("empty reasoning read error = %v, want EOF", err)
+	}
+
+	mock.askResponse = "4"
+	mock.askThinking = "add them"
+	NewAskFile(mock).Write([]byte("2+2?"), 0)
+
+	n, _ := f.Read(buf, 0)
+	if got := string(buf[:n]); got != "add them" {
+		t.Errorf("reasoning = %q, want %q", got, "add them")
+	}
+	if got := f.Stat().Length; got != uint64(len("add them")) {
+		t.Errorf("Stat().Length = %d", got)
+	}
+	if _, err := f.Write([]byte("x"), 0); err != protocol.ErrPermission {
+		t.Errorf("Write() error = %v, want ErrPermission", err)
+	}
+}
+
+func TestStreamThinkingFile_Conversation(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "4"
+	mock.askThinking = "add them"
+	root := NewRoot(mock)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+
+	if _, err := walkTo(t, root, "0", "stream", "ask").Write([]byte("2+2?"), 0); err != nil {
+		t.Fatalf("stream ask error: %v", err)
+	}
+
+	thinking := walkTo(t, root, "0", "stream", "thinking")
+	buf := make([]byte, 100)
+	var got []byte
+	for {
+		n, err := thinking.Read(buf, int64(len(got)))
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("stream thinking read error: %v", err)
+		}
+	}
+	if string(got) != "add them" {
+		t.Errorf("stream/thinking = %q, want %q", got, "add them")
+	}
+
+	n, _ := walkTo(t, root, "0", "reasoning").Read(buf, 0)
+	if string(buf[:n]) != "add them" {
+		t.Errorf("conversation reasoning = %q, want %q", buf[:n], "add them")
+	}
+	if mock.LastThinking() != "" {
+		t.Error("conversation thinking leaked into the shared conversation")
+	}
+}
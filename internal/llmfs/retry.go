@@ -0,0 +1,165 @@
+package llmfs
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// RetryFile exposes the backend's retry policy (read/write).
+// Read returns one "key value" line per setting; write any of them:
+//
+//	attempts 4     total attempts per request (1 disables retries)
+//	elapsed 2m     give up rather than wait past this (0 = no limit)
+//	base 1s        first backoff delay, doubled per retry
+//	max 30s        cap on a single backoff delay
+//	off            same as "attempts 1"
+type RetryFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+}
+
+// NewRetryFile creates the retry file
+func NewRetryFile(client llm.Backend) *RetryFile {
+	return &RetryFile{
+		BaseFile: protocol.NewBaseFile("retry", 0666),
+		client:   client,
+	}
+}
+
+func (f *RetryFile) content() string {
+	p := f.client.Retrier().Policy()
+	return fmt.Sprintf("attempts %d\nelapsed %s\nbase %s\nmax %s\n",
+		p.MaxAttempts, p.MaxElapsed, p.BaseDelay, p.MaxDelay)
+}
+
+func (f *RetryFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *RetryFile) Write(p []byte, offset int64) (int, error) {
+	retrier := f.client.Retrier()
+	policy := retrier.Policy()
+
+	for _, line := range strings.Split(string(p), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 && fields[0] == "off" {
+			policy.MaxAttempts = 1
+			continue
+		}
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("usage: attempts N | elapsed D | base D | max D | off")
+		}
+
+		key, value := fields[0], fields[1]
+		if key == "attempts" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, fmt.Errorf("invalid attempts: %w", err)
+			}
+			policy.MaxAttempts = n
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		switch key {
+		case "elapsed":
+			policy.MaxElapsed = d
+		case "base":
+			policy.BaseDelay = d
+		case "max":
+			policy.MaxDelay = d
+		default:
+			return 0, fmt.Errorf("unknown retry setting: %s", key)
+		}
+	}
+
+	if err := retrier.SetPolicy(policy); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (f *RetryFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
+
+// StatusFile reports how the most recent request went, including
+// retries in progress and the last error (read-only), e.g.
+//
+//	state retrying
+//	attempts 2
+//	error Ollama API error: HTTP 503: loading model
+//	retryable true
+//	delay 2.1s
+//	updated 2025-01-02T15:04:05Z
+type StatusFile struct {
+	*protocol.BaseFile
+	client llm.Backend
+}
+
+// NewStatusFile creates the status file
+func NewStatusFile(client llm.Backend) *StatusFile {
+	return &StatusFile{
+		BaseFile: protocol.NewBaseFile("status", 0444),
+		client:   client,
+	}
+}
+
+func (f *StatusFile) content() string {
+	st := f.client.Retrier().Status()
+	if st.State == llm.RetryIdle {
+		return fmt.Sprintf("state %s\n", st.State)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "state %s\n", st.State)
+	fmt.Fprintf(&b, "attempts %d\n", st.Attempts)
+	if st.LastError != "" {
+		// Keep the error on one line so the file stays line-oriented
+		fmt.Fprintf(&b, "error %s\n", strings.Join(strings.Fields(st.LastError), " "))
+		fmt.Fprintf(&b, "retryable %t\n", st.Retryable)
+	}
+	if st.RetryAfter > 0 {
+		fmt.Fprintf(&b, "delay %s\n", st.RetryAfter.Round(time.Millisecond))
+	}
+	fmt.Fprintf(&b, "updated %s\n", st.Updated.UTC().Format(time.RFC3339))
+	return b.String()
+}
+
+func (f *StatusFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *StatusFile) Write(p []byte, offset int64) (int, error) {
+	return 0, protocol.ErrPermission
+}
+
+func (f *StatusFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
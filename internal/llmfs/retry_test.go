@@ -0,0 +1,64 @@
+package llmfs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+)
+
+func TestRetryFile_ReadWrite(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewRetryFile(mock)
+
+	buf := make([]byte, 256)
+	n, _ := f.Read(buf, 0)
+	if got := string(buf[:n]); got != "attempts 4\nelapsed 2m0s\nbase 1s\nmax 30s\n" {
+		t.Errorf("Read() = %q", got)
+	}
+
+	if _, err := f.Write([]byte("attempts 6\nbase 250ms\n"), 0); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	p := mock.Retrier().Policy()
+	if p.MaxAttempts != 6 || p.BaseDelay != 250*time.Millisecond || p.MaxDelay != 30*time.Second {
+		t.Errorf("policy after write = %+v", p)
+	}
+
+	if _, err := f.Write([]byte("off"), 0); err != nil || mock.Retrier().Policy().MaxAttempts != 1 {
+		t.Errorf("off: err %v, attempts %d; want 1", err, mock.Retrier().Policy().MaxAttempts)
+	}
+
+	for _, bad := range []string{"attempts 0", "base soon", "max 1ms", "jitter 1s", "attempts"} {
+		if _, err := f.Write([]byte(bad), 0); err == nil {
+			t.Errorf("Write(%q) should fail", bad)
+		}
+	}
+}
+
+func TestStatusFile(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewStatusFile(mock)
+
+	buf := make([]byte, 512)
+	n, _ := f.Read(buf, 0)
+	if got := string(buf[:n]); got != "state idle\n" {
+		t.Errorf("Read() before any request = %q", got)
+	}
+
+	mock.Retrier().SetPolicy(llm.RetryPolicy{MaxAttempts: 1})
+	mock.Retrier().Do(context.Background(), func(ctx context.Context) error {
+		return errors.New("bad\nrequest")
+	})
+
+	n, _ = f.Read(buf, 0)
+	got := string(buf[:n])
+	for _, want := range []string{"state failed\n", "attempts 1\n", "error bad request\n", "retryable false\n", "updated "} {
+		if !strings.Contains(got, want) {
+			t.Errorf("status missing %q:\n%s", want, got)
+		}
+	}
+}
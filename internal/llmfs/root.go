@@ -2,19 +2,91 @@
 package llmfs
 
 import (
+	"fmt"
+	"log"
+
 	"github.com/NERVsystems/llm9p/internal/llm"
 	"github.com/NERVsystems/llm9p/internal/protocol"
 )
 
+// Options configures the filesystem built by NewRootWithOptions.
+type Options struct {
+	// IsolateAsk gives every open fid on ask its own conversation
+	// (see IsolatedAskFile). Clients can also choose per mount with
+	// the aname "isolate", or "shared" for the single conversation.
+	IsolateAsk bool
+
+	// Store, if set, keeps the shared conversation and the numbered
+	// ones, which are restored from it when the filesystem is created
+	Store llm.Store
+
+	// Prices are what the cost file charges for tokens (default
+	// llm.DefaultPrices)
+	Prices llm.PriceTable
+
+	// IndexDir, if set, is the directory in which the vector indexes
+	// are kept, and from which they are loaded
+	IndexDir string
+
+	// ToolDir, if set, holds the executables tools may run, which a
+	// definition names by file name. Without it, tools are answered
+	// through their calls files only.
+	ToolDir string
+}
+
+// sharedConversation is the store ID of the conversation on /ask
+const sharedConversation = "shared"
+
 // NewRoot creates the root directory of the LLM filesystem.
 // It takes a Backend which provides access to the LLM.
 func NewRoot(client llm.Backend) protocol.Dir {
-	root := protocol.NewStaticDir("llm")
+	return NewRootWithOptions(client, Options{})
+}
+
+// NewRootWithOptions creates the root directory with the given options.
+func NewRootWithOptions(client llm.Backend, opts Options) protocol.Dir {
+	// Embedding is optional, and the wrappers below do not pass it on
+	embedder, _ := client.(llm.Embedder)
+	if opts.Store != nil {
+		stored, err := llm.NewStoredBackend(client, opts.Store, sharedConversation)
+		if err != nil {
+			log.Printf("llm9p: shared conversation not restored: %v", err)
+		} else {
+			client = stored
+		}
+	}
+	sessions := llm.NewSessionManager(client)
+	if opts.Store != nil {
+		sessions.SetStore(opts.Store)
+	}
+	if opts.Prices == nil {
+		opts.Prices = llm.DefaultPrices
+	}
+	convs := newConversations(sessions, opts.Prices)
+	if err := convs.restore(); err != nil {
+		log.Printf("llm9p: conversations not restored: %v", err)
+	}
+	root := &rootDir{
+		StaticDir: protocol.NewStaticDir("llm"),
+		convs:     convs,
+	}
 
-	// Core interaction files
-	root.AddChild(NewAskFile(client))
+	// Numbered conversations
+	root.AddChild(newCloneFile(convs))
+
+	// Core interaction files; ask and stream/ask send the files staged
+	// in attach/
+	attach := NewAttachDir()
+	ask := NewAskFile(client)
+	ask.attach = attach
+	root.AddChild(ask)
+	root.AddChild(attach)
+	root.AddChild(newSharedCtlFile(convs, ask))
 	root.AddChild(NewNewFile(client))
 	root.AddChild(NewContextFile(client))
+	root.AddChild(NewMessagesDir(client))
+	root.AddChild(NewExportDir(client))
+	root.AddChild(NewImportFile(client))
 
 	// Settings files
 	root.AddChild(NewModelFile(client))
@@ -22,20 +94,108 @@ func NewRoot(client llm.Backend) protocol.Dir {
 	root.AddChild(NewSystemFile(client))
 	root.AddChild(NewThinkingFile(client))
 	root.AddChild(NewPrefillFile(client))
+	root.AddChild(NewParamsDir(client))
+	root.AddChild(NewContinueFile(client))
+	root.AddChild(NewCacheFile(client))
+	root.AddChild(NewSchemaFile(client.Schema()))
+
+	// Tools, unless the backend runs its own
+	if tools := client.Toolbox(); tools != nil {
+		toolsDir := NewToolsDir(tools)
+		toolsDir.ctl.execDir = opts.ToolDir
+		root.AddChild(toolsDir)
+	}
+
+	// Embeddings, with a model of their own, and the indexes searched
+	// with them
+	root.AddChild(NewEmbedDir(embedder))
+	indexes, err := llm.NewIndexSet(opts.IndexDir, embedder)
+	if err != nil {
+		log.Printf("llm9p: indexes not all loaded: %v", err)
+	}
+	root.AddChild(NewIndexDir(indexes))
+
+	// Details of the last response
+	root.AddChild(NewReasoningFile(client))
+	root.AddChild(NewMetaFile(client))
 
 	// Token tracking
 	root.AddChild(NewTokensFile(client))
 	root.AddChild(NewUsageFile(client))
+	root.AddChild(NewTokenizeFile(client))
+	root.AddChild(NewCostFile(client, opts.Prices))
 	root.AddChild(NewCompactFile(client))
 
+	// Error handling
+	root.AddChild(NewRetryFile(client))
+	root.AddChild(NewStatusFile(client))
+
 	// Static files
 	root.AddChild(NewExampleFile())
 
 	// Stream directory
 	streamDir := protocol.NewStaticDir("stream")
-	streamDir.AddChild(NewStreamAskFile(client))
-	streamDir.AddChild(NewChunkFile(client))
+	streamAsk := NewStreamAskFile(client)
+	streamAsk.attach = attach
+	streamDir.AddChild(streamAsk)
+	chunk := NewChunkFile(client)
+	chunk.stream = streamAsk
+	streamDir.AddChild(chunk)
+	streamDir.AddChild(NewStreamThinkingFile(client))
 	root.AddChild(streamDir)
 
+	// The isolated view is the same tree with a per-fid ask file
+	isolated := &rootDir{
+		StaticDir: protocol.NewStaticDir("llm"),
+		convs:     convs,
+	}
+	for _, f := range root.StaticDir.Children() {
+		isolated.AddChild(f)
+	}
+	isolatedAsk := NewIsolatedAskFile(llm.NewSessionManager(client))
+	isolatedAsk.attach = attach
+	isolated.AddChild(isolatedAsk)
+
+	views := map[string]*rootDir{"shared": root, "isolate": isolated}
+	root.views, isolated.views = views, views
+	if opts.IsolateAsk {
+		return isolated
+	}
 	return root
 }
+
+// rootDir is the static root plus the live /N/ conversation directories
+type rootDir struct {
+	*protocol.StaticDir
+	convs *conversations
+	views map[string]*rootDir // by aname
+}
+
+// Attach implements protocol.Attacher - the aname selects the view
+func (d *rootDir) Attach(uname, aname string) (protocol.Dir, error) {
+	if aname == "" {
+		return d, nil
+	}
+	if v, ok := d.views[aname]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("unknown aname %q (use \"shared\" or \"isolate\")", aname)
+}
+
+func (d *rootDir) Children() []protocol.File {
+	return append(d.StaticDir.Children(), d.convs.dirs()...)
+}
+
+func (d *rootDir) Lookup(name string) (protocol.File, error) {
+	if f, err := d.StaticDir.Lookup(name); err == nil {
+		return f, nil
+	}
+	if c, ok := d.convs.lookup(name); ok {
+		return c.dir, nil
+	}
+	return nil, protocol.ErrNotFound
+}
+
+func (d *rootDir) Read(p []byte, offset int64) (int, error) {
+	return protocol.ReadDir(d.Children(), p, offset)
+}
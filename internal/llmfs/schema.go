@@ -0,0 +1,93 @@
+package llmfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// SchemaFile holds the JSON Schema that answers must match (read/write).
+// A write, committed on clunk over 9P, is either the schema, a JSON
+// object, or lines of commands:
+//
+//	retries N   send an answer that does not match back N times to be fixed
+//	off         answer freely again (as does an empty write)
+//
+// Reading shows the retry limit and the schema, if one is set.
+type SchemaFile struct {
+	*protocol.BaseFile
+	*commitOnClunk
+	schema *llm.Schema
+}
+
+// NewSchemaFile creates the schema file
+func NewSchemaFile(schema *llm.Schema) *SchemaFile {
+	f := &SchemaFile{
+		BaseFile: protocol.NewBaseFile("schema", 0666),
+		schema:   schema,
+	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
+}
+
+func (f *SchemaFile) content() string {
+	content := fmt.Sprintf("retries %d\n", f.schema.Retries())
+	if raw := f.schema.Get(); raw != nil {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, raw, "", "  "); err != nil {
+			return content + string(raw) + "\n"
+		}
+		content += indented.String() + "\n"
+	}
+	return content
+}
+
+func (f *SchemaFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *SchemaFile) Write(p []byte, offset int64) (int, error) {
+	text := strings.TrimSpace(string(p))
+	if text == "" || strings.HasPrefix(text, "{") {
+		if err := f.schema.Set(json.RawMessage(text)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case len(fields) == 1 && fields[0] == "off":
+			f.schema.Set(nil)
+		case len(fields) == 2 && fields[0] == "retries":
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, fmt.Errorf("retries: %q is not a number", fields[1])
+			}
+			if err := f.schema.SetRetries(n); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("schema: unknown command %q (write a JSON Schema, retries N or off)", line)
+		}
+	}
+	return len(p), nil
+}
+
+func (f *SchemaFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
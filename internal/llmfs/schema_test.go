@@ -0,0 +1,62 @@
+package llmfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+)
+
+func TestSchemaFile(t *testing.T) {
+	mock := NewMockBackend()
+	root := NewRoot(mock)
+	f := walkTo(t, root, "schema").(*SchemaFile)
+
+	if got := readAll(t, f); got != fmt.Sprintf("retries %d\n", llm.DefaultSchemaRetries) {
+		t.Errorf("empty schema = %q", got)
+	}
+
+	// A schema written in pieces is set on clunk
+	schema := `{"type": "object", "required": ["city"]}`
+	f.WriteFid(1, []byte(schema[:10]), 0)
+	f.WriteFid(1, []byte(schema[10:]), 10)
+	if mock.schema.Get() != nil {
+		t.Error("schema set before clunk")
+	}
+	if err := f.CloseFid(1); err != nil {
+		t.Fatalf("clunk error: %v", err)
+	}
+	if _, err := f.Write([]byte("retries 4\n"), 0); err != nil {
+		t.Fatalf("retries error: %v", err)
+	}
+	want := "retries 4\n{\n  \"type\": \"object\",\n  \"required\": [\n    \"city\"\n  ]\n}\n"
+	if got := readAll(t, f); got != want {
+		t.Errorf("schema = %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{`{"type": "float"}`, "retries 99", "retries x", "strict"} {
+		if _, err := f.Write([]byte(bad), 0); err == nil {
+			t.Errorf("Write(%q) should fail", bad)
+		}
+	}
+
+	if _, err := f.Write([]byte("off\n"), 0); err != nil || mock.schema.Get() != nil {
+		t.Errorf("off left %s (error %v)", mock.schema.Get(), err)
+	}
+}
+
+func TestAskFile_SchemaError(t *testing.T) {
+	mock := NewMockBackend()
+	ask := NewAskFile(mock)
+	mock.askError = fmt.Errorf("API error: %w", &llm.SchemaError{Reason: "(root): missing required property \"city\"", Attempts: 3, Response: "{}"})
+	ask.Write([]byte("Weather?"), 0)
+
+	var got llm.SchemaError
+	if err := json.Unmarshal([]byte(readAll(t, ask)), &got); err != nil {
+		t.Fatalf("ask should read as JSON: %v", err)
+	}
+	if got.Attempts != 3 || got.Response != "{}" || got.Reason == "" {
+		t.Errorf("error = %+v", got)
+	}
+}
@@ -0,0 +1,53 @@
+package llmfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+func TestRoot_StoreSurvivesRestart(t *testing.T) {
+	store, err := llm.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	mock := NewMockBackend()
+	mock.askResponse = "pong"
+	root := NewRootWithOptions(mock, Options{Store: store})
+	walkTo(t, root, "ask").Write([]byte("shared ping"), 0)
+	clone := walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR) // 0
+	clone.OpenFid(2, protocol.ORDWR) // 1
+	walkTo(t, root, "1", "ask").Write([]byte("ping one"), 0)
+	clone.CloseFid(1) // 0 is transient: gone, and out of the store
+
+	// A new server over the same store
+	restarted := NewMockBackend()
+	root = NewRootWithOptions(restarted, Options{Store: store})
+
+	if msgs := restarted.Messages(); len(msgs) != 2 || msgs[0].Content != "shared ping" {
+		t.Errorf("shared conversation restored as %v", msgs)
+	}
+	if _, err := root.Lookup("0"); err == nil {
+		t.Error("conversation 0 was removed and should not come back")
+	}
+	buf := make([]byte, 4096)
+	n, _ := walkTo(t, root, "1", "context").Read(buf, 0)
+	if !strings.Contains(string(buf[:n]), "ping one") {
+		t.Errorf("conversation 1 context = %s", buf[:n])
+	}
+
+	// The restored conversation stays until hung up; new ones come after it
+	clone = walkTo(t, root, "clone").(*CloneFile)
+	clone.OpenFid(1, protocol.ORDWR)
+	if got := readFid(t, clone, 1); got != "2\n" {
+		t.Errorf("clone after restore = %q, want %q", got, "2\n")
+	}
+	walkTo(t, root, "1", "ctl").Write([]byte("hangup"), 0)
+	if ids, _ := store.List(); strings.Join(ids, " ") != "shared" {
+		t.Errorf("store after hangup holds %v", ids)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/NERVsystems/llm9p/internal/llm"
 	"github.com/NERVsystems/llm9p/internal/protocol"
@@ -11,10 +12,12 @@ import (
 
 // ChunkFile provides streaming access to LLM responses
 // Reading blocks until the next chunk is available, then returns it
-// Returns EOF when the stream is complete
+// Returns EOF when the stream is complete. Flushing a blocked read
+// (e.g. Ctrl-C) stops the stream.
 type ChunkFile struct {
 	*protocol.BaseFile
 	client llm.Backend
+	stream *StreamAskFile // stops the stream, if set
 }
 
 // NewChunkFile creates the stream/chunk file
@@ -26,14 +29,28 @@ func NewChunkFile(client llm.Backend) *ChunkFile {
 }
 
 func (f *ChunkFile) Read(p []byte, offset int64) (int, error) {
+	return f.ReadContext(context.Background(), p, offset)
+}
+
+// ReadContext implements protocol.ContextAwareFile. The stream ends
+// when it is stopped, so a flushed read stops it and returns.
+func (f *ChunkFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
 	// If no stream is active, return EOF
 	if !f.client.IsStreaming() {
 		return 0, io.EOF
 	}
 
+	if f.stream != nil {
+		stop := context.AfterFunc(ctx, f.stream.stop)
+		defer stop()
+	}
+
 	// Block until we get a chunk
 	chunk, ok := f.client.ReadStreamChunk()
 	if !ok {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
 		// Stream ended
 		return 0, io.EOF
 	}
@@ -47,6 +64,11 @@ func (f *ChunkFile) Write(p []byte, offset int64) (int, error) {
 	return 0, protocol.ErrPermission
 }
 
+// WriteContext implements protocol.ContextAwareFile
+func (f *ChunkFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	return f.Write(p, offset)
+}
+
 func (f *ChunkFile) Stat() protocol.Stat {
 	s := f.BaseFile.Stat()
 	// Length is unknown for streaming
@@ -55,18 +77,29 @@ func (f *ChunkFile) Stat() protocol.Stat {
 }
 
 // StreamAskFile starts a streaming request
-// Write a prompt to start streaming, then read chunks from stream/chunk
+// Write a prompt to start streaming, then read chunks from stream/chunk.
+// Over 9P the stream starts once the whole prompt is written and clunked.
+// The stream outlives the clunk; it is stopped by flushing the clunk
+// before the stream has started, or a read of stream/chunk after.
 type StreamAskFile struct {
 	*protocol.BaseFile
+	*commitOnClunk
 	client llm.Backend
+	attach *AttachDir // files for the next prompt, if any
+	mu     sync.Mutex
+	cancel context.CancelFunc // stops the last stream started
 }
 
+var _ protocol.ContextFidAwareFile = (*StreamAskFile)(nil)
+
 // NewStreamAskFile creates the stream/ask file
 func NewStreamAskFile(client llm.Backend) *StreamAskFile {
-	return &StreamAskFile{
+	f := &StreamAskFile{
 		BaseFile: protocol.NewBaseFile("ask", 0222), // write-only
 		client:   client,
 	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
 }
 
 func (f *StreamAskFile) Read(p []byte, offset int64) (int, error) {
@@ -74,21 +107,71 @@ func (f *StreamAskFile) Read(p []byte, offset int64) (int, error) {
 }
 
 func (f *StreamAskFile) Write(p []byte, offset int64) (int, error) {
+	return f.WriteContext(context.Background(), p, offset)
+}
+
+// WriteContext implements protocol.ContextAwareFile. The stream keeps
+// the values of ctx but not its cancellation once it has started, as
+// the request that starts it ends before the stream does.
+func (f *StreamAskFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
 	prompt := strings.TrimSpace(string(p))
 	if prompt == "" {
 		return len(p), nil
 	}
 
 	// Start streaming - chunks will be available via stream/chunk
-	err := f.client.StartStream(context.Background(), prompt)
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stopStart := context.AfterFunc(ctx, cancel)
+	attachments := f.attach.take()
+	err := f.client.StartStream(streamCtx, prompt, attachments...)
+	stopStart()
 	if err != nil {
+		cancel()
+		f.attach.restore(attachments)
 		// Return error to indicate stream failed to start
 		return 0, err
 	}
 
+	f.mu.Lock()
+	if f.cancel != nil {
+		f.cancel() // that stream has ended, or this one could not start
+	}
+	f.cancel = cancel
+	f.mu.Unlock()
 	return len(p), nil
 }
 
+// stop cancels the last stream started, if it is still running
+func (f *StreamAskFile) stop() {
+	f.mu.Lock()
+	cancel := f.cancel
+	f.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// ReadFidContext implements protocol.ContextFidAwareFile
+func (f *StreamAskFile) ReadFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return f.ReadFid(fid, p, offset)
+}
+
+// WriteFidContext implements protocol.ContextFidAwareFile - buffers until clunk
+func (f *StreamAskFile) WriteFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return f.WriteFid(fid, p, offset)
+}
+
+// CloseFidContext implements protocol.ContextFidAwareFile - starts the
+// stream with the buffered prompt
+func (f *StreamAskFile) CloseFidContext(ctx context.Context, fid uint32) error {
+	data, ok := f.pending.take(fid)
+	if !ok {
+		return nil
+	}
+	_, err := f.WriteContext(ctx, data, 0)
+	return err
+}
+
 func (f *StreamAskFile) Stat() protocol.Stat {
 	return f.BaseFile.Stat()
 }
@@ -0,0 +1,67 @@
+package llmfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+)
+
+// blockingStream streams nothing until its context is cancelled
+type blockingStream struct {
+	*MockBackend
+	ctx    context.Context
+	chunks chan string
+}
+
+func (b *blockingStream) StartStream(ctx context.Context, prompt string, attachments ...llm.Attachment) error {
+	b.ctx = ctx
+	b.chunks = make(chan string)
+	go func() {
+		<-ctx.Done()
+		close(b.chunks)
+	}()
+	return nil
+}
+
+func (b *blockingStream) ReadStreamChunk() (string, bool) {
+	chunk, ok := <-b.chunks
+	return chunk, ok
+}
+
+func (b *blockingStream) IsStreaming() bool {
+	return b.chunks != nil
+}
+
+func TestStream_FlushedChunkReadStops(t *testing.T) {
+	backend := &blockingStream{MockBackend: NewMockBackend()}
+	ask := NewStreamAskFile(backend)
+	chunk := NewChunkFile(backend)
+	chunk.stream = ask
+
+	// The stream outlives the clunk that starts it
+	ctx, cancel := context.WithCancel(context.Background())
+	ask.WriteFid(1, []byte("count"), 0)
+	if err := ask.CloseFidContext(ctx, 1); err != nil {
+		t.Fatalf("CloseFidContext() error = %v", err)
+	}
+	cancel()
+	if err := backend.ctx.Err(); err != nil {
+		t.Fatalf("stream stopped with the clunk: %v", err)
+	}
+
+	// Flushing a blocked chunk read stops it
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := chunk.ReadContext(ctx, make([]byte, 100), 0)
+		done <- err
+	}()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("flushed ReadContext() error = %v, want context.Canceled", err)
+	}
+	if backend.ctx.Err() == nil {
+		t.Error("flushed chunk read did not stop the stream")
+	}
+}
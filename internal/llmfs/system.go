@@ -8,18 +8,22 @@ import (
 	"github.com/NERVsystems/llm9p/internal/protocol"
 )
 
-// SystemFile exposes the system prompt (read/write)
+// SystemFile exposes the system prompt (read/write).
+// Over 9P the prompt is assembled from all writes and set on clunk.
 type SystemFile struct {
 	*protocol.BaseFile
+	*commitOnClunk
 	client llm.Backend
 }
 
 // NewSystemFile creates the system file
 func NewSystemFile(client llm.Backend) *SystemFile {
-	return &SystemFile{
+	f := &SystemFile{
 		BaseFile: protocol.NewBaseFile("system", 0666),
 		client:   client,
 	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
 }
 
 func (f *SystemFile) Read(p []byte, offset int64) (int, error) {
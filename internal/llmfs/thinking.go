@@ -12,7 +12,9 @@ import (
 
 // ThinkingFile exposes the thinking token budget (read/write)
 // Values: -1 = max (31999), 0 = disabled, >0 = specific budget
-// Only effective with CLI backend; API backend ignores this setting.
+// The API backend raises budgets below 1024 to that minimum; Ollama has no
+// budget and asks reasoning models to think for any non-zero value.
+// The resulting thinking is in reasoning and stream/thinking.
 type ThinkingFile struct {
 	*protocol.BaseFile
 	client llm.Backend
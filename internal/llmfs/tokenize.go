@@ -0,0 +1,68 @@
+package llmfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// TokenizeFile counts tokens for the current model - write text, read
+// back "N method", where method is how the backend counted it (see
+// llm.TokenCount). Over 9P the text is assembled from all writes and
+// counted on clunk. The conversation is not touched.
+type TokenizeFile struct {
+	*protocol.BaseFile
+	*commitOnClunk
+	client llm.Backend
+	mu     sync.RWMutex
+	last   string // the last count, as read
+}
+
+// NewTokenizeFile creates the tokenize file
+func NewTokenizeFile(client llm.Backend) *TokenizeFile {
+	f := &TokenizeFile{
+		BaseFile: protocol.NewBaseFile("tokenize", 0666),
+		client:   client,
+	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
+}
+
+func (f *TokenizeFile) content() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.last
+}
+
+func (f *TokenizeFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *TokenizeFile) Write(p []byte, offset int64) (int, error) {
+	// Verbatim but for the newline echo adds
+	text := strings.TrimSuffix(string(p), "\n")
+	count, err := f.client.CountTokens(context.Background(), text)
+	if err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	f.last = fmt.Sprintf("%d %s\n", count.Tokens, count.Method)
+	f.mu.Unlock()
+	return len(p), nil
+}
+
+func (f *TokenizeFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
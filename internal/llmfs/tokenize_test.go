@@ -0,0 +1,35 @@
+package llmfs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTokenizeFile(t *testing.T) {
+	mock := NewMockBackend()
+	f := NewTokenizeFile(mock)
+
+	if got := readAll(t, f); got != "" {
+		t.Errorf("tokenize before any write = %q", got)
+	}
+
+	f.WriteFid(1, []byte("Hello, "), 0)
+	f.WriteFid(1, []byte("world!\n"), 7)
+	if err := f.CloseFid(1); err != nil {
+		t.Fatalf("clunk error: %v", err)
+	}
+	if got := readAll(t, f); got != "4 estimate\n" {
+		t.Errorf("tokenize = %q, want %q", got, "4 estimate\n")
+	}
+	if f.Stat().Length != uint64(len("4 estimate\n")) {
+		t.Errorf("Stat().Length = %d", f.Stat().Length)
+	}
+	if len(mock.Messages()) != 0 {
+		t.Error("tokenize changed the conversation")
+	}
+
+	mock.askError = fmt.Errorf("unreachable")
+	if _, err := f.Write([]byte("more"), 0); err == nil {
+		t.Error("Write() should fail when the count does")
+	}
+}
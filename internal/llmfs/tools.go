@@ -0,0 +1,464 @@
+package llmfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// ToolsDir offers tools to the model. Writing a definition to ctl
+// registers a tool; each tool then has a directory NAME/ with its schema
+// and, if a client rather than an executable answers its calls, a calls
+// file. Removing NAME/ (rmdir) deletes the tool. The tools are the
+// backend's, shared by every conversation.
+type ToolsDir struct {
+	*protocol.BaseFile
+	tools *llm.Toolbox
+	ctl   *ToolsCtlFile
+
+	mu   sync.Mutex
+	dirs map[string]*ToolDir // by name, created on first use
+}
+
+// NewToolsDir creates the tools directory for a backend's toolbox
+func NewToolsDir(tools *llm.Toolbox) *ToolsDir {
+	return &ToolsDir{
+		BaseFile: protocol.NewBaseFile("tools", protocol.DMDIR|0777),
+		tools:    tools,
+		ctl:      NewToolsCtlFile(tools),
+		dirs:     make(map[string]*ToolDir),
+	}
+}
+
+// dir returns the directory of a registered tool, replacing the one
+// of an earlier tool of the same name
+func (d *ToolsDir) dir(name string, handler llm.ToolHandler) *ToolDir {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dir, ok := d.dirs[name]; ok && dir.handler == handler {
+		return dir
+	}
+	dir := newToolDir(d.tools, name, handler)
+	d.dirs[name] = dir
+	return dir
+}
+
+func (d *ToolsDir) Children() []protocol.File {
+	children := []protocol.File{d.ctl}
+	for _, tool := range d.tools.Tools() {
+		if _, handler, ok := d.tools.Lookup(tool.Name); ok {
+			children = append(children, d.dir(tool.Name, handler))
+		}
+	}
+	return children
+}
+
+func (d *ToolsDir) Lookup(name string) (protocol.File, error) {
+	if name == "ctl" {
+		return d.ctl, nil
+	}
+	_, handler, ok := d.tools.Lookup(name)
+	if !ok {
+		return nil, protocol.ErrNotFound
+	}
+	return d.dir(name, handler), nil
+}
+
+func (d *ToolsDir) Read(p []byte, offset int64) (int, error) {
+	return protocol.ReadDir(d.Children(), p, offset)
+}
+
+// ToolDir is one tool, tools/NAME
+type ToolDir struct {
+	*protocol.StaticDir
+	tools   *llm.Toolbox
+	handler llm.ToolHandler
+}
+
+func newToolDir(tools *llm.Toolbox, name string, handler llm.ToolHandler) *ToolDir {
+	d := &ToolDir{
+		StaticDir: protocol.NewStaticDir(name),
+		tools:     tools,
+		handler:   handler,
+	}
+	d.AddChild(protocol.NewDynamicFile("schema", func() []byte {
+		tool, _, _ := tools.Lookup(name)
+		data, _ := json.MarshalIndent(tool, "", "  ")
+		return append(data, '\n')
+	}))
+	if calls, ok := handler.(*pendingCalls); ok {
+		d.AddChild(newCallsFile(calls))
+	}
+	return d
+}
+
+var _ protocol.Remover = (*ToolDir)(nil)
+
+// Remove implements protocol.Remover - deletes the tool
+func (d *ToolDir) Remove() error {
+	return d.tools.Remove(d.Stat().Name)
+}
+
+// ToolsCtlFile registers and removes tools (read/write). Reading shows
+// the round limit and each tool with its handler. A write, committed on
+// clunk over 9P, is either a tool definition
+//
+//	{"name": "...", "description": "...", "input_schema": {...}, "exec": "NAME"}
+//
+// whose calls run the executable NAME in the tool directory or, without
+// exec, are answered through tools/NAME/calls; or lines of commands:
+//
+//	remove NAME   delete a tool
+//	rounds N      allow N rounds of tool calls per prompt (0 offers none)
+type ToolsCtlFile struct {
+	*protocol.BaseFile
+	*commitOnClunk
+	tools   *llm.Toolbox
+	execDir string // where exec names executables; exec is refused if empty
+}
+
+// NewToolsCtlFile creates the tools ctl file
+func NewToolsCtlFile(tools *llm.Toolbox) *ToolsCtlFile {
+	f := &ToolsCtlFile{
+		BaseFile: protocol.NewBaseFile("ctl", 0666),
+		tools:    tools,
+	}
+	f.commitOnClunk = newCommitOnClunk(f)
+	return f
+}
+
+func (f *ToolsCtlFile) content() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "rounds %d\n", f.tools.Rounds())
+	for _, tool := range f.tools.Tools() {
+		_, handler, _ := f.tools.Lookup(tool.Name)
+		switch h := handler.(type) {
+		case llm.CommandHandler:
+			fmt.Fprintf(&b, "%s exec %s\n", tool.Name, h.Path)
+		case *pendingCalls:
+			fmt.Fprintf(&b, "%s calls\n", tool.Name)
+		default:
+			fmt.Fprintf(&b, "%s\n", tool.Name)
+		}
+	}
+	return b.String()
+}
+
+func (f *ToolsCtlFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+func (f *ToolsCtlFile) Write(p []byte, offset int64) (int, error) {
+	text := strings.TrimSpace(string(p))
+	if strings.HasPrefix(text, "{") {
+		if err := f.register([]byte(text)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("tools: unknown command %q (write a JSON definition, remove NAME or rounds N)", line)
+		}
+		switch fields[0] {
+		case "remove":
+			if err := f.tools.Remove(fields[1]); err != nil {
+				return 0, err
+			}
+		case "rounds":
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, fmt.Errorf("rounds: %q is not a number", fields[1])
+			}
+			if err := f.tools.SetRounds(n); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("tools: unknown command %q (write a JSON definition, remove NAME or rounds N)", line)
+		}
+	}
+	return len(p), nil
+}
+
+// register adds the tool of a JSON definition
+func (f *ToolsCtlFile) register(data []byte) error {
+	var def struct {
+		llm.Tool
+		Exec string `json:"exec"`
+	}
+	if err := json.Unmarshal(data, &def); err != nil {
+		return fmt.Errorf("tools: invalid definition: %w", err)
+	}
+	var handler llm.ToolHandler = newPendingCalls()
+	if def.Exec != "" {
+		path, err := f.executable(def.Exec)
+		if err != nil {
+			return fmt.Errorf("tool %s: %w", def.Name, err)
+		}
+		handler = llm.CommandHandler{Path: path}
+	}
+	return f.tools.Register(def.Tool, handler)
+}
+
+// executable returns the path of the executable a definition's exec
+// names: a file directly in the tool directory, and only there, since
+// any client may register a tool
+func (f *ToolsCtlFile) executable(name string) (string, error) {
+	if f.execDir == "" {
+		return "", errors.New("exec is disabled: the server has no tool directory (-tool-dir)")
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("exec %q is not a file name in the tool directory", name)
+	}
+	path, err := filepath.Abs(filepath.Join(f.execDir, name))
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return "", fmt.Errorf("exec %s is not an executable file", name)
+	}
+	return path, nil
+}
+
+func (f *ToolsCtlFile) Stat() protocol.Stat {
+	s := f.BaseFile.Stat()
+	s.Length = uint64(len(f.content()))
+	return s
+}
+
+// pendingCalls is the handler of a tool whose calls a client answers:
+// each call waits in a queue until a fid reading the calls file takes
+// it, and until that fid writes the result and is clunked.
+type pendingCalls struct {
+	mu    sync.Mutex
+	queue []*pendingCall
+	taken map[uint32]*pendingCall // by fid
+	wake  chan struct{}           // closed when a call is queued
+}
+
+// pendingCall is a call waiting for its result
+type pendingCall struct {
+	ctx    context.Context // of the request making the call
+	call   llm.ToolCall
+	answer chan toolAnswer // buffered: the answer never blocks
+}
+
+// toolAnswer is the result a client wrote for a call
+type toolAnswer struct {
+	content string
+	err     error
+}
+
+func newPendingCalls() *pendingCalls {
+	return &pendingCalls{taken: make(map[uint32]*pendingCall), wake: make(chan struct{})}
+}
+
+// Run implements llm.ToolHandler - queues the call and waits for a
+// client to answer it, or for the request to be cancelled
+func (h *pendingCalls) Run(ctx context.Context, call llm.ToolCall) (string, error) {
+	pc := &pendingCall{ctx: ctx, call: call, answer: make(chan toolAnswer, 1)}
+	h.push(pc, false)
+	select {
+	case a := <-pc.answer:
+		return a.content, a.err
+	case <-ctx.Done():
+		h.drop(pc)
+		return "", ctx.Err()
+	}
+}
+
+// push queues pc, at the front if it goes back after a fid gave it up,
+// and wakes the waiting readers
+func (h *pendingCalls) push(pc *pendingCall, front bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if front {
+		h.queue = append([]*pendingCall{pc}, h.queue...)
+	} else {
+		h.queue = append(h.queue, pc)
+	}
+	close(h.wake)
+	h.wake = make(chan struct{})
+}
+
+// drop forgets a call nobody waits for any more
+func (h *pendingCalls) drop(pc *pendingCall) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, queued := range h.queue {
+		if queued == pc {
+			h.queue = append(h.queue[:i], h.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// take returns the call fid holds, taking the next queued one if it
+// holds none; it waits for a call until ctx is cancelled
+func (h *pendingCalls) take(ctx context.Context, fid uint32) (*pendingCall, error) {
+	for {
+		h.mu.Lock()
+		if pc, ok := h.taken[fid]; ok {
+			h.mu.Unlock()
+			return pc, nil
+		}
+		for len(h.queue) > 0 {
+			pc := h.queue[0]
+			h.queue = h.queue[1:]
+			if pc.ctx.Err() != nil {
+				continue // given back after its request ended
+			}
+			h.taken[fid] = pc
+			h.mu.Unlock()
+			return pc, nil
+		}
+		wake := h.wake
+		h.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release ends fid's hold on its call, if any
+func (h *pendingCalls) release(fid uint32) *pendingCall {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pc := h.taken[fid]
+	delete(h.taken, fid)
+	return pc
+}
+
+// CallsFile answers the calls of a tool, tools/NAME/calls. Reading
+// blocks until the model calls the tool, then gives the call as JSON,
+// {"id": ..., "name": ..., "input": {...}}; writing on the same fid sets
+// the result, which is returned to the model when the fid is clunked.
+// A result starting "error:" reports the call as failed. A fid clunked
+// without writing puts its call back for another reader. Each open fid
+// holds at most one call, so a client answering in a loop opens the file
+// once per call. Writes append whatever their offset, since a client
+// writes the result after reading the call.
+type CallsFile struct {
+	*protocol.BaseFile
+	calls *pendingCalls
+
+	mu      sync.Mutex
+	results map[uint32][]byte // by fid
+}
+
+var _ protocol.ContextFidAwareFile = (*CallsFile)(nil)
+
+func newCallsFile(calls *pendingCalls) *CallsFile {
+	return &CallsFile{
+		BaseFile: protocol.NewBaseFile("calls", 0666),
+		calls:    calls,
+		results:  make(map[uint32][]byte),
+	}
+}
+
+// ReadFid implements protocol.FidAwareFile
+func (f *CallsFile) ReadFid(fid uint32, p []byte, offset int64) (int, error) {
+	return f.ReadFidContext(context.Background(), fid, p, offset)
+}
+
+// ReadFidContext implements protocol.ContextFidAwareFile - waits for a
+// call; a flush cancels the wait
+func (f *CallsFile) ReadFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	pc, err := f.calls.take(ctx, fid)
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(pc.call)
+	if err != nil {
+		return 0, err
+	}
+	content := string(data) + "\n"
+	if offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, content[offset:])
+	return n, nil
+}
+
+// WriteFid implements protocol.FidAwareFile - buffers until clunk
+func (f *CallsFile) WriteFid(fid uint32, p []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.results[fid])+len(p) > MaxWriteSize {
+		return 0, fmt.Errorf("write exceeds %d bytes", MaxWriteSize)
+	}
+	f.results[fid] = append(f.results[fid], p...)
+	return len(p), nil
+}
+
+// WriteFidContext implements protocol.ContextFidAwareFile
+func (f *CallsFile) WriteFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return f.WriteFid(fid, p, offset)
+}
+
+// CloseFid implements protocol.FidAwareFile - answers the fid's call
+func (f *CallsFile) CloseFid(fid uint32) error {
+	f.mu.Lock()
+	data, wrote := f.results[fid]
+	delete(f.results, fid)
+	f.mu.Unlock()
+	pc := f.calls.release(fid)
+	switch {
+	case pc == nil && wrote:
+		return fmt.Errorf("calls: no call to answer (read a call first)")
+	case pc == nil:
+		return nil
+	case !wrote:
+		f.calls.push(pc, true) // for another reader
+		return nil
+	}
+	result := strings.TrimSuffix(string(data), "\n")
+	if msg, failed := strings.CutPrefix(result, "error:"); failed {
+		pc.answer <- toolAnswer{err: errors.New(strings.TrimSpace(msg))}
+	} else {
+		pc.answer <- toolAnswer{content: result}
+	}
+	return nil
+}
+
+// CloseFidContext implements protocol.ContextFidAwareFile
+func (f *CallsFile) CloseFidContext(ctx context.Context, fid uint32) error {
+	return f.CloseFid(fid)
+}
+
+// AbortFid implements protocol.FidAborter - the result may be cut short,
+// so it is discarded, and the fid's call put back for another reader
+func (f *CallsFile) AbortFid(fid uint32) {
+	f.mu.Lock()
+	delete(f.results, fid)
+	f.mu.Unlock()
+	if pc := f.calls.release(fid); pc != nil {
+		f.calls.push(pc, true)
+	}
+}
@@ -0,0 +1,156 @@
+package llmfs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+const weatherTool = `{"name": "weather", "description": "Current weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}`
+
+func TestToolsDir_Ctl(t *testing.T) {
+	mock := NewMockBackend()
+	toolDir := t.TempDir()
+	root := NewRootWithOptions(mock, Options{ToolDir: toolDir})
+	ctl := walkTo(t, root, "tools", "ctl").(*ToolsCtlFile)
+
+	script := filepath.Join(toolDir, "clock")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho noon\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(toolDir, "notes"), []byte("not a program\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clock := `{"name": "clock", "input_schema": {}, "exec": "clock"}`
+	for _, def := range []string{weatherTool, clock, "rounds 3"} {
+		if _, err := ctl.Write([]byte(def), 0); err != nil {
+			t.Fatalf("Write(%s) error: %v", def, err)
+		}
+	}
+	want := "rounds 3\nclock exec " + script + "\nweather calls\n"
+	if got := readAll(t, ctl); got != want {
+		t.Errorf("ctl = %q, want %q", got, want)
+	}
+
+	schema := readAll(t, walkTo(t, root, "tools", "weather", "schema"))
+	var tool llm.Tool
+	if err := json.Unmarshal([]byte(schema), &tool); err != nil || tool.Name != "weather" || tool.Description != "Current weather" {
+		t.Errorf("schema = %q (%v)", schema, err)
+	}
+	if _, err := walkTo(t, root, "tools", "clock").(protocol.Dir).Lookup("calls"); err == nil {
+		t.Error("a tool with exec should have no calls file")
+	}
+
+	// exec names an executable in the tool directory, and nothing else
+	for _, bad := range []string{`{"name": "bad name", "input_schema": {}}`, `{"name": "x", "input_schema": {}, "exec": "/no/such/tool"}`,
+		`{"name": "x", "input_schema": {}, "exec": "` + script + `"}`, `{"name": "x", "input_schema": {}, "exec": "../clock"}`,
+		`{"name": "x", "input_schema": {}, "exec": "sh"}`, `{"name": "x", "input_schema": {}, "exec": "notes"}`,
+		"rounds 1000", "remove nope", "launch"} {
+		if _, err := ctl.Write([]byte(bad), 0); err == nil {
+			t.Errorf("Write(%q) should fail", bad)
+		}
+	}
+
+	if err := walkTo(t, root, "tools", "clock").(protocol.Remover).Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if _, err := ctl.Write([]byte("remove weather\n"), 0); err != nil {
+		t.Fatalf("remove error: %v", err)
+	}
+	if len(mock.tools.Tools()) != 0 {
+		t.Errorf("tools left: %v", mock.tools.Tools())
+	}
+}
+
+func TestCallsFile(t *testing.T) {
+	mock := NewMockBackend()
+	tools := NewToolsDir(mock.tools)
+	if _, err := tools.ctl.Write([]byte(weatherTool), 0); err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+	calls := walkTo(t, tools, "weather", "calls").(*CallsFile)
+	_, handler, _ := mock.tools.Lookup("weather")
+
+	type result struct {
+		content string
+		err     error
+	}
+	run := func(id string) chan result {
+		done := make(chan result, 1)
+		go func() {
+			content, err := handler.Run(context.Background(), llm.ToolCall{ID: id, Name: "weather", Input: json.RawMessage(`{"city":"Oslo"}`)})
+			done <- result{content, err}
+		}()
+		return done
+	}
+
+	// The reader waits for the call
+	read := make(chan string, 1)
+	go func() { read <- readFid(t, calls, 1) }()
+	first := run("c1")
+	if got := <-read; got != `{"id":"c1","name":"weather","input":{"city":"Oslo"}}`+"\n" {
+		t.Errorf("call = %q", got)
+	}
+
+	// A fid clunked without an answer gives the call back
+	if err := calls.CloseFid(1); err != nil {
+		t.Fatalf("clunk error: %v", err)
+	}
+	if got := readFid(t, calls, 2); !strings.Contains(got, `"id":"c1"`) {
+		t.Errorf("call after give-back = %q", got)
+	}
+	calls.WriteFid(2, []byte("4°C, "), int64(len(`{"id":"c1"}`)))
+	calls.WriteFid(2, []byte("rain\n"), 0)
+	if err := calls.CloseFid(2); err != nil {
+		t.Fatalf("answer error: %v", err)
+	}
+	if r := <-first; r.content != "4°C, rain" || r.err != nil {
+		t.Errorf("Run() = %q, %v", r.content, r.err)
+	}
+
+	second := run("c2")
+	readFid(t, calls, 3)
+	calls.WriteFid(3, []byte("error: no such city"), 0)
+	calls.CloseFid(3)
+	if r := <-second; r.err == nil || r.err.Error() != "no such city" {
+		t.Errorf("failed Run() error = %v", r.err)
+	}
+
+	calls.WriteFid(4, []byte("stray"), 0)
+	if err := calls.CloseFid(4); err == nil {
+		t.Error("an answer without a call should fail")
+	}
+
+	// A flushed read stops waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := calls.ReadFidContext(ctx, 5, make([]byte, 100), 0); err == nil {
+		t.Error("read with no call should end with its context")
+	}
+}
+
+func TestToolsDir_CLI(t *testing.T) {
+	root := NewRoot(llm.NewCLIClient())
+	if _, err := root.Lookup("tools"); err == nil {
+		t.Error("the CLI backend should have no tools directory")
+	}
+}
+
+func TestToolsCtl_ExecDisabled(t *testing.T) {
+	ctl := walkTo(t, NewRoot(NewMockBackend()), "tools", "ctl").(*ToolsCtlFile)
+
+	// Without a tool directory, no client may have the server run a program
+	if _, err := ctl.Write([]byte(`{"name": "x", "input_schema": {}, "exec": "sh"}`), 0); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("exec without a tool directory: error = %v", err)
+	}
+	if _, err := ctl.Write([]byte(weatherTool), 0); err != nil {
+		t.Errorf("a tool without exec: error = %v", err)
+	}
+}
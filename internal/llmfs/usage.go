@@ -9,7 +9,9 @@ import (
 )
 
 // UsageFile provides token usage observability
-// Read returns "tokens/limit" (e.g., "45000/200000")
+// Read returns "tokens/limit" (e.g., "45000/200000"), followed by the
+// prompt cache use of the last exchange when there was any:
+// "cache_read N" and "cache_write N" lines
 type UsageFile struct {
 	*protocol.BaseFile
 	client llm.Backend
@@ -23,11 +25,18 @@ func NewUsageFile(client llm.Backend) *UsageFile {
 	}
 }
 
-func (f *UsageFile) Read(p []byte, offset int64) (int, error) {
+func (f *UsageFile) content() string {
 	tokens := f.client.TotalTokens()
 	limit := f.client.ContextLimit()
 	content := fmt.Sprintf("%d/%d\n", tokens, limit)
+	if meta := f.client.LastMeta(); meta.CacheReadTokens > 0 || meta.CacheWriteTokens > 0 {
+		content += fmt.Sprintf("cache_read %d\ncache_write %d\n", meta.CacheReadTokens, meta.CacheWriteTokens)
+	}
+	return content
+}
 
+func (f *UsageFile) Read(p []byte, offset int64) (int, error) {
+	content := f.content()
 	if offset >= int64(len(content)) {
 		return 0, io.EOF
 	}
@@ -41,9 +50,6 @@ func (f *UsageFile) Write(p []byte, offset int64) (int, error) {
 
 func (f *UsageFile) Stat() protocol.Stat {
 	s := f.BaseFile.Stat()
-	tokens := f.client.TotalTokens()
-	limit := f.client.ContextLimit()
-	content := fmt.Sprintf("%d/%d\n", tokens, limit)
-	s.Length = uint64(len(content))
+	s.Length = uint64(len(f.content()))
 	return s
 }
@@ -3,6 +3,8 @@ package llmfs
 import (
 	"io"
 	"testing"
+
+	"github.com/NERVsystems/llm9p/internal/llm"
 )
 
 func TestUsageFile_Read(t *testing.T) {
@@ -117,3 +119,19 @@ func TestUsageFile_DynamicContent(t *testing.T) {
 		t.Errorf("Second read = %q, want '50000/100000\\n'", string(buf[:n]))
 	}
 }
+
+func TestUsageFile_Cache(t *testing.T) {
+	mock := NewMockBackend()
+	mock.totalTokens = 5000
+	mock.contextLimit = 200000
+	mock.lastMeta = llm.Meta{CacheReadTokens: 4000, CacheWriteTokens: 300}
+
+	usage := NewUsageFile(mock)
+	want := "5000/200000\ncache_read 4000\ncache_write 300\n"
+	if got := readAll(t, usage); got != want {
+		t.Errorf("usage = %q, want %q", got, want)
+	}
+	if usage.Stat().Length != uint64(len(want)) {
+		t.Errorf("Stat().Length = %d, want %d", usage.Stat().Length, len(want))
+	}
+}
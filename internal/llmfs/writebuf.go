@@ -0,0 +1,254 @@
+package llmfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/NERVsystems/llm9p/internal/protocol"
+)
+
+// MaxWriteSize caps how much a single fid may buffer before it is clunked.
+const MaxWriteSize = 16 << 20
+
+// fidBuffers accumulates Twrite payloads per fid so that a value larger
+// than one 9P message (a long prompt, a big system prompt) is assembled
+// in full and committed once, when the fid is clunked.
+type fidBuffers struct {
+	mu   sync.Mutex
+	bufs map[uint32][]byte
+	max  int64 // how many bytes a fid may buffer
+}
+
+func newFidBuffers() *fidBuffers {
+	return newFidBuffersMax(MaxWriteSize)
+}
+
+// newFidBuffersMax is newFidBuffers with a limit other than MaxWriteSize
+func newFidBuffersMax(limit int64) *fidBuffers {
+	return &fidBuffers{bufs: make(map[uint32][]byte), max: limit}
+}
+
+// write stores p at offset in the fid's buffer, growing it as needed
+func (b *fidBuffers) write(fid uint32, p []byte, offset int64) (int, error) {
+	end := offset + int64(len(p))
+	if offset < 0 || end > b.max {
+		return 0, fmt.Errorf("write exceeds %d bytes", b.max)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	buf := b.bufs[fid]
+	if int64(len(buf)) < end {
+		grown := make([]byte, end)
+		copy(grown, buf)
+		buf = grown
+	}
+	copy(buf[offset:], p)
+	b.bufs[fid] = buf
+	return len(p), nil
+}
+
+// take removes and returns the fid's buffer. ok is false if the fid
+// never wrote anything, so opening a file just to read it commits nothing.
+func (b *fidBuffers) take(fid uint32) (data []byte, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok = b.bufs[fid]
+	delete(b.bufs, fid)
+	return data, ok
+}
+
+// commitOnClunk makes a file fid-aware so that writes arriving over 9P are
+// buffered per fid and handed to the file's own Write in one piece when the
+// fid is clunked. Embed it and point file at the embedding file.
+type commitOnClunk struct {
+	file    protocol.File
+	pending *fidBuffers
+}
+
+func newCommitOnClunk(file protocol.File) *commitOnClunk {
+	return &commitOnClunk{file: file, pending: newFidBuffers()}
+}
+
+// ReadFid implements protocol.FidAwareFile
+func (c *commitOnClunk) ReadFid(fid uint32, p []byte, offset int64) (int, error) {
+	return c.file.Read(p, offset)
+}
+
+// WriteFid implements protocol.FidAwareFile - buffers until clunk
+func (c *commitOnClunk) WriteFid(fid uint32, p []byte, offset int64) (int, error) {
+	return c.pending.write(fid, p, offset)
+}
+
+// CloseFid implements protocol.FidAwareFile - commits the buffered value
+func (c *commitOnClunk) CloseFid(fid uint32) error {
+	data, ok := c.pending.take(fid)
+	if !ok {
+		return nil
+	}
+	_, err := c.file.Write(data, 0)
+	return err
+}
+
+// AbortFid implements protocol.FidAborter - discards the buffered value
+func (c *commitOnClunk) AbortFid(fid uint32) {
+	c.pending.take(fid)
+}
+
+// errNoFid is what a file answering per fid says to a write without one
+var errNoFid = errors.New("write and read the answer on one open file")
+
+// answerOnRead makes a file fid-aware for files that answer what is
+// written to them, such as a query. A fid's writes are buffered, and its
+// next read sends them and reads back the answer, which no other fid
+// sees; reading again without writing rereads it. As on an isolated ask
+// file, read offsets are taken relative to the first read of the
+// answer, and write offsets to the first write after it, since a
+// read-write file descriptor keeps advancing its offset.
+//
+// If commit is set, writes that no read answered are sent when the fid
+// is clunked, for files whose writes change more than the answer.
+type answerOnRead struct {
+	answer  func(ctx context.Context, fid uint32, p []byte) ([]byte, error)
+	commit  bool
+	pending *fidBuffers
+	mu      sync.Mutex
+	answers map[uint32]*fidAnswer
+}
+
+// fidAnswer is the last answer to a fid
+type fidAnswer struct {
+	data      []byte
+	readBase  int64 // offset of the first read of data
+	writeBase int64 // offset of the first write since, or -1
+}
+
+// readAt returns where a read at offset falls in the answer
+func (fa *fidAnswer) readAt(offset int64) int64 {
+	if offset < fa.readBase {
+		fa.readBase = offset
+	}
+	return offset - fa.readBase
+}
+
+// writeAt returns where a write at offset falls in the next writes
+func (fa *fidAnswer) writeAt(offset int64) int64 {
+	if fa.writeBase < 0 {
+		fa.writeBase = offset
+	}
+	return offset - fa.writeBase
+}
+
+func newAnswerOnRead(answer func(ctx context.Context, fid uint32, p []byte) ([]byte, error), commit bool) *answerOnRead {
+	return &answerOnRead{
+		answer:  answer,
+		commit:  commit,
+		pending: newFidBuffers(),
+		answers: make(map[uint32]*fidAnswer),
+	}
+}
+
+// ReadFid implements protocol.FidAwareFile
+func (a *answerOnRead) ReadFid(fid uint32, p []byte, offset int64) (int, error) {
+	return a.ReadFidContext(context.Background(), fid, p, offset)
+}
+
+// ReadFidContext implements protocol.ContextFidAwareFile - sends the
+// fid's writes, if any, then reads their answer; flushing the read
+// cancels the request
+func (a *answerOnRead) ReadFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	if data, ok := a.pending.take(fid); ok {
+		answer, err := a.answer(ctx, fid, data)
+		if err != nil {
+			return 0, err
+		}
+		a.mu.Lock()
+		a.answers[fid] = &fidAnswer{data: answer, readBase: offset, writeBase: -1}
+		a.mu.Unlock()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fa, ok := a.answers[fid]
+	if !ok {
+		return 0, io.EOF
+	}
+	if at := fa.readAt(offset); at < int64(len(fa.data)) {
+		return copy(p, fa.data[at:]), nil
+	}
+	return 0, io.EOF
+}
+
+// WriteFid implements protocol.FidAwareFile - buffers until the next read
+func (a *answerOnRead) WriteFid(fid uint32, p []byte, offset int64) (int, error) {
+	a.mu.Lock()
+	if fa, ok := a.answers[fid]; ok {
+		offset = fa.writeAt(offset)
+	}
+	a.mu.Unlock()
+	return a.pending.write(fid, p, offset)
+}
+
+// WriteFidContext implements protocol.ContextFidAwareFile
+func (a *answerOnRead) WriteFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (int, error) {
+	return a.WriteFid(fid, p, offset)
+}
+
+// CloseFid implements protocol.FidAwareFile - drops the fid's answer
+func (a *answerOnRead) CloseFid(fid uint32) error {
+	return a.CloseFidContext(context.Background(), fid)
+}
+
+// CloseFidContext implements protocol.ContextFidAwareFile
+func (a *answerOnRead) CloseFidContext(ctx context.Context, fid uint32) error {
+	a.mu.Lock()
+	delete(a.answers, fid)
+	a.mu.Unlock()
+	data, ok := a.pending.take(fid)
+	if !ok || !a.commit {
+		return nil
+	}
+	_, err := a.answer(ctx, fid, data)
+	return err
+}
+
+// AbortFid implements protocol.FidAborter - drops the fid's answer and
+// its writes since
+func (a *answerOnRead) AbortFid(fid uint32) {
+	a.mu.Lock()
+	delete(a.answers, fid)
+	a.mu.Unlock()
+	a.pending.take(fid)
+}
+
+// Files whose 9P writes are committed on clunk
+var (
+	_ protocol.FidAwareFile = (*SystemFile)(nil)
+	_ protocol.FidAwareFile = (*ContextFile)(nil)
+	_ protocol.FidAwareFile = (*StreamAskFile)(nil)
+	_ protocol.FidAwareFile = (*TokenizeFile)(nil)
+	_ protocol.FidAwareFile = (*ToolsCtlFile)(nil)
+	_ protocol.FidAwareFile = (*AttachNewFile)(nil)
+	_ protocol.FidAwareFile = (*AttachFile)(nil)
+	_ protocol.FidAwareFile = (*SchemaFile)(nil)
+)
+
+// Files that discard what a fid wrote when it goes away unclunked
+var (
+	_ protocol.FidAborter = (*SystemFile)(nil)
+	_ protocol.FidAborter = (*AskFile)(nil)
+	_ protocol.FidAborter = (*IsolatedAskFile)(nil)
+	_ protocol.FidAborter = (*CallsFile)(nil)
+	_ protocol.FidAborter = (*IndexAddFile)(nil)
+)
+
+// Files that answer each fid's writes on its next read
+var (
+	_ protocol.ContextFidAwareFile = (*EmbedFile)(nil)
+	_ protocol.ContextFidAwareFile = (*IndexAddFile)(nil)
+	_ protocol.ContextFidAwareFile = (*IndexQueryFile)(nil)
+)
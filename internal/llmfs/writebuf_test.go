@@ -0,0 +1,161 @@
+package llmfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFidBuffers_Offsets(t *testing.T) {
+	b := newFidBuffers()
+
+	// Out-of-order writes land at their offsets
+	b.write(1, []byte("world"), 6)
+	b.write(1, []byte("hello "), 0)
+	b.write(2, []byte("other"), 0)
+
+	data, ok := b.take(1)
+	if !ok || string(data) != "hello world" {
+		t.Errorf("take(1) = %q, %v; want %q", data, ok, "hello world")
+	}
+	if _, ok := b.take(1); ok {
+		t.Error("take(1) twice should report nothing buffered")
+	}
+	if data, _ := b.take(2); string(data) != "other" {
+		t.Errorf("take(2) = %q, want %q", data, "other")
+	}
+	if _, ok := b.take(3); ok {
+		t.Error("take() for a fid that never wrote should report nothing buffered")
+	}
+}
+
+func TestFidBuffers_Limit(t *testing.T) {
+	b := newFidBuffers()
+	if _, err := b.write(1, []byte("x"), MaxWriteSize); err == nil {
+		t.Error("write() past MaxWriteSize should fail")
+	}
+}
+
+func TestAskFile_MultiWriteCommitOnClunk(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "summary"
+	ask := NewAskFile(mock)
+
+	// A prompt larger than one 9P message arrives as several Twrites
+	part1 := strings.Repeat("a", 8000)
+	part2 := strings.Repeat("b", 3000)
+	ask.WriteFid(7, []byte(part1), 0)
+	ask.WriteFid(7, []byte(part2), int64(len(part1)))
+
+	if len(mock.messages) != 0 {
+		t.Fatal("prompt should not be sent before clunk")
+	}
+
+	if err := ask.CloseFid(7); err != nil {
+		t.Fatalf("CloseFid() error: %v", err)
+	}
+
+	if len(mock.messages) != 2 {
+		t.Fatalf("expected exactly one exchange, got %d messages", len(mock.messages))
+	}
+	if mock.messages[0].Content != part1+part2 {
+		t.Errorf("prompt length = %d, want %d", len(mock.messages[0].Content), len(part1+part2))
+	}
+
+	buf := make([]byte, 100)
+	n, _ := ask.ReadFid(8, buf, 0)
+	if string(buf[:n]) != "summary\n" {
+		t.Errorf("ReadFid() = %q, want %q", buf[:n], "summary\n")
+	}
+}
+
+func TestAskFile_ReadCommits(t *testing.T) {
+	mock := NewMockBackend()
+	mock.askResponse = "four"
+	ask := NewAskFile(mock)
+
+	// A read-write fid asks, then reads on from where it wrote
+	ask.WriteFid(5, []byte("2+2?\n"), 0)
+	buf := make([]byte, 100)
+	if n, _ := ask.ReadFid(5, buf, 5); string(buf[:n]) != "four\n" {
+		t.Errorf("ReadFid() = %q, want %q", buf[:n], "four\n")
+	}
+	if len(mock.messages) != 2 {
+		t.Fatalf("expected one exchange, got %d messages", len(mock.messages))
+	}
+
+	// and again, its writes following the answer
+	mock.askResponse = "six"
+	ask.WriteFid(5, []byte("3+3?\n"), 10)
+	if n, _ := ask.ReadFid(5, buf, 15); string(buf[:n]) != "six\n" {
+		t.Errorf("second ReadFid() = %q", buf[:n])
+	}
+	if mock.messages[2].Content != "3+3?" {
+		t.Errorf("second prompt = %q", mock.messages[2].Content)
+	}
+
+	// The clunk has nothing left to send
+	if err := ask.CloseFid(5); err != nil || len(mock.messages) != 4 {
+		t.Errorf("CloseFid() = %v, %d messages", err, len(mock.messages))
+	}
+}
+
+func TestAskFile_ClunkWithoutWrite(t *testing.T) {
+	mock := NewMockBackend()
+	ask := NewAskFile(mock)
+
+	// Reading fids clunk too; that must not send anything
+	if err := ask.CloseFid(3); err != nil {
+		t.Fatalf("CloseFid() error: %v", err)
+	}
+	if len(mock.messages) != 0 {
+		t.Error("clunk without writes should not send a prompt")
+	}
+}
+
+func TestAbortFid_Discards(t *testing.T) {
+	mock := NewMockBackend()
+	ask := NewAskFile(mock)
+	sys := NewSystemFile(mock)
+
+	// A client gone before its clunk may have written half a value
+	ask.WriteFid(1, []byte("half a"), 0)
+	sys.WriteFid(1, []byte("You are"), 0)
+	ask.AbortFid(1)
+	sys.AbortFid(1)
+	if err := ask.CloseFid(1); err != nil || len(mock.messages) != 0 {
+		t.Errorf("an aborted prompt was sent: %v, %v", err, mock.messages)
+	}
+	if sys.CloseFid(1); mock.systemPrompt != "" {
+		t.Errorf("an aborted system prompt was set: %q", mock.systemPrompt)
+	}
+}
+
+func TestSystemFile_MultiWriteCommitOnClunk(t *testing.T) {
+	mock := NewMockBackend()
+	sys := NewSystemFile(mock)
+
+	sys.WriteFid(1, []byte("You are "), 0)
+	sys.WriteFid(1, []byte("a poet.\n"), 8)
+	if mock.systemPrompt != "" {
+		t.Fatal("system prompt should not change before clunk")
+	}
+
+	sys.CloseFid(1)
+	if mock.systemPrompt != "You are a poet." {
+		t.Errorf("system prompt = %q, want %q", mock.systemPrompt, "You are a poet.")
+	}
+}
+
+func TestContextFile_MultiWriteCommitOnClunk(t *testing.T) {
+	mock := NewMockBackend()
+	ctxFile := NewContextFile(mock)
+
+	ctxFile.WriteFid(1, []byte("first half, "), 0)
+	ctxFile.WriteFid(1, []byte("second half"), 12)
+	ctxFile.CloseFid(1)
+
+	msgs := mock.Messages()
+	if len(msgs) != 1 || msgs[0].Content != "first half, second half" {
+		t.Errorf("context messages = %+v, want one combined system message", msgs)
+	}
+}
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"context"
 	"io"
 	"sync/atomic"
 	"time"
@@ -39,6 +40,10 @@ type Dir interface {
 // FidAwareFile is implemented by files that need per-fid state.
 // When a file implements this interface, the server will call the
 // fid-aware methods instead of the standard File methods.
+//
+// The fid passed to these methods is a server-wide key for the client's
+// fid, unique across connections, so state keyed by it never collides
+// between clients that use the same fid numbers.
 type FidAwareFile interface {
 	File
 
@@ -52,6 +57,73 @@ type FidAwareFile interface {
 	CloseFid(fid uint32) error
 }
 
+// FidAborter is implemented by fid-aware files that commit what a fid
+// wrote when it is clunked. A fid that goes away without a Tclunk - the
+// client disconnected, or sent Tversion - may have written only part of
+// a value, so the server calls AbortFid for it instead of CloseFid.
+type FidAborter interface {
+	FidAwareFile
+
+	// AbortFid releases the fid as CloseFid does, but discards what it
+	// wrote instead of committing it
+	AbortFid(fid uint32)
+}
+
+// ContextAwareFile is implemented by files whose reads or writes may block
+// for a long time (e.g. waiting on an LLM). The server passes a context that
+// is cancelled when the request is flushed or the connection goes away.
+type ContextAwareFile interface {
+	File
+
+	// ReadContext reads from the file, aborting if ctx is cancelled
+	ReadContext(ctx context.Context, p []byte, offset int64) (n int, err error)
+
+	// WriteContext writes to the file, aborting if ctx is cancelled
+	WriteContext(ctx context.Context, p []byte, offset int64) (n int, err error)
+}
+
+// ContextFidAwareFile is the context-aware variant of FidAwareFile.
+// The server prefers these methods over both ReadFid/WriteFid and
+// ReadContext/WriteContext when a file implements them.
+type ContextFidAwareFile interface {
+	FidAwareFile
+
+	// ReadFidContext reads with fid context, aborting if ctx is cancelled
+	ReadFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (n int, err error)
+
+	// WriteFidContext writes with fid context, aborting if ctx is cancelled
+	WriteFidContext(ctx context.Context, fid uint32, p []byte, offset int64) (n int, err error)
+
+	// CloseFidContext is called when a fid is clunked. Files that commit
+	// buffered writes on clunk should abort the commit if ctx is cancelled.
+	CloseFidContext(ctx context.Context, fid uint32) error
+}
+
+// FidOpener is implemented by files that need to know which fid is
+// opening them (e.g. a clone file that allocates a resource per open).
+// When implemented, the server calls OpenFid instead of Open.
+type FidOpener interface {
+	OpenFid(fid uint32, mode uint8) error
+}
+
+// Attacher is implemented by root directories that serve a different
+// tree depending on the attach name (aname) a client mounts with.
+type Attacher interface {
+	Attach(uname, aname string) (Dir, error)
+}
+
+// Creator is implemented by directories in which clients may create files.
+// The returned file must already be open in the given mode.
+type Creator interface {
+	Create(name string, perm uint32, mode uint8) (File, error)
+}
+
+// Remover is implemented by files that clients may remove, with Tremove
+// or, on 9P2000.L, Tunlinkat. Other files refuse removal.
+type Remover interface {
+	Remove() error
+}
+
 // pathCounter generates unique path IDs for qids
 var pathCounter uint64
 
@@ -184,9 +256,13 @@ func (d *StaticDir) Lookup(name string) (File, error) {
 }
 
 func (d *StaticDir) Read(p []byte, offset int64) (int, error) {
-	// Directory read returns packed stat entries
+	return ReadDir(d.Children(), p, offset)
+}
+
+// ReadDir implements a directory read over children: packed stat entries
+func ReadDir(children []File, p []byte, offset int64) (int, error) {
 	var buf []byte
-	for _, f := range d.Children() {
+	for _, f := range children {
 		stat := f.Stat()
 		entry := make([]byte, 256)
 		n := stat.Encode(entry)
@@ -236,11 +312,13 @@ type Error string
 func (e Error) Error() string { return string(e) }
 
 const (
-	ErrNotFound   Error = "file not found"
-	ErrPermission Error = "permission denied"
-	ErrNotDir     Error = "not a directory"
-	ErrIsDir      Error = "is a directory"
-	ErrBadFid     Error = "bad fid"
-	ErrFidInUse   Error = "fid already in use"
-	ErrBadOffset  Error = "bad offset"
+	ErrNotFound     Error = "file not found"
+	ErrPermission   Error = "permission denied"
+	ErrNotDir       Error = "not a directory"
+	ErrIsDir        Error = "is a directory"
+	ErrBadFid       Error = "bad fid"
+	ErrFidInUse     Error = "fid already in use"
+	ErrBadOffset    Error = "bad offset"
+	ErrExists       Error = "file already exists"
+	ErrNotSupported Error = "operation not supported"
 )
@@ -52,10 +52,11 @@ func (m *RversionMsg) Encode(buf []byte) int {
 
 // TattachMsg attaches to a filesystem
 type TattachMsg struct {
-	Fid   uint32 // fid to use for this connection
-	Afid  uint32 // auth fid (NoFid if no auth)
-	Uname string // user name
-	Aname string // attach name (filesystem to attach)
+	Fid    uint32 // fid to use for this connection
+	Afid   uint32 // auth fid (NoFid if no auth)
+	Uname  string // user name
+	Aname  string // attach name (filesystem to attach)
+	Nuname uint32 // numeric user id (9P2000.L only)
 }
 
 func (m *TattachMsg) Type() uint8 { return Tattach }
@@ -81,7 +82,12 @@ func DecodeTattach(buf []byte) (*TattachMsg, error) {
 	var sn int
 	m.Uname, sn = DecodeString(buf[n:])
 	n += sn
-	m.Aname, _ = DecodeString(buf[n:])
+	m.Aname, sn = DecodeString(buf[n:])
+	n += sn
+	// 9P2000.L appends a numeric uid
+	if len(buf) >= n+4 {
+		m.Nuname = binary.LittleEndian.Uint32(buf[n : n+4])
+	}
 	return m, nil
 }
 
@@ -303,6 +309,36 @@ func (m *RclunkMsg) Encode(buf []byte) int {
 	return 0
 }
 
+// TremoveMsg removes the file a fid refers to and clunks the fid
+type TremoveMsg struct {
+	Fid uint32
+}
+
+func (m *TremoveMsg) Type() uint8 { return Tremove }
+
+func (m *TremoveMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	return 4
+}
+
+func DecodeTremove(buf []byte) (*TremoveMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Tremove too short")
+	}
+	return &TremoveMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}, nil
+}
+
+// RremoveMsg is the response to Tremove
+type RremoveMsg struct{}
+
+func (m *RremoveMsg) Type() uint8 { return Rremove }
+
+func (m *RremoveMsg) Encode(buf []byte) int {
+	return 0
+}
+
 // TstatMsg requests file stats
 type TstatMsg struct {
 	Fid uint32
@@ -379,3 +415,495 @@ func (m *RflushMsg) Type() uint8 { return Rflush }
 func (m *RflushMsg) Encode(buf []byte) int {
 	return 0
 }
+
+// 9P2000.L messages
+
+// RlerrorMsg indicates an error as a Linux errno (9P2000.L)
+type RlerrorMsg struct {
+	Ecode uint32
+}
+
+func (m *RlerrorMsg) Type() uint8 { return Rlerror }
+
+func (m *RlerrorMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Ecode)
+	return 4
+}
+
+// TstatfsMsg requests filesystem information
+type TstatfsMsg struct {
+	Fid uint32
+}
+
+func (m *TstatfsMsg) Type() uint8 { return Tstatfs }
+
+func (m *TstatfsMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	return 4
+}
+
+func DecodeTstatfs(buf []byte) (*TstatfsMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Tstatfs too short")
+	}
+	return &TstatfsMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}, nil
+}
+
+// RstatfsMsg is the response to Tstatfs
+type RstatfsMsg struct {
+	FsType  uint32
+	Bsize   uint32
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Fsid    uint64
+	Namelen uint32
+}
+
+func (m *RstatfsMsg) Type() uint8 { return Rstatfs }
+
+func (m *RstatfsMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.FsType)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Bsize)
+	binary.LittleEndian.PutUint64(buf[8:16], m.Blocks)
+	binary.LittleEndian.PutUint64(buf[16:24], m.Bfree)
+	binary.LittleEndian.PutUint64(buf[24:32], m.Bavail)
+	binary.LittleEndian.PutUint64(buf[32:40], m.Files)
+	binary.LittleEndian.PutUint64(buf[40:48], m.Ffree)
+	binary.LittleEndian.PutUint64(buf[48:56], m.Fsid)
+	binary.LittleEndian.PutUint32(buf[56:60], m.Namelen)
+	return 60
+}
+
+// TlopenMsg opens a file using Linux open flags
+type TlopenMsg struct {
+	Fid   uint32
+	Flags uint32
+}
+
+func (m *TlopenMsg) Type() uint8 { return Tlopen }
+
+func (m *TlopenMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Flags)
+	return 8
+}
+
+func DecodeTlopen(buf []byte) (*TlopenMsg, error) {
+	if len(buf) < 8 {
+		return nil, fmt.Errorf("Tlopen too short")
+	}
+	return &TlopenMsg{
+		Fid:   binary.LittleEndian.Uint32(buf[0:4]),
+		Flags: binary.LittleEndian.Uint32(buf[4:8]),
+	}, nil
+}
+
+// Mode converts Linux open flags to a 9P open mode
+func (m *TlopenMsg) Mode() uint8 {
+	return linuxFlagsToMode(m.Flags)
+}
+
+func linuxFlagsToMode(flags uint32) uint8 {
+	mode := uint8(flags & LOAccmode)
+	if flags&LOTrunc != 0 {
+		mode |= OTRUNC
+	}
+	return mode
+}
+
+// RlopenMsg is the response to Tlopen
+type RlopenMsg struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (m *RlopenMsg) Type() uint8 { return Rlopen }
+
+func (m *RlopenMsg) Encode(buf []byte) int {
+	n := m.Qid.Encode(buf)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Iounit)
+	return n + 4
+}
+
+// TlcreateMsg creates and opens a file in the directory fid
+type TlcreateMsg struct {
+	Fid   uint32
+	Name  string
+	Flags uint32
+	Mode  uint32
+	Gid   uint32
+}
+
+func (m *TlcreateMsg) Type() uint8 { return Tlcreate }
+
+func (m *TlcreateMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	n := 4
+	n += EncodeString(buf[n:], m.Name)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Flags)
+	binary.LittleEndian.PutUint32(buf[n+4:n+8], m.Mode)
+	binary.LittleEndian.PutUint32(buf[n+8:n+12], m.Gid)
+	return n + 12
+}
+
+func DecodeTlcreate(buf []byte) (*TlcreateMsg, error) {
+	if len(buf) < 18 {
+		return nil, fmt.Errorf("Tlcreate too short")
+	}
+	m := &TlcreateMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	var sn int
+	m.Name, sn = DecodeString(buf[4:])
+	n := 4 + sn
+	if sn == 0 || len(buf) < n+12 {
+		return nil, fmt.Errorf("Tlcreate truncated")
+	}
+	m.Flags = binary.LittleEndian.Uint32(buf[n : n+4])
+	m.Mode = binary.LittleEndian.Uint32(buf[n+4 : n+8])
+	m.Gid = binary.LittleEndian.Uint32(buf[n+8 : n+12])
+	return m, nil
+}
+
+// RlcreateMsg is the response to Tlcreate
+type RlcreateMsg struct {
+	Qid    Qid
+	Iounit uint32
+}
+
+func (m *RlcreateMsg) Type() uint8 { return Rlcreate }
+
+func (m *RlcreateMsg) Encode(buf []byte) int {
+	n := m.Qid.Encode(buf)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Iounit)
+	return n + 4
+}
+
+// TgetattrMsg requests Linux file attributes
+type TgetattrMsg struct {
+	Fid         uint32
+	RequestMask uint64
+}
+
+func (m *TgetattrMsg) Type() uint8 { return Tgetattr }
+
+func (m *TgetattrMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint64(buf[4:12], m.RequestMask)
+	return 12
+}
+
+func DecodeTgetattr(buf []byte) (*TgetattrMsg, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("Tgetattr too short")
+	}
+	return &TgetattrMsg{
+		Fid:         binary.LittleEndian.Uint32(buf[0:4]),
+		RequestMask: binary.LittleEndian.Uint64(buf[4:12]),
+	}, nil
+}
+
+// RgetattrMsg is the response to Tgetattr
+type RgetattrMsg struct {
+	Valid       uint64
+	Qid         Qid
+	Mode        uint32
+	Uid         uint32
+	Gid         uint32
+	Nlink       uint64
+	Rdev        uint64
+	Size        uint64
+	Blksize     uint64
+	Blocks      uint64
+	AtimeSec    uint64
+	AtimeNsec   uint64
+	MtimeSec    uint64
+	MtimeNsec   uint64
+	CtimeSec    uint64
+	CtimeNsec   uint64
+	BtimeSec    uint64
+	BtimeNsec   uint64
+	Gen         uint64
+	DataVersion uint64
+}
+
+func (m *RgetattrMsg) Type() uint8 { return Rgetattr }
+
+func (m *RgetattrMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint64(buf[0:8], m.Valid)
+	n := 8
+	n += m.Qid.Encode(buf[n:])
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Mode)
+	binary.LittleEndian.PutUint32(buf[n+4:n+8], m.Uid)
+	binary.LittleEndian.PutUint32(buf[n+8:n+12], m.Gid)
+	n += 12
+	for _, v := range []uint64{
+		m.Nlink, m.Rdev, m.Size, m.Blksize, m.Blocks,
+		m.AtimeSec, m.AtimeNsec, m.MtimeSec, m.MtimeNsec,
+		m.CtimeSec, m.CtimeNsec, m.BtimeSec, m.BtimeNsec,
+		m.Gen, m.DataVersion,
+	} {
+		binary.LittleEndian.PutUint64(buf[n:n+8], v)
+		n += 8
+	}
+	return n
+}
+
+// TsetattrMsg sets Linux file attributes
+type TsetattrMsg struct {
+	Fid       uint32
+	Valid     uint32
+	Mode      uint32
+	Uid       uint32
+	Gid       uint32
+	Size      uint64
+	AtimeSec  uint64
+	AtimeNsec uint64
+	MtimeSec  uint64
+	MtimeNsec uint64
+}
+
+func (m *TsetattrMsg) Type() uint8 { return Tsetattr }
+
+func (m *TsetattrMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Valid)
+	binary.LittleEndian.PutUint32(buf[8:12], m.Mode)
+	binary.LittleEndian.PutUint32(buf[12:16], m.Uid)
+	binary.LittleEndian.PutUint32(buf[16:20], m.Gid)
+	binary.LittleEndian.PutUint64(buf[20:28], m.Size)
+	binary.LittleEndian.PutUint64(buf[28:36], m.AtimeSec)
+	binary.LittleEndian.PutUint64(buf[36:44], m.AtimeNsec)
+	binary.LittleEndian.PutUint64(buf[44:52], m.MtimeSec)
+	binary.LittleEndian.PutUint64(buf[52:60], m.MtimeNsec)
+	return 60
+}
+
+func DecodeTsetattr(buf []byte) (*TsetattrMsg, error) {
+	if len(buf) < 60 {
+		return nil, fmt.Errorf("Tsetattr too short")
+	}
+	return &TsetattrMsg{
+		Fid:       binary.LittleEndian.Uint32(buf[0:4]),
+		Valid:     binary.LittleEndian.Uint32(buf[4:8]),
+		Mode:      binary.LittleEndian.Uint32(buf[8:12]),
+		Uid:       binary.LittleEndian.Uint32(buf[12:16]),
+		Gid:       binary.LittleEndian.Uint32(buf[16:20]),
+		Size:      binary.LittleEndian.Uint64(buf[20:28]),
+		AtimeSec:  binary.LittleEndian.Uint64(buf[28:36]),
+		AtimeNsec: binary.LittleEndian.Uint64(buf[36:44]),
+		MtimeSec:  binary.LittleEndian.Uint64(buf[44:52]),
+		MtimeNsec: binary.LittleEndian.Uint64(buf[52:60]),
+	}, nil
+}
+
+// RsetattrMsg is the response to Tsetattr
+type RsetattrMsg struct{}
+
+func (m *RsetattrMsg) Type() uint8 { return Rsetattr }
+
+func (m *RsetattrMsg) Encode(buf []byte) int {
+	return 0
+}
+
+// TxattrwalkMsg prepares newfid for reading an extended attribute
+type TxattrwalkMsg struct {
+	Fid    uint32
+	Newfid uint32
+	Name   string
+}
+
+func (m *TxattrwalkMsg) Type() uint8 { return Txattrwalk }
+
+func (m *TxattrwalkMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint32(buf[4:8], m.Newfid)
+	return 8 + EncodeString(buf[8:], m.Name)
+}
+
+func DecodeTxattrwalk(buf []byte) (*TxattrwalkMsg, error) {
+	if len(buf) < 10 {
+		return nil, fmt.Errorf("Txattrwalk too short")
+	}
+	m := &TxattrwalkMsg{
+		Fid:    binary.LittleEndian.Uint32(buf[0:4]),
+		Newfid: binary.LittleEndian.Uint32(buf[4:8]),
+	}
+	m.Name, _ = DecodeString(buf[8:])
+	return m, nil
+}
+
+// RxattrwalkMsg is the response to Txattrwalk
+type RxattrwalkMsg struct {
+	Size uint64
+}
+
+func (m *RxattrwalkMsg) Type() uint8 { return Rxattrwalk }
+
+func (m *RxattrwalkMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint64(buf[0:8], m.Size)
+	return 8
+}
+
+// TreaddirMsg reads directory entries
+type TreaddirMsg struct {
+	Fid    uint32
+	Offset uint64
+	Count  uint32
+}
+
+func (m *TreaddirMsg) Type() uint8 { return Treaddir }
+
+func (m *TreaddirMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	binary.LittleEndian.PutUint64(buf[4:12], m.Offset)
+	binary.LittleEndian.PutUint32(buf[12:16], m.Count)
+	return 16
+}
+
+func DecodeTreaddir(buf []byte) (*TreaddirMsg, error) {
+	if len(buf) < 16 {
+		return nil, fmt.Errorf("Treaddir too short")
+	}
+	return &TreaddirMsg{
+		Fid:    binary.LittleEndian.Uint32(buf[0:4]),
+		Offset: binary.LittleEndian.Uint64(buf[4:12]),
+		Count:  binary.LittleEndian.Uint32(buf[12:16]),
+	}, nil
+}
+
+// Dirent is a single entry in an Rreaddir response
+type Dirent struct {
+	Qid    Qid
+	Offset uint64 // cookie to pass as Treaddir offset to resume after this entry
+	Type   uint8  // Linux DT_* type
+	Name   string
+}
+
+// Linux directory entry types
+const (
+	DTDIR uint8 = 4
+	DTREG uint8 = 8
+)
+
+// EncodedLen returns the number of bytes Encode will write
+func (d *Dirent) EncodedLen() int {
+	return 13 + 8 + 1 + 2 + len(d.Name)
+}
+
+func (d *Dirent) Encode(buf []byte) int {
+	n := d.Qid.Encode(buf)
+	binary.LittleEndian.PutUint64(buf[n:n+8], d.Offset)
+	buf[n+8] = d.Type
+	n += 9
+	return n + EncodeString(buf[n:], d.Name)
+}
+
+func DecodeDirent(buf []byte) (Dirent, int) {
+	if len(buf) < 24 {
+		return Dirent{}, 0
+	}
+	var d Dirent
+	var n int
+	d.Qid, n = DecodeQid(buf)
+	d.Offset = binary.LittleEndian.Uint64(buf[n : n+8])
+	d.Type = buf[n+8]
+	n += 9
+	var sn int
+	d.Name, sn = DecodeString(buf[n:])
+	if sn == 0 {
+		return Dirent{}, 0
+	}
+	return d, n + sn
+}
+
+// RreaddirMsg is the response to Treaddir
+type RreaddirMsg struct {
+	Data []byte // packed Dirent entries
+}
+
+func (m *RreaddirMsg) Type() uint8 { return Rreaddir }
+
+func (m *RreaddirMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(m.Data)))
+	copy(buf[4:], m.Data)
+	return 4 + len(m.Data)
+}
+
+// TfsyncMsg flushes a file to stable storage
+type TfsyncMsg struct {
+	Fid uint32
+}
+
+func (m *TfsyncMsg) Type() uint8 { return Tfsync }
+
+func (m *TfsyncMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Fid)
+	return 4
+}
+
+func DecodeTfsync(buf []byte) (*TfsyncMsg, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("Tfsync too short")
+	}
+	return &TfsyncMsg{
+		Fid: binary.LittleEndian.Uint32(buf[0:4]),
+	}, nil
+}
+
+// RfsyncMsg is the response to Tfsync
+type RfsyncMsg struct{}
+
+func (m *RfsyncMsg) Type() uint8 { return Rfsync }
+
+func (m *RfsyncMsg) Encode(buf []byte) int {
+	return 0
+}
+
+// TunlinkatMsg removes the named entry of the directory fid
+type TunlinkatMsg struct {
+	Dirfid uint32
+	Name   string
+	Flags  uint32
+}
+
+func (m *TunlinkatMsg) Type() uint8 { return Tunlinkat }
+
+func (m *TunlinkatMsg) Encode(buf []byte) int {
+	binary.LittleEndian.PutUint32(buf[0:4], m.Dirfid)
+	n := 4
+	n += EncodeString(buf[n:], m.Name)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Flags)
+	return n + 4
+}
+
+func DecodeTunlinkat(buf []byte) (*TunlinkatMsg, error) {
+	if len(buf) < 10 {
+		return nil, fmt.Errorf("Tunlinkat too short")
+	}
+	m := &TunlinkatMsg{
+		Dirfid: binary.LittleEndian.Uint32(buf[0:4]),
+	}
+	var sn int
+	m.Name, sn = DecodeString(buf[4:])
+	n := 4 + sn
+	if sn == 0 || len(buf) < n+4 {
+		return nil, fmt.Errorf("Tunlinkat truncated")
+	}
+	m.Flags = binary.LittleEndian.Uint32(buf[n : n+4])
+	return m, nil
+}
+
+// RunlinkatMsg is the response to Tunlinkat
+type RunlinkatMsg struct{}
+
+func (m *RunlinkatMsg) Type() uint8 { return Runlinkat }
+
+func (m *RunlinkatMsg) Encode(buf []byte) int {
+	return 0
+}
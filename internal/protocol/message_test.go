@@ -0,0 +1,249 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestTattach_Nuname(t *testing.T) {
+	buf := make([]byte, 64)
+	m := &TattachMsg{Fid: 1, Afid: NoFid, Uname: "glenda", Aname: ""}
+	n := m.Encode(buf)
+
+	// 9P2000.L appends n_uname after aname
+	binary.LittleEndian.PutUint32(buf[n:n+4], 1000)
+
+	got, err := DecodeTattach(buf[:n+4])
+	if err != nil {
+		t.Fatalf("DecodeTattach() error: %v", err)
+	}
+	if got.Uname != "glenda" || got.Nuname != 1000 {
+		t.Errorf("DecodeTattach() = %+v, want Uname=glenda Nuname=1000", got)
+	}
+
+	// Plain 9P2000 attach has no numeric uid
+	got, err = DecodeTattach(buf[:n])
+	if err != nil {
+		t.Fatalf("DecodeTattach() error: %v", err)
+	}
+	if got.Nuname != 0 {
+		t.Errorf("DecodeTattach() Nuname = %d, want 0", got.Nuname)
+	}
+}
+
+func TestRlerror_Encode(t *testing.T) {
+	buf := make([]byte, 16)
+	n := (&RlerrorMsg{Ecode: ENOENT}).Encode(buf)
+	if n != 4 || binary.LittleEndian.Uint32(buf) != ENOENT {
+		t.Errorf("Rlerror encoded %x, want ecode %d", buf[:n], ENOENT)
+	}
+}
+
+func TestTstatfs_RoundTrip(t *testing.T) {
+	buf := make([]byte, 16)
+	want := &TstatfsMsg{Fid: 7}
+	n := want.Encode(buf)
+	got, err := DecodeTstatfs(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTstatfs() = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := DecodeTstatfs(buf[:3]); err == nil {
+		t.Error("DecodeTstatfs() should fail on short buffer")
+	}
+}
+
+func TestRstatfs_Encode(t *testing.T) {
+	buf := make([]byte, 64)
+	m := &RstatfsMsg{FsType: V9FSMagic, Bsize: 4096, Blocks: 1, Fsid: 9, Namelen: 255}
+	n := m.Encode(buf)
+	if n != 60 {
+		t.Fatalf("Rstatfs length = %d, want 60", n)
+	}
+	if binary.LittleEndian.Uint32(buf[0:4]) != V9FSMagic ||
+		binary.LittleEndian.Uint32(buf[4:8]) != 4096 ||
+		binary.LittleEndian.Uint64(buf[8:16]) != 1 ||
+		binary.LittleEndian.Uint64(buf[48:56]) != 9 ||
+		binary.LittleEndian.Uint32(buf[56:60]) != 255 {
+		t.Errorf("Rstatfs encoded fields wrong: %x", buf[:n])
+	}
+}
+
+func TestTlopen_RoundTrip(t *testing.T) {
+	buf := make([]byte, 16)
+	want := &TlopenMsg{Fid: 3, Flags: 1 | LOTrunc}
+	n := want.Encode(buf)
+	got, err := DecodeTlopen(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("DecodeTlopen() = %+v, %v; want %+v", got, err, want)
+	}
+	if mode := got.Mode(); mode != OWRITE|OTRUNC {
+		t.Errorf("Mode() = %d, want %d", mode, OWRITE|OTRUNC)
+	}
+	if _, err := DecodeTlopen(buf[:7]); err == nil {
+		t.Error("DecodeTlopen() should fail on short buffer")
+	}
+}
+
+func TestRlopen_Encode(t *testing.T) {
+	buf := make([]byte, 32)
+	m := &RlopenMsg{Qid: Qid{Type: QTFILE, Version: 2, Path: 99}, Iounit: 512}
+	n := m.Encode(buf)
+	if n != 17 {
+		t.Fatalf("Rlopen length = %d, want 17", n)
+	}
+	qid, _ := DecodeQid(buf)
+	if qid != m.Qid || binary.LittleEndian.Uint32(buf[13:17]) != 512 {
+		t.Errorf("Rlopen encoded %x", buf[:n])
+	}
+}
+
+func TestTlcreate_RoundTrip(t *testing.T) {
+	buf := make([]byte, 64)
+	want := &TlcreateMsg{Fid: 4, Name: "shot.png", Flags: 2, Mode: 0644, Gid: 100}
+	n := want.Encode(buf)
+	got, err := DecodeTlcreate(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTlcreate() = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := DecodeTlcreate(buf[:n-1]); err == nil {
+		t.Error("DecodeTlcreate() should fail on truncated buffer")
+	}
+}
+
+func TestRlcreate_Encode(t *testing.T) {
+	buf := make([]byte, 32)
+	m := &RlcreateMsg{Qid: Qid{Path: 5}}
+	if n := m.Encode(buf); n != 17 {
+		t.Errorf("Rlcreate length = %d, want 17", n)
+	}
+}
+
+func TestTgetattr_RoundTrip(t *testing.T) {
+	buf := make([]byte, 16)
+	want := &TgetattrMsg{Fid: 2, RequestMask: GetattrBasic}
+	n := want.Encode(buf)
+	got, err := DecodeTgetattr(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTgetattr() = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := DecodeTgetattr(buf[:11]); err == nil {
+		t.Error("DecodeTgetattr() should fail on short buffer")
+	}
+}
+
+func TestRgetattr_Encode(t *testing.T) {
+	buf := make([]byte, 256)
+	m := &RgetattrMsg{
+		Valid:       GetattrBasic,
+		Qid:         Qid{Type: QTDIR, Path: 1},
+		Mode:        SIFDIR | 0555,
+		Uid:         1000,
+		Gid:         1000,
+		Nlink:       2,
+		Size:        42,
+		DataVersion: 7,
+	}
+	n := m.Encode(buf)
+	if n != 153 {
+		t.Fatalf("Rgetattr length = %d, want 153", n)
+	}
+	if binary.LittleEndian.Uint64(buf[0:8]) != GetattrBasic {
+		t.Error("Rgetattr valid mask not encoded first")
+	}
+	if binary.LittleEndian.Uint32(buf[21:25]) != SIFDIR|0555 {
+		t.Error("Rgetattr mode not encoded after qid")
+	}
+	if binary.LittleEndian.Uint64(buf[49:57]) != 42 {
+		t.Error("Rgetattr size not encoded after nlink and rdev")
+	}
+	if binary.LittleEndian.Uint64(buf[145:153]) != 7 {
+		t.Error("Rgetattr data_version not encoded last")
+	}
+}
+
+func TestTsetattr_RoundTrip(t *testing.T) {
+	buf := make([]byte, 64)
+	want := &TsetattrMsg{Fid: 1, Valid: 8, Mode: 0644, Uid: 1, Gid: 2, Size: 0, AtimeSec: 3, MtimeNsec: 4}
+	n := want.Encode(buf)
+	got, err := DecodeTsetattr(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTsetattr() = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := DecodeTsetattr(buf[:59]); err == nil {
+		t.Error("DecodeTsetattr() should fail on short buffer")
+	}
+}
+
+func TestTxattrwalk_RoundTrip(t *testing.T) {
+	buf := make([]byte, 64)
+	want := &TxattrwalkMsg{Fid: 1, Newfid: 2, Name: "security.selinux"}
+	n := want.Encode(buf)
+	got, err := DecodeTxattrwalk(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTxattrwalk() = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestRxattrwalk_Encode(t *testing.T) {
+	buf := make([]byte, 16)
+	n := (&RxattrwalkMsg{Size: 12}).Encode(buf)
+	if n != 8 || binary.LittleEndian.Uint64(buf) != 12 {
+		t.Errorf("Rxattrwalk encoded %x", buf[:n])
+	}
+}
+
+func TestTreaddir_RoundTrip(t *testing.T) {
+	buf := make([]byte, 16)
+	want := &TreaddirMsg{Fid: 1, Offset: 3, Count: 4096}
+	n := want.Encode(buf)
+	got, err := DecodeTreaddir(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTreaddir() = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestDirent_RoundTrip(t *testing.T) {
+	buf := make([]byte, 64)
+	want := Dirent{Qid: Qid{Type: QTDIR, Path: 8}, Offset: 2, Type: DTDIR, Name: "stream"}
+	n := want.Encode(buf)
+	if n != want.EncodedLen() {
+		t.Errorf("Encode() = %d bytes, EncodedLen() = %d", n, want.EncodedLen())
+	}
+	got, gn := DecodeDirent(buf[:n])
+	if gn != n || got != want {
+		t.Errorf("DecodeDirent() = %+v (%d bytes), want %+v (%d bytes)", got, gn, want, n)
+	}
+}
+
+func TestRreaddir_Encode(t *testing.T) {
+	buf := make([]byte, 64)
+	data := []byte{1, 2, 3}
+	n := (&RreaddirMsg{Data: data}).Encode(buf)
+	if n != 7 || binary.LittleEndian.Uint32(buf) != 3 {
+		t.Errorf("Rreaddir encoded %x", buf[:n])
+	}
+}
+
+func TestTfsync_RoundTrip(t *testing.T) {
+	buf := make([]byte, 16)
+	want := &TfsyncMsg{Fid: 11}
+	n := want.Encode(buf)
+	got, err := DecodeTfsync(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTfsync() = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestTunlinkat_RoundTrip(t *testing.T) {
+	buf := make([]byte, 64)
+	want := &TunlinkatMsg{Dirfid: 3, Name: "2", Flags: 0x200}
+	n := want.Encode(buf)
+	got, err := DecodeTunlinkat(buf[:n])
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeTunlinkat() = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := DecodeTunlinkat(buf[:n-1]); err == nil {
+		t.Error("DecodeTunlinkat() should fail on truncated buffer")
+	}
+}
@@ -1,5 +1,8 @@
 // Package protocol implements the 9P2000 protocol for the LLM filesystem.
 //
+// The 9P2000.L dialect is also negotiated so that the Linux kernel v9fs
+// client can mount the filesystem directly (version=9p2000.L).
+//
 // This is a minimal, clean implementation focused on the subset of 9P
 // needed for LLM interaction. It is designed to be:
 //   - Zero external dependencies (stdlib only)
@@ -22,6 +25,9 @@ const (
 	// Version is the protocol version we implement
 	Version = "9P2000"
 
+	// VersionL is the Linux dialect of 9P2000
+	VersionL = "9P2000.L"
+
 	// MaxMessageSize is the maximum size of a 9P message
 	MaxMessageSize = 8192
 
@@ -64,6 +70,65 @@ const (
 	Rwstat   uint8 = 127
 )
 
+// 9P2000.L message types
+const (
+	Rlerror      uint8 = 7
+	Tstatfs      uint8 = 8
+	Rstatfs      uint8 = 9
+	Tlopen       uint8 = 12
+	Rlopen       uint8 = 13
+	Tlcreate     uint8 = 14
+	Rlcreate     uint8 = 15
+	Tgetattr     uint8 = 24
+	Rgetattr     uint8 = 25
+	Tsetattr     uint8 = 26
+	Rsetattr     uint8 = 27
+	Txattrwalk   uint8 = 30
+	Rxattrwalk   uint8 = 31
+	Txattrcreate uint8 = 32
+	Rxattrcreate uint8 = 33
+	Treaddir     uint8 = 40
+	Rreaddir     uint8 = 41
+	Tfsync       uint8 = 50
+	Rfsync       uint8 = 51
+	Tunlinkat    uint8 = 76
+	Runlinkat    uint8 = 77
+)
+
+// Linux open flags carried by Tlopen and Tlcreate
+const (
+	LOAccmode uint32 = 0x3
+	LOTrunc   uint32 = 0x200
+)
+
+// Linux file type bits used in Rgetattr modes
+const (
+	SIFDIR uint32 = 0040000
+	SIFREG uint32 = 0100000
+)
+
+// GetattrBasic is the Rgetattr valid mask for the fields we fill in
+// (mode, nlink, uid, gid, rdev, atime, mtime, ctime, ino, size, blocks)
+const GetattrBasic uint64 = 0x000007ff
+
+// V9FSMagic is the filesystem type reported by Rstatfs
+const V9FSMagic uint32 = 0x01021997
+
+// Linux errno values sent in Rlerror
+const (
+	EPERM      uint32 = 1
+	ENOENT     uint32 = 2
+	EINTR      uint32 = 4
+	EIO        uint32 = 5
+	EBADF      uint32 = 9
+	EACCES     uint32 = 13
+	EEXIST     uint32 = 17
+	ENOTDIR    uint32 = 20
+	EISDIR     uint32 = 21
+	EINVAL     uint32 = 22
+	EOPNOTSUPP uint32 = 95
+)
+
 // Open modes
 const (
 	OREAD  uint8 = 0  // open for read
@@ -319,6 +384,17 @@ func MessageName(t uint8) string {
 		Tremove: "Tremove", Rremove: "Rremove",
 		Tstat: "Tstat", Rstat: "Rstat",
 		Twstat: "Twstat", Rwstat: "Rwstat",
+		Rlerror: "Rlerror",
+		Tstatfs: "Tstatfs", Rstatfs: "Rstatfs",
+		Tlopen: "Tlopen", Rlopen: "Rlopen",
+		Tlcreate: "Tlcreate", Rlcreate: "Rlcreate",
+		Tgetattr: "Tgetattr", Rgetattr: "Rgetattr",
+		Tsetattr: "Tsetattr", Rsetattr: "Rsetattr",
+		Txattrwalk: "Txattrwalk", Rxattrwalk: "Rxattrwalk",
+		Txattrcreate: "Txattrcreate", Rxattrcreate: "Rxattrcreate",
+		Treaddir: "Treaddir", Rreaddir: "Rreaddir",
+		Tfsync: "Tfsync", Rfsync: "Rfsync",
+		Tunlinkat: "Tunlinkat", Runlinkat: "Runlinkat",
 	}
 	if name, ok := names[t]; ok {
 		return name
@@ -6,7 +6,9 @@ import (
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // Server is a 9P file server
@@ -17,10 +19,24 @@ type Server struct {
 	clients map[net.Conn]*clientState
 }
 
-// clientState tracks state for a single client connection
+// clientState tracks state for a single client connection.
+// Requests on a connection are handled concurrently, so fids and
+// pending tags are guarded by mu.
 type clientState struct {
-	fids  map[uint32]File
-	msize uint32
+	mu      sync.Mutex
+	fids    map[uint32]File
+	keys    map[uint32]uint32 // fid -> server-wide key passed to FidAwareFile
+	msize   uint32
+	pending map[uint16]*request
+	dotl    bool   // negotiated 9P2000.L
+	uid     uint32 // numeric uid from the 9P2000.L attach
+}
+
+// request tracks an in-flight T-message so that Tflush can cancel it
+type request struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	flushed bool
 }
 
 // NewServer creates a new 9P server with the given root directory
@@ -50,21 +66,31 @@ func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
 			}
 		}
 
-		go s.handleConn(conn)
+		go s.handleConn(ctx, conn)
 	}
 }
 
 // ServeConn handles a single connection (useful for testing)
 func (s *Server) ServeConn(conn net.Conn) {
-	s.handleConn(conn)
+	s.handleConn(context.Background(), conn)
 }
 
-func (s *Server) handleConn(conn net.Conn) {
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 
+	// Cancelling the connection context aborts every in-flight request
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
 	state := &clientState{
-		fids:  make(map[uint32]File),
-		msize: MaxMessageSize,
+		fids:    make(map[uint32]File),
+		keys:    make(map[uint32]uint32),
+		msize:   MaxMessageSize,
+		pending: make(map[uint16]*request),
 	}
 
 	s.mu.Lock()
@@ -77,9 +103,24 @@ func (s *Server) handleConn(conn net.Conn) {
 		s.mu.Unlock()
 	}()
 
+	// Fids left open when the client goes away are clunked implicitly
+	defer state.closeAll()
+
 	dec := NewDecoder(conn)
 	enc := NewEncoder(conn)
-	buf := make([]byte, MaxMessageSize)
+	var encMu sync.Mutex
+
+	send := func(respType uint8, tag uint16, resp []byte) {
+		if s.debug {
+			log.Printf("> %s tag=%d len=%d", MessageName(respType), tag, len(resp))
+		}
+		encMu.Lock()
+		defer encMu.Unlock()
+		if err := enc.WriteMessage(respType, tag, resp); err != nil {
+			log.Printf("write error: %v", err)
+			conn.Close()
+		}
+	}
 
 	for {
 		msgType, tag, payload, err := dec.ReadMessage()
@@ -94,23 +135,179 @@ func (s *Server) handleConn(conn net.Conn) {
 			log.Printf("< %s tag=%d len=%d", MessageName(msgType), tag, len(payload))
 		}
 
-		resp, respType := s.handleMessage(state, msgType, payload, buf)
+		// Tversion resets the session, so it is handled inline once
+		// all outstanding requests have been aborted.
+		if msgType == Tversion {
+			state.abortAll()
+			wg.Wait()
+			buf := make([]byte, MaxMessageSize)
+			resp, respType := s.handleVersion(state, payload, buf)
+			send(respType, tag, resp)
+			continue
+		}
 
-		if s.debug {
-			log.Printf("> %s tag=%d len=%d", MessageName(respType), tag, len(resp))
+		reqCtx, reqCancel := context.WithCancel(ctx)
+		req := &request{cancel: reqCancel, done: make(chan struct{})}
+		if !state.addRequest(tag, req) {
+			reqCancel()
+			buf := make([]byte, MaxMessageSize)
+			resp, respType := s.errorResponse(buf, fmt.Sprintf("tag %d already in use", tag))
+			resp, respType = s.translateError(state, buf, resp, respType)
+			send(respType, tag, resp)
+			continue
 		}
 
-		if err := enc.WriteMessage(respType, tag, resp); err != nil {
-			log.Printf("write error: %v", err)
-			return
+		// The decoder reuses its buffer for the next message
+		payload = append([]byte(nil), payload...)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(req.done)
+			defer reqCancel()
+
+			buf := make([]byte, MaxMessageSize)
+			resp, respType := s.handleMessage(reqCtx, state, msgType, tag, payload, buf)
+
+			// A flushed request must not be answered
+			if state.finishRequest(tag) {
+				send(respType, tag, resp)
+			}
+		}()
+	}
+}
+
+// addRequest registers an in-flight request, failing if the tag is in use
+func (cs *clientState) addRequest(tag uint16, req *request) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, exists := cs.pending[tag]; exists {
+		return false
+	}
+	cs.pending[tag] = req
+	return true
+}
+
+// finishRequest removes a completed request and reports whether its
+// response should still be sent (false if it was flushed)
+func (cs *clientState) finishRequest(tag uint16) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	req, ok := cs.pending[tag]
+	if !ok {
+		return false
+	}
+	delete(cs.pending, tag)
+	return !req.flushed
+}
+
+// abortAll cancels every in-flight request and suppresses their responses
+func (cs *clientState) abortAll() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, req := range cs.pending {
+		req.flushed = true
+		req.cancel()
+	}
+}
+
+// isDotl reports whether the connection negotiated 9P2000.L
+func (cs *clientState) isDotl() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.dotl
+}
+
+// fidKeyCounter allocates server-wide fid keys
+var fidKeyCounter uint32
+
+// bindLocked binds fid to file. Each fid gets a key that is unique across
+// all connections, so per-fid state in shared files never collides between
+// clients that happen to pick the same fid numbers. cs.mu must be held.
+func (cs *clientState) bindLocked(fid uint32, file File) {
+	cs.fids[fid] = file
+	if _, ok := cs.keys[fid]; !ok {
+		cs.keys[fid] = atomic.AddUint32(&fidKeyCounter, 1)
+	}
+}
+
+// unbind removes fid, returning its file and key
+func (cs *clientState) unbind(fid uint32) (File, uint32, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	file, ok := cs.fids[fid]
+	key := cs.keys[fid]
+	delete(cs.fids, fid)
+	delete(cs.keys, fid)
+	return file, key, ok
+}
+
+// fidKey returns the server-wide key for fid
+func (cs *clientState) fidKey(fid uint32) uint32 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.keys[fid]
+}
+
+// closeAll clunks every fid, as happens on Tversion or disconnect. Fids
+// clunked this way are aborted where the file supports it, so that a
+// value cut short is not committed.
+func (cs *clientState) closeAll() {
+	cs.mu.Lock()
+	fids, keys := cs.fids, cs.keys
+	cs.fids = make(map[uint32]File)
+	cs.keys = make(map[uint32]uint32)
+	cs.mu.Unlock()
+
+	for fid, file := range fids {
+		switch f := file.(type) {
+		case FidAborter:
+			f.AbortFid(keys[fid])
+		case FidAwareFile:
+			f.CloseFid(keys[fid])
 		}
+		file.Close()
 	}
 }
 
-func (s *Server) handleMessage(state *clientState, msgType uint8, payload []byte, buf []byte) ([]byte, uint8) {
+// lookupFid returns the file bound to fid
+func (cs *clientState) lookupFid(fid uint32) (File, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	f, ok := cs.fids[fid]
+	return f, ok
+}
+
+func (s *Server) handleMessage(ctx context.Context, state *clientState, msgType uint8, tag uint16, payload []byte, buf []byte) ([]byte, uint8) {
+	resp, respType := s.dispatch(ctx, state, msgType, tag, payload, buf)
+	return s.translateError(state, buf, resp, respType)
+}
+
+func (s *Server) dispatch(ctx context.Context, state *clientState, msgType uint8, tag uint16, payload []byte, buf []byte) ([]byte, uint8) {
+	if state.isDotl() {
+		switch msgType {
+		case Tlopen:
+			return s.handleLopen(state, payload, buf)
+		case Tlcreate:
+			return s.handleLcreate(state, payload, buf)
+		case Tgetattr:
+			return s.handleGetattr(state, payload, buf)
+		case Tsetattr:
+			return s.handleSetattr(state, payload, buf)
+		case Treaddir:
+			return s.handleReaddir(state, payload, buf)
+		case Tstatfs:
+			return s.handleStatfs(state, payload, buf)
+		case Txattrwalk:
+			return s.handleXattrwalk(state, payload, buf)
+		case Tfsync:
+			return s.handleFsync(state, payload, buf)
+		case Tunlinkat:
+			return s.handleUnlinkat(state, payload, buf)
+		}
+	}
+
 	switch msgType {
-	case Tversion:
-		return s.handleVersion(state, payload, buf)
 	case Tattach:
 		return s.handleAttach(state, payload, buf)
 	case Twalk:
@@ -118,20 +315,62 @@ func (s *Server) handleMessage(state *clientState, msgType uint8, payload []byte
 	case Topen:
 		return s.handleOpen(state, payload, buf)
 	case Tread:
-		return s.handleRead(state, payload, buf)
+		return s.handleRead(ctx, state, payload, buf)
 	case Twrite:
-		return s.handleWrite(state, payload, buf)
+		return s.handleWrite(ctx, state, payload, buf)
 	case Tclunk:
-		return s.handleClunk(state, payload, buf)
+		return s.handleClunk(ctx, state, payload, buf)
+	case Tremove:
+		return s.handleRemove(ctx, state, payload, buf)
 	case Tstat:
 		return s.handleStat(state, payload, buf)
 	case Tflush:
-		return s.handleFlush(state, payload, buf)
+		return s.handleFlush(state, tag, payload, buf)
 	default:
 		return s.errorResponse(buf, fmt.Sprintf("unknown message type: %d", msgType))
 	}
 }
 
+// translateError converts an Rerror into an Rlerror for 9P2000.L sessions,
+// which carry errno values instead of error strings
+func (s *Server) translateError(state *clientState, buf []byte, resp []byte, respType uint8) ([]byte, uint8) {
+	if respType != Rerror || !state.isDotl() {
+		return resp, respType
+	}
+	ename, _ := DecodeString(resp)
+	lerr := &RlerrorMsg{Ecode: errnoFor(ename)}
+	n := lerr.Encode(buf)
+	return buf[:n], Rlerror
+}
+
+// errnoFor maps an error string to the closest Linux errno
+func errnoFor(ename string) uint32 {
+	switch ename {
+	case ErrNotFound.Error():
+		return ENOENT
+	case ErrPermission.Error():
+		return EACCES
+	case ErrNotDir.Error():
+		return ENOTDIR
+	case ErrIsDir.Error():
+		return EISDIR
+	case ErrBadFid.Error(), ErrFidInUse.Error():
+		return EBADF
+	case ErrBadOffset.Error():
+		return EINVAL
+	case ErrExists.Error():
+		return EEXIST
+	case ErrNotSupported.Error():
+		return EOPNOTSUPP
+	case context.Canceled.Error():
+		return EINTR
+	}
+	if strings.HasPrefix(ename, "unknown message type") {
+		return EOPNOTSUPP
+	}
+	return EIO
+}
+
 func (s *Server) errorResponse(buf []byte, msg string) ([]byte, uint8) {
 	resp := &RerrorMsg{Ename: msg}
 	n := resp.Encode(buf)
@@ -149,14 +388,25 @@ func (s *Server) handleVersion(state *clientState, payload []byte, buf []byte) (
 	if msize > MaxMessageSize {
 		msize = MaxMessageSize
 	}
-	state.msize = msize
-
-	// Check version - accept both 9P2000 and Styx (Inferno's name)
+	// Check version - accept 9P2000, 9P2000.L and Styx (Inferno's name).
+	// Other 9P2000 extensions (e.g. 9P2000.u) fall back to plain 9P2000.
 	version := msg.Version
-	if msg.Version != Version && msg.Version != "Styx" {
+	switch {
+	case msg.Version == Version, msg.Version == VersionL, msg.Version == "Styx":
+	case strings.HasPrefix(msg.Version, Version+"."):
+		version = Version
+	default:
 		version = "unknown"
 	}
 
+	// A new version message starts a fresh session
+	state.closeAll()
+
+	state.mu.Lock()
+	state.msize = msize
+	state.dotl = version == VersionL
+	state.mu.Unlock()
+
 	if s.debug {
 		log.Printf("Version negotiation: client=%q responding=%q msize=%d", msg.Version, version, msize)
 	}
@@ -172,13 +422,25 @@ func (s *Server) handleAttach(state *clientState, payload []byte, buf []byte) ([
 		return s.errorResponse(buf, err.Error())
 	}
 
+	var root Dir = s.root
+	if a, ok := s.root.(Attacher); ok {
+		if root, err = a.Attach(msg.Uname, msg.Aname); err != nil {
+			return s.errorResponse(buf, err.Error())
+		}
+	}
+
+	state.mu.Lock()
 	if _, exists := state.fids[msg.Fid]; exists {
+		state.mu.Unlock()
 		return s.errorResponse(buf, ErrFidInUse.Error())
 	}
+	state.bindLocked(msg.Fid, root)
+	if msg.Nuname != NoFid {
+		state.uid = msg.Nuname
+	}
+	state.mu.Unlock()
 
-	state.fids[msg.Fid] = s.root
-
-	resp := &RattachMsg{Qid: s.root.Stat().Qid}
+	resp := &RattachMsg{Qid: root.Stat().Qid}
 	n := resp.Encode(buf)
 	return buf[:n], Rattach
 }
@@ -189,13 +451,13 @@ func (s *Server) handleWalk(state *clientState, payload []byte, buf []byte) ([]b
 		return s.errorResponse(buf, err.Error())
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, exists := state.lookupFid(msg.Fid)
 	if !exists {
 		return s.errorResponse(buf, ErrBadFid.Error())
 	}
 
 	if msg.Fid != msg.Newfid {
-		if _, exists := state.fids[msg.Newfid]; exists {
+		if _, exists := state.lookupFid(msg.Newfid); exists {
 			return s.errorResponse(buf, ErrFidInUse.Error())
 		}
 	}
@@ -222,7 +484,13 @@ func (s *Server) handleWalk(state *clientState, payload []byte, buf []byte) ([]b
 
 	// Only update fid if we walked at least one element (or no elements requested)
 	if len(qids) == len(msg.Names) {
-		state.fids[msg.Newfid] = current
+		state.mu.Lock()
+		if _, exists := state.fids[msg.Newfid]; exists && msg.Fid != msg.Newfid {
+			state.mu.Unlock()
+			return s.errorResponse(buf, ErrFidInUse.Error())
+		}
+		state.bindLocked(msg.Newfid, current)
+		state.mu.Unlock()
 	}
 
 	resp := &RwalkMsg{Qids: qids}
@@ -236,12 +504,12 @@ func (s *Server) handleOpen(state *clientState, payload []byte, buf []byte) ([]b
 		return s.errorResponse(buf, err.Error())
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, exists := state.lookupFid(msg.Fid)
 	if !exists {
 		return s.errorResponse(buf, ErrBadFid.Error())
 	}
 
-	if err := file.Open(msg.Mode); err != nil {
+	if err := openFile(state, msg.Fid, file, msg.Mode); err != nil {
 		return s.errorResponse(buf, err.Error())
 	}
 
@@ -253,20 +521,31 @@ func (s *Server) handleOpen(state *clientState, payload []byte, buf []byte) ([]b
 	return buf[:n], Ropen
 }
 
-func (s *Server) handleRead(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+// openFile opens file for fid, telling fid-aware openers which fid it is
+func openFile(state *clientState, fid uint32, file File, mode uint8) error {
+	if fo, ok := file.(FidOpener); ok {
+		return fo.OpenFid(state.fidKey(fid), mode)
+	}
+	return file.Open(mode)
+}
+
+func (s *Server) handleRead(ctx context.Context, state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTread(payload)
 	if err != nil {
 		return s.errorResponse(buf, err.Error())
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, exists := state.lookupFid(msg.Fid)
 	if !exists {
 		return s.errorResponse(buf, ErrBadFid.Error())
 	}
 
 	// Limit read size to available buffer
+	state.mu.Lock()
+	msize := state.msize
+	state.mu.Unlock()
 	count := msg.Count
-	maxData := state.msize - 4 - 1 - 2 - 4 // size, type, tag, count
+	maxData := msize - 4 - 1 - 2 - 4 // size, type, tag, count
 	if count > maxData {
 		count = maxData
 	}
@@ -274,10 +553,15 @@ func (s *Server) handleRead(state *clientState, payload []byte, buf []byte) ([]b
 	data := make([]byte, count)
 	var n int
 
-	// Check for fid-aware file
-	if faf, ok := file.(FidAwareFile); ok {
-		n, err = faf.ReadFid(msg.Fid, data, int64(msg.Offset))
-	} else {
+	// Prefer the most specific interface the file implements
+	switch f := file.(type) {
+	case ContextFidAwareFile:
+		n, err = f.ReadFidContext(ctx, state.fidKey(msg.Fid), data, int64(msg.Offset))
+	case FidAwareFile:
+		n, err = f.ReadFid(state.fidKey(msg.Fid), data, int64(msg.Offset))
+	case ContextAwareFile:
+		n, err = f.ReadContext(ctx, data, int64(msg.Offset))
+	default:
 		n, err = file.Read(data, int64(msg.Offset))
 	}
 
@@ -290,23 +574,28 @@ func (s *Server) handleRead(state *clientState, payload []byte, buf []byte) ([]b
 	return buf[:rn], Rread
 }
 
-func (s *Server) handleWrite(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+func (s *Server) handleWrite(ctx context.Context, state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTwrite(payload)
 	if err != nil {
 		return s.errorResponse(buf, err.Error())
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, exists := state.lookupFid(msg.Fid)
 	if !exists {
 		return s.errorResponse(buf, ErrBadFid.Error())
 	}
 
 	var n int
 
-	// Check for fid-aware file
-	if faf, ok := file.(FidAwareFile); ok {
-		n, err = faf.WriteFid(msg.Fid, msg.Data, int64(msg.Offset))
-	} else {
+	// Prefer the most specific interface the file implements
+	switch f := file.(type) {
+	case ContextFidAwareFile:
+		n, err = f.WriteFidContext(ctx, state.fidKey(msg.Fid), msg.Data, int64(msg.Offset))
+	case FidAwareFile:
+		n, err = f.WriteFid(state.fidKey(msg.Fid), msg.Data, int64(msg.Offset))
+	case ContextAwareFile:
+		n, err = f.WriteContext(ctx, msg.Data, int64(msg.Offset))
+	default:
 		n, err = file.Write(msg.Data, int64(msg.Offset))
 	}
 
@@ -319,37 +608,81 @@ func (s *Server) handleWrite(state *clientState, payload []byte, buf []byte) ([]
 	return buf[:rn], Rwrite
 }
 
-func (s *Server) handleClunk(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+func (s *Server) handleClunk(ctx context.Context, state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTclunk(payload)
 	if err != nil {
 		return s.errorResponse(buf, err.Error())
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, key, exists := state.unbind(msg.Fid)
 	if !exists {
 		return s.errorResponse(buf, ErrBadFid.Error())
 	}
 
-	// Call CloseFid for fid-aware files to clean up per-fid state
-	if faf, ok := file.(FidAwareFile); ok {
-		faf.CloseFid(msg.Fid)
+	// Call CloseFid for fid-aware files to clean up per-fid state. Files
+	// that commit buffered writes on clunk may fail; the fid is gone
+	// regardless, but the client still hears about the error.
+	switch f := file.(type) {
+	case ContextFidAwareFile:
+		err = f.CloseFidContext(ctx, key)
+	case FidAwareFile:
+		err = f.CloseFid(key)
 	}
 
 	file.Close()
-	delete(state.fids, msg.Fid)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
 
 	resp := &RclunkMsg{}
 	n := resp.Encode(buf)
 	return buf[:n], Rclunk
 }
 
+func (s *Server) handleRemove(ctx context.Context, state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTremove(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	// The fid is clunked whether or not the remove succeeds
+	file, key, exists := state.unbind(msg.Fid)
+	if !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+	switch f := file.(type) {
+	case ContextFidAwareFile:
+		f.CloseFidContext(ctx, key)
+	case FidAwareFile:
+		f.CloseFid(key)
+	}
+	file.Close()
+
+	if err := removeFile(file); err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	resp := &RremoveMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rremove
+}
+
+// removeFile removes file if it allows that
+func removeFile(file File) error {
+	remover, ok := file.(Remover)
+	if !ok {
+		return ErrPermission
+	}
+	return remover.Remove()
+}
+
 func (s *Server) handleStat(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
 	msg, err := DecodeTstat(payload)
 	if err != nil {
 		return s.errorResponse(buf, err.Error())
 	}
 
-	file, exists := state.fids[msg.Fid]
+	file, exists := state.lookupFid(msg.Fid)
 	if !exists {
 		return s.errorResponse(buf, ErrBadFid.Error())
 	}
@@ -359,9 +692,271 @@ func (s *Server) handleStat(state *clientState, payload []byte, buf []byte) ([]b
 	return buf[:n], Rstat
 }
 
-func (s *Server) handleFlush(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
-	// We don't have async operations to cancel, so just respond OK
+func (s *Server) handleFlush(state *clientState, tag uint16, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTflush(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	// Cancel the old request and wait for it to unwind. Its response is
+	// suppressed, so Rflush is the only reply the client sees for oldtag.
+	state.mu.Lock()
+	old, exists := state.pending[msg.Oldtag]
+	if msg.Oldtag == tag {
+		exists = false // flushing ourselves would deadlock
+	}
+	if exists {
+		old.flushed = true
+		old.cancel()
+	}
+	state.mu.Unlock()
+
+	if exists {
+		<-old.done
+	}
+
 	resp := &RflushMsg{}
 	n := resp.Encode(buf)
 	return buf[:n], Rflush
 }
+
+// 9P2000.L handlers
+
+func (s *Server) handleLopen(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTlopen(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	file, exists := state.lookupFid(msg.Fid)
+	if !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	if err := openFile(state, msg.Fid, file, msg.Mode()); err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	resp := &RlopenMsg{Qid: file.Stat().Qid}
+	n := resp.Encode(buf)
+	return buf[:n], Rlopen
+}
+
+func (s *Server) handleLcreate(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTlcreate(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	file, exists := state.lookupFid(msg.Fid)
+	if !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	creator, ok := file.(Creator)
+	if !ok {
+		return s.errorResponse(buf, ErrPermission.Error())
+	}
+
+	created, err := creator.Create(msg.Name, msg.Mode&0777, linuxFlagsToMode(msg.Flags))
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	// The fid now refers to the newly created, opened file
+	state.mu.Lock()
+	state.bindLocked(msg.Fid, created)
+	state.mu.Unlock()
+
+	resp := &RlcreateMsg{Qid: created.Stat().Qid}
+	n := resp.Encode(buf)
+	return buf[:n], Rlcreate
+}
+
+func (s *Server) handleGetattr(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTgetattr(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	file, exists := state.lookupFid(msg.Fid)
+	if !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	state.mu.Lock()
+	uid := state.uid
+	state.mu.Unlock()
+
+	st := file.Stat()
+	mode := st.Mode & 0777
+	nlink := uint64(1)
+	if st.Mode&DMDIR != 0 {
+		mode |= SIFDIR
+		nlink = 2
+	} else {
+		mode |= SIFREG
+	}
+
+	resp := &RgetattrMsg{
+		Valid:    GetattrBasic,
+		Qid:      st.Qid,
+		Mode:     mode,
+		Uid:      uid,
+		Gid:      uid,
+		Nlink:    nlink,
+		Size:     st.Length,
+		Blksize:  4096,
+		Blocks:   (st.Length + 511) / 512,
+		AtimeSec: uint64(st.Atime),
+		MtimeSec: uint64(st.Mtime),
+		CtimeSec: uint64(st.Mtime),
+	}
+	n := resp.Encode(buf)
+	return buf[:n], Rgetattr
+}
+
+func (s *Server) handleSetattr(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTsetattr(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	if _, exists := state.lookupFid(msg.Fid); !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	// Attributes are synthesized from file state, so changes (chmod,
+	// utimes, truncate-to-zero before a write) are accepted and ignored.
+	resp := &RsetattrMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rsetattr
+}
+
+func (s *Server) handleReaddir(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTreaddir(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	file, exists := state.lookupFid(msg.Fid)
+	if !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	dir, ok := file.(Dir)
+	if !ok {
+		return s.errorResponse(buf, ErrNotDir.Error())
+	}
+
+	state.mu.Lock()
+	msize := state.msize
+	state.mu.Unlock()
+	count := msg.Count
+	maxData := msize - 4 - 1 - 2 - 4 // size, type, tag, count
+	if count > maxData {
+		count = maxData
+	}
+
+	// The offset is an index cookie: each entry carries the index of the
+	// entry that follows it.
+	children := dir.Children()
+	data := make([]byte, count)
+	used := 0
+	for i := int(msg.Offset); i < len(children); i++ {
+		st := children[i].Stat()
+		d := Dirent{Qid: st.Qid, Offset: uint64(i + 1), Type: DTREG, Name: st.Name}
+		if st.Mode&DMDIR != 0 {
+			d.Type = DTDIR
+		}
+		if used+d.EncodedLen() > int(count) {
+			break
+		}
+		used += d.Encode(data[used:])
+	}
+
+	resp := &RreaddirMsg{Data: data[:used]}
+	n := resp.Encode(buf)
+	return buf[:n], Rreaddir
+}
+
+func (s *Server) handleStatfs(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTstatfs(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	if _, exists := state.lookupFid(msg.Fid); !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	resp := &RstatfsMsg{FsType: V9FSMagic, Bsize: 4096, Namelen: 255}
+	n := resp.Encode(buf)
+	return buf[:n], Rstatfs
+}
+
+func (s *Server) handleXattrwalk(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTxattrwalk(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	if _, exists := state.lookupFid(msg.Fid); !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	// We have no extended attributes: newfid reads as an empty list
+	state.mu.Lock()
+	if _, exists := state.fids[msg.Newfid]; exists {
+		state.mu.Unlock()
+		return s.errorResponse(buf, ErrFidInUse.Error())
+	}
+	state.bindLocked(msg.Newfid, NewStaticFile(msg.Name, nil))
+	state.mu.Unlock()
+
+	resp := &RxattrwalkMsg{Size: 0}
+	n := resp.Encode(buf)
+	return buf[:n], Rxattrwalk
+}
+
+func (s *Server) handleFsync(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTfsync(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	if _, exists := state.lookupFid(msg.Fid); !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+
+	resp := &RfsyncMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Rfsync
+}
+
+func (s *Server) handleUnlinkat(state *clientState, payload []byte, buf []byte) ([]byte, uint8) {
+	msg, err := DecodeTunlinkat(payload)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	file, exists := state.lookupFid(msg.Dirfid)
+	if !exists {
+		return s.errorResponse(buf, ErrBadFid.Error())
+	}
+	dir, ok := file.(Dir)
+	if !ok {
+		return s.errorResponse(buf, ErrNotDir.Error())
+	}
+	child, err := dir.Lookup(msg.Name)
+	if err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+	if err := removeFile(child); err != nil {
+		return s.errorResponse(buf, err.Error())
+	}
+
+	resp := &RunlinkatMsg{}
+	n := resp.Encode(buf)
+	return buf[:n], Runlinkat
+}
@@ -0,0 +1,372 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingFile blocks writes until its context is cancelled
+type blockingFile struct {
+	*BaseFile
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func newBlockingFile(name string) *blockingFile {
+	return &blockingFile{
+		BaseFile:  NewBaseFile(name, 0666),
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+func (f *blockingFile) ReadContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	return f.Read(p, offset)
+}
+
+func (f *blockingFile) WriteContext(ctx context.Context, p []byte, offset int64) (int, error) {
+	close(f.started)
+	<-ctx.Done()
+	close(f.cancelled)
+	return 0, ctx.Err()
+}
+
+// testClient speaks raw 9P to a server over a pipe
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	enc  *Encoder
+	dec  *Decoder
+	buf  []byte
+}
+
+func newTestClient(t *testing.T, root Dir) *testClient {
+	server, client := net.Pipe()
+	go NewServer(root).ServeConn(server)
+	t.Cleanup(func() { client.Close() })
+	return &testClient{
+		t:    t,
+		conn: client,
+		enc:  NewEncoder(client),
+		dec:  NewDecoder(client),
+		buf:  make([]byte, MaxMessageSize),
+	}
+}
+
+func (c *testClient) send(tag uint16, m Message) {
+	c.t.Helper()
+	n := m.Encode(c.buf)
+	if err := c.enc.WriteMessage(m.Type(), tag, c.buf[:n]); err != nil {
+		c.t.Fatalf("send %s: %v", MessageName(m.Type()), err)
+	}
+}
+
+func (c *testClient) recv() (uint8, uint16, []byte) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	msgType, tag, payload, err := c.dec.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("recv: %v", err)
+	}
+	return msgType, tag, append([]byte(nil), payload...)
+}
+
+func (c *testClient) rpc(tag uint16, m Message, want uint8) []byte {
+	c.t.Helper()
+	c.send(tag, m)
+	msgType, rtag, payload := c.recv()
+	if msgType != want || rtag != tag {
+		ename, _ := DecodeString(payload)
+		c.t.Fatalf("rpc %s: got %s tag=%d (%s), want %s tag=%d",
+			MessageName(m.Type()), MessageName(msgType), rtag, ename, MessageName(want), tag)
+	}
+	return payload
+}
+
+func TestServer_FlushCancelsInFlightWrite(t *testing.T) {
+	root := NewStaticDir("root")
+	slow := newBlockingFile("slow")
+	root.AddChild(slow)
+	root.AddChild(NewStaticFile("info", []byte("hello")))
+
+	c := newTestClient(t, root)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "test"}, Rattach)
+	c.rpc(2, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"slow"}}, Rwalk)
+	c.rpc(3, &TopenMsg{Fid: 1, Mode: OWRITE}, Ropen)
+
+	// Start a write that blocks until cancelled
+	c.send(4, &TwriteMsg{Fid: 1, Data: []byte("prompt")})
+	select {
+	case <-slow.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("write never reached the file")
+	}
+
+	// Other requests on the same connection are still served
+	c.rpc(5, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"info"}}, Rwalk)
+	c.rpc(6, &TstatMsg{Fid: 2}, Rstat)
+
+	// Flushing the write cancels its context; only Rflush comes back
+	c.rpc(7, &TflushMsg{Oldtag: 4}, Rflush)
+	select {
+	case <-slow.cancelled:
+	default:
+		t.Fatal("flush did not cancel the write context")
+	}
+
+	// The connection stays usable afterwards
+	c.rpc(8, &TclunkMsg{Fid: 2}, Rclunk)
+}
+
+func TestServer_FlushUnknownTag(t *testing.T) {
+	c := newTestClient(t, NewStaticDir("root"))
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TflushMsg{Oldtag: 42}, Rflush)
+}
+
+// lattachMsg encodes a 9P2000.L attach, which carries a numeric uid
+type lattachMsg struct {
+	TattachMsg
+}
+
+func (m *lattachMsg) Encode(buf []byte) int {
+	n := m.TattachMsg.Encode(buf)
+	binary.LittleEndian.PutUint32(buf[n:n+4], m.Nuname)
+	return n + 4
+}
+
+func TestServer_DotL(t *testing.T) {
+	root := NewStaticDir("root")
+	root.AddChild(NewStaticFile("info", []byte("hello")))
+	sub := NewStaticDir("stream")
+	root.AddChild(sub)
+
+	c := newTestClient(t, root)
+	payload := c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	if v, _ := DecodeString(payload[4:]); v != VersionL {
+		t.Fatalf("Rversion version = %q, want %q", v, VersionL)
+	}
+	c.rpc(1, &lattachMsg{TattachMsg{Fid: 0, Afid: NoFid, Uname: "test", Nuname: 1000}}, Rattach)
+
+	// Readdir lists the root's children with resumable offsets
+	c.rpc(2, &TwalkMsg{Fid: 0, Newfid: 1}, Rwalk)
+	c.rpc(3, &TlopenMsg{Fid: 1, Flags: 0}, Rlopen)
+	payload = c.rpc(4, &TreaddirMsg{Fid: 1, Offset: 0, Count: 4096}, Rreaddir)
+	data := payload[4:]
+	var names []string
+	for len(data) > 0 {
+		d, n := DecodeDirent(data)
+		if n == 0 {
+			t.Fatal("malformed dirent")
+		}
+		names = append(names, d.Name)
+		data = data[n:]
+	}
+	if len(names) != 2 || names[0] != "info" || names[1] != "stream" {
+		t.Errorf("readdir names = %v, want [info stream]", names)
+	}
+	payload = c.rpc(5, &TreaddirMsg{Fid: 1, Offset: 2, Count: 4096}, Rreaddir)
+	if binary.LittleEndian.Uint32(payload) != 0 {
+		t.Error("readdir past the end should return no entries")
+	}
+
+	// Getattr reports Linux modes and the attaching uid
+	c.rpc(6, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"info"}}, Rwalk)
+	payload = c.rpc(7, &TgetattrMsg{Fid: 2, RequestMask: GetattrBasic}, Rgetattr)
+	if mode := binary.LittleEndian.Uint32(payload[21:25]); mode != SIFREG|0444 {
+		t.Errorf("getattr mode = %o, want %o", mode, SIFREG|0444)
+	}
+	if uid := binary.LittleEndian.Uint32(payload[25:29]); uid != 1000 {
+		t.Errorf("getattr uid = %d, want 1000", uid)
+	}
+	if size := binary.LittleEndian.Uint64(payload[49:57]); size != 5 {
+		t.Errorf("getattr size = %d, want 5", size)
+	}
+
+	c.rpc(8, &TlopenMsg{Fid: 2, Flags: 0}, Rlopen)
+	payload = c.rpc(9, &TreadMsg{Fid: 2, Count: 100}, Rread)
+	if string(payload[4:]) != "hello" {
+		t.Errorf("read = %q, want hello", payload[4:])
+	}
+
+	c.rpc(10, &TstatfsMsg{Fid: 0}, Rstatfs)
+	c.rpc(11, &TxattrwalkMsg{Fid: 2, Newfid: 3, Name: "security.selinux"}, Rxattrwalk)
+	c.rpc(12, &TsetattrMsg{Fid: 2, Valid: 8}, Rsetattr)
+
+	// Errors are reported as errnos
+	c.rpc(13, &TwalkMsg{Fid: 0, Newfid: 4, Names: []string{"info"}}, Rwalk)
+	payload = c.rpc(14, &TlcreateMsg{Fid: 4, Name: "new", Mode: 0644}, Rlerror)
+	if ecode := binary.LittleEndian.Uint32(payload); ecode != EACCES {
+		t.Errorf("lcreate on a file: errno = %d, want %d", ecode, EACCES)
+	}
+	payload = c.rpc(15, &TgetattrMsg{Fid: 99}, Rlerror)
+	if ecode := binary.LittleEndian.Uint32(payload); ecode != EBADF {
+		t.Errorf("getattr on bad fid: errno = %d, want %d", ecode, EBADF)
+	}
+}
+
+// anameRoot serves a different directory per attach name
+type anameRoot struct {
+	*StaticDir
+	alt *StaticDir
+}
+
+func (r *anameRoot) Attach(uname, aname string) (Dir, error) {
+	switch aname {
+	case "":
+		return r, nil
+	case "alt":
+		return r.alt, nil
+	}
+	return nil, ErrNotFound
+}
+
+func TestServer_AttachAname(t *testing.T) {
+	root := &anameRoot{StaticDir: NewStaticDir("root"), alt: NewStaticDir("alt")}
+	root.AddChild(NewStaticFile("main", nil))
+	root.alt.AddChild(NewStaticFile("other", nil))
+
+	c := newTestClient(t, root)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "test"}, Rattach)
+	c.rpc(2, &TattachMsg{Fid: 1, Afid: NoFid, Uname: "test", Aname: "alt"}, Rattach)
+	c.rpc(3, &TattachMsg{Fid: 2, Afid: NoFid, Uname: "test", Aname: "nope"}, Rerror)
+
+	c.rpc(4, &TwalkMsg{Fid: 0, Newfid: 3, Names: []string{"main"}}, Rwalk)
+	c.rpc(5, &TwalkMsg{Fid: 1, Newfid: 4, Names: []string{"other"}}, Rwalk)
+	if payload := c.rpc(6, &TwalkMsg{Fid: 1, Newfid: 5, Names: []string{"main"}}, Rwalk); len(payload) != 2 || payload[0] != 0 {
+		t.Error("alt tree should not contain the default tree's files")
+	}
+}
+
+// removableFile records that it was removed
+type removableFile struct {
+	*StaticFile
+	removed bool
+}
+
+func (f *removableFile) Remove() error {
+	f.removed = true
+	return nil
+}
+
+func TestServer_Remove(t *testing.T) {
+	root := NewStaticDir("root")
+	root.AddChild(NewStaticFile("fixed", nil))
+	a := &removableFile{StaticFile: NewStaticFile("a", nil)}
+	b := &removableFile{StaticFile: NewStaticFile("b", nil)}
+	root.AddChild(a)
+	root.AddChild(b)
+
+	c := newTestClient(t, root)
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: VersionL}, Rversion)
+	c.rpc(1, &lattachMsg{TattachMsg{Fid: 0, Afid: NoFid, Uname: "test", Nuname: 1000}}, Rattach)
+
+	// Tremove removes the file and clunks the fid
+	c.rpc(2, &TwalkMsg{Fid: 0, Newfid: 1, Names: []string{"a"}}, Rwalk)
+	c.rpc(3, &TremoveMsg{Fid: 1}, Rremove)
+	if !a.removed {
+		t.Error("Tremove did not remove a")
+	}
+	c.rpc(4, &TclunkMsg{Fid: 1}, Rlerror)
+
+	// Tunlinkat removes a named entry of a directory
+	c.rpc(5, &TunlinkatMsg{Dirfid: 0, Name: "b"}, Runlinkat)
+	if !b.removed {
+		t.Error("Tunlinkat did not remove b")
+	}
+
+	payload := c.rpc(6, &TunlinkatMsg{Dirfid: 0, Name: "fixed"}, Rlerror)
+	if ecode := binary.LittleEndian.Uint32(payload); ecode != EACCES {
+		t.Errorf("unlinkat on a fixed file: errno = %d, want %d", ecode, EACCES)
+	}
+	c.rpc(7, &TwalkMsg{Fid: 0, Newfid: 2, Names: []string{"fixed"}}, Rwalk)
+	c.rpc(8, &TremoveMsg{Fid: 2}, Rlerror)
+	c.rpc(9, &TclunkMsg{Fid: 2}, Rlerror) // clunked despite the failure
+}
+
+// bufferingFile records how each of its fids was let go
+type bufferingFile struct {
+	*BaseFile
+	mu     sync.Mutex
+	closed []uint32
+	abort  []uint32
+}
+
+func (f *bufferingFile) ReadFid(fid uint32, p []byte, offset int64) (int, error) {
+	return 0, io.EOF
+}
+
+func (f *bufferingFile) WriteFid(fid uint32, p []byte, offset int64) (int, error) {
+	return len(p), nil
+}
+
+func (f *bufferingFile) CloseFid(fid uint32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = append(f.closed, fid)
+	return nil
+}
+
+func (f *bufferingFile) AbortFid(fid uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.abort = append(f.abort, fid)
+}
+
+func (f *bufferingFile) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.closed), len(f.abort)
+}
+
+func TestServer_ImplicitClunkAborts(t *testing.T) {
+	root := NewStaticDir("root")
+	buffered := &bufferingFile{BaseFile: NewBaseFile("ask", 0666)}
+	root.AddChild(buffered)
+
+	c := newTestClient(t, root)
+	open := func(tag uint16, fid uint32) {
+		c.rpc(tag, &TwalkMsg{Fid: 0, Newfid: fid, Names: []string{"ask"}}, Rwalk)
+		c.rpc(tag+1, &TopenMsg{Fid: fid, Mode: OWRITE}, Ropen)
+		c.rpc(tag+2, &TwriteMsg{Fid: fid, Data: []byte("half a prompt")}, Rwrite)
+	}
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	c.rpc(1, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "test"}, Rattach)
+	open(2, 1)
+	open(5, 2)
+	c.rpc(8, &TclunkMsg{Fid: 1}, Rclunk)
+	if closed, aborted := buffered.counts(); closed != 1 || aborted != 0 {
+		t.Errorf("after Tclunk: %d closed, %d aborted", closed, aborted)
+	}
+
+	// Tversion drops fid 2 without a clunk
+	c.rpc(NoTag, &TversionMsg{Msize: MaxMessageSize, Version: Version}, Rversion)
+	if closed, aborted := buffered.counts(); closed != 1 || aborted != 1 {
+		t.Errorf("after Tversion: %d closed, %d aborted", closed, aborted)
+	}
+
+	// and so does a disconnect
+	c.rpc(9, &TattachMsg{Fid: 0, Afid: NoFid, Uname: "test"}, Rattach)
+	open(10, 3)
+	c.conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, aborted := buffered.counts(); aborted == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("disconnect did not abort the open fid")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if closed, _ := buffered.counts(); closed != 1 {
+		t.Errorf("after disconnect: %d closed", closed)
+	}
+}